@@ -0,0 +1,238 @@
+package apiv3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// defaultTimeout default http request timeout
+const defaultTimeout = 10 * time.Second
+
+// APIv3 微信支付APIv3（普通商户直连模式），请求使用商户私钥签名（Authorization 头），应答使用微信支付平台证书验签（Wechatpay-Signature 头），
+// 平台证书首次使用时下载并缓存，之后在后台定时刷新（证书过期前自动轮换）
+type APIv3 struct {
+	appid      string
+	mchid      string
+	apiv3Key   string
+	serialNo   string
+	privateKey *rsa.PrivateKey
+	baseURL    string
+	client     *http.Client
+	certs      wx.PlatformCertCache
+	rotateOnce sync.Once
+	rotateWG   sync.WaitGroup
+	ctx        context.Context // Close 时取消，用于停止后台刷新平台证书
+	cancel     context.CancelFunc
+	nonce      func(size int) string
+	now        func() time.Time
+}
+
+// Option configures how we set up the APIv3
+type Option func(v3 *APIv3)
+
+// WithHTTPClient specifies the *http.Client to send requests (eg: to customize connection pooling, dial timeout, proxy, etc.).
+func WithHTTPClient(c *http.Client) Option {
+	return func(v3 *APIv3) {
+		v3.client = c
+	}
+}
+
+// WithBaseURL specifies the base url to send requests (eg: https://api2.mch.weixin.qq.com, or a proxy with path prefix), default is BaseURL.
+// The Authorization is always signed with the api path (eg: /v3/certificates), without the path prefix of the base url.
+func WithBaseURL(base string) Option {
+	return func(v3 *APIv3) {
+		v3.baseURL = strings.TrimSuffix(base, "/")
+	}
+}
+
+// WithNonceFunc specifies the func to generate the nonce of the pay params (eg: for testing), default is a random hex string of the size.
+func WithNonceFunc(f func(size int) string) Option {
+	return func(v3 *APIv3) {
+		v3.nonce = f
+	}
+}
+
+// New returns new wechat pay APIv3, the privateKey is the merchant private key (apiclient_key.pem, see wx.ParseRSAPrivateKey),
+// the serialNo is the serial number of the merchant certificate.
+func New(appid, mchid, apiV3Key, serialNo string, privateKey *rsa.PrivateKey, options ...Option) *APIv3 {
+	v3 := &APIv3{
+		appid:      appid,
+		mchid:      mchid,
+		apiv3Key:   apiV3Key,
+		serialNo:   serialNo,
+		privateKey: privateKey,
+		baseURL:    BaseURL,
+		client:     &http.Client{Timeout: defaultTimeout},
+		nonce: func(size int) string {
+			nonce := make([]byte, size/2)
+			io.ReadFull(rand.Reader, nonce)
+
+			return hex.EncodeToString(nonce)
+		},
+		now: time.Now,
+	}
+
+	v3.ctx, v3.cancel = context.WithCancel(context.Background())
+
+	for _, f := range options {
+		f(v3)
+	}
+
+	return v3
+}
+
+// AppID returns appid
+func (v3 *APIv3) AppID() string {
+	return v3.appid
+}
+
+// MchID returns mchid
+func (v3 *APIv3) MchID() string {
+	return v3.mchid
+}
+
+// Do 发送APIv3请求，path 为接口路径（含查询参数），如：/v3/pay/transactions/id/{transaction_id}?mchid=xxx
+// body 不为 nil 时序列化为JSON作为请求体；应答状态码为2xx时验证应答签名，dest 不为 nil 时将应答解析到 dest；
+// 应答状态码非2xx时返回 *Error（可通过 errors.Is 或 errors.As 判断错误码）
+func (v3 *APIv3) Do(ctx context.Context, method, path string, body, dest interface{}) error {
+	return v3.do(ctx, method, path, body, dest, nil)
+}
+
+func (v3 *APIv3) do(ctx context.Context, method, path string, body, dest interface{}, header http.Header) error {
+	var (
+		reqBody []byte
+		err     error
+	)
+
+	if body != nil {
+		if reqBody, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := v3.request(ctx, method, path, reqBody, header)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.statusCode < 200 || resp.statusCode >= 300 {
+		return newError(resp.statusCode, resp.body)
+	}
+
+	if err = v3.verifyResponse(ctx, resp); err != nil {
+		return err
+	}
+
+	if dest != nil && len(resp.body) != 0 {
+		return json.Unmarshal(resp.body, dest)
+	}
+
+	return nil
+}
+
+// response APIv3应答
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// request 使用商户私钥签名并发送请求
+func (v3 *APIv3) request(ctx context.Context, method, path string, body []byte, header http.Header) (*response, error) {
+	if v3.privateKey == nil {
+		return nil, errors.New("gochat: invalid rsa private key")
+	}
+
+	authorization, err := wx.BuildAuthHeader(v3.privateKey, v3.mchid, v3.serialNo, method, path, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, v3.baseURL+path, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/json")
+
+	if len(body) != 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v3.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &response{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       b,
+	}, nil
+}
+
+// verifyResponse 使用 Wechatpay-Serial 对应的平台证书验证应答签名（缺少签名视为验证失败）
+func (v3 *APIv3) verifyResponse(ctx context.Context, resp *response) error {
+	serialNo := resp.header.Get(HeaderSerial)
+
+	if serialNo == "" || resp.header.Get(HeaderSignature) == "" {
+		return errors.New("gochat: apiv3 response is not signed (missing Wechatpay-Signature or Wechatpay-Serial)")
+	}
+
+	cert, err := v3.PlatformCertificate(ctx, serialNo)
+
+	if err != nil {
+		return err
+	}
+
+	return verifySignature(cert, resp.header, resp.body)
+}
+
+// verifySignature 使用平台证书验证 Wechatpay-Signature 签名
+func verifySignature(cert *x509.Certificate, header http.Header, body []byte) error {
+	if err := wx.VerifySignature(cert, header.Get(HeaderTimestamp), header.Get(HeaderNonce), string(body), header.Get(HeaderSignature)); err != nil {
+		return fmt.Errorf("gochat: apiv3 response signature verification failed (serial_no: %s): %w", header.Get(HeaderSerial), err)
+	}
+
+	return nil
+}
+
+// Close 停止后台刷新平台证书（如：优雅退出），可多次调用
+func (v3 *APIv3) Close() error {
+	v3.cancel()
+	v3.rotateWG.Wait()
+
+	return nil
+}
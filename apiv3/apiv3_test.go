@@ -0,0 +1,345 @@
+package apiv3
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testMchID    = "10000100"
+	testSerialNo = "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C"
+	testAPIv3Key = "AES256Key-32Characters1234567890"
+)
+
+// testPlatform 模拟的微信支付平台证书
+type testPlatform struct {
+	serialNo string
+	key      *rsa.PrivateKey
+	certPEM  []byte
+}
+
+func newTestPlatform(t *testing.T, serial int64, notAfter time.Time) *testPlatform {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+
+	assert.Nil(t, err)
+
+	return &testPlatform{
+		serialNo: fmt.Sprintf("%X", serial),
+		key:      key,
+		certPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// sign 使用平台私钥签名应答
+func (p *testPlatform) sign(t *testing.T, w http.ResponseWriter, body []byte) {
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	nonce := "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body)))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+
+	assert.Nil(t, err)
+
+	w.Header().Set(HeaderTimestamp, timestamp)
+	w.Header().Set(HeaderNonce, nonce)
+	w.Header().Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature))
+	w.Header().Set(HeaderSerial, p.serialNo)
+}
+
+// testServer 模拟的微信支付APIv3，验证 Authorization 头的签名，并使用当前平台证书签名应答
+type testServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	platforms []*testPlatform // 平台证书列表，第一个用于签名应答
+	downloads int
+	handlers  map[string]func(w http.ResponseWriter, r *http.Request, body []byte)
+}
+
+var testAuthRegexp = regexp.MustCompile(`^WECHATPAY2-SHA256-RSA2048 mchid="10000100",nonce_str="(\w+)",signature="([^"]+)",timestamp="(\d+)",serial_no="1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C"$`)
+
+func newTestServer(t *testing.T, publicKey *rsa.PublicKey, platforms ...*testPlatform) *testServer {
+	ts := &testServer{
+		platforms: platforms,
+		handlers:  make(map[string]func(w http.ResponseWriter, r *http.Request, body []byte)),
+	}
+
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+
+		assert.Nil(t, err)
+
+		matches := testAuthRegexp.FindStringSubmatch(r.Header.Get("Authorization"))
+
+		if len(matches) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"SIGN_ERROR","message":"签名错误"}`))
+
+			return
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(matches[2])
+
+		assert.Nil(t, err)
+
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), matches[3], matches[1], body)))
+
+		if err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, h[:], signature); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"SIGN_ERROR","message":"签名错误"}`))
+
+			return
+		}
+
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+
+		if r.URL.Path == CertificatesURL {
+			ts.downloads++
+			ts.writeCertificates(t, w)
+
+			return
+		}
+
+		handler, ok := ts.handlers[r.Method+" "+r.URL.Path]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"NOT_FOUND","message":"接口不存在"}`))
+
+			return
+		}
+
+		handler(w, r, body)
+	}))
+
+	return ts
+}
+
+func (ts *testServer) writeCertificates(t *testing.T, w http.ResponseWriter) {
+	type encryptCertificate struct {
+		Algorithm      string `json:"algorithm"`
+		Nonce          string `json:"nonce"`
+		AssociatedData string `json:"associated_data"`
+		Ciphertext     string `json:"ciphertext"`
+	}
+
+	type certificate struct {
+		SerialNo           string             `json:"serial_no"`
+		EncryptCertificate encryptCertificate `json:"encrypt_certificate"`
+	}
+
+	data := make([]certificate, 0, len(ts.platforms))
+
+	for _, p := range ts.platforms {
+		ciphertext, err := wx.EncryptResource(testAPIv3Key, string(p.certPEM), "61f9c719728a", "certificate")
+
+		assert.Nil(t, err)
+
+		data = append(data, certificate{
+			SerialNo: p.serialNo,
+			EncryptCertificate: encryptCertificate{
+				Algorithm:      "AEAD_AES_256_GCM",
+				Nonce:          "61f9c719728a",
+				AssociatedData: "certificate",
+				Ciphertext:     ciphertext,
+			},
+		})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"data": data})
+
+	assert.Nil(t, err)
+
+	ts.platforms[0].sign(t, w, body)
+	w.Write(body)
+}
+
+func (ts *testServer) handle(pattern string, handler func(w http.ResponseWriter, r *http.Request, body []byte)) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.handlers[pattern] = handler
+}
+
+func (ts *testServer) setPlatforms(platforms ...*testPlatform) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.platforms = platforms
+}
+
+func (ts *testServer) downloadCount() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.downloads
+}
+
+func newTestAPIv3(t *testing.T, platforms ...*testPlatform) (*APIv3, *testServer) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	ts := newTestServer(t, &key.PublicKey, platforms...)
+
+	return New("wx2421b1c4370ec43b", testMchID, testAPIv3Key, testSerialNo, key, WithBaseURL(ts.URL+"/")), ts
+}
+
+func TestDo(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("POST /v3/pay/transactions/native", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"appid":"wx2421b1c4370ec43b","mchid":"10000100","out_trade_no":"1217752501201407033233368018"}`, string(body))
+
+		resp := []byte(`{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`)
+
+		platform.sign(t, w, resp)
+		w.Write(resp)
+	})
+
+	var result struct {
+		CodeURL string `json:"code_url"`
+	}
+
+	// 首次请求时下载平台证书
+	err := v3.Do(context.TODO(), http.MethodPost, "/v3/pay/transactions/native", map[string]string{
+		"appid":        "wx2421b1c4370ec43b",
+		"mchid":        "10000100",
+		"out_trade_no": "1217752501201407033233368018",
+	}, &result)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "weixin://wxpay/bizpayurl?pr=p4lpSuKzz", result.CodeURL)
+	assert.Equal(t, 1, ts.downloadCount())
+
+	// 命中缓存
+	err = v3.Do(context.TODO(), http.MethodPost, "/v3/pay/transactions/native", map[string]string{
+		"appid":        "wx2421b1c4370ec43b",
+		"mchid":        "10000100",
+		"out_trade_no": "1217752501201407033233368018",
+	}, &result)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ts.downloadCount())
+}
+
+func TestDoInvalidSignature(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	// 应答被篡改
+	ts.handle("GET /v3/pay/transactions/id/1217752501201407033233368018", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		platform.sign(t, w, []byte(`{"trade_state":"NOTPAY"}`))
+		w.Write([]byte(`{"trade_state":"SUCCESS"}`))
+	})
+
+	// 应答未签名
+	ts.handle("GET /v3/pay/transactions/id/1217752501201407033233368019", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		w.Write([]byte(`{"trade_state":"SUCCESS"}`))
+	})
+
+	// 未知的平台证书序列号
+	ts.handle("GET /v3/pay/transactions/id/1217752501201407033233368020", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		resp := []byte(`{"trade_state":"SUCCESS"}`)
+
+		platform.sign(t, w, resp)
+		w.Header().Set(HeaderSerial, "UNKNOWN")
+		w.Write(resp)
+	})
+
+	err := v3.Do(context.TODO(), http.MethodGet, "/v3/pay/transactions/id/1217752501201407033233368018?mchid=10000100", nil, nil)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "gochat: apiv3 response signature verification failed")
+
+	err = v3.Do(context.TODO(), http.MethodGet, "/v3/pay/transactions/id/1217752501201407033233368019?mchid=10000100", nil, nil)
+
+	assert.EqualError(t, err, "gochat: apiv3 response is not signed (missing Wechatpay-Signature or Wechatpay-Serial)")
+
+	err = v3.Do(context.TODO(), http.MethodGet, "/v3/pay/transactions/id/1217752501201407033233368020?mchid=10000100", nil, nil)
+
+	assert.EqualError(t, err, "gochat: platform certificate not found (serial_no: UNKNOWN)")
+}
+
+func TestDoError(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("POST /v3/pay/transactions/jsapi", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"PARAM_ERROR","message":"参数错误","detail":{"field":"/amount/total","issue":"total 必须大于0"}}`))
+	})
+
+	err := v3.Do(context.TODO(), http.MethodPost, "/v3/pay/transactions/jsapi", map[string]string{"appid": "wx2421b1c4370ec43b"}, nil)
+
+	assert.True(t, errors.Is(err, &Error{Code: "PARAM_ERROR"}))
+	assert.False(t, errors.Is(err, &Error{Code: "SIGN_ERROR"}))
+
+	var e *Error
+
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusBadRequest, e.StatusCode)
+	assert.Equal(t, "参数错误", e.Message)
+	assert.JSONEq(t, `{"field":"/amount/total","issue":"total 必须大于0"}`, string(e.Detail))
+	assert.EqualError(t, err, "gochat: apiv3 error (status: 400, code: PARAM_ERROR): 参数错误")
+
+	// 非JSON应答
+	ts.handle("GET /v3/pay/transactions/id/1217752501201407033233368018", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`Bad Gateway`))
+	})
+
+	err = v3.Do(context.TODO(), http.MethodGet, "/v3/pay/transactions/id/1217752501201407033233368018", nil, nil)
+
+	assert.EqualError(t, err, "gochat: apiv3 error (status: 502, code: ): Bad Gateway")
+	// 错误应答不验签，无需下载平台证书
+	assert.Equal(t, 0, ts.downloadCount())
+}
@@ -0,0 +1,101 @@
+package apiv3
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// certRetryInterval 后台刷新平台证书的最小间隔（下载失败后按此间隔重试）
+var certRetryInterval = time.Minute
+
+// DownloadCertificates 下载微信支付平台证书，使用APIv3密钥解密各证书（AEAD_AES_256_GCM），并验证应答签名（首次下载时使用下载的证书验签），
+// 下载的证书按序列号缓存，供应答验签使用，同时启动后台刷新（证书过期前自动下载新证书）；并发调用只会发起一次下载
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/wechatpay5_1.shtml)
+func (v3 *APIv3) DownloadCertificates(ctx context.Context) ([]*wx.PlatformCertificate, error) {
+	certs, err := v3.certs.Download(ctx, v3.apiv3Key, v3.fetchCertificates, v3.now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	v3.startRotation()
+
+	return certs, nil
+}
+
+// fetchCertificates 请求「获取平台证书列表」
+func (v3 *APIv3) fetchCertificates(ctx context.Context) ([]byte, http.Header, error) {
+	resp, err := v3.request(ctx, http.MethodGet, CertificatesURL, nil, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.statusCode < 200 || resp.statusCode >= 300 {
+		return nil, nil, newError(resp.statusCode, resp.body)
+	}
+
+	return resp.body, resp.header, nil
+}
+
+// PlatformCertificate 根据序列号（即 Wechatpay-Serial 头）获取微信支付平台证书，用于应答及回调通知的验签，
+// 优先使用缓存，缓存中不存在或需要刷新时自动下载；下载失败时，若缓存中的证书尚未过期则继续使用
+func (v3 *APIv3) PlatformCertificate(ctx context.Context, serialNo string) (*x509.Certificate, error) {
+	cert, err := v3.certs.Certificate(ctx, serialNo, v3.apiv3Key, v3.fetchCertificates, v3.now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	v3.startRotation()
+
+	return cert, nil
+}
+
+// currentCertificate 获取用于加密敏感信息的平台证书（当前有效且过期时间最晚的证书），缓存中不存在或需要刷新时自动下载
+func (v3 *APIv3) currentCertificate(ctx context.Context) (string, *x509.Certificate, error) {
+	serialNo, cert, err := v3.certs.Current(ctx, v3.apiv3Key, v3.fetchCertificates, v3.now())
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	v3.startRotation()
+
+	return serialNo, cert, nil
+}
+
+// startRotation 启动后台刷新平台证书（仅启动一次，Close 时停止）
+func (v3 *APIv3) startRotation() {
+	v3.rotateOnce.Do(func() {
+		v3.rotateWG.Add(1)
+
+		go func() {
+			defer v3.rotateWG.Done()
+
+			for {
+				wait := v3.certs.NextRefresh().Sub(v3.now())
+
+				if wait < certRetryInterval {
+					wait = certRetryInterval
+				}
+
+				timer := time.NewTimer(wait)
+
+				select {
+				case <-v3.ctx.Done():
+					timer.Stop()
+
+					return
+				case <-timer.C:
+				}
+
+				v3.DownloadCertificates(v3.ctx)
+			}
+		}()
+	})
+}
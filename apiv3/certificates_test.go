@@ -0,0 +1,177 @@
+package apiv3
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCertificates(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	certs, err := v3.DownloadCertificates(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(certs))
+	assert.Equal(t, "5157F09EFDC096DE", certs[0].SerialNo)
+	assert.Equal(t, "Tenpay.com Root CA", certs[0].Certificate.Subject.CommonName)
+
+	cert, err := v3.PlatformCertificate(context.TODO(), "5157F09EFDC096DE")
+
+	assert.Nil(t, err)
+	assert.Equal(t, certs[0].Certificate, cert)
+	assert.Equal(t, 1, ts.downloadCount())
+
+	// APIv3密钥错误时解密失败
+	v3 = New("wx2421b1c4370ec43b", testMchID, "AES256Key-32Characters0987654321", testSerialNo, v3.privateKey, WithBaseURL(ts.URL))
+
+	defer v3.Close()
+
+	_, err = v3.DownloadCertificates(context.TODO())
+
+	assert.NotNil(t, err)
+
+	// 商户私钥不匹配
+	other := newTestPlatform(t, 1, time.Now().Add(time.Hour))
+
+	v3 = New("wx2421b1c4370ec43b", testMchID, testAPIv3Key, testSerialNo, other.key, WithBaseURL(ts.URL))
+
+	defer v3.Close()
+
+	_, err = v3.DownloadCertificates(context.TODO())
+
+	assert.EqualError(t, err, "gochat: apiv3 error (status: 401, code: SIGN_ERROR): 签名错误")
+}
+
+func TestDownloadCertificatesConcurrent(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := v3.PlatformCertificate(context.TODO(), "5157F09EFDC096DE")
+
+			assert.Nil(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.True(t, ts.downloadCount() < 10)
+}
+
+func TestPlatformCertificateRefresh(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	var mu sync.Mutex
+
+	now := time.Now()
+
+	// 后台刷新的 goroutine 同样会读取当前时间
+	v3.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now = now.Add(d)
+	}
+
+	_, err := v3.PlatformCertificate(context.TODO(), "5157F09EFDC096DE")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ts.downloadCount())
+
+	// 超过刷新间隔后重新下载
+	advance(13 * time.Hour)
+
+	_, err = v3.PlatformCertificate(context.TODO(), "5157F09EFDC096DE")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ts.downloadCount())
+
+	// 下载失败时继续使用未过期的证书
+	ts.Close()
+
+	advance(13 * time.Hour)
+
+	_, err = v3.PlatformCertificate(context.TODO(), "5157F09EFDC096DE")
+
+	assert.Nil(t, err)
+
+	// 缓存中不存在且下载失败
+	_, err = v3.PlatformCertificate(context.TODO(), "UNKNOWN")
+
+	assert.NotNil(t, err)
+}
+
+func TestCertificateRotation(t *testing.T) {
+	retry := certRetryInterval
+	certRetryInterval = 10 * time.Millisecond
+
+	defer func() {
+		certRetryInterval = retry
+	}()
+
+	// 即将过期的旧证书，下载后立即需要刷新
+	old := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(time.Hour))
+
+	v3, ts := newTestAPIv3(t, old)
+
+	defer ts.Close()
+
+	_, err := v3.DownloadCertificates(context.TODO())
+
+	assert.Nil(t, err)
+
+	// 平台启用新证书（新旧证书并存，应答使用新证书签名）
+	renewed := newTestPlatform(t, 0x6A36B2C7D1E0F123, time.Now().Add(365*24*time.Hour))
+
+	ts.setPlatforms(renewed, old)
+
+	assert.Eventually(t, func() bool {
+		cert, _ := v3.certs.Get("6A36B2C7D1E0F123", v3.now())
+
+		return cert != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 关闭后停止后台刷新（关闭前已发出的请求可能稍后才到达服务端）
+	assert.Nil(t, v3.Close())
+
+	time.Sleep(20 * time.Millisecond)
+
+	count := ts.downloadCount()
+
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, count, ts.downloadCount())
+	assert.Nil(t, v3.Close())
+}
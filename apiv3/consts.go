@@ -0,0 +1,41 @@
+package apiv3
+
+// BaseURL 微信支付APIv3接口的默认域名
+const BaseURL = "https://api.mch.weixin.qq.com"
+
+// 应答及回调通知的签名头
+const (
+	HeaderTimestamp = "Wechatpay-Timestamp"
+	HeaderNonce     = "Wechatpay-Nonce"
+	HeaderSignature = "Wechatpay-Signature"
+	HeaderSerial    = "Wechatpay-Serial"
+)
+
+// URL - certificates
+const (
+	CertificatesURL = "/v3/certificates" // 获取平台证书列表
+)
+
+// URL - transactions
+const (
+	TransactionsJSAPIURL  = "/v3/pay/transactions/jsapi"  // JSAPI/小程序下单
+	TransactionsNativeURL = "/v3/pay/transactions/native" // Native下单
+	TransactionsAPPURL    = "/v3/pay/transactions/app"    // APP下单
+	TransactionsH5URL     = "/v3/pay/transactions/h5"     // H5下单
+)
+
+// SignTypeRSA 调起支付的签名类型
+const SignTypeRSA = "RSA"
+
+// URL - refund
+const (
+	RefundURL      = "/v3/refund/domestic/refunds"    // 申请退款
+	QueryRefundURL = "/v3/refund/domestic/refunds/%s" // 查询单笔退款（通过商户退款单号）
+)
+
+// URL - transfer
+const (
+	TransferBatchesURL          = "/v3/transfer/batches"                                          // 发起商家转账
+	QueryTransferBatchURL       = "/v3/transfer/batches/out-batch-no/%s"                          // 通过商家批次单号查询批次单
+	QueryTransferBatchDetailURL = "/v3/transfer/batches/out-batch-no/%s/details/out-detail-no/%s" // 通过商家明细单号查询明细单
+)
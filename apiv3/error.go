@@ -0,0 +1,41 @@
+package apiv3
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error APIv3接口错误（应答状态码非2xx），应答体如：{"code":"PARAM_ERROR","message":"参数错误","detail":{"field":"/amount/total","issue":"..."}}
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay2_0.shtml)
+type Error struct {
+	StatusCode int             `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gochat: apiv3 error (status: %d, code: %s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is 根据错误码判断，如：errors.Is(err, &apiv3.Error{Code: "PARAM_ERROR"})
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+
+	if !ok {
+		return false
+	}
+
+	return t.Code == e.Code
+}
+
+// newError 解析错误应答，应答体不是JSON时（如：网关错误）Message 为原始内容
+func newError(statusCode int, body []byte) error {
+	e := &Error{StatusCode: statusCode}
+
+	if err := json.Unmarshal(body, e); err != nil || e.Code == "" {
+		e.Message = string(body)
+	}
+
+	return e
+}
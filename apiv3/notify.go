@@ -0,0 +1,158 @@
+package apiv3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// NotifyTimeWindow 回调通知的有效时间窗口，Wechatpay-Timestamp 与当前时间相差超过该时间的通知视为重放，拒绝处理
+const NotifyTimeWindow = 5 * time.Minute
+
+// 回调通知的事件类型
+const (
+	EventTransactionSuccess = "TRANSACTION.SUCCESS" // 支付成功
+	EventRefundSuccess      = "REFUND.SUCCESS"      // 退款成功
+	EventRefundAbnormal     = "REFUND.ABNORMAL"     // 退款异常
+	EventRefundClosed       = "REFUND.CLOSED"       // 退款关闭
+)
+
+// TransactionAmount 订单金额
+type TransactionAmount struct {
+	Total         int    `json:"total"`          // 订单总金额，单位为分
+	PayerTotal    int    `json:"payer_total"`    // 用户支付金额，单位为分
+	Currency      string `json:"currency"`       // 货币类型
+	PayerCurrency string `json:"payer_currency"` // 用户支付币种
+}
+
+// Transaction 订单（支付结果通知解密后的 resource）
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_5.shtml)
+type Transaction struct {
+	AppID          string             `json:"appid"`            // 应用ID
+	MchID          string             `json:"mchid"`            // 商户号
+	OutTradeNO     string             `json:"out_trade_no"`     // 商户订单号
+	TransactionID  string             `json:"transaction_id"`   // 微信支付订单号
+	TradeType      string             `json:"trade_type"`       // 交易类型，如：JSAPI、NATIVE、APP、MWEB
+	TradeState     string             `json:"trade_state"`      // 交易状态，如：SUCCESS、REFUND、NOTPAY、CLOSED
+	TradeStateDesc string             `json:"trade_state_desc"` // 交易状态描述
+	BankType       string             `json:"bank_type"`        // 付款银行
+	Attach         string             `json:"attach"`           // 附加数据
+	SuccessTime    string             `json:"success_time"`     // 支付完成时间，rfc3339格式
+	Payer          *Payer             `json:"payer"`            // 支付者
+	Amount         *TransactionAmount `json:"amount"`           // 订单金额
+}
+
+// NotifyResource 解密后的回调通知
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_2.shtml)
+type NotifyResource struct {
+	ID           string        // 通知ID
+	CreateTime   string        // 通知创建时间，rfc3339格式
+	EventType    string        // 通知类型，如：TRANSACTION.SUCCESS
+	ResourceType string        // 通知数据类型，如：encrypt-resource
+	Summary      string        // 回调摘要
+	OriginalType string        // 原始回调类型，如：transaction
+	Plaintext    []byte        // 解密后的 resource（JSON）
+	Transaction  *Transaction  // 支付结果（event_type 为 TRANSACTION.SUCCESS 时）
+	Refund       *RefundNotify // 退款结果（event_type 为 REFUND.SUCCESS、REFUND.ABNORMAL、REFUND.CLOSED 时）
+}
+
+// notifyBody 回调通知的请求体
+type notifyBody struct {
+	ID           string `json:"id"`
+	CreateTime   string `json:"create_time"`
+	EventType    string `json:"event_type"`
+	ResourceType string `json:"resource_type"`
+	Summary      string `json:"summary"`
+	Resource     struct {
+		OriginalType   string `json:"original_type"`
+		Algorithm      string `json:"algorithm"`
+		Ciphertext     string `json:"ciphertext"`
+		AssociatedData string `json:"associated_data"`
+		Nonce          string `json:"nonce"`
+	} `json:"resource"`
+}
+
+// ErrNotifyExpired 回调通知的 Wechatpay-Timestamp 超出有效时间窗口（可能为重放请求）
+var ErrNotifyExpired = errors.New("gochat: apiv3 notify timestamp expired (possible replay)")
+
+// ParseNotify 解析回调通知：校验 Wechatpay-Timestamp（与当前时间相差不超过5分钟），使用 Wechatpay-Serial 对应的平台证书验证签名
+// （缓存中不存在时自动下载，仍不存在则返回错误），再使用APIv3密钥解密 resource（AEAD_AES_256_GCM）
+func (v3 *APIv3) ParseNotify(ctx context.Context, header http.Header, body []byte) (*NotifyResource, error) {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("gochat: invalid %s: %w", HeaderTimestamp, err)
+	}
+
+	if d := v3.now().Sub(time.Unix(timestamp, 0)); d > NotifyTimeWindow || d < -NotifyTimeWindow {
+		return nil, ErrNotifyExpired
+	}
+
+	if err = v3.verifyResponse(ctx, &response{header: header, body: body}); err != nil {
+		return nil, err
+	}
+
+	notify := new(notifyBody)
+
+	if err = json.Unmarshal(body, notify); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := wx.DecryptNotify(v3.apiv3Key, notify.Resource.Ciphertext, notify.Resource.Nonce, notify.Resource.AssociatedData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resource := &NotifyResource{
+		ID:           notify.ID,
+		CreateTime:   notify.CreateTime,
+		EventType:    notify.EventType,
+		ResourceType: notify.ResourceType,
+		Summary:      notify.Summary,
+		OriginalType: notify.Resource.OriginalType,
+		Plaintext:    plaintext,
+	}
+
+	switch notify.EventType {
+	case EventTransactionSuccess:
+		resource.Transaction = new(Transaction)
+
+		err = json.Unmarshal(plaintext, resource.Transaction)
+	case EventRefundSuccess, EventRefundAbnormal, EventRefundClosed:
+		resource.Refund = new(RefundNotify)
+
+		err = json.Unmarshal(plaintext, resource.Refund)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+// NotifyReply 返回回调通知的应答（JSON），ok 为 false 时应以非2xx状态码应答，微信会重新发送通知
+func NotifyReply(ok bool, msg string) []byte {
+	reply := struct {
+		Code    string `json:"code"`
+		Message string `json:"message,omitempty"`
+	}{
+		Code:    "SUCCESS",
+		Message: msg,
+	}
+
+	if !ok {
+		reply.Code = "FAIL"
+	}
+
+	b, _ := json.Marshal(reply)
+
+	return b
+}
@@ -0,0 +1,191 @@
+package apiv3
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTransaction = `{"appid":"wx2421b1c4370ec43b","mchid":"10000100","out_trade_no":"1217752501201407033233368018","transaction_id":"1217752501201407033233368018","trade_type":"JSAPI","trade_state":"SUCCESS","trade_state_desc":"支付成功","bank_type":"CMC","attach":"自定义数据","success_time":"2018-06-08T10:34:56+08:00","payer":{"openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},"amount":{"total":100,"payer_total":100,"currency":"CNY","payer_currency":"CNY"}}`
+
+// newTestNotify 构造使用平台证书签名的支付结果通知
+func newTestNotify(t *testing.T, platform *testPlatform, apiv3Key string) (*httptest.ResponseRecorder, []byte) {
+	return newTestEventNotify(t, platform, apiv3Key, EventTransactionSuccess, "transaction", testTransaction)
+}
+
+// newTestEventNotify 构造使用平台证书签名的回调通知
+func newTestEventNotify(t *testing.T, platform *testPlatform, apiv3Key, eventType, originalType, plaintext string) (*httptest.ResponseRecorder, []byte) {
+	ciphertext, err := wx.EncryptResource(apiv3Key, plaintext, "fdasflkja484w", originalType)
+
+	assert.Nil(t, err)
+
+	body := []byte(`{"id":"EV-2018022511223320873","create_time":"2015-05-20T13:29:35+08:00","resource_type":"encrypt-resource","event_type":"` + eventType + `","summary":"支付成功","resource":{"original_type":"` + originalType + `","algorithm":"AEAD_AES_256_GCM","ciphertext":"` + ciphertext + `","associated_data":"` + originalType + `","nonce":"fdasflkja484w"}}`)
+
+	w := httptest.NewRecorder()
+
+	platform.sign(t, w, body)
+
+	return w, body
+}
+
+func TestParseNotify(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	w, body := newTestNotify(t, platform, testAPIv3Key)
+
+	resource, err := v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "EV-2018022511223320873", resource.ID)
+	assert.Equal(t, EventTransactionSuccess, resource.EventType)
+	assert.Equal(t, "transaction", resource.OriginalType)
+	assert.JSONEq(t, testTransaction, string(resource.Plaintext))
+	assert.Equal(t, &Transaction{
+		AppID:          "wx2421b1c4370ec43b",
+		MchID:          "10000100",
+		OutTradeNO:     "1217752501201407033233368018",
+		TransactionID:  "1217752501201407033233368018",
+		TradeType:      "JSAPI",
+		TradeState:     "SUCCESS",
+		TradeStateDesc: "支付成功",
+		BankType:       "CMC",
+		Attach:         "自定义数据",
+		SuccessTime:    "2018-06-08T10:34:56+08:00",
+		Payer:          &Payer{OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+		Amount: &TransactionAmount{
+			Total:         100,
+			PayerTotal:    100,
+			Currency:      "CNY",
+			PayerCurrency: "CNY",
+		},
+	}, resource.Transaction)
+	assert.Equal(t, 1, ts.downloadCount())
+
+	// 通知内容被篡改
+	_, err = v3.ParseNotify(context.TODO(), w.Header(), append(body, ' '))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "signature verification failed")
+}
+
+func TestParseNotifyExpired(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	w, body := newTestNotify(t, platform, testAPIv3Key)
+
+	// 重放超过5分钟前的通知
+	v3.now = func() time.Time {
+		return time.Now().Add(6 * time.Minute)
+	}
+
+	_, err := v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Equal(t, ErrNotifyExpired, err)
+
+	v3.now = func() time.Time {
+		return time.Now().Add(-6 * time.Minute)
+	}
+
+	_, err = v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Equal(t, ErrNotifyExpired, err)
+
+	// 时间窗口内
+	v3.now = func() time.Time {
+		return time.Now().Add(4 * time.Minute)
+	}
+
+	_, err = v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Nil(t, err)
+
+	// 缺少 Wechatpay-Timestamp
+	w.Header().Del(HeaderTimestamp)
+
+	_, err = v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "gochat: invalid Wechatpay-Timestamp")
+}
+
+func TestParseNotifyUnknownSerial(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	var mu sync.Mutex
+
+	now := time.Now()
+
+	// 后台刷新的 goroutine 同样会读取当前时间
+	v3.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	_, err := v3.DownloadCertificates(context.TODO())
+
+	assert.Nil(t, err)
+
+	// 未知的平台证书（如：伪造的通知），缓存无需刷新时不会重新下载
+	forged := newTestPlatform(t, 1, time.Now().Add(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		w, body := newTestNotify(t, forged, testAPIv3Key)
+
+		_, err = v3.ParseNotify(context.TODO(), w.Header(), body)
+
+		assert.EqualError(t, err, "gochat: platform certificate not found (serial_no: 1)")
+	}
+
+	assert.Equal(t, 1, ts.downloadCount())
+
+	// 平台证书轮换后，新证书签名的通知在间隔（1分钟）后自动下载新证书
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+
+	renewed := newTestPlatform(t, 0x6A36B2C7D1E0F123, time.Now().Add(365*24*time.Hour))
+
+	ts.setPlatforms(renewed, platform)
+
+	w, body := newTestNotify(t, renewed, testAPIv3Key)
+
+	_, err = v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ts.downloadCount())
+
+	// APIv3密钥错误
+	w, body = newTestNotify(t, platform, "AES256Key-32Characters0987654321")
+
+	_, err = v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "gochat: apiv3 notify decrypt failed")
+}
+
+func TestNotifyReply(t *testing.T) {
+	assert.Equal(t, `{"code":"SUCCESS"}`, string(NotifyReply(true, "")))
+	assert.Equal(t, `{"code":"FAIL","message":"签名错误"}`, string(NotifyReply(false, "签名错误")))
+}
@@ -0,0 +1,165 @@
+package apiv3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// RefundFrom 退款出资账户及金额
+type RefundFrom struct {
+	Account string `json:"account"` // 出资账户类型，如：AVAILABLE（可用余额）、UNAVAILABLE（不可用余额）
+	Amount  int    `json:"amount"`  // 出资金额，单位为分
+}
+
+// RefundAmountRequest 退款金额
+type RefundAmountRequest struct {
+	Refund   int           `json:"refund"`         // 退款金额，单位为分，不能超过原订单支付金额（部分退款时小于 total）
+	From     []*RefundFrom `json:"from,omitempty"` // 退款出资账户及金额
+	Total    int           `json:"total"`          // 原订单金额，单位为分
+	Currency string        `json:"currency"`       // 退款币种，默认：CNY
+}
+
+// RefundGoodsDetail 退款商品
+type RefundGoodsDetail struct {
+	MerchantGoodsID  string `json:"merchant_goods_id"`            // 商户侧商品编码
+	WechatpayGoodsID string `json:"wechatpay_goods_id,omitempty"` // 微信侧商品编码
+	GoodsName        string `json:"goods_name,omitempty"`         // 商品名称
+	UnitPrice        int    `json:"unit_price"`                   // 商品单价，单位为分
+	RefundAmount     int    `json:"refund_amount"`                // 商品退款金额，单位为分
+	RefundQuantity   int    `json:"refund_quantity"`              // 商品退货数量
+}
+
+// RefundRequest 申请退款参数（transaction_id 与 out_trade_no 二选一）
+type RefundRequest struct {
+	TransactionID string               `json:"transaction_id,omitempty"` // 微信支付订单号
+	OutTradeNO    string               `json:"out_trade_no,omitempty"`   // 商户订单号
+	OutRefundNO   string               `json:"out_refund_no"`            // 商户退款单号
+	Reason        string               `json:"reason,omitempty"`         // 退款原因
+	NotifyURL     string               `json:"notify_url,omitempty"`     // 退款结果通知地址
+	FundsAccount  string               `json:"funds_account,omitempty"`  // 退款资金来源，如：AVAILABLE（可用余额账户）
+	Amount        *RefundAmountRequest `json:"amount"`                   // 退款金额
+	GoodsDetail   []*RefundGoodsDetail `json:"goods_detail,omitempty"`   // 退款商品
+}
+
+// RefundAmount 退款金额（应答）
+type RefundAmount struct {
+	Total            int           `json:"total"`             // 订单总金额，单位为分
+	Refund           int           `json:"refund"`            // 退款金额，单位为分
+	From             []*RefundFrom `json:"from"`              // 退款出资账户及金额
+	PayerTotal       int           `json:"payer_total"`       // 用户支付金额，单位为分
+	PayerRefund      int           `json:"payer_refund"`      // 用户退款金额，单位为分
+	SettlementRefund int           `json:"settlement_refund"` // 应结退款金额，单位为分
+	SettlementTotal  int           `json:"settlement_total"`  // 应结订单金额，单位为分
+	DiscountRefund   int           `json:"discount_refund"`   // 优惠退款金额，单位为分
+	Currency         string        `json:"currency"`          // 退款币种
+}
+
+// RefundPromotionDetail 退款优惠信息
+type RefundPromotionDetail struct {
+	PromotionID  string               `json:"promotion_id"`  // 券ID
+	Scope        string               `json:"scope"`         // 优惠范围，如：GLOBAL（全场代金券）、SINGLE（单品优惠）
+	Type         string               `json:"type"`          // 优惠类型，如：COUPON（代金券）、DISCOUNT（优惠券）
+	Amount       int                  `json:"amount"`        // 优惠券面额，单位为分
+	RefundAmount int                  `json:"refund_amount"` // 优惠退款金额，单位为分
+	GoodsDetail  []*RefundGoodsDetail `json:"goods_detail"`  // 商品列表
+}
+
+// RefundResult 退款单（申请退款及查询退款的应答）
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_9.shtml)
+type RefundResult struct {
+	RefundID            string                   `json:"refund_id"`             // 微信支付退款单号
+	OutRefundNO         string                   `json:"out_refund_no"`         // 商户退款单号
+	TransactionID       string                   `json:"transaction_id"`        // 微信支付订单号
+	OutTradeNO          string                   `json:"out_trade_no"`          // 商户订单号
+	Channel             string                   `json:"channel"`               // 退款渠道，如：ORIGINAL（原路退款）、BALANCE（退回到余额）
+	UserReceivedAccount string                   `json:"user_received_account"` // 退款入账账户
+	SuccessTime         string                   `json:"success_time"`          // 退款成功时间，rfc3339格式
+	CreateTime          string                   `json:"create_time"`           // 退款创建时间，rfc3339格式
+	Status              string                   `json:"status"`                // 退款状态，如：SUCCESS、CLOSED、PROCESSING、ABNORMAL
+	FundsAccount        string                   `json:"funds_account"`         // 资金账户
+	Amount              *RefundAmount            `json:"amount"`                // 金额信息
+	PromotionDetail     []*RefundPromotionDetail `json:"promotion_detail"`      // 优惠退款信息
+}
+
+// RefundNotifyAmount 退款结果通知的金额信息
+type RefundNotifyAmount struct {
+	Total       int `json:"total"`        // 订单总金额，单位为分
+	Refund      int `json:"refund"`       // 退款金额，单位为分
+	PayerTotal  int `json:"payer_total"`  // 用户支付金额，单位为分
+	PayerRefund int `json:"payer_refund"` // 用户退款金额，单位为分
+}
+
+// RefundNotify 退款结果通知（解密后的 resource）
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_11.shtml)
+type RefundNotify struct {
+	MchID               string              `json:"mchid"`                 // 商户号
+	OutTradeNO          string              `json:"out_trade_no"`          // 商户订单号
+	TransactionID       string              `json:"transaction_id"`        // 微信支付订单号
+	OutRefundNO         string              `json:"out_refund_no"`         // 商户退款单号
+	RefundID            string              `json:"refund_id"`             // 微信支付退款单号
+	RefundStatus        string              `json:"refund_status"`         // 退款状态，如：SUCCESS、CLOSED、ABNORMAL
+	SuccessTime         string              `json:"success_time"`          // 退款成功时间，rfc3339格式
+	UserReceivedAccount string              `json:"user_received_account"` // 退款入账账户
+	Amount              *RefundNotifyAmount `json:"amount"`                // 金额信息
+}
+
+// Refund 申请退款（支持部分退款，同一订单可多次退款，退款总金额不能超过订单金额），币种未指定时为 CNY
+// 退款受理后返回的 status 通常为 PROCESSING，退款结果通过退款结果通知（ParseNotify）或 QueryRefund 获取
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_9.shtml)
+func (v3 *APIv3) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	if req == nil {
+		return nil, errors.New("gochat: refund request is required")
+	}
+
+	if req.TransactionID == "" && req.OutTradeNO == "" {
+		return nil, errors.New("gochat: transaction_id or out_trade_no is required")
+	}
+
+	if req.OutRefundNO == "" {
+		return nil, errors.New("gochat: out_refund_no is required")
+	}
+
+	if req.Amount == nil || req.Amount.Refund <= 0 || req.Amount.Total <= 0 {
+		return nil, errors.New("gochat: amount.refund and amount.total must be greater than 0")
+	}
+
+	if req.Amount.Refund > req.Amount.Total {
+		return nil, errors.New("gochat: amount.refund must not exceed amount.total")
+	}
+
+	body := *req
+
+	if req.Amount.Currency == "" {
+		amount := *req.Amount
+		amount.Currency = "CNY"
+
+		body.Amount = &amount
+	}
+
+	result := new(RefundResult)
+
+	if err := v3.Do(ctx, http.MethodPost, RefundURL, &body, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// QueryRefund 根据商户退款单号查询单笔退款
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_10.shtml)
+func (v3 *APIv3) QueryRefund(ctx context.Context, outRefundNO string) (*RefundResult, error) {
+	if outRefundNO == "" {
+		return nil, errors.New("gochat: out_refund_no is required")
+	}
+
+	result := new(RefundResult)
+
+	if err := v3.Do(ctx, http.MethodGet, fmt.Sprintf(QueryRefundURL, url.PathEscape(outRefundNO)), nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
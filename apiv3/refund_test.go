@@ -0,0 +1,242 @@
+package apiv3
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRefundResult = `{
+	"refund_id": "50000000382019052709732678859",
+	"out_refund_no": "1217752501201407033233368018",
+	"transaction_id": "1217752501201407033233368018",
+	"out_trade_no": "1217752501201407033233368018",
+	"channel": "ORIGINAL",
+	"user_received_account": "招商银行信用卡0403",
+	"success_time": "",
+	"create_time": "2020-12-01T16:18:12+08:00",
+	"status": "PROCESSING",
+	"funds_account": "UNSETTLED",
+	"amount": {
+		"total": 100,
+		"refund": 60,
+		"from": [{"account": "AVAILABLE", "amount": 60}],
+		"payer_total": 90,
+		"payer_refund": 50,
+		"settlement_refund": 60,
+		"settlement_total": 100,
+		"discount_refund": 10,
+		"currency": "CNY"
+	},
+	"promotion_detail": [{
+		"promotion_id": "109519",
+		"scope": "SINGLE",
+		"type": "DISCOUNT",
+		"amount": 10,
+		"refund_amount": 10,
+		"goods_detail": [{
+			"merchant_goods_id": "1217752501201407033233368018",
+			"wechatpay_goods_id": "1001",
+			"goods_name": "iPhone6s 16G",
+			"unit_price": 100,
+			"refund_amount": 60,
+			"refund_quantity": 1
+		}]
+	}]
+}`
+
+func TestRefund(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("POST "+RefundURL, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		// 部分退款，未指定币种时默认 CNY
+		assert.JSONEq(t, `{"transaction_id":"1217752501201407033233368018","out_refund_no":"1217752501201407033233368018","reason":"商品已售完","notify_url":"https://weixin.qq.com","funds_account":"AVAILABLE","amount":{"refund":60,"from":[{"account":"AVAILABLE","amount":60}],"total":100,"currency":"CNY"},"goods_detail":[{"merchant_goods_id":"1217752501201407033233368018","wechatpay_goods_id":"1001","goods_name":"iPhone6s 16G","unit_price":100,"refund_amount":60,"refund_quantity":1}]}`, string(body))
+
+		platform.sign(t, w, []byte(testRefundResult))
+		w.Write([]byte(testRefundResult))
+	})
+
+	req := &RefundRequest{
+		TransactionID: "1217752501201407033233368018",
+		OutRefundNO:   "1217752501201407033233368018",
+		Reason:        "商品已售完",
+		NotifyURL:     "https://weixin.qq.com",
+		FundsAccount:  "AVAILABLE",
+		Amount: &RefundAmountRequest{
+			Refund: 60,
+			From:   []*RefundFrom{{Account: "AVAILABLE", Amount: 60}},
+			Total:  100,
+		},
+		GoodsDetail: []*RefundGoodsDetail{
+			{
+				MerchantGoodsID:  "1217752501201407033233368018",
+				WechatpayGoodsID: "1001",
+				GoodsName:        "iPhone6s 16G",
+				UnitPrice:        100,
+				RefundAmount:     60,
+				RefundQuantity:   1,
+			},
+		},
+	}
+
+	result, err := v3.Refund(context.TODO(), req)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RefundResult{
+		RefundID:            "50000000382019052709732678859",
+		OutRefundNO:         "1217752501201407033233368018",
+		TransactionID:       "1217752501201407033233368018",
+		OutTradeNO:          "1217752501201407033233368018",
+		Channel:             "ORIGINAL",
+		UserReceivedAccount: "招商银行信用卡0403",
+		CreateTime:          "2020-12-01T16:18:12+08:00",
+		Status:              "PROCESSING",
+		FundsAccount:        "UNSETTLED",
+		Amount: &RefundAmount{
+			Total:            100,
+			Refund:           60,
+			From:             []*RefundFrom{{Account: "AVAILABLE", Amount: 60}},
+			PayerTotal:       90,
+			PayerRefund:      50,
+			SettlementRefund: 60,
+			SettlementTotal:  100,
+			DiscountRefund:   10,
+			Currency:         "CNY",
+		},
+		PromotionDetail: []*RefundPromotionDetail{
+			{
+				PromotionID:  "109519",
+				Scope:        "SINGLE",
+				Type:         "DISCOUNT",
+				Amount:       10,
+				RefundAmount: 10,
+				GoodsDetail:  req.GoodsDetail,
+			},
+		},
+	}, result)
+
+	// 不修改调用方的请求参数
+	assert.Equal(t, "", req.Amount.Currency)
+}
+
+func TestRefundValidate(t *testing.T) {
+	v3 := New("wx2421b1c4370ec43b", testMchID, testAPIv3Key, testSerialNo, nil)
+
+	_, err := v3.Refund(context.TODO(), nil)
+
+	assert.EqualError(t, err, "gochat: refund request is required")
+
+	_, err = v3.Refund(context.TODO(), &RefundRequest{OutRefundNO: "1217752501201407033233368018"})
+
+	assert.EqualError(t, err, "gochat: transaction_id or out_trade_no is required")
+
+	_, err = v3.Refund(context.TODO(), &RefundRequest{OutTradeNO: "1217752501201407033233368018"})
+
+	assert.EqualError(t, err, "gochat: out_refund_no is required")
+
+	_, err = v3.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO:  "1217752501201407033233368018",
+		OutRefundNO: "1217752501201407033233368018",
+		Amount:      &RefundAmountRequest{Total: 100},
+	})
+
+	assert.EqualError(t, err, "gochat: amount.refund and amount.total must be greater than 0")
+
+	_, err = v3.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO:  "1217752501201407033233368018",
+		OutRefundNO: "1217752501201407033233368018",
+		Amount:      &RefundAmountRequest{Refund: 101, Total: 100},
+	})
+
+	assert.EqualError(t, err, "gochat: amount.refund must not exceed amount.total")
+
+	_, err = v3.QueryRefund(context.TODO(), "")
+
+	assert.EqualError(t, err, "gochat: out_refund_no is required")
+}
+
+func TestQueryRefund(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("GET /v3/refund/domestic/refunds/1217752501201407033233368018", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		platform.sign(t, w, []byte(testRefundResult))
+		w.Write([]byte(testRefundResult))
+	})
+
+	result, err := v3.QueryRefund(context.TODO(), "1217752501201407033233368018")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "50000000382019052709732678859", result.RefundID)
+	assert.Equal(t, "PROCESSING", result.Status)
+	assert.Equal(t, 60, result.Amount.Refund)
+	assert.Equal(t, 1, len(result.PromotionDetail))
+
+	ts.handle("GET /v3/refund/domestic/refunds/1217752501201407033233368019", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"RESOURCE_NOT_EXISTS","message":"退款单不存在"}`))
+	})
+
+	_, err = v3.QueryRefund(context.TODO(), "1217752501201407033233368019")
+
+	assert.EqualError(t, err, "gochat: apiv3 error (status: 404, code: RESOURCE_NOT_EXISTS): 退款单不存在")
+}
+
+func TestParseRefundNotify(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	plaintext := `{"mchid":"1900000100","transaction_id":"1008450740201411110005820873","out_trade_no":"20150806125346","refund_id":"50200207182018070300011301001","out_refund_no":"7752501201407033233368018","refund_status":"SUCCESS","success_time":"2018-06-08T10:34:56+08:00","user_received_account":"招商银行信用卡0403","amount":{"total":999,"refund":999,"payer_total":999,"payer_refund":999}}`
+
+	for _, event := range []string{EventRefundSuccess, EventRefundAbnormal, EventRefundClosed} {
+		w, body := newTestEventNotify(t, platform, testAPIv3Key, event, "refund", plaintext)
+
+		resource, err := v3.ParseNotify(context.TODO(), w.Header(), body)
+
+		assert.Nil(t, err)
+		assert.Equal(t, event, resource.EventType)
+		assert.Nil(t, resource.Transaction)
+		assert.Equal(t, &RefundNotify{
+			MchID:               "1900000100",
+			OutTradeNO:          "20150806125346",
+			TransactionID:       "1008450740201411110005820873",
+			OutRefundNO:         "7752501201407033233368018",
+			RefundID:            "50200207182018070300011301001",
+			RefundStatus:        "SUCCESS",
+			SuccessTime:         "2018-06-08T10:34:56+08:00",
+			UserReceivedAccount: "招商银行信用卡0403",
+			Amount: &RefundNotifyAmount{
+				Total:       999,
+				Refund:      999,
+				PayerTotal:  999,
+				PayerRefund: 999,
+			},
+		}, resource.Refund)
+	}
+
+	// 未知的事件类型仅解密，不解析
+	w, body := newTestEventNotify(t, platform, testAPIv3Key, "UNKNOWN.EVENT", "unknown", `{"foo":"bar"}`)
+
+	resource, err := v3.ParseNotify(context.TODO(), w.Header(), body)
+
+	assert.Nil(t, err)
+	assert.Nil(t, resource.Transaction)
+	assert.Nil(t, resource.Refund)
+	assert.Equal(t, `{"foo":"bar"}`, string(resource.Plaintext))
+}
@@ -0,0 +1,265 @@
+package apiv3
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Amount 订单金额
+type Amount struct {
+	Total    int    `json:"total"`              // 订单总金额，单位为分
+	Currency string `json:"currency,omitempty"` // 货币类型，默认：CNY
+}
+
+// Payer 支付者
+type Payer struct {
+	OpenID string `json:"openid"` // 用户在 appid 下的唯一标识
+}
+
+// H5Info H5场景信息
+type H5Info struct {
+	Type        string `json:"type"`                   // 场景类型，如：iOS、Android、Wap
+	AppName     string `json:"app_name,omitempty"`     // 应用名称
+	AppURL      string `json:"app_url,omitempty"`      // 网站URL
+	BundleID    string `json:"bundle_id,omitempty"`    // iOS平台BundleID
+	PackageName string `json:"package_name,omitempty"` // Android平台PackageName
+}
+
+// SceneInfo 场景信息
+type SceneInfo struct {
+	PayerClientIP string  `json:"payer_client_ip"`     // 用户终端IP
+	DeviceID      string  `json:"device_id,omitempty"` // 商户端设备号
+	H5Info        *H5Info `json:"h5_info,omitempty"`   // H5场景信息（H5下单必填）
+}
+
+// TransactionRequest 下单参数（appid 及 mchid 由 APIv3 实例填充）
+type TransactionRequest struct {
+	Description string     `json:"description"`           // 商品描述
+	OutTradeNO  string     `json:"out_trade_no"`          // 商户订单号
+	TimeExpire  string     `json:"time_expire,omitempty"` // 交易结束时间，rfc3339格式，如：2018-06-08T10:34:56+08:00
+	Attach      string     `json:"attach,omitempty"`      // 附加数据，在查询API和支付通知中原样返回
+	NotifyURL   string     `json:"notify_url"`            // 支付结果通知地址
+	GoodsTag    string     `json:"goods_tag,omitempty"`   // 订单优惠标记
+	Amount      *Amount    `json:"amount"`                // 订单金额
+	Payer       *Payer     `json:"payer,omitempty"`       // 支付者（JSAPI下单必填）
+	SceneInfo   *SceneInfo `json:"scene_info,omitempty"`  // 场景信息（H5下单必填）
+}
+
+// transactionBody 下单请求体
+type transactionBody struct {
+	AppID string `json:"appid"`
+	MchID string `json:"mchid"`
+	*TransactionRequest
+}
+
+func (r *TransactionRequest) validate() error {
+	if r == nil {
+		return errors.New("gochat: transaction request is required")
+	}
+
+	if r.Description == "" {
+		return errors.New("gochat: description is required")
+	}
+
+	if r.OutTradeNO == "" {
+		return errors.New("gochat: out_trade_no is required")
+	}
+
+	if r.NotifyURL == "" {
+		return errors.New("gochat: notify_url is required")
+	}
+
+	if r.Amount == nil || r.Amount.Total <= 0 {
+		return errors.New("gochat: amount.total must be greater than 0")
+	}
+
+	return nil
+}
+
+// transaction 下单，将应答解析到 dest
+func (v3 *APIv3) transaction(ctx context.Context, path string, req *TransactionRequest, dest interface{}) error {
+	return v3.Do(ctx, http.MethodPost, path, &transactionBody{
+		AppID:              v3.appid,
+		MchID:              v3.mchid,
+		TransactionRequest: req,
+	}, dest)
+}
+
+// JSAPI JSAPI/小程序下单，返回 prepay_id（用于 JSAPIParams 生成调起支付参数），payer.openid 必填
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_1.shtml)
+func (v3 *APIv3) JSAPI(ctx context.Context, req *TransactionRequest) (string, error) {
+	if err := req.validate(); err != nil {
+		return "", err
+	}
+
+	if req.Payer == nil || req.Payer.OpenID == "" {
+		return "", errors.New("gochat: payer.openid is required")
+	}
+
+	var resp struct {
+		PrepayID string `json:"prepay_id"`
+	}
+
+	if err := v3.transaction(ctx, TransactionsJSAPIURL, req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.PrepayID, nil
+}
+
+// Native Native下单，返回二维码链接 code_url（如：weixin://wxpay/bizpayurl?pr=p4lpSuKzz）
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_1.shtml)
+func (v3 *APIv3) Native(ctx context.Context, req *TransactionRequest) (string, error) {
+	if err := req.validate(); err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		CodeURL string `json:"code_url"`
+	}
+
+	if err := v3.transaction(ctx, TransactionsNativeURL, req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.CodeURL, nil
+}
+
+// APP APP下单，返回 prepay_id（用于 APPParams 生成调起支付参数）
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_2_1.shtml)
+func (v3 *APIv3) APP(ctx context.Context, req *TransactionRequest) (string, error) {
+	if err := req.validate(); err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		PrepayID string `json:"prepay_id"`
+	}
+
+	if err := v3.transaction(ctx, TransactionsAPPURL, req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.PrepayID, nil
+}
+
+// H5 H5下单，返回支付跳转链接 h5_url，scene_info.payer_client_ip 及 scene_info.h5_info.type 必填
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_3_1.shtml)
+func (v3 *APIv3) H5(ctx context.Context, req *TransactionRequest) (string, error) {
+	if err := req.validate(); err != nil {
+		return "", err
+	}
+
+	if req.SceneInfo == nil || req.SceneInfo.PayerClientIP == "" {
+		return "", errors.New("gochat: scene_info.payer_client_ip is required")
+	}
+
+	if req.SceneInfo.H5Info == nil || req.SceneInfo.H5Info.Type == "" {
+		return "", errors.New("gochat: scene_info.h5_info.type is required")
+	}
+
+	var resp struct {
+		H5URL string `json:"h5_url"`
+	}
+
+	if err := v3.transaction(ctx, TransactionsH5URL, req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.H5URL, nil
+}
+
+// JSAPIPayParams JSAPI调起支付所需参数（wx.requestPayment / WeixinJSBridge）
+type JSAPIPayParams struct {
+	AppID     string `json:"appId"`
+	TimeStamp string `json:"timeStamp"`
+	NonceStr  string `json:"nonceStr"`
+	Package   string `json:"package"`
+	SignType  string `json:"signType"`
+	PaySign   string `json:"paySign"`
+}
+
+// JSAPIParams 根据 prepay_id 生成JSAPI/小程序调起支付参数，使用商户私钥对「appId\ntimeStamp\nnonceStr\npackage\n」进行 SHA256withRSA 签名
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_4.shtml)
+func (v3 *APIv3) JSAPIParams(prepayID string) (*JSAPIPayParams, error) {
+	params := &JSAPIPayParams{
+		AppID:     v3.appid,
+		TimeStamp: strconv.FormatInt(v3.now().Unix(), 10),
+		NonceStr:  v3.nonce(32),
+		Package:   fmt.Sprintf("prepay_id=%s", prepayID),
+		SignType:  SignTypeRSA,
+	}
+
+	sign, err := v3.sign(params.AppID, params.TimeStamp, params.NonceStr, params.Package)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params.PaySign = sign
+
+	return params, nil
+}
+
+// APPPayParams APP调起支付所需参数（微信 OpenSDK 的 PayReq）
+type APPPayParams struct {
+	AppID     string `json:"appid"`
+	PartnerID string `json:"partnerid"`
+	PrepayID  string `json:"prepayid"`
+	Package   string `json:"package"`
+	NonceStr  string `json:"noncestr"`
+	Timestamp string `json:"timestamp"`
+	Sign      string `json:"sign"`
+}
+
+// APPParams 根据 prepay_id 生成APP调起支付参数，使用商户私钥对「appid\ntimestamp\nnoncestr\nprepayid\n」进行 SHA256withRSA 签名
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_2_4.shtml)
+func (v3 *APIv3) APPParams(prepayID string) (*APPPayParams, error) {
+	params := &APPPayParams{
+		AppID:     v3.appid,
+		PartnerID: v3.mchid,
+		PrepayID:  prepayID,
+		Package:   "Sign=WXPay",
+		NonceStr:  v3.nonce(32),
+		Timestamp: strconv.FormatInt(v3.now().Unix(), 10),
+	}
+
+	sign, err := v3.sign(params.AppID, params.Timestamp, params.NonceStr, params.PrepayID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params.Sign = sign
+
+	return params, nil
+}
+
+// sign 使用商户私钥对各行（每行以 \n 结尾）进行 SHA256withRSA 签名，返回 Base64 编码的签名
+func (v3 *APIv3) sign(lines ...string) (string, error) {
+	if v3.privateKey == nil {
+		return "", errors.New("gochat: invalid rsa private key")
+	}
+
+	h := sha256.New()
+
+	for _, v := range lines {
+		h.Write([]byte(v + "\n"))
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, v3.privateKey, crypto.SHA256, h.Sum(nil))
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
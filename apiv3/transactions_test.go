@@ -0,0 +1,248 @@
+package apiv3
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactions(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	cases := []struct {
+		path string
+		body string
+		resp string
+		call func(ctx context.Context, req *TransactionRequest) (string, error)
+		req  *TransactionRequest
+		want string
+	}{
+		{
+			path: TransactionsJSAPIURL,
+			body: `{"appid":"wx2421b1c4370ec43b","mchid":"10000100","description":"Image形象店-深圳腾大-QQ公仔","out_trade_no":"1217752501201407033233368018","notify_url":"https://www.weixin.qq.com/wxpay/pay.php","amount":{"total":100,"currency":"CNY"},"payer":{"openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}}`,
+			resp: `{"prepay_id":"wx26112221580621e9b071c00d9e093b0000"}`,
+			call: v3.JSAPI,
+			req: &TransactionRequest{
+				Description: "Image形象店-深圳腾大-QQ公仔",
+				OutTradeNO:  "1217752501201407033233368018",
+				NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+				Amount:      &Amount{Total: 100, Currency: "CNY"},
+				Payer:       &Payer{OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+			},
+			want: "wx26112221580621e9b071c00d9e093b0000",
+		},
+		{
+			path: TransactionsNativeURL,
+			body: `{"appid":"wx2421b1c4370ec43b","mchid":"10000100","description":"Image形象店-深圳腾大-QQ公仔","out_trade_no":"1217752501201407033233368018","notify_url":"https://www.weixin.qq.com/wxpay/pay.php","amount":{"total":100}}`,
+			resp: `{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`,
+			call: v3.Native,
+			req: &TransactionRequest{
+				Description: "Image形象店-深圳腾大-QQ公仔",
+				OutTradeNO:  "1217752501201407033233368018",
+				NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+				Amount:      &Amount{Total: 100},
+			},
+			want: "weixin://wxpay/bizpayurl?pr=p4lpSuKzz",
+		},
+		{
+			path: TransactionsAPPURL,
+			body: `{"appid":"wx2421b1c4370ec43b","mchid":"10000100","description":"Image形象店-深圳腾大-QQ公仔","out_trade_no":"1217752501201407033233368018","attach":"自定义数据","notify_url":"https://www.weixin.qq.com/wxpay/pay.php","amount":{"total":100}}`,
+			resp: `{"prepay_id":"wx26112221580621e9b071c00d9e093b0000"}`,
+			call: v3.APP,
+			req: &TransactionRequest{
+				Description: "Image形象店-深圳腾大-QQ公仔",
+				OutTradeNO:  "1217752501201407033233368018",
+				Attach:      "自定义数据",
+				NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+				Amount:      &Amount{Total: 100},
+			},
+			want: "wx26112221580621e9b071c00d9e093b0000",
+		},
+		{
+			path: TransactionsH5URL,
+			body: `{"appid":"wx2421b1c4370ec43b","mchid":"10000100","description":"Image形象店-深圳腾大-QQ公仔","out_trade_no":"1217752501201407033233368018","notify_url":"https://www.weixin.qq.com/wxpay/pay.php","amount":{"total":100},"scene_info":{"payer_client_ip":"14.23.150.211","h5_info":{"type":"Wap"}}}`,
+			resp: `{"h5_url":"https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx2916263004719461949c84457c735b0000&package=2150917749"}`,
+			call: v3.H5,
+			req: &TransactionRequest{
+				Description: "Image形象店-深圳腾大-QQ公仔",
+				OutTradeNO:  "1217752501201407033233368018",
+				NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+				Amount:      &Amount{Total: 100},
+				SceneInfo: &SceneInfo{
+					PayerClientIP: "14.23.150.211",
+					H5Info:        &H5Info{Type: "Wap"},
+				},
+			},
+			want: "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx2916263004719461949c84457c735b0000&package=2150917749",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		ts.handle("POST "+c.path, func(w http.ResponseWriter, r *http.Request, body []byte) {
+			assert.JSONEq(t, c.body, string(body))
+
+			platform.sign(t, w, []byte(c.resp))
+			w.Write([]byte(c.resp))
+		})
+
+		result, err := c.call(context.TODO(), c.req)
+
+		assert.Nil(t, err, c.path)
+		assert.Equal(t, c.want, result, c.path)
+	}
+}
+
+func TestTransactionsValidate(t *testing.T) {
+	v3 := New("wx2421b1c4370ec43b", testMchID, testAPIv3Key, testSerialNo, nil)
+
+	req := func() *TransactionRequest {
+		return &TransactionRequest{
+			Description: "Image形象店-深圳腾大-QQ公仔",
+			OutTradeNO:  "1217752501201407033233368018",
+			NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+			Amount:      &Amount{Total: 100},
+		}
+	}
+
+	_, err := v3.Native(context.TODO(), nil)
+
+	assert.EqualError(t, err, "gochat: transaction request is required")
+
+	r := req()
+	r.Description = ""
+
+	_, err = v3.Native(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: description is required")
+
+	r = req()
+	r.OutTradeNO = ""
+
+	_, err = v3.APP(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: out_trade_no is required")
+
+	r = req()
+	r.NotifyURL = ""
+
+	_, err = v3.APP(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: notify_url is required")
+
+	r = req()
+	r.Amount = &Amount{}
+
+	_, err = v3.Native(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: amount.total must be greater than 0")
+
+	_, err = v3.JSAPI(context.TODO(), req())
+
+	assert.EqualError(t, err, "gochat: payer.openid is required")
+
+	_, err = v3.H5(context.TODO(), req())
+
+	assert.EqualError(t, err, "gochat: scene_info.payer_client_ip is required")
+
+	r = req()
+	r.SceneInfo = &SceneInfo{PayerClientIP: "14.23.150.211"}
+
+	_, err = v3.H5(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: scene_info.h5_info.type is required")
+}
+
+func TestTransactionsError(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("POST "+TransactionsNativeURL, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":"ORDERPAID","message":"该订单已支付"}`))
+	})
+
+	_, err := v3.Native(context.TODO(), &TransactionRequest{
+		Description: "Image形象店-深圳腾大-QQ公仔",
+		OutTradeNO:  "1217752501201407033233368018",
+		NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+		Amount:      &Amount{Total: 100},
+	})
+
+	assert.True(t, errors.Is(err, &Error{Code: "ORDERPAID"}))
+	assert.EqualError(t, err, "gochat: apiv3 error (status: 403, code: ORDERPAID): 该订单已支付")
+}
+
+func TestPayParams(t *testing.T) {
+	v3, ts := newTestAPIv3(t)
+
+	ts.Close()
+
+	WithNonceFunc(func(size int) string {
+		return "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+	})(v3)
+	v3.now = func() time.Time {
+		return time.Unix(1414561699, 0)
+	}
+
+	verify := func(sign, message string) {
+		signature, err := base64.StdEncoding.DecodeString(sign)
+
+		assert.Nil(t, err)
+
+		h := sha256.Sum256([]byte(message))
+
+		assert.Nil(t, rsa.VerifyPKCS1v15(&v3.privateKey.PublicKey, crypto.SHA256, h[:], signature))
+	}
+
+	jsapi, err := v3.JSAPIParams("wx201410272009395522657a690389285100")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "wx2421b1c4370ec43b", jsapi.AppID)
+	assert.Equal(t, "1414561699", jsapi.TimeStamp)
+	assert.Equal(t, "5K8264ILTKCH16CQ2502SI8ZNMTM67VS", jsapi.NonceStr)
+	assert.Equal(t, "prepay_id=wx201410272009395522657a690389285100", jsapi.Package)
+	assert.Equal(t, "RSA", jsapi.SignType)
+
+	verify(jsapi.PaySign, "wx2421b1c4370ec43b\n1414561699\n5K8264ILTKCH16CQ2502SI8ZNMTM67VS\nprepay_id=wx201410272009395522657a690389285100\n")
+
+	app, err := v3.APPParams("WX1217752501201407033233368018")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &APPPayParams{
+		AppID:     "wx2421b1c4370ec43b",
+		PartnerID: "10000100",
+		PrepayID:  "WX1217752501201407033233368018",
+		Package:   "Sign=WXPay",
+		NonceStr:  "5K8264ILTKCH16CQ2502SI8ZNMTM67VS",
+		Timestamp: "1414561699",
+		Sign:      app.Sign,
+	}, app)
+
+	verify(app.Sign, "wx2421b1c4370ec43b\n1414561699\n5K8264ILTKCH16CQ2502SI8ZNMTM67VS\nWX1217752501201407033233368018\n")
+
+	// 未指定商户私钥
+	v3 = New("wx2421b1c4370ec43b", testMchID, testAPIv3Key, testSerialNo, nil)
+
+	_, err = v3.JSAPIParams("wx201410272009395522657a690389285100")
+
+	assert.EqualError(t, err, "gochat: invalid rsa private key")
+}
@@ -0,0 +1,315 @@
+package apiv3
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 转账批次状态
+const (
+	TransferBatchWaitPay    = "WAIT_PAY"   // 待付款确认
+	TransferBatchAccepted   = "ACCEPTED"   // 已受理
+	TransferBatchProcessing = "PROCESSING" // 转账中
+	TransferBatchFinished   = "FINISHED"   // 已完成
+	TransferBatchClosed     = "CLOSED"     // 已关闭
+)
+
+// 转账明细状态
+const (
+	TransferDetailInit       = "INIT"       // 初始态
+	TransferDetailWaitPay    = "WAIT_PAY"   // 待确认
+	TransferDetailProcessing = "PROCESSING" // 转账中
+	TransferDetailSuccess    = "SUCCESS"    // 转账成功
+	TransferDetailFail       = "FAIL"       // 转账失败
+)
+
+// TransferDetail 转账明细
+type TransferDetail struct {
+	OutDetailNO    string `json:"out_detail_no"`       // 商家明细单号
+	TransferAmount int    `json:"transfer_amount"`     // 转账金额，单位为分
+	TransferRemark string `json:"transfer_remark"`     // 转账备注
+	OpenID         string `json:"openid"`              // 收款用户 openid
+	UserName       string `json:"user_name,omitempty"` // 收款用户姓名（明文，由 TransferBatch 使用平台证书加密），转账金额 >= 2000元时必填
+}
+
+// TransferBatchRequest 发起商家转账参数（appid 由 APIv3 实例填充）
+type TransferBatchRequest struct {
+	OutBatchNO         string            `json:"out_batch_no"`                // 商家批次单号
+	BatchName          string            `json:"batch_name"`                  // 批次名称
+	BatchRemark        string            `json:"batch_remark"`                // 批次备注
+	TotalAmount        int               `json:"total_amount"`                // 转账总金额，单位为分，须与明细金额之和一致
+	TotalNum           int               `json:"total_num"`                   // 转账总笔数，须与明细笔数一致
+	TransferDetailList []*TransferDetail `json:"transfer_detail_list"`        // 转账明细列表
+	TransferSceneID    string            `json:"transfer_scene_id,omitempty"` // 转账场景ID
+	NotifyURL          string            `json:"notify_url,omitempty"`        // 批次回调通知地址
+}
+
+// transferBatchBody 发起商家转账请求体
+type transferBatchBody struct {
+	AppID string `json:"appid"`
+	*TransferBatchRequest
+}
+
+// TransferBatchResult 发起商家转账的应答
+type TransferBatchResult struct {
+	OutBatchNO  string `json:"out_batch_no"` // 商家批次单号
+	BatchID     string `json:"batch_id"`     // 微信批次单号
+	CreateTime  string `json:"create_time"`  // 批次创建时间，rfc3339格式
+	BatchStatus string `json:"batch_status"` // 批次状态
+}
+
+// TransferBatch 转账批次单
+type TransferBatch struct {
+	MchID           string `json:"mchid"`             // 商户号
+	OutBatchNO      string `json:"out_batch_no"`      // 商家批次单号
+	BatchID         string `json:"batch_id"`          // 微信批次单号
+	AppID           string `json:"appid"`             // 商户appid
+	BatchStatus     string `json:"batch_status"`      // 批次状态，如：ACCEPTED、PROCESSING、FINISHED、CLOSED
+	BatchType       string `json:"batch_type"`        // 批次类型，如：API、WEB
+	BatchName       string `json:"batch_name"`        // 批次名称
+	BatchRemark     string `json:"batch_remark"`      // 批次备注
+	CloseReason     string `json:"close_reason"`      // 批次关闭原因
+	TotalAmount     int    `json:"total_amount"`      // 转账总金额，单位为分
+	TotalNum        int    `json:"total_num"`         // 转账总笔数
+	CreateTime      string `json:"create_time"`       // 批次创建时间，rfc3339格式
+	UpdateTime      string `json:"update_time"`       // 批次更新时间，rfc3339格式
+	SuccessAmount   int    `json:"success_amount"`    // 转账成功金额，单位为分
+	SuccessNum      int    `json:"success_num"`       // 转账成功笔数
+	FailAmount      int    `json:"fail_amount"`       // 转账失败金额，单位为分
+	FailNum         int    `json:"fail_num"`          // 转账失败笔数
+	TransferSceneID string `json:"transfer_scene_id"` // 转账场景ID
+}
+
+// TransferDetailBrief 批次单中的明细单
+type TransferDetailBrief struct {
+	DetailID     string `json:"detail_id"`     // 微信明细单号
+	OutDetailNO  string `json:"out_detail_no"` // 商家明细单号
+	DetailStatus string `json:"detail_status"` // 明细状态，如：PROCESSING、SUCCESS、FAIL
+}
+
+// TransferBatchQuery 查询批次单参数
+type TransferBatchQuery struct {
+	OutBatchNO      string // 商家批次单号
+	NeedQueryDetail bool   // 是否查询明细单
+	Offset          int    // 明细单的起始位置，默认：0
+	Limit           int    // 明细单的最大数量，默认：20，最大：100
+	DetailStatus    string // 明细状态（查询明细单时有效），如：ALL、SUCCESS、FAIL
+}
+
+// TransferBatchQueryResult 查询批次单的应答
+type TransferBatchQueryResult struct {
+	TransferBatch      *TransferBatch         `json:"transfer_batch"`       // 转账批次单
+	TransferDetailList []*TransferDetailBrief `json:"transfer_detail_list"` // 转账明细单列表
+	Offset             int                    `json:"offset"`               // 请求资源的起始位置
+	Limit              int                    `json:"limit"`                // 最大资源条数
+}
+
+// TransferDetailResult 转账明细单
+type TransferDetailResult struct {
+	MchID          string `json:"mchid"`           // 商户号
+	OutBatchNO     string `json:"out_batch_no"`    // 商家批次单号
+	BatchID        string `json:"batch_id"`        // 微信批次单号
+	AppID          string `json:"appid"`           // 商户appid
+	OutDetailNO    string `json:"out_detail_no"`   // 商家明细单号
+	DetailID       string `json:"detail_id"`       // 微信明细单号
+	DetailStatus   string `json:"detail_status"`   // 明细状态，如：PROCESSING、SUCCESS、FAIL
+	TransferAmount int    `json:"transfer_amount"` // 转账金额，单位为分
+	TransferRemark string `json:"transfer_remark"` // 转账备注
+	FailReason     string `json:"fail_reason"`     // 失败原因
+	OpenID         string `json:"openid"`          // 收款用户 openid
+	UserName       string `json:"user_name"`       // 收款用户姓名（已使用商户私钥解密）
+	InitiateTime   string `json:"initiate_time"`   // 转账发起时间，rfc3339格式
+	UpdateTime     string `json:"update_time"`     // 明细更新时间，rfc3339格式
+}
+
+// TransferBatch 发起商家转账（转账到零钱），收款用户姓名使用平台证书进行 RSA-OAEP 加密，并通过 Wechatpay-Serial 头指明证书序列号
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter4_3_1.shtml)
+func (v3 *APIv3) TransferBatch(ctx context.Context, req *TransferBatchRequest) (*TransferBatchResult, error) {
+	if req == nil {
+		return nil, errors.New("gochat: transfer batch request is required")
+	}
+
+	if req.OutBatchNO == "" {
+		return nil, errors.New("gochat: out_batch_no is required")
+	}
+
+	if req.BatchName == "" {
+		return nil, errors.New("gochat: batch_name is required")
+	}
+
+	if len(req.TransferDetailList) == 0 {
+		return nil, errors.New("gochat: transfer_detail_list is required")
+	}
+
+	if req.TotalNum != len(req.TransferDetailList) {
+		return nil, errors.New("gochat: total_num must equal the number of transfer details")
+	}
+
+	total := 0
+
+	for _, v := range req.TransferDetailList {
+		if v.OutDetailNO == "" || v.OpenID == "" || v.TransferAmount <= 0 {
+			return nil, errors.New("gochat: transfer detail requires out_detail_no, openid and a positive transfer_amount")
+		}
+
+		total += v.TransferAmount
+	}
+
+	if req.TotalAmount != total {
+		return nil, errors.New("gochat: total_amount must equal the sum of transfer amounts")
+	}
+
+	body := *req
+	header := http.Header{}
+
+	body.TransferDetailList = make([]*TransferDetail, 0, len(req.TransferDetailList))
+
+	// 同一批次的敏感信息使用同一平台证书加密
+	var cert *x509.Certificate
+
+	for _, v := range req.TransferDetailList {
+		detail := *v
+
+		if detail.UserName != "" {
+			if cert == nil {
+				serialNo, c, err := v3.currentCertificate(ctx)
+
+				if err != nil {
+					return nil, err
+				}
+
+				cert = c
+				header.Set(HeaderSerial, serialNo)
+			}
+
+			userName, err := encryptSensitive(cert, detail.UserName)
+
+			if err != nil {
+				return nil, err
+			}
+
+			detail.UserName = userName
+		}
+
+		body.TransferDetailList = append(body.TransferDetailList, &detail)
+	}
+
+	result := new(TransferBatchResult)
+
+	if err := v3.do(ctx, http.MethodPost, TransferBatchesURL, &transferBatchBody{
+		AppID:                v3.appid,
+		TransferBatchRequest: &body,
+	}, result, header); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// QueryTransferBatch 通过商家批次单号查询批次单，NeedQueryDetail 为 true 时同时返回明细单列表
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter4_3_5.shtml)
+func (v3 *APIv3) QueryTransferBatch(ctx context.Context, query *TransferBatchQuery) (*TransferBatchQueryResult, error) {
+	if query == nil || query.OutBatchNO == "" {
+		return nil, errors.New("gochat: out_batch_no is required")
+	}
+
+	params := url.Values{}
+
+	params.Set("need_query_detail", strconv.FormatBool(query.NeedQueryDetail))
+
+	if query.NeedQueryDetail {
+		if query.Offset > 0 {
+			params.Set("offset", strconv.Itoa(query.Offset))
+		}
+
+		if query.Limit > 0 {
+			params.Set("limit", strconv.Itoa(query.Limit))
+		}
+
+		if query.DetailStatus != "" {
+			params.Set("detail_status", query.DetailStatus)
+		}
+	}
+
+	result := new(TransferBatchQueryResult)
+
+	if err := v3.Do(ctx, http.MethodGet, fmt.Sprintf(QueryTransferBatchURL, url.PathEscape(query.OutBatchNO))+"?"+params.Encode(), nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// QueryTransferDetail 通过商家明细单号查询明细单，收款用户姓名使用商户私钥解密
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter4_3_6.shtml)
+func (v3 *APIv3) QueryTransferDetail(ctx context.Context, outBatchNO, outDetailNO string) (*TransferDetailResult, error) {
+	if outBatchNO == "" || outDetailNO == "" {
+		return nil, errors.New("gochat: out_batch_no and out_detail_no are required")
+	}
+
+	result := new(TransferDetailResult)
+
+	if err := v3.Do(ctx, http.MethodGet, fmt.Sprintf(QueryTransferBatchDetailURL, url.PathEscape(outBatchNO), url.PathEscape(outDetailNO)), nil, result); err != nil {
+		return nil, err
+	}
+
+	if result.UserName != "" {
+		userName, err := decryptSensitive(v3.privateKey, result.UserName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.UserName = userName
+	}
+
+	return result, nil
+}
+
+// encryptSensitive 使用平台证书的公钥对敏感信息进行 RSA-OAEP 加密，返回 Base64 编码的密文
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_3.shtml)
+func encryptSensitive(cert *x509.Certificate, plaintext string) (string, error) {
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+
+	if !ok {
+		return "", errors.New("gochat: invalid rsa public key")
+	}
+
+	cipherText, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, publicKey, []byte(plaintext), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+// decryptSensitive 使用商户私钥对应答中的敏感信息进行 RSA-OAEP 解密
+func decryptSensitive(privateKey *rsa.PrivateKey, ciphertext string) (string, error) {
+	if privateKey == nil {
+		return "", errors.New("gochat: invalid rsa private key")
+	}
+
+	cipherText, err := base64.StdEncoding.DecodeString(ciphertext)
+
+	if err != nil {
+		return "", err
+	}
+
+	plainText, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, privateKey, cipherText, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(plainText), nil
+}
@@ -0,0 +1,240 @@
+package apiv3
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferBatch(t *testing.T) {
+	old := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(30*24*time.Hour))
+	renewed := newTestPlatform(t, 0x6A36B2C7D1E0F123, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, old, renewed)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("POST "+TransferBatchesURL, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		// 使用过期时间最晚的平台证书加密
+		assert.Equal(t, "6A36B2C7D1E0F123", r.Header.Get(HeaderSerial))
+
+		var req struct {
+			AppID              string            `json:"appid"`
+			OutBatchNO         string            `json:"out_batch_no"`
+			TotalAmount        int               `json:"total_amount"`
+			TotalNum           int               `json:"total_num"`
+			TransferDetailList []*TransferDetail `json:"transfer_detail_list"`
+		}
+
+		assert.Nil(t, json.Unmarshal(body, &req))
+		assert.Equal(t, "wx2421b1c4370ec43b", req.AppID)
+		assert.Equal(t, "plfk2020042013", req.OutBatchNO)
+		assert.Equal(t, 2, len(req.TransferDetailList))
+		assert.Equal(t, "", req.TransferDetailList[1].UserName)
+
+		cipherText, err := base64.StdEncoding.DecodeString(req.TransferDetailList[0].UserName)
+
+		assert.Nil(t, err)
+
+		userName, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, renewed.key, cipherText, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "张三", string(userName))
+
+		resp := []byte(`{"out_batch_no":"plfk2020042013","batch_id":"1030000071100999991182020050700019480001","create_time":"2015-05-20T13:29:35.120+08:00","batch_status":"ACCEPTED"}`)
+
+		old.sign(t, w, resp)
+		w.Write(resp)
+	})
+
+	req := &TransferBatchRequest{
+		OutBatchNO:  "plfk2020042013",
+		BatchName:   "2019年1月深圳分部报销单",
+		BatchRemark: "2019年1月深圳分部报销单",
+		TotalAmount: 400000,
+		TotalNum:    2,
+		TransferDetailList: []*TransferDetail{
+			{
+				OutDetailNO:    "x23zy545Bd5436",
+				TransferAmount: 200000,
+				TransferRemark: "2020年4月报销",
+				OpenID:         "o-MYE42l80oelYMDE34nYD456Xoy",
+				UserName:       "张三",
+			},
+			{
+				OutDetailNO:    "x23zy545Bd5437",
+				TransferAmount: 200000,
+				TransferRemark: "2020年4月报销",
+				OpenID:         "o-MYE42l80oelYMDE34nYD456Xoz",
+			},
+		},
+	}
+
+	result, err := v3.TransferBatch(context.TODO(), req)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &TransferBatchResult{
+		OutBatchNO:  "plfk2020042013",
+		BatchID:     "1030000071100999991182020050700019480001",
+		CreateTime:  "2015-05-20T13:29:35.120+08:00",
+		BatchStatus: TransferBatchAccepted,
+	}, result)
+
+	// 不修改调用方的请求参数
+	assert.Equal(t, "张三", req.TransferDetailList[0].UserName)
+}
+
+func TestTransferBatchValidate(t *testing.T) {
+	v3 := New("wx2421b1c4370ec43b", testMchID, testAPIv3Key, testSerialNo, nil)
+
+	req := func() *TransferBatchRequest {
+		return &TransferBatchRequest{
+			OutBatchNO:  "plfk2020042013",
+			BatchName:   "2019年1月深圳分部报销单",
+			TotalAmount: 200000,
+			TotalNum:    1,
+			TransferDetailList: []*TransferDetail{
+				{OutDetailNO: "x23zy545Bd5436", TransferAmount: 200000, OpenID: "o-MYE42l80oelYMDE34nYD456Xoy"},
+			},
+		}
+	}
+
+	_, err := v3.TransferBatch(context.TODO(), nil)
+
+	assert.EqualError(t, err, "gochat: transfer batch request is required")
+
+	r := req()
+	r.OutBatchNO = ""
+
+	_, err = v3.TransferBatch(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: out_batch_no is required")
+
+	r = req()
+	r.BatchName = ""
+
+	_, err = v3.TransferBatch(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: batch_name is required")
+
+	r = req()
+	r.TransferDetailList = nil
+
+	_, err = v3.TransferBatch(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: transfer_detail_list is required")
+
+	r = req()
+	r.TotalNum = 2
+
+	_, err = v3.TransferBatch(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: total_num must equal the number of transfer details")
+
+	r = req()
+	r.TotalAmount = 100
+
+	_, err = v3.TransferBatch(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: total_amount must equal the sum of transfer amounts")
+
+	r = req()
+	r.TransferDetailList[0].OpenID = ""
+
+	_, err = v3.TransferBatch(context.TODO(), r)
+
+	assert.EqualError(t, err, "gochat: transfer detail requires out_detail_no, openid and a positive transfer_amount")
+}
+
+func TestQueryTransferBatch(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	ts.handle("GET /v3/transfer/batches/out-batch-no/plfk2020042013", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		assert.Equal(t, "detail_status=FAIL&limit=20&need_query_detail=true", r.URL.RawQuery)
+
+		resp := []byte(`{"limit":20,"offset":0,"transfer_batch":{"mchid":"10000100","out_batch_no":"plfk2020042013","batch_id":"1030000071100999991182020050700019480001","appid":"wx2421b1c4370ec43b","batch_status":"FINISHED","batch_type":"API","batch_name":"2019年1月深圳分部报销单","batch_remark":"2019年1月深圳分部报销单","total_amount":400000,"total_num":2,"create_time":"2015-05-20T13:29:35.120+08:00","update_time":"2015-05-20T13:29:35.120+08:00","success_amount":200000,"success_num":1,"fail_amount":200000,"fail_num":1},"transfer_detail_list":[{"detail_id":"1040000071100999991182020050700019500100","out_detail_no":"x23zy545Bd5437","detail_status":"FAIL"}]}`)
+
+		platform.sign(t, w, resp)
+		w.Write(resp)
+	})
+
+	result, err := v3.QueryTransferBatch(context.TODO(), &TransferBatchQuery{
+		OutBatchNO:      "plfk2020042013",
+		NeedQueryDetail: true,
+		Limit:           20,
+		DetailStatus:    TransferDetailFail,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, TransferBatchFinished, result.TransferBatch.BatchStatus)
+	assert.Equal(t, 200000, result.TransferBatch.FailAmount)
+	assert.Equal(t, []*TransferDetailBrief{
+		{
+			DetailID:     "1040000071100999991182020050700019500100",
+			OutDetailNO:  "x23zy545Bd5437",
+			DetailStatus: TransferDetailFail,
+		},
+	}, result.TransferDetailList)
+
+	_, err = v3.QueryTransferBatch(context.TODO(), &TransferBatchQuery{})
+
+	assert.EqualError(t, err, "gochat: out_batch_no is required")
+}
+
+func TestQueryTransferDetail(t *testing.T) {
+	platform := newTestPlatform(t, 0x5157F09EFDC096DE, time.Now().Add(365*24*time.Hour))
+
+	v3, ts := newTestAPIv3(t, platform)
+
+	defer ts.Close()
+	defer v3.Close()
+
+	// 应答中的收款用户姓名使用商户证书的公钥加密
+	cipherText, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, &v3.privateKey.PublicKey, []byte("张三"), nil)
+
+	assert.Nil(t, err)
+
+	ts.handle("GET /v3/transfer/batches/out-batch-no/plfk2020042013/details/out-detail-no/x23zy545Bd5436", func(w http.ResponseWriter, r *http.Request, body []byte) {
+		resp := []byte(`{"mchid":"10000100","out_batch_no":"plfk2020042013","batch_id":"1030000071100999991182020050700019480001","appid":"wx2421b1c4370ec43b","out_detail_no":"x23zy545Bd5436","detail_id":"1040000071100999991182020050700019500100","detail_status":"SUCCESS","transfer_amount":200000,"transfer_remark":"2020年4月报销","openid":"o-MYE42l80oelYMDE34nYD456Xoy","user_name":"` + base64.StdEncoding.EncodeToString(cipherText) + `","initiate_time":"2015-05-20T13:29:35.120+08:00","update_time":"2015-05-20T13:29:35.120+08:00"}`)
+
+		platform.sign(t, w, resp)
+		w.Write(resp)
+	})
+
+	result, err := v3.QueryTransferDetail(context.TODO(), "plfk2020042013", "x23zy545Bd5436")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &TransferDetailResult{
+		MchID:          "10000100",
+		OutBatchNO:     "plfk2020042013",
+		BatchID:        "1030000071100999991182020050700019480001",
+		AppID:          "wx2421b1c4370ec43b",
+		OutDetailNO:    "x23zy545Bd5436",
+		DetailID:       "1040000071100999991182020050700019500100",
+		DetailStatus:   TransferDetailSuccess,
+		TransferAmount: 200000,
+		TransferRemark: "2020年4月报销",
+		OpenID:         "o-MYE42l80oelYMDE34nYD456Xoy",
+		UserName:       "张三",
+		InitiateTime:   "2015-05-20T13:29:35.120+08:00",
+		UpdateTime:     "2015-05-20T13:29:35.120+08:00",
+	}, result)
+
+	_, err = v3.QueryTransferDetail(context.TODO(), "plfk2020042013", "")
+
+	assert.EqualError(t, err, "gochat: out_batch_no and out_detail_no are required")
+}
@@ -2,6 +2,8 @@
 //
 // /mch ---> 微信支付（普通商户直连模式） ---> 统一下单、支付、退款、查询、委托代扣、企业付款、企业红包 等
 //
+// /apiv3 ---> 微信支付APIv3（普通商户直连模式） ---> 请求签名、应答验签、平台证书下载及自动轮换 等
+//
 // /oa ---> 微信公众号（Official Accounts） ---> 网页授权、用户管理、模板消息、菜单管理、客服、事件消息 等
 //
 // /mp ---> 微信小程序（Mini Program） ---> 小程序授权、数据解密、二维码、消息发送、事件消息 等
@@ -3,14 +3,33 @@ package event
 import (
 	"crypto/aes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 
 	"github.com/shenghui0779/gochat/wx"
 )
 
+// ErrInvalidEncodingAESKey EncodingAESKey 须为43位字符，base64解码后为32字节的AES密钥
+var ErrInvalidEncodingAESKey = errors.New("gochat: invalid encoding aes key")
+
+// decodeAESKey 解码 EncodingAESKey
+func decodeAESKey(encodingAESKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncodingAESKey, err)
+	}
+
+	if len(key) != 32 {
+		return nil, ErrInvalidEncodingAESKey
+	}
+
+	return key, nil
+}
+
 // Encrypt 参考微信[加密技术方案](https://open.weixin.qq.com/cgi-bin/showdocument?action=dir_list&t=resource/res_list&verify=1&id=open1419318482&token=&lang=zh_CN)
 func Encrypt(appid, encodingAESKey, nonce string, plainText []byte) ([]byte, error) {
-	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	key, err := decodeAESKey(encodingAESKey)
 
 	if err != nil {
 		return nil, err
@@ -37,8 +56,9 @@ func Encrypt(appid, encodingAESKey, nonce string, plainText []byte) ([]byte, err
 }
 
 // Decrypt 参考微信[加密技术方案](https://open.weixin.qq.com/cgi-bin/showdocument?action=dir_list&t=resource/res_list&verify=1&id=open1419318482&token=&lang=zh_CN)
+// 明文格式：16字节随机串 + 4字节内容长度（网络字节序） + 内容 + appid
 func Decrypt(appid, encodingAESKey, cipherText string) ([]byte, error) {
-	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	key, err := decodeAESKey(encodingAESKey)
 
 	if err != nil {
 		return nil, err
@@ -57,7 +77,15 @@ func Decrypt(appid, encodingAESKey, cipherText string) ([]byte, error) {
 		return nil, err
 	}
 
-	appidOffset := len(plainText) - len([]byte(appid))
+	if len(plainText) < 20 {
+		return nil, errors.New("invalid plain text length")
+	}
+
+	appidOffset := 20 + int(wx.DecodeBytesToUint32(plainText[16:20]))
+
+	if appidOffset > len(plainText) {
+		return nil, errors.New("invalid content length")
+	}
 
 	// 校验 AppID
 	if v := string(plainText[appidOffset:]); v != appid {
@@ -2,6 +2,7 @@ package event
 
 import (
 	"encoding/base64"
+	"errors"
 	"testing"
 
 	"github.com/shenghui0779/gochat/wx"
@@ -32,3 +33,22 @@ func TestCrypto(t *testing.T) {
 		"Content":      "ILoveGochat",
 	}, msg)
 }
+
+func TestDecryptInvalid(t *testing.T) {
+	appid := "wx1def0e9e5891b338"
+	encodingAESKey := "jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY1U"
+
+	_, err := Encrypt(appid, "jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJ", "343a802b6073aae5", []byte("<xml></xml>"))
+
+	assert.True(t, errors.Is(err, ErrInvalidEncodingAESKey))
+
+	// 明文过短
+	key, _ := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	cb, err := wx.NewCBCCrypto(key, key[:16], wx.PKCS7).Encrypt([]byte("short"))
+
+	assert.Nil(t, err)
+
+	_, err = Decrypt(appid, encodingAESKey, base64.StdEncoding.EncodeToString(cb))
+
+	assert.NotNil(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/xml"
+	"fmt"
 	"sort"
 	"strings"
 )
@@ -67,6 +68,179 @@ type EventMessage struct {
 	Encrypt    string   `xml:"Encrypt"`
 }
 
+// Message 微信推送的消息（已解密的明文XML）
+type Message interface {
+	GetMsgType() MessageType
+}
+
+// MessageHeader 推送消息公共字段
+type MessageHeader struct {
+	XMLName      xml.Name    `xml:"xml"`
+	ToUserName   string      `xml:"ToUserName"`   // 开发者微信号
+	FromUserName string      `xml:"FromUserName"` // 发送方帐号（一个OpenID）
+	CreateTime   int64       `xml:"CreateTime"`   // 消息创建时间 （整型）
+	MsgType      MessageType `xml:"MsgType"`      // 消息类型
+}
+
+// GetMsgType 消息类型
+func (h *MessageHeader) GetMsgType() MessageType {
+	return h.MsgType
+}
+
+// TextMessage 文本消息
+type TextMessage struct {
+	MessageHeader
+	Content string `xml:"Content"` // 文本消息内容
+	MsgID   int64  `xml:"MsgId"`   // 消息id，64位整型
+}
+
+// ImageMessage 图片消息
+type ImageMessage struct {
+	MessageHeader
+	PicURL  string `xml:"PicUrl"`  // 图片链接（由系统生成）
+	MediaID string `xml:"MediaId"` // 图片消息媒体id，可以调用获取临时素材接口拉取数据
+	MsgID   int64  `xml:"MsgId"`   // 消息id，64位整型
+}
+
+// VoiceMessage 语音消息
+type VoiceMessage struct {
+	MessageHeader
+	MediaID     string `xml:"MediaId"`     // 语音消息媒体id，可以调用获取临时素材接口拉取数据
+	Format      string `xml:"Format"`      // 语音格式，如amr，speex等
+	Recognition string `xml:"Recognition"` // 语音识别结果（开通语音识别后才有）
+	MsgID       int64  `xml:"MsgId"`       // 消息id，64位整型
+}
+
+// VideoMessage 视频/小视频消息
+type VideoMessage struct {
+	MessageHeader
+	MediaID      string `xml:"MediaId"`      // 视频消息媒体id，可以调用获取临时素材接口拉取数据
+	ThumbMediaID string `xml:"ThumbMediaId"` // 视频消息缩略图的媒体id，可以调用多媒体文件下载接口拉取数据
+	MsgID        int64  `xml:"MsgId"`        // 消息id，64位整型
+}
+
+// LocationMessage 地理位置消息
+type LocationMessage struct {
+	MessageHeader
+	LocationX float64 `xml:"Location_X"` // 地理位置纬度
+	LocationY float64 `xml:"Location_Y"` // 地理位置经度
+	Scale     int     `xml:"Scale"`      // 地图缩放大小
+	Label     string  `xml:"Label"`      // 地理位置信息
+	MsgID     int64   `xml:"MsgId"`      // 消息id，64位整型
+}
+
+// LinkMessage 链接消息
+type LinkMessage struct {
+	MessageHeader
+	Title       string `xml:"Title"`       // 消息标题
+	Description string `xml:"Description"` // 消息描述
+	URL         string `xml:"Url"`         // 消息链接
+	MsgID       int64  `xml:"MsgId"`       // 消息id，64位整型
+}
+
+// EventHeader 事件推送公共字段
+type EventHeader struct {
+	MessageHeader
+	Event EventType `xml:"Event"` // 事件类型
+}
+
+// GetEvent 事件类型
+func (h *EventHeader) GetEvent() EventType {
+	return h.Event
+}
+
+// SubscribeEvent 关注事件（扫描带参数二维码关注时，EventKey 为 qrscene_ 前缀的场景值）
+type SubscribeEvent struct {
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，qrscene_为前缀，后面为二维码的参数值
+	Ticket   string `xml:"Ticket"`   // 二维码的ticket，可用来换取二维码图片
+}
+
+// UnsubscribeEvent 取消关注事件
+type UnsubscribeEvent struct {
+	EventHeader
+}
+
+// ScanEvent 已关注用户扫描带参数二维码事件
+type ScanEvent struct {
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，是一个32位无符号整数，即创建二维码时的二维码scene_id
+	Ticket   string `xml:"Ticket"`   // 二维码的ticket，可用来换取二维码图片
+}
+
+// LocationEvent 上报地理位置事件
+type LocationEvent struct {
+	EventHeader
+	Latitude  float64 `xml:"Latitude"`  // 地理位置纬度
+	Longitude float64 `xml:"Longitude"` // 地理位置经度
+	Precision float64 `xml:"Precision"` // 地理位置精度
+}
+
+// MenuEvent 自定义菜单事件（点击菜单拉取消息、点击菜单跳转链接）
+type MenuEvent struct {
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，CLICK 时与自定义菜单接口中KEY值对应，VIEW 时为跳转URL
+}
+
+// GenericEvent 未单独定义结构的事件，可通过 wx.ParseXML2Map 获取完整字段
+type GenericEvent struct {
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值
+}
+
+// ParseMessage 解析推送消息（明文XML），根据 MsgType/Event 返回具体的消息结构
+func ParseMessage(body []byte) (Message, error) {
+	header := new(EventHeader)
+
+	if err := xml.Unmarshal(body, header); err != nil {
+		return nil, err
+	}
+
+	var msg Message
+
+	switch header.MsgType {
+	case MessageText:
+		msg = new(TextMessage)
+	case MessageImage:
+		msg = new(ImageMessage)
+	case MessageVoice:
+		msg = new(VoiceMessage)
+	case MessageVideo, MessageShortVideo:
+		msg = new(VideoMessage)
+	case MessageLocation:
+		msg = new(LocationMessage)
+	case MessageLink:
+		msg = new(LinkMessage)
+	case MessageEvent:
+		msg = newEventMessage(header.Event)
+	default:
+		return nil, fmt.Errorf("gochat: unsupported msg_type: %s", header.MsgType)
+	}
+
+	if err := xml.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func newEventMessage(event EventType) Message {
+	switch event {
+	case EventSubscribe:
+		return new(SubscribeEvent)
+	case EventUnSubscribe:
+		return new(UnsubscribeEvent)
+	case EventScan:
+		return new(ScanEvent)
+	case EventLocation:
+		return new(LocationEvent)
+	case EventClick, EventView:
+		return new(MenuEvent)
+	}
+
+	return new(GenericEvent)
+}
+
 // SignWithSHA1 事件消息sha1签名
 func SignWithSHA1(token string, items ...string) string {
 	items = append(items, token)
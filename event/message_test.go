@@ -1,6 +1,7 @@
 package event
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,3 +12,157 @@ func TestSignWithSHA1(t *testing.T) {
 
 	assert.Equal(t, "ffb882ae55647757d3b807ff0e9b6098dfc2bc57", sign)
 }
+
+func TestParseTextMessage(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[gh_3ad31c0ba9b5]]></ToUserName>
+	<FromUserName><![CDATA[oB4tA6ANthOfuQ5XSlkdPsWOVUsY]]></FromUserName>
+	<CreateTime>1606902602</CreateTime>
+	<MsgType><![CDATA[text]]></MsgType>
+	<Content><![CDATA[ILoveGochat]]></Content>
+	<MsgId>1234567890123456</MsgId>
+</xml>`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, MessageText, msg.GetMsgType())
+
+	text, ok := msg.(*TextMessage)
+
+	assert.True(t, ok)
+	assert.Equal(t, "gh_3ad31c0ba9b5", text.ToUserName)
+	assert.Equal(t, "oB4tA6ANthOfuQ5XSlkdPsWOVUsY", text.FromUserName)
+	assert.Equal(t, int64(1606902602), text.CreateTime)
+	assert.Equal(t, "ILoveGochat", text.Content)
+	assert.Equal(t, int64(1234567890123456), text.MsgID)
+}
+
+func TestParseImageMessage(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[toUser]]></ToUserName>
+	<FromUserName><![CDATA[fromUser]]></FromUserName>
+	<CreateTime>1348831860</CreateTime>
+	<MsgType><![CDATA[image]]></MsgType>
+	<PicUrl><![CDATA[http://mmbiz.qpic.cn/image.jpg]]></PicUrl>
+	<MediaId><![CDATA[media_id]]></MediaId>
+	<MsgId>1234567890123456</MsgId>
+</xml>`))
+
+	assert.Nil(t, err)
+
+	image, ok := msg.(*ImageMessage)
+
+	assert.True(t, ok)
+	assert.Equal(t, MessageImage, image.GetMsgType())
+	assert.Equal(t, "http://mmbiz.qpic.cn/image.jpg", image.PicURL)
+	assert.Equal(t, "media_id", image.MediaID)
+}
+
+func TestParseVoiceMessage(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[toUser]]></ToUserName>
+	<FromUserName><![CDATA[fromUser]]></FromUserName>
+	<CreateTime>1357290913</CreateTime>
+	<MsgType><![CDATA[voice]]></MsgType>
+	<MediaId><![CDATA[media_id]]></MediaId>
+	<Format><![CDATA[amr]]></Format>
+	<Recognition><![CDATA[腾讯微信团队]]></Recognition>
+	<MsgId>1234567890123456</MsgId>
+</xml>`))
+
+	assert.Nil(t, err)
+
+	voice, ok := msg.(*VoiceMessage)
+
+	assert.True(t, ok)
+	assert.Equal(t, "media_id", voice.MediaID)
+	assert.Equal(t, "amr", voice.Format)
+	assert.Equal(t, "腾讯微信团队", voice.Recognition)
+}
+
+func TestParseLocationMessage(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[toUser]]></ToUserName>
+	<FromUserName><![CDATA[fromUser]]></FromUserName>
+	<CreateTime>1351776360</CreateTime>
+	<MsgType><![CDATA[location]]></MsgType>
+	<Location_X>23.134521</Location_X>
+	<Location_Y>113.358803</Location_Y>
+	<Scale>20</Scale>
+	<Label><![CDATA[位置信息]]></Label>
+	<MsgId>1234567890123456</MsgId>
+</xml>`))
+
+	assert.Nil(t, err)
+
+	location, ok := msg.(*LocationMessage)
+
+	assert.True(t, ok)
+	assert.Equal(t, 23.134521, location.LocationX)
+	assert.Equal(t, 113.358803, location.LocationY)
+	assert.Equal(t, 20, location.Scale)
+	assert.Equal(t, "位置信息", location.Label)
+}
+
+func TestParseSubscribeEvent(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[toUser]]></ToUserName>
+	<FromUserName><![CDATA[FromUser]]></FromUserName>
+	<CreateTime>123456789</CreateTime>
+	<MsgType><![CDATA[event]]></MsgType>
+	<Event><![CDATA[subscribe]]></Event>
+	<EventKey><![CDATA[qrscene_123123]]></EventKey>
+	<Ticket><![CDATA[TICKET]]></Ticket>
+</xml>`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, MessageEvent, msg.GetMsgType())
+
+	subscribe, ok := msg.(*SubscribeEvent)
+
+	assert.True(t, ok)
+	assert.Equal(t, EventSubscribe, subscribe.GetEvent())
+	assert.Equal(t, "FromUser", subscribe.FromUserName)
+	assert.Equal(t, "qrscene_123123", subscribe.EventKey)
+	assert.Equal(t, "TICKET", subscribe.Ticket)
+}
+
+func TestParseUnsubscribeEvent(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[toUser]]></ToUserName>
+	<FromUserName><![CDATA[FromUser]]></FromUserName>
+	<CreateTime>123456789</CreateTime>
+	<MsgType><![CDATA[event]]></MsgType>
+	<Event><![CDATA[unsubscribe]]></Event>
+</xml>`))
+
+	assert.Nil(t, err)
+
+	unsubscribe, ok := msg.(*UnsubscribeEvent)
+
+	assert.True(t, ok)
+	assert.Equal(t, EventUnSubscribe, unsubscribe.GetEvent())
+	assert.Equal(t, "FromUser", unsubscribe.FromUserName)
+}
+
+func TestParseGenericEvent(t *testing.T) {
+	msg, err := ParseMessage([]byte(`<xml>
+	<ToUserName><![CDATA[toUser]]></ToUserName>
+	<FromUserName><![CDATA[FromUser]]></FromUserName>
+	<CreateTime>123456789</CreateTime>
+	<MsgType><![CDATA[event]]></MsgType>
+	<Event><![CDATA[TEMPLATESENDJOBFINISH]]></Event>
+</xml>`))
+
+	assert.Nil(t, err)
+
+	e, ok := msg.(*GenericEvent)
+
+	assert.True(t, ok)
+	assert.Equal(t, EventTemplateSendJobFinish, e.GetEvent())
+}
+
+func TestParseUnsupportedMessage(t *testing.T) {
+	_, err := ParseMessage([]byte(`<xml><MsgType><![CDATA[unknown]]></MsgType></xml>`))
+
+	assert.Equal(t, errors.New("gochat: unsupported msg_type: unknown"), err)
+}
@@ -1,10 +1,11 @@
 module github.com/shenghui0779/gochat
 
-go 1.12
+go 1.13
 
 require (
 	github.com/golang/mock v1.5.0
 	github.com/stretchr/testify v1.7.0
 	github.com/tidwall/gjson v1.7.4
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/sync v0.0.0-20220907140024-f12130a52804
 )
@@ -0,0 +1,468 @@
+package mch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// BillType 账单类型（BillTypeAll、BillTypeSuccess、BillTypeRefund、BillTypeRechargeRefund）
+type BillType string
+
+// BillRow 账单明细（金额单位为分，账单中不存在的列为零值）
+type BillRow struct {
+	TradeTime       time.Time // 交易时间（北京时间）
+	AppID           string    // 公众账号ID
+	MchID           string    // 商户号
+	SubMchID        string    // 子商户号（特约商户号）
+	DeviceInfo      string    // 设备号
+	TransactionID   string    // 微信订单号
+	OutTradeNO      string    // 商户订单号
+	OpenID          string    // 用户标识
+	TradeType       string    // 交易类型
+	TradeState      string    // 交易状态
+	BankType        string    // 付款银行
+	FeeType         string    // 货币种类
+	TotalFee        int       // 应结订单金额（总金额）
+	CouponFee       int       // 代金券金额（代金券或立减优惠金额）
+	RefundID        string    // 微信退款单号
+	OutRefundNO     string    // 商户退款单号
+	RefundFee       int       // 退款金额
+	CouponRefundFee int       // 充值券退款金额（代金券或立减优惠退款金额）
+	RefundType      string    // 退款类型
+	RefundStatus    string    // 退款状态
+	Body            string    // 商品名称
+	Attach          string    // 商户数据包
+	ServiceFee      int       // 手续费
+	Rate            string    // 费率（如：0.60%）
+	OrderFee        int       // 订单金额
+	ApplyRefundFee  int       // 申请退款金额
+	RateRemark      string    // 费率备注
+}
+
+// BillSummary 账单汇总（金额单位为分）
+type BillSummary struct {
+	TotalCount           int // 总交易单数
+	TotalFee             int // 应结订单总金额（总交易额）
+	TotalRefundFee       int // 退款总金额
+	TotalCouponRefundFee int // 充值券退款总金额（总代金券或立减优惠退款金额）
+	TotalServiceFee      int // 手续费总金额
+	TotalOrderFee        int // 订单总金额
+	TotalApplyRefundFee  int // 申请退款总金额
+}
+
+// Bill 交易账单
+type Bill struct {
+	Raw     []byte       // 账单原始内容（CSV文本，已解压）
+	Header  []string     // 明细表头
+	Rows    []*BillRow   // 账单明细
+	Summary *BillSummary // 汇总数据（账单末尾的统计行）
+}
+
+// readBillResponse 读取下载账单接口的应答，返回CSV文本：
+// 失败时返回XML（以 < 开头），成功时返回CSV文本（tar_type=GZIP 时为gzip压缩，以 0x1f 0x8b 开头）
+func readBillResponse(resp []byte) ([]byte, error) {
+	b := bytes.TrimLeft(resp, " \t\r\n")
+
+	if bytes.HasPrefix(b, []byte("<")) {
+		result, err := wx.ParseXML2Map(b)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if result["return_code"] != ResultSuccess {
+			return nil, newReturnError(result)
+		}
+
+		return nil, fmt.Errorf("gochat: unexpected bill response: %s", b)
+	}
+
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+
+		if err != nil {
+			return nil, fmt.Errorf("gochat: invalid gzip bill: %w", err)
+		}
+
+		if b, err = ioutil.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("gochat: invalid gzip bill: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// ParseBill 解析CSV格式的交易账单（字段值前的 ` 会被去除），按表头列名解析明细，末尾的统计行解析为汇总数据
+func ParseBill(b []byte) (*Bill, error) {
+	table, err := readBillTable(b, "总交易单数")
+
+	if err != nil {
+		return nil, err
+	}
+
+	bill := &Bill{
+		Raw:    b,
+		Header: table.header,
+	}
+
+	for _, record := range table.rows {
+		row, err := parseBillRow(table.header, record)
+
+		if err != nil {
+			return nil, err
+		}
+
+		bill.Rows = append(bill.Rows, row)
+	}
+
+	if table.summary != nil {
+		if bill.Summary, err = parseBillSummary(table.summaryHeader, table.summary); err != nil {
+			return nil, err
+		}
+	}
+
+	return bill, nil
+}
+
+// billTable CSV账单的内容：明细表头及明细，汇总表头及汇总数据
+type billTable struct {
+	header        []string
+	rows          [][]string
+	summaryHeader []string
+	summary       []string
+}
+
+// readBillTable 读取CSV账单（交易账单、资金账单），首列为 summaryKey 的行为汇总表头
+func readBillTable(b []byte, summaryKey string) (*billTable, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))))
+
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	table := new(billTable)
+
+	for {
+		record, err := r.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("gochat: invalid bill: %w", err)
+		}
+
+		for i, v := range record {
+			record[i] = strings.TrimPrefix(strings.TrimSpace(v), "`")
+		}
+
+		switch {
+		case table.header == nil:
+			table.header = record
+		case table.summaryHeader == nil && record[0] == summaryKey:
+			table.summaryHeader = record
+		case table.summaryHeader == nil:
+			table.rows = append(table.rows, record)
+		case table.summary == nil:
+			table.summary = record
+		}
+	}
+
+	return table, nil
+}
+
+// billColumns 账单列名与字段的映射，同一字段可对应多个列名（账单版本不同，列名不同）
+type billColumns struct {
+	strs   map[string]*string
+	fees   map[string]*int // 金额（元）转换为分
+	counts map[string]*int
+	times  map[string]*time.Time
+}
+
+// scan 按表头列名将 record 的值写入对应字段，未知的列忽略
+func (c *billColumns) scan(header, record []string) error {
+	var err error
+
+	for i, name := range header {
+		if i >= len(record) {
+			break
+		}
+
+		v := record[i]
+
+		if dest, ok := c.strs[name]; ok {
+			*dest = v
+
+			continue
+		}
+
+		if dest, ok := c.fees[name]; ok {
+			if *dest, err = yuanToFen(v); err != nil {
+				return fmt.Errorf("gochat: invalid bill %s: %w", name, err)
+			}
+
+			continue
+		}
+
+		if dest, ok := c.counts[name]; ok {
+			if *dest, err = parseBillCount(v); err != nil {
+				return fmt.Errorf("gochat: invalid bill %s: %w", name, err)
+			}
+
+			continue
+		}
+
+		if dest, ok := c.times[name]; ok {
+			if *dest, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+				return fmt.Errorf("gochat: invalid bill %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseBillRow(header, record []string) (*BillRow, error) {
+	row := new(BillRow)
+
+	columns := &billColumns{
+		strs: map[string]*string{
+			"公众账号ID": &row.AppID,
+			"商户号":    &row.MchID,
+			"子商户号":   &row.SubMchID,
+			"特约商户号":  &row.SubMchID,
+			"设备号":    &row.DeviceInfo,
+			"微信订单号":  &row.TransactionID,
+			"商户订单号":  &row.OutTradeNO,
+			"用户标识":   &row.OpenID,
+			"交易类型":   &row.TradeType,
+			"交易状态":   &row.TradeState,
+			"付款银行":   &row.BankType,
+			"货币种类":   &row.FeeType,
+			"微信退款单号": &row.RefundID,
+			"商户退款单号": &row.OutRefundNO,
+			"退款类型":   &row.RefundType,
+			"退款状态":   &row.RefundStatus,
+			"商品名称":   &row.Body,
+			"商户数据包":  &row.Attach,
+			"费率":     &row.Rate,
+			"费率备注":   &row.RateRemark,
+		},
+		fees: map[string]*int{
+			"总金额":          &row.TotalFee,
+			"应结订单金额":       &row.TotalFee,
+			"代金券或立减优惠金额":   &row.CouponFee,
+			"代金券金额":        &row.CouponFee,
+			"退款金额":         &row.RefundFee,
+			"代金券或立减优惠退款金额": &row.CouponRefundFee,
+			"充值券退款金额":      &row.CouponRefundFee,
+			"手续费":          &row.ServiceFee,
+			"订单金额":         &row.OrderFee,
+			"申请退款金额":       &row.ApplyRefundFee,
+		},
+		times: map[string]*time.Time{
+			"交易时间": &row.TradeTime,
+		},
+	}
+
+	if err := columns.scan(header, record); err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+func parseBillSummary(header, record []string) (*BillSummary, error) {
+	summary := new(BillSummary)
+
+	columns := &billColumns{
+		fees: map[string]*int{
+			"总交易额":          &summary.TotalFee,
+			"应结订单总金额":       &summary.TotalFee,
+			"总退款金额":         &summary.TotalRefundFee,
+			"退款总金额":         &summary.TotalRefundFee,
+			"总代金券或立减优惠退款金额": &summary.TotalCouponRefundFee,
+			"充值券退款总金额":      &summary.TotalCouponRefundFee,
+			"手续费总金额":        &summary.TotalServiceFee,
+			"订单总金额":         &summary.TotalOrderFee,
+			"申请退款总金额":       &summary.TotalApplyRefundFee,
+		},
+		counts: map[string]*int{
+			"总交易单数": &summary.TotalCount,
+		},
+	}
+
+	if err := columns.scan(header, record); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// FundAccountType 资金账户类型
+type FundAccountType string
+
+// FundFlowRow 资金账单明细（金额单位为分）
+type FundFlowRow struct {
+	BillingTime      time.Time // 记账时间（北京时间）
+	BizTransactionID string    // 微信支付业务单号
+	FundFlowID       string    // 资金流水单号
+	BizName          string    // 业务名称
+	BizType          string    // 业务类型
+	FinancialType    string    // 收支类型（收入、支出）
+	Amount           int       // 收支金额
+	Balance          int       // 账户结余
+	Applicant        string    // 资金变更提交申请人
+	Memo             string    // 备注
+	BizVoucherID     string    // 业务凭证号
+}
+
+// FundFlowSummary 资金账单汇总（金额单位为分）
+type FundFlowSummary struct {
+	TotalCount        int // 资金流水总笔数
+	IncomeCount       int // 收入笔数
+	IncomeAmount      int // 收入金额
+	ExpenditureCount  int // 支出笔数
+	ExpenditureAmount int // 支出金额
+}
+
+// FundFlow 资金账单
+type FundFlow struct {
+	Raw     []byte           // 账单原始内容（CSV文本，已解压）
+	Header  []string         // 明细表头
+	Rows    []*FundFlowRow   // 账单明细
+	Summary *FundFlowSummary // 汇总数据（账单末尾的统计行）
+}
+
+// ParseFundFlow 解析CSV格式的资金账单（字段值前的 ` 会被去除），按表头列名解析明细，末尾的统计行解析为汇总数据
+func ParseFundFlow(b []byte) (*FundFlow, error) {
+	table, err := readBillTable(b, "资金流水总笔数")
+
+	if err != nil {
+		return nil, err
+	}
+
+	flow := &FundFlow{
+		Raw:    b,
+		Header: table.header,
+	}
+
+	for _, record := range table.rows {
+		row := new(FundFlowRow)
+
+		columns := &billColumns{
+			strs: map[string]*string{
+				"微信支付业务单号":  &row.BizTransactionID,
+				"资金流水单号":    &row.FundFlowID,
+				"业务名称":      &row.BizName,
+				"业务类型":      &row.BizType,
+				"收支类型":      &row.FinancialType,
+				"资金变更提交申请人": &row.Applicant,
+				"备注":        &row.Memo,
+				"业务凭证号":     &row.BizVoucherID,
+			},
+			fees: map[string]*int{
+				"收支金额（元）": &row.Amount,
+				"账户结余（元）": &row.Balance,
+			},
+			times: map[string]*time.Time{
+				"记账时间": &row.BillingTime,
+			},
+		}
+
+		if err = columns.scan(table.header, record); err != nil {
+			return nil, err
+		}
+
+		flow.Rows = append(flow.Rows, row)
+	}
+
+	if table.summary != nil {
+		summary := new(FundFlowSummary)
+
+		columns := &billColumns{
+			fees: map[string]*int{
+				"收入金额": &summary.IncomeAmount,
+				"支出金额": &summary.ExpenditureAmount,
+			},
+			counts: map[string]*int{
+				"资金流水总笔数": &summary.TotalCount,
+				"收入笔数":    &summary.IncomeCount,
+				"支出笔数":    &summary.ExpenditureCount,
+			},
+		}
+
+		if err = columns.scan(table.summaryHeader, table.summary); err != nil {
+			return nil, err
+		}
+
+		flow.Summary = summary
+	}
+
+	return flow, nil
+}
+
+// parseBillCount 解析账单中的笔数（资金账单中笔数形如：20.0）
+func parseBillCount(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if i := strings.Index(s, "."); i >= 0 && strings.Trim(s[i+1:], "0") == "" {
+		s = s[:i]
+	}
+
+	return strconv.Atoi(s)
+}
+
+// yuanToFen 将以元为单位的金额（如：0.01、-1.2）转换为分，避免浮点误差
+func yuanToFen(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	neg := strings.HasPrefix(s, "-")
+
+	s = strings.TrimPrefix(s, "-")
+
+	parts := strings.SplitN(s, ".", 2)
+
+	yuan, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return 0, err
+	}
+
+	fen := 0
+
+	if len(parts) == 2 {
+		frac := parts[1]
+
+		if len(frac) > 2 {
+			return 0, fmt.Errorf("too many decimal places: %s", s)
+		}
+
+		frac += strings.Repeat("0", 2-len(frac))
+
+		if fen, err = strconv.Atoi(frac); err != nil {
+			return 0, err
+		}
+	}
+
+	v := yuan*100 + fen
+
+	if neg {
+		v = -v
+	}
+
+	return v, nil
+}
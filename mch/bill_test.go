@@ -0,0 +1,116 @@
+package mch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBill(t *testing.T) {
+	// 字段值以 ` 开头，列名随账单版本变化（应结订单金额、充值券退款金额 等）
+	b := []byte("交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\r\n" +
+		"`2018-11-19 16:24:13,`wx2421b1c4370ec43b,`10000100,`0,`,`4200000215201811190261405420,`71106718111915575302817,`oUpF8uMEb4qRXf22hE3X68TekukE,`JSAPI,`REFUND,`CFT,`CNY,`0.00,`0.00,`50000408942018111907145868882,`131811191610442717309,`39.60,`0.00,`ORIGINAL,`SUCCESS,`测试商品,`,`-0.24,`0.60%,`0.00,`39.60,`\r\n" +
+		"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\r\n" +
+		"`1,`0.00,`39.60,`0.00,`-0.24,`0.00,`39.60\r\n")
+
+	bill, err := ParseBill(b)
+
+	assert.Nil(t, err)
+	assert.Equal(t, b, bill.Raw)
+	assert.Equal(t, []*BillRow{
+		{
+			TradeTime:      time.Date(2018, 11, 19, 16, 24, 13, 0, beijing),
+			AppID:          "wx2421b1c4370ec43b",
+			MchID:          "10000100",
+			SubMchID:       "0",
+			TransactionID:  "4200000215201811190261405420",
+			OutTradeNO:     "71106718111915575302817",
+			OpenID:         "oUpF8uMEb4qRXf22hE3X68TekukE",
+			TradeType:      TradeJSAPI,
+			TradeState:     TradeStateRefund,
+			BankType:       "CFT",
+			FeeType:        "CNY",
+			RefundID:       "50000408942018111907145868882",
+			OutRefundNO:    "131811191610442717309",
+			RefundFee:      3960,
+			RefundType:     RefundChannelOriginal,
+			RefundStatus:   RefundStatusSuccess,
+			Body:           "测试商品",
+			ServiceFee:     -24,
+			Rate:           "0.60%",
+			ApplyRefundFee: 3960,
+		},
+	}, bill.Rows)
+	assert.Equal(t, &BillSummary{
+		TotalCount:          1,
+		TotalRefundFee:      3960,
+		TotalServiceFee:     -24,
+		TotalApplyRefundFee: 3960,
+	}, bill.Summary)
+}
+
+func TestParseBillInvalidAmount(t *testing.T) {
+	_, err := ParseBill([]byte("交易时间,总金额\n2014-11-10 16:33:45,abc\n"))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "gochat: invalid bill 总金额")
+}
+
+func TestYuanToFen(t *testing.T) {
+	cases := map[string]int{
+		"":       0,
+		"0":      0,
+		"0.0":    0,
+		"0.01":   1,
+		"0.6":    60,
+		"39.60":  3960,
+		"-0.24":  -24,
+		"100.00": 10000,
+	}
+
+	for s, want := range cases {
+		v, err := yuanToFen(s)
+
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, v, s)
+	}
+
+	_, err := yuanToFen("0.001")
+
+	assert.NotNil(t, err)
+}
+
+func TestParseFundFlowWithoutSummary(t *testing.T) {
+	flow, err := ParseFundFlow([]byte("记账时间,资金流水单号,收支金额（元）\n`2018-02-01 04:21:23,`1900009231201802015884652186,`-0.5\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*FundFlowRow{
+		{
+			BillingTime: time.Date(2018, 2, 1, 4, 21, 23, 0, beijing),
+			FundFlowID:  "1900009231201802015884652186",
+			Amount:      -50,
+		},
+	}, flow.Rows)
+	assert.Nil(t, flow.Summary)
+}
+
+func TestParseBillCount(t *testing.T) {
+	cases := map[string]int{
+		"":     0,
+		"2":    2,
+		"20.0": 20,
+		"3.00": 3,
+	}
+
+	for s, want := range cases {
+		v, err := parseBillCount(s)
+
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, v, s)
+	}
+
+	_, err := parseBillCount("2.5")
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,78 @@
+package mch
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// DownloadCertificates 下载微信支付平台证书（APIv3，需通过 WithAPIv3 指定APIv3密钥及商户私钥），
+// 使用商户私钥生成 Authorization 头，并用APIv3密钥解密各证书（AEAD_AES_256_GCM），验证应答签名（首次下载时使用下载的证书验签），
+// 下载的证书按序列号缓存，供 PlatformCertificate 使用
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/wechatpay5_1.shtml)
+func (mch *Mch) DownloadCertificates(ctx context.Context, options ...wx.HTTPOption) ([]*x509.Certificate, error) {
+	if len(mch.apiv3Key) == 0 || mch.privateKey == nil {
+		return nil, errors.New("gochat: apiv3 key and merchant private key are required (see WithAPIv3)")
+	}
+
+	result, err := mch.platform.Download(ctx, mch.apiv3Key, mch.certificatesFetcher(options), time.Unix(mch.timestamp(), 0))
+
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make([]*x509.Certificate, 0, len(result))
+
+	for _, v := range result {
+		certs = append(certs, v.Certificate)
+	}
+
+	return certs, nil
+}
+
+// certificatesFetcher 请求「获取平台证书列表」，使用商户私钥生成 Authorization 头
+func (mch *Mch) certificatesFetcher(options []wx.HTTPOption) wx.PlatformCertFetcher {
+	return func(ctx context.Context) ([]byte, http.Header, error) {
+		authorization, err := wx.BuildAuthHeader(mch.privateKey, mch.mchid, mch.serialNo, string(wx.MethodGet), PlatformCertificatesURL, nil)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		opts := make([]wx.HTTPOption, 0, len(options)+2)
+		opts = append(opts, wx.WithHTTPHeader("Authorization", authorization), wx.WithHTTPHeader("Accept", "application/json"))
+		opts = append(opts, options...)
+
+		// 需要应答头验证签名
+		body, header, err := mch.client.GetStream(ctx, mch.endpoint(PlatformCertificatesURL), mch.httpOptions(opts)...)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		defer body.Close()
+
+		b, err := ioutil.ReadAll(body)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return b, header, nil
+	}
+}
+
+// PlatformCertificate 根据序列号（即 Wechatpay-Serial 头）获取微信支付平台证书，用于APIv3应答及回调通知的验签（wx.VerifySignature），
+// 优先使用缓存，缓存中不存在或需要刷新时自动下载；下载失败时，若缓存中的证书尚未过期则继续使用
+func (mch *Mch) PlatformCertificate(ctx context.Context, serialNo string, options ...wx.HTTPOption) (*x509.Certificate, error) {
+	if len(mch.apiv3Key) == 0 || mch.privateKey == nil {
+		return nil, errors.New("gochat: apiv3 key and merchant private key are required (see WithAPIv3)")
+	}
+
+	return mch.platform.Certificate(ctx, serialNo, mch.apiv3Key, mch.certificatesFetcher(options), time.Unix(mch.timestamp(), 0))
+}
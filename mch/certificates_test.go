@@ -0,0 +1,228 @@
+package mch
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCertificatesTestServer 模拟 /v3/certificates，验证 Authorization 头的签名后返回使用APIv3密钥加密的平台证书，
+// 应答使用平台证书的私钥签名（forged 为 true 时使用其他私钥签名，模拟伪造的应答）
+func newCertificatesTestServer(t *testing.T, publicKey *rsa.PublicKey, count *int, forged bool) (*httptest.Server, *http.Client) {
+	platformKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	serial, _ := new(big.Int).SetString("5157F09EFDC096DE15EBE81A47057A7232F1B8E1", 16)
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &platformKey.PublicKey, platformKey)
+
+	assert.Nil(t, err)
+
+	ciphertext, err := wx.EncryptResource("AES256Key-32Characters1234567890", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), "4de73afd28b6", "certificate")
+
+	assert.Nil(t, err)
+
+	fixture := []byte(fmt.Sprintf(`{"data":[{"serial_no":"5157F09EFDC096DE15EBE81A47057A7232F1B8E1","effective_time":"%s","expire_time":"%s","encrypt_certificate":{"algorithm":"AEAD_AES_256_GCM","nonce":"4de73afd28b6","associated_data":"certificate","ciphertext":"%s"}}]}`,
+		tpl.NotBefore.Format(time.RFC3339), tpl.NotAfter.Format(time.RFC3339), ciphertext))
+
+	signKey := platformKey
+
+	if forged {
+		signKey, err = rsa.GenerateKey(rand.Reader, 2048)
+
+		assert.Nil(t, err)
+	}
+
+	re := regexp.MustCompile(`^WECHATPAY2-SHA256-RSA2048 mchid="10000100",nonce_str="(\w+)",signature="([^"]+)",timestamp="(\d+)",serial_no="1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C"$`)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*count++
+
+		matches := re.FindStringSubmatch(r.Header.Get("Authorization"))
+
+		if r.Method != http.MethodGet || r.URL.Path != "/v3/certificates" || len(matches) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(matches[2])
+
+		assert.Nil(t, err)
+
+		h := sha256.Sum256([]byte(fmt.Sprintf("GET\n/v3/certificates\n%s\n%s\n\n", matches[3], matches[1])))
+
+		if err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, h[:], signature); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		nonce := "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+
+		h = sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, fixture)))
+
+		signature, err = rsa.SignPKCS1v15(rand.Reader, signKey, crypto.SHA256, h[:])
+
+		assert.Nil(t, err)
+
+		w.Header().Set("Wechatpay-Timestamp", timestamp)
+		w.Header().Set("Wechatpay-Nonce", nonce)
+		w.Header().Set("Wechatpay-Signature", base64.StdEncoding.EncodeToString(signature))
+		w.Header().Set("Wechatpay-Serial", "5157F09EFDC096DE15EBE81A47057A7232F1B8E1")
+
+		w.Write(fixture)
+	}))
+
+	dialer := new(net.Dialer)
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, ts.Listener.Addr().String())
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	return ts, client
+}
+
+func TestDownloadCertificates(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	count := 0
+
+	ts, client := newCertificatesTestServer(t, &key.PublicKey, &count, false)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithAPIv3("AES256Key-32Characters1234567890", "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C", key))
+
+	certs, err := mch.DownloadCertificates(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(certs))
+	assert.Equal(t, "5157F09EFDC096DE15EBE81A47057A7232F1B8E1", fmt.Sprintf("%X", certs[0].SerialNumber))
+	assert.Equal(t, "Tenpay.com Root CA", certs[0].Subject.CommonName)
+
+	// APIv3密钥错误时解密失败
+	mch = New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithAPIv3("AES256Key-32Characters0987654321", "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C", key))
+
+	_, err = mch.DownloadCertificates(context.TODO())
+
+	assert.NotNil(t, err)
+
+	// 未指定APIv3配置
+	mch = New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client))
+
+	_, err = mch.DownloadCertificates(context.TODO())
+
+	assert.EqualError(t, err, "gochat: apiv3 key and merchant private key are required (see WithAPIv3)")
+	assert.Equal(t, 2, count)
+}
+
+func TestDownloadCertificatesForged(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	count := 0
+
+	ts, client := newCertificatesTestServer(t, &key.PublicKey, &count, true)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithAPIv3("AES256Key-32Characters1234567890", "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C", key))
+
+	// 应答签名验证失败时不缓存证书
+	_, err = mch.DownloadCertificates(context.TODO())
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "gochat: platform certificates response signature verification failed (serial_no: 5157F09EFDC096DE15EBE81A47057A7232F1B8E1)")
+
+	cert, _ := mch.platform.Get("5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now())
+
+	assert.Nil(t, cert)
+}
+
+func TestPlatformCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	assert.Nil(t, err)
+
+	count := 0
+
+	ts, client := newCertificatesTestServer(t, &key.PublicKey, &count, false)
+
+	defer ts.Close()
+
+	now := time.Now()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithAPIv3("AES256Key-32Characters1234567890", "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C", key), WithTimestampFunc(func() int64 {
+		return now.Unix()
+	}))
+
+	// 首次获取时下载
+	cert, err := mch.PlatformCertificate(context.TODO(), "5157F09EFDC096DE15EBE81A47057A7232F1B8E1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Tenpay.com Root CA", cert.Subject.CommonName)
+	assert.Equal(t, 1, count)
+
+	// 命中缓存
+	_, err = mch.PlatformCertificate(context.TODO(), "5157F09EFDC096DE15EBE81A47057A7232F1B8E1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// 超过刷新间隔后重新下载
+	now = now.Add(13 * time.Hour)
+
+	_, err = mch.PlatformCertificate(context.TODO(), "5157F09EFDC096DE15EBE81A47057A7232F1B8E1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	// 序列号不存在（如：伪造的通知），缓存无需刷新时不会重新下载
+	_, err = mch.PlatformCertificate(context.TODO(), "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C")
+
+	assert.EqualError(t, err, "gochat: platform certificate not found (serial_no: 1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C)")
+	assert.Equal(t, 2, count)
+
+	// 间隔1分钟后才会重新下载
+	now = now.Add(time.Minute)
+
+	_, err = mch.PlatformCertificate(context.TODO(), "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C")
+
+	assert.EqualError(t, err, "gochat: platform certificate not found (serial_no: 1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C)")
+	assert.Equal(t, 3, count)
+}
@@ -124,12 +124,59 @@ const (
 	RedpackTypeGroup  = "GROUP"  // 裂变红包
 )
 
+// RedpackAmtType 裂变红包的金额设置方式
+type RedpackAmtType string
+
+const (
+	RedpackAmtAllRand RedpackAmtType = "ALL_RAND" // 全部随机，由微信支付随机计算出各红包金额
+	RedpackAmtConst   RedpackAmtType = "CONST"    // 固定金额，各红包金额相同
+)
+
 const (
 	RedpackSendTypeAPI      = "API"      // 通过API接口发放
 	RedpackSendTypeUpload   = "UPLOAD"   // 通过上传文件方式发放
 	RedpackSendTypeActivity = "ACTIVITY" // 通过活动方式发放
 )
 
+// ProfitSharingReceiverType 分账接收方类型
+type ProfitSharingReceiverType string
+
+const (
+	ProfitSharingMerchantID        ProfitSharingReceiverType = "MERCHANT_ID"         // 商户号（mch_id或者sub_mch_id）
+	ProfitSharingPersonalOpenID    ProfitSharingReceiverType = "PERSONAL_OPENID"     // 个人openid（由父商户APPID转换得到）
+	ProfitSharingPersonalSubOpenID ProfitSharingReceiverType = "PERSONAL_SUB_OPENID" // 个人sub_openid（由子商户APPID转换得到）
+)
+
+// ProfitSharingRelationType 分账接收方与分账方的关系类型
+type ProfitSharingRelationType string
+
+const (
+	RelationServiceProvider ProfitSharingRelationType = "SERVICE_PROVIDER" // 服务商
+	RelationStore           ProfitSharingRelationType = "STORE"            // 门店
+	RelationStaff           ProfitSharingRelationType = "STAFF"            // 员工
+	RelationStoreOwner      ProfitSharingRelationType = "STORE_OWNER"      // 店主
+	RelationPartner         ProfitSharingRelationType = "PARTNER"          // 合作伙伴
+	RelationHeadquarter     ProfitSharingRelationType = "HEADQUARTER"      // 总部
+	RelationBrand           ProfitSharingRelationType = "BRAND"            // 品牌方
+	RelationDistributor     ProfitSharingRelationType = "DISTRIBUTOR"      // 分销商
+	RelationUser            ProfitSharingRelationType = "USER"             // 用户
+	RelationSupplier        ProfitSharingRelationType = "SUPPLIER"         // 供应商
+	RelationCustom          ProfitSharingRelationType = "CUSTOM"           // 自定义（需填写 custom_relation）
+)
+
+const (
+	ProfitSharingStatusAccepted   = "ACCEPTED"   // 受理成功
+	ProfitSharingStatusProcessing = "PROCESSING" // 处理中
+	ProfitSharingStatusFinished   = "FINISHED"   // 处理完成
+	ProfitSharingStatusClosed     = "CLOSED"     // 处理失败，已关单
+)
+
+const (
+	ProfitSharingResultPending = "PENDING" // 待分账
+	ProfitSharingResultSuccess = "SUCCESS" // 分账成功
+	ProfitSharingResultClosed  = "CLOSED"  // 已关闭
+)
+
 // 账单类型
 const (
 	BillTypeAll            = "ALL"             // 当日所有订单信息（不含充值退款订单）
@@ -140,18 +187,22 @@ const (
 
 // 资金账户类型
 const (
-	AccountTypeBasic     = "Basic"     // 基本账户
-	AccountTypeOperation = "Operation" // 运营账户
-	AccountTypeFees      = "Fees"      // 手续费账户
+	AccountTypeBasic     FundAccountType = "Basic"     // 基本账户
+	AccountTypeOperation FundAccountType = "Operation" // 运营账户
+	AccountTypeFees      FundAccountType = "Fees"      // 手续费账户
 )
 
 const RSAPublicKeyURL = "https://fraud.mch.weixin.qq.com/risk/getpublickey"
 
 // URL - order
 const (
-	OrderUnifyURL = "https://api.mch.weixin.qq.com/pay/unifiedorder" // 统一下单
-	OrderQueryURL = "https://api.mch.weixin.qq.com/pay/orderquery"   // 订单查询
-	OrderCloseURL = "https://api.mch.weixin.qq.com/pay/closeorder"   // 订单关闭
+	OrderUnifyURL       = "https://api.mch.weixin.qq.com/pay/unifiedorder"       // 统一下单
+	OrderQueryURL       = "https://api.mch.weixin.qq.com/pay/orderquery"         // 订单查询
+	OrderCloseURL       = "https://api.mch.weixin.qq.com/pay/closeorder"         // 订单关闭
+	MicropayURL         = "https://api.mch.weixin.qq.com/pay/micropay"           // 付款码支付
+	OrderReverseURL     = "https://api.mch.weixin.qq.com/secapi/pay/reverse"     // 撤销订单
+	AuthCodeToOpenIDURL = "https://api.mch.weixin.qq.com/tools/authcodetoopenid" // 付款码查询openid
+	ShortURLURL         = "https://api.mch.weixin.qq.com/tools/shorturl"         // 转换短链接
 )
 
 // URL - refund
@@ -188,6 +239,31 @@ const (
 	RedpackQueryURL  = "https://api.mch.weixin.qq.com/mmpaymkttransfers/gethbinfo"         // 红包查询
 )
 
+// URL - profitsharing
+const (
+	ProfitSharingURL               = "https://api.mch.weixin.qq.com/secapi/pay/profitsharing"        // 请求单次分账
+	MultiProfitSharingURL          = "https://api.mch.weixin.qq.com/secapi/pay/multiprofitsharing"   // 请求多次分账
+	ProfitSharingQueryURL          = "https://api.mch.weixin.qq.com/pay/profitsharingquery"          // 查询分账结果
+	ProfitSharingAddReceiverURL    = "https://api.mch.weixin.qq.com/pay/profitsharingaddreceiver"    // 添加分账接收方
+	ProfitSharingRemoveReceiverURL = "https://api.mch.weixin.qq.com/pay/profitsharingremovereceiver" // 删除分账接收方
+	ProfitSharingFinishURL         = "https://api.mch.weixin.qq.com/secapi/pay/profitsharingfinish"  // 完结分账
+)
+
+// URL - report
+const (
+	ReportURL = "https://api.mch.weixin.qq.com/payitil/report" // 交易保障
+)
+
+// URL - sandbox
+const (
+	SandboxSignKeyURL = "https://api.mch.weixin.qq.com/sandboxnew/pay/getsignkey" // 获取仿真测试验签秘钥
+)
+
+// URL - apiv3
+const (
+	PlatformCertificatesURL = "https://api.mch.weixin.qq.com/v3/certificates" // 获取平台证书列表
+)
+
 // URL - other
 const (
 	DownloadBillURL      = "https://api.mch.weixin.qq.com/pay/downloadbill"                // 下载交易账单
@@ -0,0 +1,81 @@
+package mch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// ReturnError 通信失败（return_code != SUCCESS）
+type ReturnError struct {
+	ReturnCode string
+	ReturnMsg  string
+}
+
+func (e *ReturnError) Error() string {
+	return e.ReturnMsg
+}
+
+// ResultError 业务失败（result_code != SUCCESS）
+type ResultError struct {
+	ErrCode    string
+	ErrCodeDes string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("%s|%s", e.ErrCode, e.ErrCodeDes)
+}
+
+// Is reports whether target is a *ResultError with the same err_code, so that errors.Is(err, mch.ErrOrderPaid) works.
+func (e *ResultError) Is(target error) bool {
+	t, ok := target.(*ResultError)
+
+	return ok && t.ErrCode == e.ErrCode
+}
+
+// 常见的业务错误码（err_code），可通过 errors.Is 判断，如：errors.Is(err, mch.ErrOrderPaid)
+var (
+	ErrOrderPaid           = &ResultError{ErrCode: "ORDERPAID", ErrCodeDes: "订单已支付"}              // 订单已支付，不能发起关单，请当作已支付的正常交易处理
+	ErrOrderClosed         = &ResultError{ErrCode: "ORDERCLOSED", ErrCodeDes: "订单已关闭"}            // 订单已关闭，无需继续调用
+	ErrSystemError         = &ResultError{ErrCode: "SYSTEMERROR", ErrCodeDes: "系统错误"}             // 系统异常，请用相同参数重新调用
+	ErrBizError            = &ResultError{ErrCode: "ERROR", ErrCodeDes: "业务错误"}                   // 申请退款业务发生错误，请用相同参数重新调用
+	ErrNotEnough           = &ResultError{ErrCode: "NOTENOUGH", ErrCodeDes: "余额不足"}               // 商户可用退款余额不足，请充值后用原商户退款单号重新调用
+	ErrFrequencyLimited    = &ResultError{ErrCode: "FREQUENCY_LIMITED", ErrCodeDes: "频率限制"}       // 请求频率过高，请降低频率后重试
+	ErrUserAccountAbnormal = &ResultError{ErrCode: "USER_ACCOUNT_ABNORMAL", ErrCodeDes: "退款请求失败"} // 用户账号已注销，请商户自行处理退款
+	ErrNotFound            = &ResultError{ErrCode: "NOT_FOUND", ErrCodeDes: "数据不存在"}              // 查询的订单不存在（如：付款从未发起），可作为终态处理
+	ErrUserPaying          = &ResultError{ErrCode: "USERPAYING", ErrCodeDes: "用户支付中，需要输入密码"}      // 付款码支付等待用户输入密码，需轮询订单查询
+	ErrBankError           = &ResultError{ErrCode: "BANKERROR", ErrCodeDes: "银行系统异常"}             // 付款码支付银行端超时，需轮询订单查询
+	ErrAuthCodeInvalid     = &ResultError{ErrCode: "AUTH_CODE_INVALID", ErrCodeDes: "授权码检验错误"}    // 付款码无效（本地格式校验失败时同样可判断），请扫描微信支付被扫条码/二维码
+	ErrAuthCodeExpired     = &ResultError{ErrCode: "AUTHCODEEXPIRE", ErrCodeDes: "二维码已过期"}        // 付款码已过期，请用户在微信上刷新后再试
+)
+
+// ErrInvalidSign 签名验证失败（应答/回调通知的签名与根据 apikey 重新计算的签名不一致，或缺少签名），
+// 不包含重新计算的正确签名（错误信息可能被返回给调用方，泄露正确签名会导致通知被伪造）
+type ErrInvalidSign struct {
+	SignType string   // 签名类型
+	Fields   []string // 参与签名的字段（按字典序排列）
+	Got      string   // 应答/回调通知中的签名（为空表示缺少签名）
+}
+
+func (e *ErrInvalidSign) Error() string {
+	if len(e.Got) == 0 {
+		return fmt.Sprintf("gochat: sign missing, signed fields: %s", strings.Join(e.Fields, ","))
+	}
+
+	return fmt.Sprintf("gochat: invalid sign (%s), got: %s, signed fields: %s", e.SignType, e.Got, strings.Join(e.Fields, ","))
+}
+
+func newReturnError(m wx.WXML) error {
+	return &ReturnError{
+		ReturnCode: m["return_code"],
+		ReturnMsg:  m["return_msg"],
+	}
+}
+
+func newResultError(m wx.WXML) error {
+	return &ResultError{
+		ErrCode:    m["err_code"],
+		ErrCodeDes: m["err_code_des"],
+	}
+}
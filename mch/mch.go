@@ -2,55 +2,256 @@ package mch
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/md5"
 	"crypto/rand"
-	"crypto/sha256"
+	"crypto/rsa"
 	"crypto/tls"
-	"encoding/base64"
 	"encoding/hex"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/shenghui0779/gochat/wx"
 	"golang.org/x/crypto/pkcs12"
+	"golang.org/x/sync/singleflight"
 )
 
 // Mch 微信支付
 type Mch struct {
-	appid     string
-	mchid     string
-	apikey    string
-	nonce     func(size int) string
-	client    wx.HTTPClient
-	tlsClient wx.HTTPClient
+	appid        string
+	mchid        string
+	apikey       string
+	signType     string
+	nonce        func(size int) string
+	timestamp    func() int64
+	httpClient   *http.Client
+	client       wx.HTTPClient
+	tlsClient    wx.HTTPClient
+	certs        atomic.Value // *certState
+	rsaKey       atomic.Value // []byte，付款到银行卡的RSA公钥（GetRSAPublicKey 缓存）
+	apiv3Key     string
+	serialNo     string
+	privateKey   *rsa.PrivateKey
+	platform     wx.PlatformCertCache // 微信支付平台证书（APIv3，DownloadCertificates 缓存）
+	sandbox      bool
+	sandboxKey   atomic.Value // string，仿真测试验签秘钥（首次请求时获取并缓存）
+	sandboxGroup singleflight.Group
+	debug        wx.DebugFunc
+	logger       wx.LoggerFunc
+	metrics      wx.Metrics
+	limiter      wx.Limiter
+	skipVerify   map[string]bool
+	reporter     *reporter
+	baseURL      string
 }
 
-// New returns new wechat pay
-func New(appid, mchid, apikey string) *Mch {
-	c := wx.NewHTTPClient(&tls.Config{InsecureSkipVerify: true})
+// Option configures how we set up the Mch
+type Option func(mch *Mch)
+
+// WithHTTPClient specifies the *http.Client to send requests (eg: to customize connection pooling, dial timeout, proxy, etc.).
+// When loading the certificate, the transport of the client will be cloned with the certificate,
+// so the transport should be nil or an *http.Transport, otherwise the actions requiring TLS return an error.
+func WithHTTPClient(c *http.Client) Option {
+	return func(mch *Mch) {
+		mch.httpClient = c
+	}
+}
+
+// WithTransport specifies the *http.Transport to send requests (eg: to customize proxy, dial timeout, tls, etc.).
+// When loading the certificate, the certificate is merged into the TLSClientConfig of the transport (if set), instead of overwriting it.
+func WithTransport(t *http.Transport) Option {
+	return func(mch *Mch) {
+		mch.httpClient = &http.Client{Transport: t}
+	}
+}
+
+// WithCertificate specifies the merchant certificate (eg: decoded from the material in vault),
+// which is only presented by the actions requiring TLS (Action.TLS() is true).
+func WithCertificate(cert tls.Certificate) Option {
+	return func(mch *Mch) {
+		mch.setCertState(&cert, nil)
+	}
+}
+
+// WithCertPEMBlock specifies the merchant certificate from a pair of PEM encoded data in memory (apiclient_cert.pem, apiclient_key.pem),
+// if the data is invalid, the error is returned by the actions requiring TLS.
+func WithCertPEMBlock(certPEMBlock, keyPEMBlock []byte) Option {
+	return func(mch *Mch) {
+		cert, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+
+		if err != nil {
+			mch.setCertState(nil, fmt.Errorf("gochat: invalid merchant certificate: %w", err))
+
+			return
+		}
+
+		mch.setCertState(&cert, nil)
+	}
+}
+
+// WithPKCS12 specifies the merchant certificate from the p12 data (apiclient_cert.p12) in memory, the password is the mchid,
+// if the data is invalid, the error is returned by the actions requiring TLS.
+func WithPKCS12(p12 []byte) Option {
+	return func(mch *Mch) {
+		cert, err := mch.pkcs12ToPem(p12)
+
+		if err != nil {
+			mch.setCertState(nil, err)
+
+			return
+		}
+
+		mch.setCertState(&cert, nil)
+	}
+}
+
+// WithSignType specifies the sign type (MD5 or HMAC-SHA256) used by the Mch, default is MD5.
+// It applies to the requests whose endpoint supports HMAC-SHA256 (eg: order, refund, pappay; and the verification of their responses) and the payment params for client,
+// the endpoints which only support one sign type (eg: redpack and transfer require MD5, download fund flow requires HMAC-SHA256) keep their own.
+func WithSignType(signType string) Option {
+	return func(mch *Mch) {
+		mch.signType = signType
+	}
+}
+
+// WithDebugFunc specifies the hook for tracing all the http requests of the Mch, the api key is never included (only the sign is sent).
+func WithDebugFunc(f wx.DebugFunc) Option {
+	return func(mch *Mch) {
+		mch.debug = f
+	}
+}
+
+// WithLogger specifies the hook for logging all the http requests of the Mch, the url and bodies are passed raw (callers should redact the sensitive fields).
+func WithLogger(f wx.LoggerFunc) Option {
+	return func(mch *Mch) {
+		mch.logger = f
+	}
+}
 
-	return &Mch{
-		appid:  appid,
-		mchid:  mchid,
-		apikey: apikey,
+// WithMetrics specifies the hook for collecting metrics (eg: prometheus) of all the http requests of the Mch.
+func WithMetrics(m wx.Metrics) Option {
+	return func(mch *Mch) {
+		mch.metrics = m
+	}
+}
+
+// WithLimiter specifies the rate limiter for all the http requests of the Mch (eg: wx.NewTokenBucketLimiter),
+// which is consulted before each request, so that the api quotas are not exceeded (errcode 45009).
+func WithLimiter(l wx.Limiter) Option {
+	return func(mch *Mch) {
+		mch.limiter = l
+	}
+}
+
+// WithNonceFunc specifies the func to generate the nonce_str (eg: for testing), default is a random hex string of the size.
+func WithNonceFunc(f func(size int) string) Option {
+	return func(mch *Mch) {
+		mch.nonce = f
+	}
+}
+
+// WithTimestampFunc specifies the func to get the current unix timestamp (eg: for testing), default is time.Now().Unix().
+func WithTimestampFunc(f func() int64) Option {
+	return func(mch *Mch) {
+		mch.timestamp = f
+	}
+}
+
+// WithAPIv3 specifies the APIv3 key, the serial number of the merchant certificate and the merchant private key (apiclient_key.pem),
+// which are required by the APIv3 endpoints (eg: DownloadCertificates).
+func WithAPIv3(apiV3Key, serialNo string, privateKey *rsa.PrivateKey) Option {
+	return func(mch *Mch) {
+		mch.apiv3Key = apiV3Key
+		mch.serialNo = serialNo
+		mch.privateKey = privateKey
+	}
+}
+
+// WithSandbox enables the sandbox mode (仿真测试): the sandbox sign key is fetched from mch.SandboxSignKeyURL on the first request and cached,
+// the requests are sent to the sandbox endpoints (https://api.mch.weixin.qq.com/sandboxnew/...) and signed (and verified) with the sandbox sign key,
+// so that the callers need no code changes (eg: UnifiedOrder, QueryOrder).
+func WithSandbox() Option {
+	return func(mch *Mch) {
+		mch.sandbox = true
+	}
+}
+
+// WithAutoReport enables the automatic report (交易保障, mch.ReportURL): after every api call of the Mch, the execute time and the result are reported asynchronously,
+// the userIP is the ip of the machine calling the apis. It is best-effort: the reports are queued (default size is DefaultReportQueueSize) and dropped when the queue is full,
+// the callers are never blocked. Call Mch.Close to stop it (eg: graceful shutdown).
+func WithAutoReport(userIP string, queueSize int) Option {
+	return func(mch *Mch) {
+		mch.reporter = newReporter(userIP, queueSize)
+	}
+}
+
+// WithBaseURL specifies the base url to send requests (eg: https://api2.mch.weixin.qq.com, the regional hosts, or a proxy with path prefix),
+// the request urls on https://api.mch.weixin.qq.com are rebased onto it with the path and query preserved, the other hosts (eg: fraud.mch.weixin.qq.com) are kept.
+func WithBaseURL(base string) Option {
+	return func(mch *Mch) {
+		mch.baseURL = strings.TrimSuffix(base, "/")
+	}
+}
+
+// WithSkipSignVerify skips the signature verification of the responses from the endpoints (eg: mch.TransferToBankCardURL),
+// only for the few endpoints which return unsigned bodies, the responses are trusted as is.
+func WithSkipSignVerify(reqURLs ...string) Option {
+	return func(mch *Mch) {
+		if mch.skipVerify == nil {
+			mch.skipVerify = make(map[string]bool, len(reqURLs))
+		}
+
+		for _, v := range reqURLs {
+			mch.skipVerify[v] = true
+		}
+	}
+}
+
+// New returns new wechat pay
+func New(appid, mchid, apikey string, options ...Option) *Mch {
+	mch := &Mch{
+		appid:    appid,
+		mchid:    mchid,
+		apikey:   apikey,
+		signType: SignMD5,
 		nonce: func(size int) string {
 			nonce := make([]byte, size/2)
 			io.ReadFull(rand.Reader, nonce)
 
 			return hex.EncodeToString(nonce)
 		},
-		client:    c,
-		tlsClient: c,
+		timestamp: func() int64 {
+			return time.Now().Unix()
+		},
 	}
+
+	for _, f := range options {
+		f(mch)
+	}
+
+	var c wx.HTTPClient
+
+	if mch.httpClient != nil {
+		c = wx.NewHTTPClientWith(mch.httpClient)
+	} else {
+		c = wx.NewHTTPClient(&tls.Config{InsecureSkipVerify: true})
+	}
+
+	mch.client = c
+	mch.tlsClient = mch.newTLSClient()
+
+	if mch.reporter != nil {
+		mch.reporter.start(mch)
+	}
+
+	return mch
 }
 
 // LoadCertFromP12File load cert from p12(pfx) file
@@ -61,16 +262,19 @@ func (mch *Mch) LoadCertFromP12File(path string) error {
 		return err
 	}
 
+	return mch.LoadCertFromP12Block(p12)
+}
+
+// LoadCertFromP12Block load cert from p12(pfx) data
+func (mch *Mch) LoadCertFromP12Block(p12 []byte) error {
 	cert, err := mch.pkcs12ToPem(p12)
 
 	if err != nil {
 		return err
 	}
 
-	mch.tlsClient = wx.NewHTTPClient(&tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		InsecureSkipVerify: true,
-	})
+	mch.setCertState(&cert, nil)
+	mch.closeIdleConnections()
 
 	return nil
 }
@@ -83,10 +287,8 @@ func (mch *Mch) LoadCertFromPemFile(certFile, keyFile string) error {
 		return err
 	}
 
-	mch.tlsClient = wx.NewHTTPClient(&tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		InsecureSkipVerify: true,
-	})
+	mch.setCertState(&cert, nil)
+	mch.closeIdleConnections()
 
 	return nil
 }
@@ -99,29 +301,55 @@ func (mch *Mch) LoadCertFromPemBlock(certPEMBlock, keyPEMBlock []byte) error {
 		return err
 	}
 
-	mch.tlsClient = wx.NewHTTPClient(&tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		InsecureSkipVerify: true,
-	})
+	mch.setCertState(&cert, nil)
+	mch.closeIdleConnections()
+
+	return nil
+}
+
+// ReloadCertificate replaces the merchant certificate (eg: rotated annually) without recreating the Mch,
+// the new certificate is presented on the next TLS handshake, the in-flight requests are not disturbed.
+// It is safe to be called concurrently.
+func (mch *Mch) ReloadCertificate(cert tls.Certificate) error {
+	if len(cert.Certificate) == 0 || cert.PrivateKey == nil {
+		return errors.New("gochat: invalid merchant certificate: missing certificate or private key")
+	}
+
+	mch.setCertState(&cert, nil)
+	mch.closeIdleConnections()
 
 	return nil
 }
 
 // Do exec action
-func (mch *Mch) Do(ctx context.Context, action wx.Action, options ...wx.HTTPOption) (wx.WXML, error) {
+func (mch *Mch) Do(ctx context.Context, action wx.Action, options ...wx.HTTPOption) (result wx.WXML, err error) {
 	m, err := action.WXML(mch.appid, mch.mchid, mch.nonce(16))
 
 	if err != nil {
 		return nil, err
 	}
 
-	// 签名
-	if v, ok := m["sign_type"]; ok && v == SignHMacSHA256 {
-		m["sign"] = mch.SignWithHMacSHA256(m, true)
-	} else {
-		m["sign"] = mch.SignWithMD5(m, true)
+	// 签名：支持HMAC-SHA256的接口，默认的MD5签名替换为客户端指定的签名类型（WithSignType）；
+	// 其他接口（如：红包、企业付款）即使请求含 sign_type 也仅支持MD5签名，不支持 sign_type 的接口同样仅支持MD5签名
+	signType := SignMD5
+
+	if v, ok := m["sign_type"]; ok {
+		if v == SignMD5 && mch.signType == SignHMacSHA256 && hmacEndpoints[action.URL()] {
+			m["sign_type"] = SignHMacSHA256
+		}
+
+		signType = m["sign_type"]
+	}
+
+	// 仿真测试模式下使用仿真测试验签秘钥
+	key, err := mch.signKey(ctx, options...)
+
+	if err != nil {
+		return nil, err
 	}
 
+	m["sign"] = signWithType(key, m, signType)
+
 	reqURL := action.URL()
 
 	switch reqURL {
@@ -145,12 +373,30 @@ func (mch *Mch) Do(ctx context.Context, action wx.Action, options ...wx.HTTPOpti
 		return wx.WXML{"entrust_url": fmt.Sprintf("%s?%s", PappayH5EntrustURL, query.Encode())}, nil
 	}
 
+	// 转换短链接：long_url 签名用原串，传输需URLencode
+	if reqURL == ShortURLURL {
+		m["long_url"] = url.QueryEscape(m["long_url"])
+	}
+
+	// 交易保障：自动上报接口耗时及结果（WithAutoReport）
+	if mch.reporter != nil && reqURL != ReportURL {
+		start := time.Now()
+
+		defer func() {
+			mch.reporter.add(mch.reportData(reqURL, start, result, err))
+		}()
+	}
+
 	var resp []byte
 
 	if action.TLS() {
-		resp, err = mch.tlsClient.PostXML(ctx, reqURL, m, options...)
+		if err = mch.certErr(); err != nil {
+			return nil, err
+		}
+
+		resp, err = mch.tlsClient.PostXML(ctx, mch.endpoint(reqURL), m, mch.httpOptions(options)...)
 	} else {
-		resp, err = mch.client.PostXML(ctx, reqURL, m, options...)
+		resp, err = mch.client.PostXML(ctx, mch.endpoint(reqURL), m, mch.httpOptions(options)...)
 	}
 
 	if err != nil {
@@ -158,21 +404,28 @@ func (mch *Mch) Do(ctx context.Context, action wx.Action, options ...wx.HTTPOpti
 	}
 
 	// XML解析
-	result, err := wx.ParseXML2Map(resp)
+	result, err = wx.ParseXML2Map(resp)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if result["return_code"] != ResultSuccess {
-		return nil, errors.New(result["return_msg"])
+		return nil, newReturnError(result)
 	}
 
-	// 签名验证
-	if err := mch.VerifyWXMLResult(result); err != nil {
+	// 签名验证（应答的签名类型与请求一致）
+	if err := mch.verifyResponse(reqURL, result, key, signType); err != nil {
 		return nil, err
 	}
 
+	// 含嵌套节点的应答（如：红包查询的 hblist），由 Action 自行解析
+	if decode := action.Decode(); decode != nil {
+		if err = decode(resp); err != nil {
+			return nil, err
+		}
+	}
+
 	return result, nil
 }
 
@@ -184,7 +437,7 @@ func (mch *Mch) APPAPI(prepayID string) wx.WXML {
 		"prepayid":  prepayID,
 		"package":   "Sign=WXPay",
 		"noncestr":  mch.nonce(16),
-		"timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+		"timestamp": strconv.FormatInt(mch.timestamp(), 10),
 	}
 
 	m["sign"] = mch.SignWithMD5(m, true)
@@ -199,7 +452,7 @@ func (mch *Mch) JSAPI(prepayID string) wx.WXML {
 		"nonceStr":  mch.nonce(16),
 		"package":   fmt.Sprintf("prepay_id=%s", prepayID),
 		"signType":  SignMD5,
-		"timeStamp": strconv.FormatInt(time.Now().Unix(), 10),
+		"timeStamp": strconv.FormatInt(mch.timestamp(), 10),
 	}
 
 	m["paySign"] = mch.SignWithMD5(m, true)
@@ -207,13 +460,103 @@ func (mch *Mch) JSAPI(prepayID string) wx.WXML {
 	return m
 }
 
+// JSAPIPayParams JSAPI调起支付所需参数（wx.requestPayment / WeixinJSBridge）
+type JSAPIPayParams struct {
+	AppID     string `json:"appId"`
+	TimeStamp string `json:"timeStamp"`
+	NonceStr  string `json:"nonceStr"`
+	Package   string `json:"package"`
+	SignType  string `json:"signType"`
+	PaySign   string `json:"paySign"`
+}
+
+// JSAPIPayParams 根据 prepay_id 生成JSAPI调起支付参数，签名类型由 WithSignType 指定
+func (mch *Mch) JSAPIPayParams(prepayID string) JSAPIPayParams {
+	m := wx.WXML{
+		"appId":     mch.appid,
+		"nonceStr":  mch.nonce(16),
+		"package":   fmt.Sprintf("prepay_id=%s", prepayID),
+		"signType":  mch.signType,
+		"timeStamp": strconv.FormatInt(mch.timestamp(), 10),
+	}
+
+	return JSAPIPayParams{
+		AppID:     m["appId"],
+		TimeStamp: m["timeStamp"],
+		NonceStr:  m["nonceStr"],
+		Package:   m["package"],
+		SignType:  m["signType"],
+		PaySign:   mch.sign(m, mch.signType),
+	}
+}
+
+// JSAPIParams 根据 prepay_id 生成JSAPI调起支付参数
+//
+// Deprecated: use JSAPIPayParams instead.
+func (mch *Mch) JSAPIParams(prepayID string) JSAPIPayParams {
+	return mch.JSAPIPayParams(prepayID)
+}
+
+// MinipPayParams 根据 prepay_id 生成小程序调起支付参数（wx.requestPayment，appId 无需传入），签名类型由 WithSignType 指定
+// 注意：Mch 的 appid 须为小程序的 appid
+func (mch *Mch) MinipPayParams(prepayID string) JSAPIPayParams {
+	return mch.JSAPIPayParams(prepayID)
+}
+
+// MinipParams 根据 prepay_id 生成小程序调起支付参数
+//
+// Deprecated: use MinipPayParams instead.
+func (mch *Mch) MinipParams(prepayID string) JSAPIPayParams {
+	return mch.MinipPayParams(prepayID)
+}
+
+// APPPayParams APP调起支付所需参数（微信 OpenSDK 的 PayReq）
+type APPPayParams struct {
+	AppID     string `json:"appid"`
+	PartnerID string `json:"partnerid"`
+	PrepayID  string `json:"prepayid"`
+	Package   string `json:"package"`
+	NonceStr  string `json:"noncestr"`
+	Timestamp string `json:"timestamp"`
+	Sign      string `json:"sign"`
+}
+
+// APPPayParams 根据 prepay_id 生成APP调起支付参数，签名类型由 WithSignType 指定（须与统一下单一致）
+func (mch *Mch) APPPayParams(prepayID string) APPPayParams {
+	m := wx.WXML{
+		"appid":     mch.appid,
+		"partnerid": mch.mchid,
+		"prepayid":  prepayID,
+		"package":   "Sign=WXPay",
+		"noncestr":  mch.nonce(16),
+		"timestamp": strconv.FormatInt(mch.timestamp(), 10),
+	}
+
+	return APPPayParams{
+		AppID:     m["appid"],
+		PartnerID: m["partnerid"],
+		PrepayID:  m["prepayid"],
+		Package:   m["package"],
+		NonceStr:  m["noncestr"],
+		Timestamp: m["timestamp"],
+		Sign:      mch.sign(m, mch.signType),
+	}
+}
+
+// APPParams 根据 prepay_id 生成APP调起支付参数
+//
+// Deprecated: use APPPayParams instead.
+func (mch *Mch) APPParams(prepayID string) APPPayParams {
+	return mch.APPPayParams(prepayID)
+}
+
 // MinipRedpackJSAPI 小程序领取红包
 func (mch *Mch) MinipRedpackJSAPI(pkg string) wx.WXML {
 	m := wx.WXML{
 		"appId":     mch.appid,
 		"nonceStr":  mch.nonce(16),
 		"package":   url.QueryEscape(pkg),
-		"timeStamp": strconv.FormatInt(time.Now().Unix(), 10),
+		"timeStamp": strconv.FormatInt(mch.timestamp(), 10),
 	}
 
 	m["paySign"] = mch.SignWithMD5(m, false)
@@ -224,70 +567,83 @@ func (mch *Mch) MinipRedpackJSAPI(pkg string) wx.WXML {
 	return m
 }
 
-// DownloadBill 下载交易账单
+// DownloadBill 下载交易账单，tarGzip 为 true 时账单以gzip压缩传输（tar_type=GZIP）
 // 账单日期格式：20140603
-func (mch *Mch) DownloadBill(ctx context.Context, billDate, billType string) ([]byte, error) {
+// 失败时应答为XML（如：No Bill Exist），返回 *ReturnError；成功时应答为CSV文本，解析为 *Bill（含原始内容、明细及汇总数据）
+func (mch *Mch) DownloadBill(ctx context.Context, billDate string, billType BillType, tarGzip bool) (*Bill, error) {
 	m := wx.WXML{
 		"appid":     mch.appid,
 		"mch_id":    mch.mchid,
 		"bill_date": billDate,
-		"bill_type": billType,
+		"bill_type": string(billType),
 		"nonce_str": mch.nonce(16),
 	}
 
-	m["sign"] = mch.SignWithMD5(m, true)
+	if tarGzip {
+		m["tar_type"] = "GZIP"
+	}
 
-	resp, err := mch.client.PostXML(ctx, DownloadBillURL, m, wx.WithHTTPClose())
+	key, err := mch.signKey(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// XML解析
-	result, err := wx.ParseXML2Map(resp)
+	m["sign"] = signWithMD5(key, m, true)
+
+	resp, err := mch.client.PostXML(ctx, mch.endpoint(DownloadBillURL), m, mch.httpOptions([]wx.HTTPOption{wx.WithHTTPClose()})...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if len(result) != 0 && result["return_code"] != ResultSuccess {
-		return nil, errors.New(result["return_msg"])
+	b, err := readBillResponse(resp)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return resp, nil
+	return ParseBill(b)
 }
 
-// DownloadFundFlow 下载资金账单
-// 账单日期格式：20140603
-func (mch *Mch) DownloadFundFlow(ctx context.Context, billDate, accountType string) ([]byte, error) {
+// DownloadFundFlow 下载资金账单（需要商户证书），返回解析后的明细及汇总数据
+// 账单日期格式：20140603；该接口仅支持HMAC-SHA256签名（与 WithSignType 无关），账单以GZIP压缩下载并自动解压
+func (mch *Mch) DownloadFundFlow(ctx context.Context, billDate string, accountType FundAccountType) (*FundFlow, error) {
 	m := wx.WXML{
 		"appid":        mch.appid,
 		"mch_id":       mch.mchid,
 		"bill_date":    billDate,
-		"account_type": accountType,
+		"account_type": string(accountType),
+		"sign_type":    SignHMacSHA256,
+		"tar_type":     "GZIP",
 		"nonce_str":    mch.nonce(16),
 	}
 
-	m["sign"] = mch.SignWithHMacSHA256(m, true)
-
-	resp, err := mch.tlsClient.PostXML(ctx, DownloadFundFlowURL, m, wx.WithHTTPClose())
+	key, err := mch.signKey(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// XML解析
-	result, err := wx.ParseXML2Map(resp)
+	m["sign"] = signWithHMacSHA256(key, m, true)
+
+	if err = mch.certErr(); err != nil {
+		return nil, err
+	}
+
+	resp, err := mch.tlsClient.PostXML(ctx, mch.endpoint(DownloadFundFlowURL), m, mch.httpOptions([]wx.HTTPOption{wx.WithHTTPClose()})...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if len(result) != 0 && result["return_code"] != ResultSuccess {
-		return nil, errors.New(result["return_msg"])
+	b, err := readBillResponse(resp)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return resp, nil
+	return ParseFundFlow(b)
 }
 
 // BatchQueryComment 拉取订单评价数据
@@ -307,9 +663,19 @@ func (mch *Mch) BatchQueryComment(ctx context.Context, beginTime, endTime string
 		m["limit"] = strconv.Itoa(limit[0])
 	}
 
-	m["sign"] = mch.SignWithHMacSHA256(m, true)
+	key, err := mch.signKey(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m["sign"] = signWithHMacSHA256(key, m, true)
+
+	if err = mch.certErr(); err != nil {
+		return nil, err
+	}
 
-	resp, err := mch.tlsClient.PostXML(ctx, BatchQueryCommentURL, m, wx.WithHTTPClose())
+	resp, err := mch.tlsClient.PostXML(ctx, mch.endpoint(BatchQueryCommentURL), m, mch.httpOptions([]wx.HTTPOption{wx.WithHTTPClose()})...)
 
 	if err != nil {
 		return nil, err
@@ -323,7 +689,7 @@ func (mch *Mch) BatchQueryComment(ctx context.Context, beginTime, endTime string
 	}
 
 	if len(result) != 0 && result["return_code"] != ResultSuccess {
-		return nil, errors.New(result["return_msg"])
+		return nil, newReturnError(result)
 	}
 
 	return resp, nil
@@ -331,48 +697,49 @@ func (mch *Mch) BatchQueryComment(ctx context.Context, beginTime, endTime string
 
 // SignWithMD5 生成MD5签名
 func (mch *Mch) SignWithMD5(m wx.WXML, toUpper bool) string {
-	h := md5.New()
-	h.Write([]byte(mch.buildSignStr(m)))
-
-	sign := hex.EncodeToString(h.Sum(nil))
-
-	if toUpper {
-		sign = strings.ToUpper(sign)
-	}
-
-	return sign
+	return signWithMD5(mch.apikey, m, toUpper)
 }
 
 // SignWithHMacSHA256 生成HMAC-SHA256签名
 func (mch *Mch) SignWithHMacSHA256(m wx.WXML, toUpper bool) string {
-	h := hmac.New(sha256.New, []byte(mch.apikey))
-	h.Write([]byte(mch.buildSignStr(m)))
-
-	sign := hex.EncodeToString(h.Sum(nil))
-
-	if toUpper {
-		sign = strings.ToUpper(sign)
-	}
+	return signWithHMacSHA256(mch.apikey, m, toUpper)
+}
 
-	return sign
+// sign 根据签名类型生成大写签名
+func (mch *Mch) sign(m wx.WXML, signType string) string {
+	return signWithType(mch.apikey, m, signType)
 }
 
-// VerifyWXMLResult 微信请求/回调通知签名验证
+// VerifyWXMLResult 微信请求/回调通知签名验证，m 中不含 sign_type 时使用客户端指定的签名类型（WithSignType）
 func (mch *Mch) VerifyWXMLResult(m wx.WXML) error {
-	if wxsign, ok := m["sign"]; ok {
-		signature := ""
+	return mch.verifyWXMLResult(m, mch.signType)
+}
 
-		if v, ok := m["sign_type"]; ok && v == SignHMacSHA256 {
-			signature = mch.SignWithHMacSHA256(m, true)
-		} else {
-			signature = mch.SignWithMD5(m, true)
+func (mch *Mch) verifyWXMLResult(m wx.WXML, signType string) error {
+	if _, ok := m["sign"]; ok {
+		if err := verifySign(mch.verifyKey(), m, signType); err != nil {
+			return err
 		}
+	}
+
+	return mch.verifyIdentity(m)
+}
 
-		if wxsign != signature {
-			return fmt.Errorf("signature verified failed, want: %s, got: %s", signature, wxsign)
+// verifyResponse 验证接口应答的签名（缺少签名视为验证失败，已知不返回签名的接口及 WithSkipSignVerify 指定的接口除外）及 appid、mch_id
+func (mch *Mch) verifyResponse(reqURL string, m wx.WXML, key, signType string) error {
+	if !mch.skipVerify[reqURL] {
+		if _, ok := m["sign"]; ok || !unsignedEndpoints[reqURL] {
+			if err := verifySign(key, m, signType); err != nil {
+				return err
+			}
 		}
 	}
 
+	return mch.verifyIdentity(m)
+}
+
+// verifyIdentity 验证 appid 及 mch_id 与商户一致
+func (mch *Mch) verifyIdentity(m wx.WXML) error {
 	if appid, ok := m["appid"]; ok {
 		if appid != mch.appid {
 			return fmt.Errorf("appid mismatch, want: %s, got: %s", mch.appid, m["appid"])
@@ -388,69 +755,227 @@ func (mch *Mch) VerifyWXMLResult(m wx.WXML) error {
 	return nil
 }
 
-// DecryptWithAES256ECB AES-256-ECB解密（主要用于退款结果通知）
+// DecryptWithAES256ECB AES-256-ECB解密（主要用于退款结果通知，亦可直接使用 ParseRefundNotify 解析）
+// 退款结果通知不含签名，req_info 使用 MD5(apikey) 作为密钥解密，与签名类型（WithSignType）无关，解密失败时返回 ErrInvalidReqInfo
 func (mch *Mch) DecryptWithAES256ECB(encrypt string) (wx.WXML, error) {
-	cipherText, err := base64.StdEncoding.DecodeString(encrypt)
+	return decryptReqInfo(mch.apikey, encrypt)
+}
 
-	if err != nil {
-		return nil, err
+// certState is the merchant certificate or the error of loading it
+type certState struct {
+	cert *tls.Certificate
+	err  error
+}
+
+func (mch *Mch) setCertState(cert *tls.Certificate, err error) {
+	mch.certs.Store(&certState{cert: cert, err: err})
+}
+
+func (mch *Mch) currentCert() *tls.Certificate {
+	if v, ok := mch.certs.Load().(*certState); ok {
+		return v.cert
 	}
 
-	h := md5.New()
-	h.Write([]byte(mch.apikey))
+	return nil
+}
 
-	ecb := wx.NewECBCrypto([]byte(hex.EncodeToString(h.Sum(nil))), wx.PKCS7)
+func (mch *Mch) certErr() error {
+	if v, ok := mch.certs.Load().(*certState); ok {
+		return v.err
+	}
 
-	plainText, err := ecb.Decrypt(cipherText)
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+// closeIdleConnections closes the idle connections of the TLS client, so that the next request presents the new certificate.
+func (mch *Mch) closeIdleConnections() {
+	if c, ok := mch.tlsClient.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// newTLSClient returns the client for the actions requiring TLS, which presents the current certificate on each handshake
+func (mch *Mch) newTLSClient() wx.HTTPClient {
+	tlsCfg := &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := mch.currentCert(); cert != nil {
+				return cert, nil
+			}
+
+			// no certificate is sent
+			return new(tls.Certificate), nil
+		},
+		InsecureSkipVerify: true,
+	}
+
+	if mch.httpClient != nil {
+		// 自定义的 http.RoundTripper 无法配置证书，需要证书的请求均返回错误
+		return wx.NewHTTPClientWith(mch.httpClient, tlsCfg)
 	}
 
-	return wx.ParseXML2Map(plainText)
+	return wx.NewHTTPClient(tlsCfg)
 }
 
 func (mch *Mch) pkcs12ToPem(p12 []byte) (tls.Certificate, error) {
-	blocks, err := pkcs12.ToPEM(p12, mch.mchid)
+	cert, err := wx.LoadCertFromP12Bytes(p12, mch.mchid)
 
-	if err != nil {
-		return tls.Certificate{}, err
+	if err != nil && errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return tls.Certificate{}, fmt.Errorf("gochat: incorrect p12 password, which should be the mchid (%s): %w", mch.mchid, pkcs12.ErrIncorrectPassword)
 	}
 
-	pemData := make([]byte, 0)
+	return cert, err
+}
 
-	for _, b := range blocks {
-		pemData = append(pemData, pem.EncodeToMemory(b)...)
+// signWithType 根据签名类型生成大写签名
+func signWithType(apikey string, m wx.WXML, signType string) string {
+	if signType == SignHMacSHA256 {
+		return wx.SignHMACSHA256(m, apikey)
 	}
 
-	// then use PEM data for tls to construct tls certificate:
-	return tls.X509KeyPair(pemData, pemData)
+	return wx.SignMD5(m, apikey)
 }
 
-// Sign 生成签名
-func (mch *Mch) buildSignStr(m wx.WXML) string {
-	l := len(m)
+func signWithMD5(apikey string, m wx.WXML, toUpper bool) string {
+	sign := wx.SignMD5(m, apikey)
 
-	ks := make([]string, 0, l)
-	kvs := make([]string, 0, l)
+	if !toUpper {
+		sign = strings.ToLower(sign)
+	}
 
-	for k := range m {
-		if k == "sign" {
-			continue
-		}
+	return sign
+}
+
+func signWithHMacSHA256(apikey string, m wx.WXML, toUpper bool) string {
+	sign := wx.SignHMACSHA256(m, apikey)
+
+	if !toUpper {
+		sign = strings.ToLower(sign)
+	}
+
+	return sign
+}
+
+// verifySign 根据 sign_type 重新计算签名并与 sign 比较，m 中不含 sign_type 时使用 signType
+func verifySign(apikey string, m wx.WXML, signType string) error {
+	if v, ok := m["sign_type"]; ok {
+		signType = v
+	}
+
+	signature := ""
+
+	if signType == SignHMacSHA256 {
+		signature = wx.SignHMACSHA256(m, apikey)
+	} else {
+		signature = wx.SignMD5(m, apikey)
+	}
 
-		ks = append(ks, k)
+	if wxsign := m["sign"]; wxsign != signature {
+		return newInvalidSignError(m, signType)
 	}
 
-	sort.Strings(ks)
+	return nil
+}
+
+// newInvalidSignError 返回 *ErrInvalidSign，Fields 为参与签名的字段（跳过空值及 sign 字段）
+func newInvalidSignError(m wx.WXML, signType string) error {
+	fields := make([]string, 0, len(m))
 
-	for _, k := range ks {
-		if v, ok := m[k]; ok && v != "" {
-			kvs = append(kvs, fmt.Sprintf("%s=%s", k, v))
+	for k, v := range m {
+		if k != "sign" && v != "" {
+			fields = append(fields, k)
 		}
 	}
 
-	kvs = append(kvs, fmt.Sprintf("key=%s", mch.apikey))
+	sort.Strings(fields)
+
+	return &ErrInvalidSign{
+		SignType: signType,
+		Fields:   fields,
+		Got:      m["sign"],
+	}
+}
+
+// hmacEndpoints 支持 WithSignType 指定HMAC-SHA256签名的接口（普通支付、退款、委托代扣），未列出的接口使用其自身的签名类型
+var hmacEndpoints = map[string]bool{
+	OrderUnifyURL:           true,
+	OrderQueryURL:           true,
+	OrderCloseURL:           true,
+	MicropayURL:             true,
+	OrderReverseURL:         true,
+	AuthCodeToOpenIDURL:     true,
+	ShortURLURL:             true,
+	RefundApplyURL:          true,
+	RefundQueryURL:          true,
+	PappayAPPEntrustURL:     true,
+	ContractOAEntrust:       true,
+	ContractMPEntrust:       true,
+	PappayContractOrderURL:  true,
+	PappayContractQueryURL:  true,
+	PappayContractDeleteURL: true,
+	PappayApplyURL:          true,
+	PappayOrderQueryURL:     true,
+}
+
+// unsignedEndpoints 应答不含签名的接口（企业付款、红包、获取RSA公钥、交易保障），应答含签名时仍会验证
+var unsignedEndpoints = map[string]bool{
+	TransferToBalanceURL:          true,
+	TransferBalanceOrderQueryURL:  true,
+	TransferToBankCardURL:         true,
+	TransferBankCardOrderQueryURL: true,
+	RedpackNormalURL:              true,
+	RedpackGroupURL:               true,
+	RedpackMinipURL:               true,
+	RedpackQueryURL:               true,
+	RSAPublicKeyURL:               true,
+	ReportURL:                     true,
+}
+
+// mchBaseURL 微信支付接口的默认域名
+const mchBaseURL = "https://api.mch.weixin.qq.com/"
+
+// endpoint 返回请求的实际地址：仿真测试模式下为仿真测试地址（/sandboxnew/...，证书接口去掉 secapi/，APIv3接口除外），指定 WithBaseURL 时替换域名（保留path及query），
+// 非微信支付默认域名的地址原样返回
+func (mch *Mch) endpoint(reqURL string) string {
+	if !strings.HasPrefix(reqURL, mchBaseURL) || (!mch.sandbox && mch.baseURL == "") {
+		return reqURL
+	}
+
+	path := strings.TrimPrefix(reqURL, mchBaseURL)
+
+	if mch.sandbox && !strings.HasPrefix(path, "sandboxnew/") && !strings.HasPrefix(path, "v3/") {
+		path = "sandboxnew/" + strings.TrimPrefix(path, "secapi/")
+	}
+
+	if mch.baseURL != "" {
+		return mch.baseURL + "/" + path
+	}
+
+	return mchBaseURL + path
+}
+
+// httpOptions 在请求选项前加上客户端级别的选项（如调试钩子、监控指标、限流），请求选项优先
+func (mch *Mch) httpOptions(options []wx.HTTPOption) []wx.HTTPOption {
+	if mch.debug == nil && mch.logger == nil && mch.metrics == nil && mch.limiter == nil {
+		return options
+	}
+
+	opts := make([]wx.HTTPOption, 0, len(options)+4)
+
+	if mch.debug != nil {
+		opts = append(opts, wx.WithDebugFunc(mch.debug))
+	}
+
+	if mch.logger != nil {
+		opts = append(opts, wx.WithLogger(mch.logger))
+	}
+
+	if mch.metrics != nil {
+		opts = append(opts, wx.WithMetrics(mch.metrics))
+	}
+
+	if mch.limiter != nil {
+		opts = append(opts, wx.WithLimiter(mch.limiter))
+	}
 
-	return strings.Join(kvs, "&")
+	return append(opts, options...)
 }
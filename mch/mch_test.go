@@ -1,12 +1,21 @@
 package mch
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pkcs12"
 )
 
 func TestLoadCertFromPemBlock(t *testing.T) {
@@ -15,65 +24,261 @@ func TestLoadCertFromPemBlock(t *testing.T) {
 	assert.Nil(t, mch.LoadCertFromPemBlock(certPemBlock, keyPemBlock))
 }
 
-// 涉及时间戳，签名会变化（已通过固定时间戳验证）
-// func TestAPPAPI(t *testing.T) {
-// 	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
-
-// 	mch.nonce = func(size int) string {
-// 		return "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
-// 	}
-
-// 	m := mch.APPAPI("WX1217752501201407033233368018")
-
-// 	assert.Equal(t, wx.WXML{
-// 		"appid":     "wx2421b1c4370ec43b",
-// 		"partnerid": "10000100",
-// 		"prepayid":  "WX1217752501201407033233368018",
-// 		"package":   "Sign=WXPay",
-// 		"noncestr":  "5K8264ILTKCH16CQ2502SI8ZNMTM67VS",
-// 		"timestamp": "1414561699",
-// 		"sign":      "C9612FA7A6BA5F51E195D5F9337CA288",
-// 	}, m)
-// }
-
-// 涉及时间戳，签名会变化（已通过固定时间戳验证）
-// func TestJSAPI(t *testing.T) {
-// 	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
-
-// 	mch.nonce = func(size int) string {
-// 		return "e61463f8efa94090b1f366cccfbbb444"
-// 	}
-
-// 	m := mch.JSAPI("u802345jgfjsdfgsdg888")
-
-// 	assert.Equal(t, wx.WXML{
-// 		"appId":     "wx2421b1c4370ec43b",
-// 		"timeStamp": "1414561699",
-// 		"nonceStr":  "e61463f8efa94090b1f366cccfbbb444",
-// 		"package":   "prepay_id=u802345jgfjsdfgsdg888",
-// 		"signType":  "MD5",
-// 		"paySign":   "A62A01211E36F5D2173A9EE93EBAC56C",
-// 	}, m)
-// }
-
-// 涉及时间戳，签名会变化（已通过固定时间戳验证）
-// func TestMinipRedpackJSAPI(t *testing.T) {
-// 	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
-
-// 	mch.nonce = func(size int) string {
-// 		return "e61463f8efa94090b1f366cccfbbb444"
-// 	}
-
-// 	m := mch.MinipRedpackJSAPI("sendid=242e8abd163d300019b2cae74ba8e8c06e3f0e51ab84d16b3c80decd22a5b672&ver=8&sign=4110d649a5aef52dd6b95654ddf91ca7d5411ac159ace4e1a766b7d3967a1c3dfe1d256811445a4abda2d9cfa4a9b377a829258bd00d90313c6c346f2349fe5d&mchid=11475856&appid=wxd27ebc41b85ce36d")
-
-// 	assert.Equal(t, wx.WXML{
-// 		"timeStamp": "1414561699",
-// 		"nonceStr":  "e61463f8efa94090b1f366cccfbbb444",
-// 		"package":   "sendid%3D242e8abd163d300019b2cae74ba8e8c06e3f0e51ab84d16b3c80decd22a5b672%26ver%3D8%26sign%3D4110d649a5aef52dd6b95654ddf91ca7d5411ac159ace4e1a766b7d3967a1c3dfe1d256811445a4abda2d9cfa4a9b377a829258bd00d90313c6c346f2349fe5d%26mchid%3D11475856%26appid%3Dwxd27ebc41b85ce36d",
-// 		"signType":  "MD5",
-// 		"paySign":   "0cecd02326e26c27fbc77f6062ef8654",
-// 	}, m)
-// }
+func TestLoadCertFromP12File(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	assert.Nil(t, mch.LoadCertFromP12File("testdata/apiclient_cert.p12"))
+}
+
+func TestPKCS12ToPem(t *testing.T) {
+	p12, err := ioutil.ReadFile("testdata/apiclient_cert.p12")
+
+	assert.Nil(t, err)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	cert, err := mch.pkcs12ToPem(p12)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cert.Certificate))
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+
+	assert.Nil(t, err)
+	assert.Equal(t, "10000100", leaf.Subject.CommonName)
+
+	// 私钥与证书匹配
+	key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+
+	assert.True(t, ok)
+	assert.Equal(t, leaf.PublicKey, &key.PublicKey)
+}
+
+func TestPKCS12ToPemWithIncorrectPassword(t *testing.T) {
+	p12, err := ioutil.ReadFile("testdata/apiclient_cert.p12")
+
+	assert.Nil(t, err)
+
+	mch := New("wx2421b1c4370ec43b", "10000101", "192006250b4c09247ec02edce69f6a2d")
+
+	_, err = mch.pkcs12ToPem(p12)
+
+	assert.True(t, errors.Is(err, pkcs12.ErrIncorrectPassword))
+	assert.Equal(t, "gochat: incorrect p12 password, which should be the mchid (10000101): pkcs12: decryption password incorrect", err.Error())
+
+	// 证书错误在调用需要证书的接口时返回
+	mch = New("wx2421b1c4370ec43b", "10000101", "192006250b4c09247ec02edce69f6a2d", WithPKCS12(p12))
+
+	_, err = mch.DownloadFundFlow(context.TODO(), "20141110", AccountTypeBasic)
+
+	assert.True(t, errors.Is(err, pkcs12.ErrIncorrectPassword))
+}
+
+func TestWithPKCS12(t *testing.T) {
+	p12, err := ioutil.ReadFile("testdata/apiclient_cert.p12")
+
+	assert.Nil(t, err)
+
+	peerCerts := make([][]byte, 0)
+
+	ts, client := newCertTestServer(t, &peerCerts)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithPKCS12(p12))
+
+	_, err = mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{mch.currentCert().Certificate[0]}, peerCerts)
+}
+
+func TestAPPAPI(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+	}
+	mch.timestamp = func() int64 {
+		return 1414561699
+	}
+
+	m := mch.APPAPI("WX1217752501201407033233368018")
+
+	assert.Equal(t, wx.WXML{
+		"appid":     "wx2421b1c4370ec43b",
+		"partnerid": "10000100",
+		"prepayid":  "WX1217752501201407033233368018",
+		"package":   "Sign=WXPay",
+		"noncestr":  "5K8264ILTKCH16CQ2502SI8ZNMTM67VS",
+		"timestamp": "1414561699",
+		"sign":      "C9612FA7A6BA5F51E195D5F9337CA288",
+	}, m)
+}
+
+func TestJSAPI(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "e61463f8efa94090b1f366cccfbbb444"
+	}
+	mch.timestamp = func() int64 {
+		return 1414561699
+	}
+
+	m := mch.JSAPI("u802345jgfjsdfgsdg888")
+
+	assert.Equal(t, wx.WXML{
+		"appId":     "wx2421b1c4370ec43b",
+		"timeStamp": "1414561699",
+		"nonceStr":  "e61463f8efa94090b1f366cccfbbb444",
+		"package":   "prepay_id=u802345jgfjsdfgsdg888",
+		"signType":  "MD5",
+		"paySign":   "A62A01211E36F5D2173A9EE93EBAC56C",
+	}, m)
+}
+
+func TestJSAPIPayParams(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "e61463f8efa94090b1f366cccfbbb444"
+	}
+	mch.timestamp = func() int64 {
+		return 1414561699
+	}
+
+	assert.Equal(t, JSAPIPayParams{
+		AppID:     "wx2421b1c4370ec43b",
+		TimeStamp: "1414561699",
+		NonceStr:  "e61463f8efa94090b1f366cccfbbb444",
+		Package:   "prepay_id=u802345jgfjsdfgsdg888",
+		SignType:  "MD5",
+		PaySign:   "A62A01211E36F5D2173A9EE93EBAC56C",
+	}, mch.JSAPIPayParams("u802345jgfjsdfgsdg888"))
+}
+
+func TestJSAPIPayParamsWithHMacSHA256(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(SignHMacSHA256))
+
+	mch.nonce = func(size int) string {
+		return "e61463f8efa94090b1f366cccfbbb444"
+	}
+	mch.timestamp = func() int64 {
+		return 1414561699
+	}
+
+	assert.Equal(t, JSAPIPayParams{
+		AppID:     "wx2421b1c4370ec43b",
+		TimeStamp: "1414561699",
+		NonceStr:  "e61463f8efa94090b1f366cccfbbb444",
+		Package:   "prepay_id=u802345jgfjsdfgsdg888",
+		SignType:  "HMAC-SHA256",
+		PaySign:   "311C3B8F50AAA11ACBCC756E871203F9F00606B4BCCA1BDCFAFD005BCF7646DE",
+	}, mch.JSAPIPayParams("u802345jgfjsdfgsdg888"))
+}
+
+func TestMinipPayParams(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d",
+		WithNonceFunc(func(size int) string {
+			return "e61463f8efa94090b1f366cccfbbb444"
+		}),
+		WithTimestampFunc(func() int64 {
+			return 1414561699
+		}),
+	)
+
+	assert.Equal(t, JSAPIPayParams{
+		AppID:     "wx2421b1c4370ec43b",
+		TimeStamp: "1414561699",
+		NonceStr:  "e61463f8efa94090b1f366cccfbbb444",
+		Package:   "prepay_id=u802345jgfjsdfgsdg888",
+		SignType:  "MD5",
+		PaySign:   "A62A01211E36F5D2173A9EE93EBAC56C",
+	}, mch.MinipPayParams("u802345jgfjsdfgsdg888"))
+}
+
+func TestAPPPayParams(t *testing.T) {
+	cases := []struct {
+		signType string
+		sign     string
+	}{
+		{SignMD5, "C9612FA7A6BA5F51E195D5F9337CA288"},
+		{SignHMacSHA256, "A4A9764D18C3087D12514D8A984F41AFB8805CD147D12195A7B151DCD5A5BB7F"},
+	}
+
+	for _, c := range cases {
+		mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d",
+			WithSignType(c.signType),
+			WithNonceFunc(func(size int) string {
+				return "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+			}),
+			WithTimestampFunc(func() int64 {
+				return 1414561699
+			}),
+		)
+
+		assert.Equal(t, APPPayParams{
+			AppID:     "wx2421b1c4370ec43b",
+			PartnerID: "10000100",
+			PrepayID:  "WX1217752501201407033233368018",
+			Package:   "Sign=WXPay",
+			NonceStr:  "5K8264ILTKCH16CQ2502SI8ZNMTM67VS",
+			Timestamp: "1414561699",
+			Sign:      c.sign,
+		}, mch.APPPayParams("WX1217752501201407033233368018"))
+	}
+}
+
+func TestDeprecatedPayParams(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d",
+		WithNonceFunc(func(size int) string {
+			return "e61463f8efa94090b1f366cccfbbb444"
+		}),
+		WithTimestampFunc(func() int64 {
+			return 1414561699
+		}),
+	)
+
+	assert.Equal(t, mch.JSAPIPayParams("u802345jgfjsdfgsdg888"), mch.JSAPIParams("u802345jgfjsdfgsdg888"))
+	assert.Equal(t, mch.MinipPayParams("u802345jgfjsdfgsdg888"), mch.MinipParams("u802345jgfjsdfgsdg888"))
+	assert.Equal(t, mch.APPPayParams("WX1217752501201407033233368018"), mch.APPParams("WX1217752501201407033233368018"))
+}
+
+func TestMinipRedpackJSAPI(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "e61463f8efa94090b1f366cccfbbb444"
+	}
+	mch.timestamp = func() int64 {
+		return 1414561699
+	}
+
+	m := mch.MinipRedpackJSAPI("sendid=242e8abd163d300019b2cae74ba8e8c06e3f0e51ab84d16b3c80decd22a5b672&ver=8&sign=4110d649a5aef52dd6b95654ddf91ca7d5411ac159ace4e1a766b7d3967a1c3dfe1d256811445a4abda2d9cfa4a9b377a829258bd00d90313c6c346f2349fe5d&mchid=11475856&appid=wxd27ebc41b85ce36d")
+
+	assert.Equal(t, wx.WXML{
+		"timeStamp": "1414561699",
+		"nonceStr":  "e61463f8efa94090b1f366cccfbbb444",
+		"package":   "sendid%3D242e8abd163d300019b2cae74ba8e8c06e3f0e51ab84d16b3c80decd22a5b672%26ver%3D8%26sign%3D4110d649a5aef52dd6b95654ddf91ca7d5411ac159ace4e1a766b7d3967a1c3dfe1d256811445a4abda2d9cfa4a9b377a829258bd00d90313c6c346f2349fe5d%26mchid%3D11475856%26appid%3Dwxd27ebc41b85ce36d",
+		"signType":  "MD5",
+		"paySign":   "0cecd02326e26c27fbc77f6062ef8654",
+	}, m)
+}
+
+// billCSV 交易账单（ALL）
+const billCSV = `交易时间,公众账号ID,商户号,子商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,总金额,代金券或立减优惠金额,微信退款单号,商户退款单号,退款金额,代金券或立减优惠退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率
+2014-11-10 16:33:45,wx2421b1c4370ec43b,10000100,0,1000,1001690740201411100005734289,1415640626,085e9858e3ba5186aafcbaed1,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
+2014-11-10 16:46:14,wx2421b1c4370ec43b,10000100,0,1000,1002780740201411100005729794,1415635270,085e9858e90ca40c0b5aee463,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
+总交易单数,总交易额,总退款金额,总代金券或立减优惠退款金额,手续费总金额
+2,0.02,0.0,0.0,0`
 
 func TestDownloadBill(t *testing.T) {
 	ctrl := gomock.NewController(t)
@@ -88,11 +293,7 @@ func TestDownloadBill(t *testing.T) {
 		"bill_type": "ALL",
 		"nonce_str": "21df7dc9cd8616b56919f20d9f679233",
 		"sign":      "EACED4DF2125661537FEA38B687AA24A",
-	}, gomock.AssignableToTypeOf(wx.WithHTTPClose())).Return([]byte(`交易时间,公众账号ID,商户号,子商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,总金额,代金券或立减优惠金额,微信退款单号,商户退款单号,退款金额,代金券或立减优惠退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率
-2014-11-10 16:33:45,wx2421b1c4370ec43b,10000100,0,1000,1001690740201411100005734289,1415640626,085e9858e3ba5186aafcbaed1,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-2014-11-10 16:46:14,wx2421b1c4370ec43b,10000100,0,1000,1002780740201411100005729794,1415635270,085e9858e90ca40c0b5aee463,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-总交易单数,总交易额,总退款金额,总代金券或立减优惠退款金额,手续费总金额
-2,0.02,0.0,0.0,0`), nil)
+	}, gomock.AssignableToTypeOf(wx.WithHTTPClose())).Return([]byte(billCSV), nil)
 
 	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
 
@@ -102,34 +303,139 @@ func TestDownloadBill(t *testing.T) {
 	mch.client = client
 	mch.tlsClient = client
 
-	b, err := mch.DownloadBill(context.TODO(), "20141110", BillTypeAll)
+	bill, err := mch.DownloadBill(context.TODO(), "20141110", BillTypeAll, false)
 
 	assert.Nil(t, err)
-	assert.Equal(t, []byte(`交易时间,公众账号ID,商户号,子商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,总金额,代金券或立减优惠金额,微信退款单号,商户退款单号,退款金额,代金券或立减优惠退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率
-2014-11-10 16:33:45,wx2421b1c4370ec43b,10000100,0,1000,1001690740201411100005734289,1415640626,085e9858e3ba5186aafcbaed1,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-2014-11-10 16:46:14,wx2421b1c4370ec43b,10000100,0,1000,1002780740201411100005729794,1415635270,085e9858e90ca40c0b5aee463,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-总交易单数,总交易额,总退款金额,总代金券或立减优惠退款金额,手续费总金额
-2,0.02,0.0,0.0,0`), b)
+	assert.Equal(t, []byte(billCSV), bill.Raw)
+	assert.Equal(t, 24, len(bill.Header))
+	assert.Equal(t, 2, len(bill.Rows))
+	assert.Equal(t, &BillRow{
+		TradeTime:     time.Date(2014, 11, 10, 16, 33, 45, 0, beijing),
+		AppID:         "wx2421b1c4370ec43b",
+		MchID:         "10000100",
+		SubMchID:      "0",
+		DeviceInfo:    "1000",
+		TransactionID: "1001690740201411100005734289",
+		OutTradeNO:    "1415640626",
+		OpenID:        "085e9858e3ba5186aafcbaed1",
+		TradeType:     "MICROPAY",
+		TradeState:    "SUCCESS",
+		BankType:      "OTHERS",
+		FeeType:       "CNY",
+		TotalFee:      1,
+		RefundID:      "0",
+		OutRefundNO:   "0",
+		Body:          "被扫支付测试",
+		Attach:        "订单额外描述",
+		Rate:          "0.60%",
+	}, bill.Rows[0])
+	assert.Equal(t, &BillSummary{
+		TotalCount: 2,
+		TotalFee:   2,
+	}, bill.Summary)
 }
 
+func TestDownloadBillGzip(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(billCSV))
+	zw.Close()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/downloadbill", wx.WXML{
+		"appid":     "wx2421b1c4370ec43b",
+		"mch_id":    "10000100",
+		"bill_date": "20141110",
+		"bill_type": "ALL",
+		"tar_type":  "GZIP",
+		"nonce_str": "21df7dc9cd8616b56919f20d9f679233",
+		"sign":      "F0CE34855A5D7AA6D52AD86D2871B67B",
+	}, gomock.AssignableToTypeOf(wx.WithHTTPClose())).Return(buf.Bytes(), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "21df7dc9cd8616b56919f20d9f679233"
+	}))
+	mch.client = client
+
+	bill, err := mch.DownloadBill(context.TODO(), "20141110", BillTypeAll, true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(billCSV), bill.Raw)
+	assert.Equal(t, 2, len(bill.Rows))
+	assert.Equal(t, 2, bill.Summary.TotalCount)
+}
+
+func TestDownloadBillInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(billCSV))
+	zw.Close()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		// 失败时返回XML
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/downloadbill", gomock.Any(), gomock.Any()).Return([]byte(`<xml>
+	<return_code><![CDATA[FAIL]]></return_code>
+	<return_msg><![CDATA[No Bill Exist]]></return_msg>
+	<error_code><![CDATA[20002]]></error_code>
+</xml>`), nil),
+		// gzip数据被截断
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/downloadbill", gomock.Any(), gomock.Any()).Return(buf.Bytes()[:buf.Len()/2], nil),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = client
+
+	_, err := mch.DownloadBill(context.TODO(), "20141110", BillTypeAll, false)
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "No Bill Exist"}, err)
+
+	_, err = mch.DownloadBill(context.TODO(), "20141110", BillTypeAll, true)
+
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Contains(t, err.Error(), "gochat: invalid gzip bill")
+}
+
+const fundFlowCSV = "记账时间,微信支付业务单号,资金流水单号,业务名称,业务类型,收支类型,收支金额（元）,账户结余（元）,资金变更提交申请人,备注,业务凭证号\r\n" +
+	"`2018-02-01 04:21:23,`50000305742018020103387128253,`1900009231201802015884652186,`退款,`退款,`支出,`0.02,`0.17,`system,`缺货,`REF4200000068201801293084726067\r\n" +
+	"`2018-02-01 10:12:05,`4200000068201801293084726067,`1900009231201802016884652190,`交易,`交易,`收入,`0.19,`0.36,`system,`,`4200000068201801293084726067\r\n" +
+	"资金流水总笔数,收入笔数,收入金额,支出笔数,支出金额\r\n" +
+	"`2.0,`1.0,`0.19,`1.0,`0.02\r\n"
+
 func TestDownloadFundFlow(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	client := wx.NewMockHTTPClient(ctrl)
 
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(fundFlowCSV))
+	zw.Close()
+
+	// 无论客户端的签名类型，均使用HMAC-SHA256签名
 	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/downloadfundflow", wx.WXML{
 		"appid":        "wx2421b1c4370ec43b",
 		"mch_id":       "10000100",
-		"bill_date":    "20141110",
+		"bill_date":    "20180201",
 		"account_type": "Basic",
+		"sign_type":    "HMAC-SHA256",
+		"tar_type":     "GZIP",
 		"nonce_str":    "21df7dc9cd8616b56919f20d9f679233",
-		"sign":         "0CFBED32BC688027EDB0A8D817FCF20D6B2DAAC26384A75E6F9A12FD6739CAED",
-	}, gomock.AssignableToTypeOf(wx.WithHTTPClose())).Return([]byte(`交易时间,公众账号ID,商户号,子商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,总金额,代金券或立减优惠金额,微信退款单号,商户退款单号,退款金额,代金券或立减优惠退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率
-2014-11-10 16:33:45,wx2421b1c4370ec43b,10000100,0,1000,1001690740201411100005734289,1415640626,085e9858e3ba5186aafcbaed1,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-2014-11-10 16:46:14,wx2421b1c4370ec43b,10000100,0,1000,1002780740201411100005729794,1415635270,085e9858e90ca40c0b5aee463,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-总交易单数,总交易额,总退款金额,总代金券或立减优惠退款金额,手续费总金额
-2,0.02,0.0,0.0,0`), nil)
+		"sign":         "302C52E45D13ADBD3FA9737108320D528089F1715ED8A2CE65FF3DC526CF94CD",
+	}, gomock.AssignableToTypeOf(wx.WithHTTPClose())).Return(buf.Bytes(), nil)
 
 	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
 
@@ -139,14 +445,67 @@ func TestDownloadFundFlow(t *testing.T) {
 	mch.client = client
 	mch.tlsClient = client
 
-	b, err := mch.DownloadFundFlow(context.TODO(), "20141110", AccountTypeBasic)
+	flow, err := mch.DownloadFundFlow(context.TODO(), "20180201", AccountTypeBasic)
 
 	assert.Nil(t, err)
-	assert.Equal(t, []byte(`交易时间,公众账号ID,商户号,子商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,总金额,代金券或立减优惠金额,微信退款单号,商户退款单号,退款金额,代金券或立减优惠退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率
-2014-11-10 16:33:45,wx2421b1c4370ec43b,10000100,0,1000,1001690740201411100005734289,1415640626,085e9858e3ba5186aafcbaed1,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-2014-11-10 16:46:14,wx2421b1c4370ec43b,10000100,0,1000,1002780740201411100005729794,1415635270,085e9858e90ca40c0b5aee463,MICROPAY,SUCCESS,OTHERS,CNY,0.01,0.0,0,0,0,0,,,被扫支付测试,订单额外描述,0,0.60%
-总交易单数,总交易额,总退款金额,总代金券或立减优惠退款金额,手续费总金额
-2,0.02,0.0,0.0,0`), b)
+	assert.Equal(t, []byte(fundFlowCSV), flow.Raw)
+	assert.Equal(t, []*FundFlowRow{
+		{
+			BillingTime:      time.Date(2018, 2, 1, 4, 21, 23, 0, beijing),
+			BizTransactionID: "50000305742018020103387128253",
+			FundFlowID:       "1900009231201802015884652186",
+			BizName:          "退款",
+			BizType:          "退款",
+			FinancialType:    "支出",
+			Amount:           2,
+			Balance:          17,
+			Applicant:        "system",
+			Memo:             "缺货",
+			BizVoucherID:     "REF4200000068201801293084726067",
+		},
+		{
+			BillingTime:      time.Date(2018, 2, 1, 10, 12, 5, 0, beijing),
+			BizTransactionID: "4200000068201801293084726067",
+			FundFlowID:       "1900009231201802016884652190",
+			BizName:          "交易",
+			BizType:          "交易",
+			FinancialType:    "收入",
+			Amount:           19,
+			Balance:          36,
+			Applicant:        "system",
+			BizVoucherID:     "4200000068201801293084726067",
+		},
+	}, flow.Rows)
+	assert.Equal(t, &FundFlowSummary{
+		TotalCount:        2,
+		IncomeCount:       1,
+		IncomeAmount:      19,
+		ExpenditureCount:  1,
+		ExpenditureAmount: 2,
+	}, flow.Summary)
+}
+
+func TestDownloadFundFlowFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/downloadfundflow", gomock.Any(), gomock.Any()).Return([]byte(`<xml><return_code><![CDATA[FAIL]]></return_code><return_msg><![CDATA[No Bill Exist]]></return_msg><error_code><![CDATA[20002]]></error_code></xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.client = client
+	mch.tlsClient = client
+
+	flow, err := mch.DownloadFundFlow(context.TODO(), "20180201", AccountTypeOperation)
+
+	assert.Nil(t, flow)
+
+	var returnErr *ReturnError
+
+	assert.True(t, errors.As(err, &returnErr))
+	assert.Equal(t, "No Bill Exist", returnErr.ReturnMsg)
 }
 
 func TestBatchQueryComment(t *testing.T) {
@@ -218,6 +577,47 @@ func TestSignWithHMacSHA256(t *testing.T) {
 	assert.Equal(t, "3B12F569A5714858F8251366BC3CBCDDBD249905CCA01D8F56D365EF1FC2CA5C", mch.SignWithHMacSHA256(m, true))
 }
 
+func TestWithSignType(t *testing.T) {
+	cases := []struct {
+		signType string
+		sign     string
+	}{
+		{SignMD5, "72D4DE9625257C606558F1027331C516"},
+		{SignHMacSHA256, "B2B78B206EDC0392055030BC16EA076A70BFBC2351B34CFFAF3F32D521C8B231"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.signType, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			client := wx.NewMockHTTPClient(ctrl)
+
+			// 同一组字段，sign_type 随客户端签名类型输出，签名以 apikey 为密钥
+			client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/closeorder", wx.WXML{
+				"appid":        "wx2421b1c4370ec43b",
+				"mch_id":       "10000100",
+				"out_trade_no": "1415983244",
+				"nonce_str":    "4ca93f17ddf3443ceabf72f26d64fe0e",
+				"sign_type":    c.signType,
+				"sign":         c.sign,
+			}).Return([]byte(`<xml>
+	<return_code>FAIL</return_code>
+	<return_msg>签名错误</return_msg>
+</xml>`), nil)
+
+			mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(c.signType), WithNonceFunc(func(size int) string {
+				return "4ca93f17ddf3443ceabf72f26d64fe0e"
+			}))
+			mch.client = client
+
+			_, err := mch.Do(context.TODO(), CloseOrder("1415983244"))
+
+			assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "签名错误"}, err)
+		})
+	}
+}
+
 func TestVerifyWXMLResult(t *testing.T) {
 	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
 
@@ -365,3 +765,102 @@ DQIDAQAB
 
 	m.Run()
 }
+
+func TestWithBaseURL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api2.mch.weixin.qq.com/pay/orderquery", wx.WXML{
+		"appid":        "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"out_trade_no": "1415757673",
+		"nonce_str":    "ec2316275641faa3aacf3cc599e8730f",
+		"sign_type":    "MD5",
+		"sign":         "5F222EA3F23200DD4E86C4C42E96698D",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<device_info>1000</device_info>
+	<nonce_str>TN55wO9Pba5yENl8</nonce_str>
+	<sign>07EACC03ED8DD7F1BAB6BBE1853EF998</sign>
+	<result_code>SUCCESS</result_code>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<is_subscribe>Y</is_subscribe>
+	<trade_type>APP</trade_type>
+	<bank_type>CCB_DEBIT</bank_type>
+	<total_fee>1</total_fee>
+	<fee_type>CNY</fee_type>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<attach>订单额外描述</attach>
+	<time_end>20141111170043</time_end>
+	<trade_state>SUCCESS</trade_state>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithBaseURL("https://api2.mch.weixin.qq.com"))
+
+	mch.nonce = func(size int) string {
+		return "ec2316275641faa3aacf3cc599e8730f"
+	}
+	mch.client = client
+
+	r, err := mch.Do(context.TODO(), QueryOrderByOutTradeNO("1415757673"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1008450740201411110005820873", r["transaction_id"])
+}
+
+func TestEndpoint(t *testing.T) {
+	cases := []struct {
+		name    string
+		options []Option
+		reqURL  string
+		want    string
+	}{
+		{
+			name:   "default",
+			reqURL: OrderQueryURL,
+			want:   "https://api.mch.weixin.qq.com/pay/orderquery",
+		},
+		{
+			name:    "base url",
+			options: []Option{WithBaseURL("https://api2.mch.weixin.qq.com")},
+			reqURL:  RefundApplyURL,
+			want:    "https://api2.mch.weixin.qq.com/secapi/pay/refund",
+		},
+		{
+			name:    "base url with path prefix and query",
+			options: []Option{WithBaseURL("https://proxy.example.com/wxpay/")},
+			reqURL:  "https://api.mch.weixin.qq.com/v3/certificates?offset=0&limit=10",
+			want:    "https://proxy.example.com/wxpay/v3/certificates?offset=0&limit=10",
+		},
+		{
+			name:    "other host",
+			options: []Option{WithBaseURL("https://api2.mch.weixin.qq.com")},
+			reqURL:  RSAPublicKeyURL,
+			want:    "https://fraud.mch.weixin.qq.com/risk/getpublickey",
+		},
+		{
+			name:    "sandbox with base url",
+			options: []Option{WithSandbox(), WithBaseURL("https://api2.mch.weixin.qq.com")},
+			reqURL:  RefundApplyURL,
+			want:    "https://api2.mch.weixin.qq.com/sandboxnew/pay/refund",
+		},
+		{
+			name:    "sandbox apiv3",
+			options: []Option{WithSandbox()},
+			reqURL:  PlatformCertificatesURL,
+			want:    "https://api.mch.weixin.qq.com/v3/certificates",
+		},
+	}
+
+	for _, c := range cases {
+		mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", c.options...)
+
+		assert.Equal(t, c.want, mch.endpoint(c.reqURL), c.name)
+	}
+}
@@ -0,0 +1,329 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// MicropayData 付款码支付数据
+type MicropayData struct {
+	// 必填参数
+	OutTradeNO     string // 商户系统内部的订单号，32个字符内、可包含字母，同一个商户号下唯一
+	TotalFee       int    // 订单总金额，单位为分，只能为整数
+	SpbillCreateIP string // 调用微信支付API的机器IP
+	Body           string // 商品或支付单简要描述
+	AuthCode       string // 扫码支付付款码，设备读取用户微信中的条码或者二维码信息
+	// 选填参数
+	DeviceInfo string // 终端设备号（商户自定义，如门店编号）
+	Detail     string // 商品名称明细列表
+	Attach     string // 附加数据，在查询API和支付通知中原样返回
+	FeeType    string // 符合ISO 4217标准的三位字母代码，默认人民币：CNY
+	GoodsTag   string // 订单优惠标记，代金券或立减优惠功能的参数
+	LimitPay   string // no_credit--指定不能使用信用卡支付
+	TimeStart  string // 订单生成时间，格式为yyyyMMddHHmmss
+	TimeExpire string // 订单失效时间，格式为yyyyMMddHHmmss
+	Receipt    bool   // 是否在支付成功消息和支付详情页中出现开票入口
+	SceneInfo  string // 该字段用于上报场景信息
+}
+
+// Micropay 付款码支付（收银员使用扫码设备读取微信用户付款码以后，二维码或条码信息会传送至商户收银台，由商户收银台或者商户后台调用该接口发起支付）
+func Micropay(data *MicropayData) wx.Action {
+	return wx.NewAction(MicropayURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			body := wx.WXML{
+				"appid":            appid,
+				"mch_id":           mchid,
+				"nonce_str":        nonce,
+				"body":             data.Body,
+				"out_trade_no":     data.OutTradeNO,
+				"total_fee":        strconv.Itoa(data.TotalFee),
+				"spbill_create_ip": data.SpbillCreateIP,
+				"auth_code":        data.AuthCode,
+				"sign_type":        SignMD5,
+			}
+
+			if data.DeviceInfo != "" {
+				body["device_info"] = data.DeviceInfo
+			}
+
+			if data.Detail != "" {
+				body["detail"] = data.Detail
+			}
+
+			if data.Attach != "" {
+				body["attach"] = data.Attach
+			}
+
+			if data.FeeType != "" {
+				body["fee_type"] = data.FeeType
+			}
+
+			if data.GoodsTag != "" {
+				body["goods_tag"] = data.GoodsTag
+			}
+
+			if data.LimitPay != "" {
+				body["limit_pay"] = data.LimitPay
+			}
+
+			if data.TimeStart != "" {
+				body["time_start"] = data.TimeStart
+			}
+
+			if data.TimeExpire != "" {
+				body["time_expire"] = data.TimeExpire
+			}
+
+			if data.Receipt {
+				body["receipt"] = "Y"
+			}
+
+			if data.SceneInfo != "" {
+				body["scene_info"] = data.SceneInfo
+			}
+
+			return body, nil
+		}),
+	)
+}
+
+// Reverse 撤销订单（支付交易返回失败或支付系统超时，调用该接口撤销交易）
+// 【注意：7天以内的交易单可调用撤销，其他正常支付的单如需实现相同功能请调用申请退款API。】
+func Reverse(outTradeNO string) wx.Action {
+	return wx.NewAction(OrderReverseURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithTLS(),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			return wx.WXML{
+				"appid":        appid,
+				"mch_id":       mchid,
+				"out_trade_no": outTradeNO,
+				"nonce_str":    nonce,
+				"sign_type":    SignMD5,
+			}, nil
+		}),
+	)
+}
+
+// AuthCodeToOpenID 付款码查询openid
+func AuthCodeToOpenID(authCode string) wx.Action {
+	return wx.NewAction(AuthCodeToOpenIDURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			return wx.WXML{
+				"appid":     appid,
+				"mch_id":    mchid,
+				"auth_code": authCode,
+				"nonce_str": nonce,
+			}, nil
+		}),
+	)
+}
+
+// MicropayRequest 付款码支付请求
+type MicropayRequest = MicropayData
+
+// Micropay 付款码支付，支付成功时返回订单信息（TradeState 为 SUCCESS）
+// 请求前校验：total_fee 须大于0，auth_code 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError，
+// 可通过 errors.Is 判断 ErrUserPaying、ErrSystemError、ErrBankError，此时支付结果未知，需轮询订单查询并在超时后撤销订单（见 MicropayWithPolling）
+func (mch *Mch) Micropay(ctx context.Context, req *MicropayRequest, options ...wx.HTTPOption) (*OrderQueryResult, error) {
+	if req.TotalFee <= 0 {
+		return nil, errors.New("gochat: total_fee must be positive")
+	}
+
+	if req.AuthCode == "" {
+		return nil, errors.New("gochat: auth_code is required")
+	}
+
+	r, err := mch.Do(ctx, Micropay(req), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	result, err := parseOrderQueryResult(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 付款码支付的成功应答不含 trade_state
+	result.TradeState = TradeStateSuccess
+
+	return result, nil
+}
+
+// Reverse 撤销订单（需加载商户证书），recall 为 true 时表示需要继续调用撤销
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) Reverse(ctx context.Context, outTradeNO string, options ...wx.HTTPOption) (recall bool, err error) {
+	r, err := mch.Do(ctx, Reverse(outTradeNO), options...)
+
+	if err != nil {
+		return false, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return r["recall"] == "Y", newResultError(r)
+	}
+
+	return r["recall"] == "Y", nil
+}
+
+// 付款码支付轮询的默认设置（微信建议：用户支付中时每隔5秒查询一次，30秒后仍未支付成功则撤销订单）
+const (
+	DefaultMicropayPollInterval = 5 * time.Second
+	DefaultMicropayPollTimeout  = 30 * time.Second
+)
+
+// maxReverseAttempts 撤销订单应答 recall=Y 时的最大调用次数
+const maxReverseAttempts = 10
+
+// ErrMicropayReversed 付款码支付未在期限内成功（或支付失败），订单已撤销
+var ErrMicropayReversed = errors.New("gochat: micropay not paid before deadline, order reversed")
+
+// MicropayPolling 付款码支付的轮询设置
+type MicropayPolling struct {
+	Interval wx.BackoffFunc // 第n次（从1开始）查询订单前，以及撤销订单应答 recall=Y 后第n次重新撤销前的等待时间，默认：每次5秒
+	Timeout  time.Duration  // 自发起支付起，超过该时长仍未支付成功则撤销订单，默认：30秒
+}
+
+// MicropayWithPolling 付款码支付，支付结果未知（USERPAYING、SYSTEMERROR、BANKERROR）时按 polling 轮询订单查询，
+// 支付成功则返回订单信息；超过期限仍未成功或支付失败时撤销订单，并返回 ErrMicropayReversed（可通过 errors.Is 判断）。
+// polling 为 nil 时使用默认设置；所有请求均使用调用方的 ctx，ctx 取消时停止轮询并返回 ctx.Err()，此时订单未撤销，需调用方自行处理。
+func (mch *Mch) MicropayWithPolling(ctx context.Context, req *MicropayRequest, polling *MicropayPolling, options ...wx.HTTPOption) (*OrderQueryResult, error) {
+	interval := func(n int) time.Duration { return DefaultMicropayPollInterval }
+	timeout := DefaultMicropayPollTimeout
+
+	if polling != nil {
+		if polling.Interval != nil {
+			interval = polling.Interval
+		}
+
+		if polling.Timeout > 0 {
+			timeout = polling.Timeout
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	result, err := mch.Micropay(ctx, req, options...)
+
+	if err == nil {
+		return result, nil
+	}
+
+	if !errors.Is(err, ErrUserPaying) && !errors.Is(err, ErrSystemError) && !errors.Is(err, ErrBankError) {
+		return nil, err
+	}
+
+	tradeState := TradeStatePaying
+
+	for n := 1; tradeState == TradeStatePaying && time.Now().Before(deadline); n++ {
+		timer := time.NewTimer(interval(n))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		result, err = mch.QueryOrderByOutTradeNO(ctx, req.OutTradeNO, options...)
+
+		if err != nil {
+			// 查询失败时继续轮询，直至超时
+			if errors.Is(err, ErrSystemError) || errors.Is(err, &ResultError{ErrCode: OrderNotExist}) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		tradeState = result.TradeState
+	}
+
+	if tradeState == TradeStateSuccess {
+		return result, nil
+	}
+
+	if err = mch.reverse(ctx, req.OutTradeNO, interval, options...); err != nil {
+		return nil, fmt.Errorf("gochat: micropay reverse failed (trade_state: %s): %w", tradeState, err)
+	}
+
+	return nil, fmt.Errorf("%w (trade_state: %s)", ErrMicropayReversed, tradeState)
+}
+
+// reverse 撤销订单，应答 recall=Y 时按 interval 等待后继续调用撤销
+func (mch *Mch) reverse(ctx context.Context, outTradeNO string, interval wx.BackoffFunc, options ...wx.HTTPOption) error {
+	for n := 1; n <= maxReverseAttempts; n++ {
+		recall, err := mch.Reverse(ctx, outTradeNO, options...)
+
+		if !recall {
+			return err
+		}
+
+		if n == maxReverseAttempts {
+			break
+		}
+
+		timer := time.NewTimer(interval(n))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return errors.New("gochat: reverse still requires recall after max attempts")
+}
+
+// validateAuthCode 付款码为18位纯数字，以10、11、12、13、14、15开头
+func validateAuthCode(authCode string) error {
+	if len(authCode) != 18 || authCode[0] != '1' || authCode[1] < '0' || authCode[1] > '5' {
+		return fmt.Errorf("gochat: invalid auth_code %q, should be 18 digits starting with 10~15: %w", authCode, ErrAuthCodeInvalid)
+	}
+
+	for _, c := range authCode {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("gochat: invalid auth_code %q, should be 18 digits starting with 10~15: %w", authCode, ErrAuthCodeInvalid)
+		}
+	}
+
+	return nil
+}
+
+// AuthCodeToOpenID 通过付款码查询用户openid（如：收银台识别会员），请求前校验付款码格式（18位纯数字，以10~15开头）
+// 付款码无效时可通过 errors.Is 判断 ErrAuthCodeInvalid（含本地校验失败），已过期时判断 ErrAuthCodeExpired
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) AuthCodeToOpenID(ctx context.Context, authCode string, options ...wx.HTTPOption) (string, error) {
+	if err := validateAuthCode(authCode); err != nil {
+		return "", err
+	}
+
+	r, err := mch.Do(ctx, AuthCodeToOpenID(authCode), options...)
+
+	if err != nil {
+		return "", err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return "", newResultError(r)
+	}
+
+	return r["openid"], nil
+}
@@ -0,0 +1,484 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testMicropayBody = wx.WXML{
+		"appid":            "wx2421b1c4370ec43b",
+		"mch_id":           "10000100",
+		"nonce_str":        "50780e0cca98c8c8e814883e5caa672e",
+		"body":             "付款码支付测试",
+		"out_trade_no":     "1415757673",
+		"total_fee":        "1",
+		"spbill_create_ip": "14.17.22.52",
+		"auth_code":        "120061098828009406",
+		"sign_type":        "MD5",
+		"sign":             "88046D47C584C854FEFD8E2AA2CF7050",
+	}
+
+	testMicropayUserPaying = []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>FAIL</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<err_code>USERPAYING</err_code>
+	<err_code_des>需要用户输入支付密码</err_code_des>
+	<sign>E632240A3DCD9CF10C67F9F799D623E7</sign>
+</xml>`)
+
+	testMicropayQueryBody = wx.WXML{
+		"appid":        "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"out_trade_no": "1415757673",
+		"nonce_str":    "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":    "MD5",
+		"sign":         "FB12DBD3E7BBC775B215291E8E1A02BE",
+	}
+
+	testMicropayQueryUserPaying = []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<trade_type>MICROPAY</trade_type>
+	<trade_state>USERPAYING</trade_state>
+	<trade_state_desc>需要用户输入支付密码</trade_state_desc>
+	<total_fee>1</total_fee>
+	<out_trade_no>1415757673</out_trade_no>
+	<sign>861682D79DF41966DE42FA1D759042CC</sign>
+</xml>`)
+
+	testMicropayQuerySuccess = []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<trade_type>MICROPAY</trade_type>
+	<trade_state>SUCCESS</trade_state>
+	<bank_type>CCB_DEBIT</bank_type>
+	<total_fee>1</total_fee>
+	<cash_fee>1</cash_fee>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<time_end>20141111170043</time_end>
+	<sign>78527F642BED3D8AC6D14D82E5ABCC8F</sign>
+</xml>`)
+
+	testMicropayReverseBody = wx.WXML{
+		"appid":        "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"out_trade_no": "1415757673",
+		"nonce_str":    "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":    "MD5",
+		"sign":         "FB12DBD3E7BBC775B215291E8E1A02BE",
+	}
+)
+
+func testMicropayRequest() *MicropayRequest {
+	return &MicropayRequest{
+		OutTradeNO:     "1415757673",
+		TotalFee:       1,
+		SpbillCreateIP: "14.17.22.52",
+		Body:           "付款码支付测试",
+		AuthCode:       "120061098828009406",
+	}
+}
+
+func TestMchMicropay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/micropay", testMicropayBody).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<is_subscribe>Y</is_subscribe>
+	<trade_type>MICROPAY</trade_type>
+	<bank_type>CCB_DEBIT</bank_type>
+	<total_fee>1</total_fee>
+	<fee_type>CNY</fee_type>
+	<cash_fee>1</cash_fee>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<time_end>20141111170043</time_end>
+	<sign>4732F387480BB82D0F313569CBA483E6</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	result, err := mch.Micropay(context.TODO(), testMicropayRequest())
+
+	assert.Nil(t, err)
+	assert.Equal(t, &OrderQueryResult{
+		TransactionID: "1008450740201411110005820873",
+		OutTradeNO:    "1415757673",
+		TradeState:    TradeStateSuccess,
+		OpenID:        "oUpF8uN95-Ptaags6E_roPHg7AG0",
+		IsSubscribe:   "Y",
+		TradeType:     "MICROPAY",
+		BankType:      "CCB_DEBIT",
+		TotalFee:      1,
+		FeeType:       "CNY",
+		CashFee:       1,
+		TimeEnd:       time.Date(2014, 11, 11, 17, 0, 43, 0, beijing),
+	}, result)
+
+	// 请求前校验
+	_, err = mch.Micropay(context.TODO(), &MicropayRequest{OutTradeNO: "1415757673", TotalFee: 1})
+
+	assert.EqualError(t, err, "gochat: auth_code is required")
+}
+
+func TestMchMicropayUserPaying(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/micropay", testMicropayBody).Return(testMicropayUserPaying, nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	_, err := mch.Micropay(context.TODO(), testMicropayRequest())
+
+	assert.True(t, errors.Is(err, ErrUserPaying))
+}
+
+func TestMchReverse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/reverse", testMicropayReverseBody).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<recall>Y</recall>
+	<sign>553B0589FDB2F91F49E7DFA9170C5043</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	recall, err := mch.Reverse(context.TODO(), "1415757673")
+
+	assert.Nil(t, err)
+	assert.True(t, recall)
+}
+
+func TestMchMicropayWithPollingSuccess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// USERPAYING → 查询 USERPAYING → 查询 SUCCESS
+	gomock.InOrder(
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/micropay", testMicropayBody).Return(testMicropayUserPaying, nil),
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", testMicropayQueryBody).Return(testMicropayQueryUserPaying, nil),
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", testMicropayQueryBody).Return(testMicropayQuerySuccess, nil),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	intervals := make([]int, 0)
+
+	result, err := mch.MicropayWithPolling(context.TODO(), testMicropayRequest(), &MicropayPolling{
+		Interval: func(n int) time.Duration {
+			intervals = append(intervals, n)
+
+			return time.Millisecond
+		},
+		Timeout: time.Minute,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, intervals)
+	assert.Equal(t, TradeStateSuccess, result.TradeState)
+	assert.Equal(t, "1008450740201411110005820873", result.TransactionID)
+}
+
+func TestMchMicropayWithPollingTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+	tlsClient := wx.NewMockHTTPClient(ctrl)
+
+	// USERPAYING → 查询 USERPAYING（直至超时）→ 撤销（recall=Y 时继续撤销）
+	micropay := client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/micropay", testMicropayBody).Return(testMicropayUserPaying, nil)
+	query := client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", testMicropayQueryBody).Return(testMicropayQueryUserPaying, nil).MinTimes(1).After(micropay)
+	recall := tlsClient.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/reverse", testMicropayReverseBody).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<recall>Y</recall>
+	<sign>553B0589FDB2F91F49E7DFA9170C5043</sign>
+</xml>`), nil).After(query)
+	tlsClient.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/reverse", testMicropayReverseBody).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<recall>N</recall>
+	<sign>93831FDDD35D7C33BC2FA48A9EA236F4</sign>
+</xml>`), nil).After(recall)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+	mch.tlsClient = tlsClient
+
+	result, err := mch.MicropayWithPolling(context.TODO(), testMicropayRequest(), &MicropayPolling{
+		Interval: func(n int) time.Duration {
+			return 5 * time.Millisecond
+		},
+		Timeout: 20 * time.Millisecond,
+	})
+
+	assert.Nil(t, result)
+	assert.True(t, errors.Is(err, ErrMicropayReversed))
+}
+
+func TestMchMicropayWithPollingCanceled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.Any(), "https://api.mch.weixin.qq.com/pay/micropay", testMicropayBody).Return(testMicropayUserPaying, nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	// ctx 取消时停止轮询，不撤销订单
+	_, err := mch.MicropayWithPolling(ctx, testMicropayRequest(), &MicropayPolling{
+		Interval: func(n int) time.Duration {
+			return time.Minute
+		},
+	})
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestMchAuthCodeToOpenID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	body := wx.WXML{
+		"appid":     "wx2421b1c4370ec43b",
+		"mch_id":    "10000100",
+		"auth_code": "120061098828009406",
+		"nonce_str": "50780e0cca98c8c8e814883e5caa672e",
+		"sign":      "66563816C9F3D38A6518EF82DAD6D1A0",
+	}
+
+	gomock.InOrder(
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/tools/authcodetoopenid", body).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<sign>4CE7E88D44F900E6B164C5B5AAC1ADA1</sign>
+</xml>`), nil),
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/tools/authcodetoopenid", body).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>FAIL</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<err_code>AUTHCODEEXPIRE</err_code>
+	<err_code_des>二维码已过期，请用户在微信上刷新后再试</err_code_des>
+	<sign>7972F8F0C7FF4F76DF9C7702D28F0E7D</sign>
+</xml>`), nil),
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/tools/authcodetoopenid", body).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>FAIL</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<err_code>AUTH_CODE_INVALID</err_code>
+	<err_code_des>授权码检验错误</err_code_des>
+	<sign>433107C80FFE3FD76A675D7B66820AB8</sign>
+</xml>`), nil),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	openid, err := mch.AuthCodeToOpenID(context.TODO(), "120061098828009406")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "oUpF8uN95-Ptaags6E_roPHg7AG0", openid)
+
+	_, err = mch.AuthCodeToOpenID(context.TODO(), "120061098828009406")
+
+	assert.True(t, errors.Is(err, ErrAuthCodeExpired))
+	assert.False(t, errors.Is(err, ErrAuthCodeInvalid))
+
+	_, err = mch.AuthCodeToOpenID(context.TODO(), "120061098828009406")
+
+	assert.True(t, errors.Is(err, ErrAuthCodeInvalid))
+}
+
+func TestMchAuthCodeToOpenIDValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 格式错误时不发起请求
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = wx.NewMockHTTPClient(ctrl)
+
+	for _, code := range []string{"", "12006109882800940", "1200610988280094061", "160061098828009406", "090061098828009406", "12006109882800940a"} {
+		_, err := mch.AuthCodeToOpenID(context.TODO(), code)
+
+		assert.True(t, errors.Is(err, ErrAuthCodeInvalid), code)
+	}
+}
+
+func TestMchMicropayReverseRecall(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tlsClient := wx.NewMockHTTPClient(ctrl)
+
+	recallY := []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<recall>Y</recall>
+	<sign>553B0589FDB2F91F49E7DFA9170C5043</sign>
+</xml>`)
+
+	recallN := []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<recall>N</recall>
+	<sign>93831FDDD35D7C33BC2FA48A9EA236F4</sign>
+</xml>`)
+
+	calls := make([]time.Time, 0, 3)
+
+	record := func(resp []byte) func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		return func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+			calls = append(calls, time.Now())
+
+			return resp, nil
+		}
+	}
+
+	// recall=Y → 等待后撤销 recall=Y → 等待后撤销 recall=N
+	gomock.InOrder(
+		tlsClient.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/reverse", testMicropayReverseBody).DoAndReturn(record(recallY)).Times(2),
+		tlsClient.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/reverse", testMicropayReverseBody).DoAndReturn(record(recallN)),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = tlsClient
+
+	intervals := make([]int, 0)
+
+	err := mch.reverse(context.TODO(), "1415757673", func(n int) time.Duration {
+		intervals = append(intervals, n)
+
+		return 20 * time.Millisecond
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, intervals)
+	assert.Len(t, calls, 3)
+
+	for i := 1; i < len(calls); i++ {
+		assert.True(t, calls[i].Sub(calls[i-1]) >= 20*time.Millisecond)
+	}
+
+	// 等待期间 ctx 取消时停止撤销
+	tlsClient.EXPECT().PostXML(gomock.Any(), "https://api.mch.weixin.qq.com/secapi/pay/reverse", testMicropayReverseBody).Return(recallY, nil)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	err = mch.reverse(ctx, "1415757673", func(n int) time.Duration {
+		return time.Minute
+	})
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
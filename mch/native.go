@@ -0,0 +1,45 @@
+package mch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// ShortURL 转换短链接（主要用于Native支付模式一中的二维码链接 weixin://wxpay/bizpayurl?...，减小二维码数据量，提升扫描速度和精确度）
+// 注意：long_url 签名用原串，传输需URLencode（由 Mch.Do 处理）
+func ShortURL(longURL string) wx.Action {
+	return wx.NewAction(ShortURLURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			return wx.WXML{
+				"appid":     appid,
+				"mch_id":    mchid,
+				"long_url":  longURL,
+				"nonce_str": nonce,
+				"sign_type": SignMD5,
+			}, nil
+		}),
+	)
+}
+
+// ShortURL 转换短链接，返回短链接（如：weixin://wxpay/s/XXXXXX）
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) ShortURL(ctx context.Context, longURL string, options ...wx.HTTPOption) (string, error) {
+	if longURL == "" {
+		return "", errors.New("gochat: long_url is required")
+	}
+
+	r, err := mch.Do(ctx, ShortURL(longURL), options...)
+
+	if err != nil {
+		return "", err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return "", newResultError(r)
+	}
+
+	return r["short_url"], nil
+}
@@ -0,0 +1,79 @@
+package mch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+const testShortURLResp = `<xml>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<return_msg><![CDATA[OK]]></return_msg>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[6cefdb308e1e2e8aabd48cf79e546a02]]></nonce_str>
+	<sign><![CDATA[16668C018185A0BB1802600F0B612C59]]></sign>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<short_url><![CDATA[weixin://wxpay/s/XXXXXX]]></short_url>
+</xml>`
+
+func TestShortURL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// long_url 签名用原串（03A70A41D4EF65B9BE1B3EC32D53B000），传输需URLencode；
+	// 若使用URLencode后的串签名，则签名为 0E2F33A224ED84763D16C2F282B87BD2（错误）
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/tools/shorturl", wx.WXML{
+		"appid":     "wx2421b1c4370ec43b",
+		"mch_id":    "10000100",
+		"long_url":  "weixin%3A%2F%2Fwxpay%2Fbizpayurl%3Fsign%3DXXXXX%26appid%3Dwx2421b1c4370ec43b%26mch_id%3D10000100%26product_id%3D88888%26time_stamp%3D1415949957%26nonce_str%3D1417574675",
+		"nonce_str": "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type": "MD5",
+		"sign":      "03A70A41D4EF65B9BE1B3EC32D53B000",
+	}).Return([]byte(testShortURLResp), nil).Times(2)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+
+	r, err := mch.Do(context.TODO(), ShortURL("weixin://wxpay/bizpayurl?sign=XXXXX&appid=wx2421b1c4370ec43b&mch_id=10000100&product_id=88888&time_stamp=1415949957&nonce_str=1417574675"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "weixin://wxpay/s/XXXXXX", r["short_url"])
+
+	shortURL, err := mch.ShortURL(context.TODO(), "weixin://wxpay/bizpayurl?sign=XXXXX&appid=wx2421b1c4370ec43b&mch_id=10000100&product_id=88888&time_stamp=1415949957&nonce_str=1417574675")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "weixin://wxpay/s/XXXXXX", shortURL)
+}
+
+func TestShortURLFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/tools/shorturl", gomock.Any()).Return([]byte(`<xml>
+	<return_code><![CDATA[FAIL]]></return_code>
+	<return_msg><![CDATA[签名错误]]></return_msg>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = client
+
+	_, err := mch.ShortURL(context.TODO(), "weixin://wxpay/bizpayurl?sign=XXXXX")
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "签名错误"}, err)
+
+	_, err = mch.ShortURL(context.TODO(), "")
+
+	assert.EqualError(t, err, "gochat: long_url is required")
+}
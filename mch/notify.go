@@ -0,0 +1,288 @@
+package mch
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// ParseNotify 解析支付结果通知（notify_url 回调），并校验签名
+// 通知不含 sign_type 时使用 signType（与下单时的签名类型一致）校验签名，默认MD5
+// return_code 不为 SUCCESS 时返回 *ReturnError；业务结果（result_code）由调用方自行判断
+// 注意：退款结果通知不含签名，请使用 ParseRefundNotify 解析
+func ParseNotify(apikey string, body []byte, signType ...string) (wx.WXML, error) {
+	m, err := wx.ParseXML2Map(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m["return_code"] != ResultSuccess {
+		return nil, newReturnError(m)
+	}
+
+	if len(m["sign"]) == 0 {
+		return nil, errors.New("notify sign missing")
+	}
+
+	st := SignMD5
+
+	if len(signType) != 0 {
+		st = signType[0]
+	}
+
+	if err = verifySign(apikey, m, st); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// PayNotifyResult 支付结果通知
+type PayNotifyResult struct {
+	TransactionID string    // 微信支付订单号
+	OutTradeNO    string    // 商户订单号
+	TotalFee      int       // 订单金额，单位为分（请与商户订单金额比对）
+	CashFee       int       // 现金支付金额，单位为分
+	FeeType       string    // 货币种类
+	OpenID        string    // 用户标识
+	IsSubscribe   string    // 是否关注公众账号
+	TradeType     string    // 交易类型
+	BankType      string    // 付款银行
+	Attach        string    // 商家数据包，原样返回
+	TimeEnd       time.Time // 支付完成时间（北京时间）
+}
+
+// ParsePayNotify 解析支付结果通知，使用商户的 apikey 校验签名（通知不含 sign_type 时使用 WithSignType 指定的签名类型），并校验 appid 及 mch_id
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+// 注意：请根据 out_trade_no 比对订单金额（total_fee），并做好幂等处理（同一通知可能会多次发送）
+func (mch *Mch) ParsePayNotify(body []byte) (*PayNotifyResult, error) {
+	m, err := ParseNotify(mch.verifyKey(), body, mch.signType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = mch.verifyIdentity(m); err != nil {
+		return nil, err
+	}
+
+	if m["result_code"] != ResultSuccess {
+		return nil, newResultError(m)
+	}
+
+	result := &PayNotifyResult{
+		TransactionID: m["transaction_id"],
+		OutTradeNO:    m["out_trade_no"],
+		FeeType:       m["fee_type"],
+		OpenID:        m["openid"],
+		IsSubscribe:   m["is_subscribe"],
+		TradeType:     m["trade_type"],
+		BankType:      m["bank_type"],
+		Attach:        m["attach"],
+	}
+
+	if result.TotalFee, err = atoi(m, "total_fee"); err != nil {
+		return nil, err
+	}
+
+	if result.CashFee, err = atoi(m, "cash_fee"); err != nil {
+		return nil, err
+	}
+
+	if v := m["time_end"]; v != "" {
+		if result.TimeEnd, err = time.ParseInLocation("20060102150405", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid time_end: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// NotifyReply 返回支付结果通知的应答，ok 为 false 时微信会重新发送通知
+func NotifyReply(ok bool, msg string) []byte {
+	if ok {
+		return ReplyOK().Bytes()
+	}
+
+	return ReplyFail(msg).Bytes()
+}
+
+// PayNotifyHandler 返回处理支付结果通知的 http.Handler：读取并解析通知、校验签名后调用 f，
+// 解析失败或 f 返回错误时应答 FAIL（微信会重新发送通知），否则应答 SUCCESS；
+// FAIL 应答的 return_msg 固定为 FAIL（不返回具体错误，避免泄露签名等信息），具体错误通过 WithLogger 指定的钩子记录
+func (mch *Mch) PayNotifyHandler(f func(ctx context.Context, result *PayNotifyResult) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+
+		if err != nil {
+			mch.notifyFail(w, r, body, err)
+
+			return
+		}
+
+		result, err := mch.ParsePayNotify(body)
+
+		if err != nil {
+			mch.notifyFail(w, r, body, err)
+
+			return
+		}
+
+		if err = f(r.Context(), result); err != nil {
+			mch.notifyFail(w, r, body, err)
+
+			return
+		}
+
+		w.Write(NotifyReply(true, "OK"))
+	})
+}
+
+// notifyFail 应答 FAIL 并记录具体错误
+func (mch *Mch) notifyFail(w http.ResponseWriter, r *http.Request, body []byte, err error) {
+	reply := NotifyReply(false, "FAIL")
+
+	if mch.logger != nil {
+		mch.logger(r.Context(), r.Method, r.URL.String(), body, reply, err)
+	}
+
+	w.Write(reply)
+}
+
+// ErrInvalidReqInfo 退款结果通知的 req_info 解密失败（apikey 错误或数据被篡改）
+var ErrInvalidReqInfo = errors.New("gochat: invalid req_info, decrypt failed (wrong apikey or corrupted data)")
+
+// RefundNotifyData 退款结果通知（解密后的 req_info）
+type RefundNotifyData struct {
+	AppID               string    // 公众账号ID
+	MchID               string    // 商户号
+	TransactionID       string    // 微信订单号
+	OutTradeNO          string    // 商户订单号
+	RefundID            string    // 微信退款单号
+	OutRefundNO         string    // 商户退款单号
+	TotalFee            int       // 订单金额，单位为分
+	SettlementTotalFee  int       // 应结订单金额，单位为分
+	RefundFee           int       // 申请退款金额，单位为分
+	SettlementRefundFee int       // 退款金额，单位为分
+	RefundStatus        string    // 退款状态（SUCCESS、CHANGE、REFUNDCLOSE）
+	SuccessTime         time.Time // 退款成功时间（北京时间），未成功时为零值
+	RefundRecvAccount   string    // 退款入账账户
+	RefundAccount       string    // 退款资金来源
+	RefundRequestSource string    // 退款发起来源（API、VENDOR_PLATFORM）
+}
+
+// ParseRefundNotify 解析退款结果通知（notify_url 回调），纯解析，无需商户实例
+// 退款结果通知不含签名，req_info 使用 MD5(apikey) 作为密钥进行 AES-256-ECB 解密，解密失败时返回 ErrInvalidReqInfo
+// return_code 不为 SUCCESS 时返回 *ReturnError；处理完成后使用 ReplyOK().Bytes() 或 ReplyFail(msg).Bytes() 应答
+func ParseRefundNotify(apikey string, body []byte) (*RefundNotifyData, error) {
+	m, err := wx.ParseXML2Map(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m["return_code"] != ResultSuccess {
+		return nil, newReturnError(m)
+	}
+
+	if len(m["req_info"]) == 0 {
+		return nil, errors.New("gochat: refund notify req_info missing")
+	}
+
+	info, err := decryptReqInfo(apikey, m["req_info"])
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := &RefundNotifyData{
+		AppID:               m["appid"],
+		MchID:               m["mch_id"],
+		TransactionID:       info["transaction_id"],
+		OutTradeNO:          info["out_trade_no"],
+		RefundID:            info["refund_id"],
+		OutRefundNO:         info["out_refund_no"],
+		RefundStatus:        info["refund_status"],
+		RefundRecvAccount:   info["refund_recv_accout"], // 微信文档中的字段名即为 accout
+		RefundAccount:       info["refund_account"],
+		RefundRequestSource: info["refund_request_source"],
+	}
+
+	fees := []struct {
+		key  string
+		dest *int
+	}{
+		{"total_fee", &data.TotalFee},
+		{"settlement_total_fee", &data.SettlementTotalFee},
+		{"refund_fee", &data.RefundFee},
+		{"settlement_refund_fee", &data.SettlementRefundFee},
+	}
+
+	for _, v := range fees {
+		if *v.dest, err = atoi(info, v.key); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := info["success_time"]; v != "" {
+		if data.SuccessTime, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid success_time: %w", err)
+		}
+	}
+
+	return data, nil
+}
+
+// decryptReqInfo 使用 MD5(apikey) 作为密钥对 req_info 进行 AES-256-ECB 解密，并严格校验 PKCS#7 填充
+func decryptReqInfo(apikey, reqInfo string) (wx.WXML, error) {
+	cipherText, err := base64.StdEncoding.DecodeString(reqInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("gochat: invalid req_info: %w", err)
+	}
+
+	if len(cipherText) == 0 || len(cipherText)%aes.BlockSize != 0 {
+		return nil, ErrInvalidReqInfo
+	}
+
+	h := md5.Sum([]byte(apikey))
+
+	block, err := aes.NewCipher([]byte(hex.EncodeToString(h[:])))
+
+	if err != nil {
+		return nil, err
+	}
+
+	plainText := make([]byte, len(cipherText))
+
+	wx.NewECBDecrypter(block).CryptBlocks(plainText, cipherText)
+
+	// 填充长度取值为 1~32（兼容按密钥长度填充）
+	padding := int(plainText[len(plainText)-1])
+
+	if padding < 1 || padding > 32 || padding > len(plainText) || !bytes.Equal(plainText[len(plainText)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrInvalidReqInfo
+	}
+
+	m, err := wx.ParseXML2Map(plainText[:len(plainText)-padding])
+
+	if err != nil {
+		return nil, ErrInvalidReqInfo
+	}
+
+	return m, nil
+}
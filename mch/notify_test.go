@@ -0,0 +1,356 @@
+package mch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNotify(t *testing.T) {
+	body := []byte(`<xml>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<attach><![CDATA[支付测试]]></attach>
+	<bank_type><![CDATA[CFT]]></bank_type>
+	<fee_type><![CDATA[CNY]]></fee_type>
+	<is_subscribe><![CDATA[Y]]></is_subscribe>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[5d2b6c2a8db53831f7eda20af46e531c]]></nonce_str>
+	<openid><![CDATA[oUpF8uMEb4qRXf22hE3X68TekukE]]></openid>
+	<out_trade_no><![CDATA[1409811653]]></out_trade_no>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<sign><![CDATA[D7D96F3F228F627CF33E2A1F80C8581A]]></sign>
+	<sub_mch_id><![CDATA[10000100]]></sub_mch_id>
+	<time_end><![CDATA[20140903131540]]></time_end>
+	<total_fee>1</total_fee>
+	<coupon_fee><![CDATA[10]]></coupon_fee>
+	<coupon_count><![CDATA[1]]></coupon_count>
+	<coupon_type><![CDATA[CASH]]></coupon_type>
+	<coupon_id><![CDATA[10000]]></coupon_id>
+	<trade_type><![CDATA[JSAPI]]></trade_type>
+	<transaction_id><![CDATA[1004400740201409030005092168]]></transaction_id>
+</xml>`)
+
+	m, err := ParseNotify("192006250b4c09247ec02edce69f6a2d", body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"attach":         "支付测试",
+		"bank_type":      "CFT",
+		"fee_type":       "CNY",
+		"is_subscribe":   "Y",
+		"mch_id":         "10000100",
+		"nonce_str":      "5d2b6c2a8db53831f7eda20af46e531c",
+		"openid":         "oUpF8uMEb4qRXf22hE3X68TekukE",
+		"out_trade_no":   "1409811653",
+		"result_code":    "SUCCESS",
+		"return_code":    "SUCCESS",
+		"sign":           "D7D96F3F228F627CF33E2A1F80C8581A",
+		"sub_mch_id":     "10000100",
+		"time_end":       "20140903131540",
+		"total_fee":      "1",
+		"coupon_fee":     "10",
+		"coupon_count":   "1",
+		"coupon_type":    "CASH",
+		"coupon_id":      "10000",
+		"trade_type":     "JSAPI",
+		"transaction_id": "1004400740201409030005092168",
+	}, m)
+
+	// 金额被篡改
+	_, err = ParseNotify("192006250b4c09247ec02edce69f6a2d", []byte(`<xml>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[5d2b6c2a8db53831f7eda20af46e531c]]></nonce_str>
+	<out_trade_no><![CDATA[1409811653]]></out_trade_no>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<sign><![CDATA[D7D96F3F228F627CF33E2A1F80C8581A]]></sign>
+	<total_fee>100</total_fee>
+</xml>`))
+
+	assert.NotNil(t, err)
+
+	// 缺少签名
+	_, err = ParseNotify("192006250b4c09247ec02edce69f6a2d", []byte(`<xml>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<total_fee>1</total_fee>
+</xml>`))
+
+	assert.NotNil(t, err)
+}
+
+// payNotifyBody 支付结果通知（MD5签名）
+var payNotifyBody = []byte(`<xml>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<attach><![CDATA[支付测试]]></attach>
+	<bank_type><![CDATA[CFT]]></bank_type>
+	<fee_type><![CDATA[CNY]]></fee_type>
+	<is_subscribe><![CDATA[Y]]></is_subscribe>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[5d2b6c2a8db53831f7eda20af46e531c]]></nonce_str>
+	<openid><![CDATA[oUpF8uMEb4qRXf22hE3X68TekukE]]></openid>
+	<out_trade_no><![CDATA[1409811653]]></out_trade_no>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<sign><![CDATA[D7D96F3F228F627CF33E2A1F80C8581A]]></sign>
+	<sub_mch_id><![CDATA[10000100]]></sub_mch_id>
+	<time_end><![CDATA[20140903131540]]></time_end>
+	<total_fee>1</total_fee>
+	<coupon_fee><![CDATA[10]]></coupon_fee>
+	<coupon_count><![CDATA[1]]></coupon_count>
+	<coupon_type><![CDATA[CASH]]></coupon_type>
+	<coupon_id><![CDATA[10000]]></coupon_id>
+	<trade_type><![CDATA[JSAPI]]></trade_type>
+	<transaction_id><![CDATA[1004400740201409030005092168]]></transaction_id>
+</xml>`)
+
+func TestParsePayNotify(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	r, err := mch.ParsePayNotify(payNotifyBody)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &PayNotifyResult{
+		TransactionID: "1004400740201409030005092168",
+		OutTradeNO:    "1409811653",
+		TotalFee:      1,
+		FeeType:       "CNY",
+		OpenID:        "oUpF8uMEb4qRXf22hE3X68TekukE",
+		IsSubscribe:   "Y",
+		TradeType:     TradeJSAPI,
+		BankType:      "CFT",
+		Attach:        "支付测试",
+		TimeEnd:       time.Date(2014, 9, 3, 13, 15, 40, 0, beijing),
+	}, r)
+
+	// 错误的 apikey
+	_, err = New("wx2421b1c4370ec43b", "10000100", "0123456789abcdef0123456789abcdef").ParsePayNotify(payNotifyBody)
+
+	var signErr *ErrInvalidSign
+
+	assert.True(t, errors.As(err, &signErr))
+}
+
+func TestParsePayNotifyWithHMacSHA256(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(SignHMacSHA256))
+
+	r, err := mch.ParsePayNotify([]byte(`<xml>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<attach><![CDATA[支付测试]]></attach>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[5d2b6c2a8db53831f7eda20af46e531c]]></nonce_str>
+	<openid><![CDATA[oUpF8uMEb4qRXf22hE3X68TekukE]]></openid>
+	<out_trade_no><![CDATA[1409811653]]></out_trade_no>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<sign><![CDATA[7CDDDD4C2A2ED5678210A8EA02EB6B7F1A81CF7804E143E79F8D9E892538B9CD]]></sign>
+	<time_end><![CDATA[20140903131540]]></time_end>
+	<total_fee>1</total_fee>
+	<cash_fee>1</cash_fee>
+	<trade_type><![CDATA[JSAPI]]></trade_type>
+	<transaction_id><![CDATA[1004400740201409030005092168]]></transaction_id>
+</xml>`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &PayNotifyResult{
+		TransactionID: "1004400740201409030005092168",
+		OutTradeNO:    "1409811653",
+		TotalFee:      1,
+		CashFee:       1,
+		OpenID:        "oUpF8uMEb4qRXf22hE3X68TekukE",
+		TradeType:     TradeJSAPI,
+		Attach:        "支付测试",
+		TimeEnd:       time.Date(2014, 9, 3, 13, 15, 40, 0, beijing),
+	}, r)
+}
+
+func TestParsePayNotifyInvalid(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	// 业务失败
+	_, err := mch.ParsePayNotify([]byte(`<xml>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[5d2b6c2a8db53831f7eda20af46e531c]]></nonce_str>
+	<out_trade_no><![CDATA[1409811653]]></out_trade_no>
+	<result_code><![CDATA[FAIL]]></result_code>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<err_code><![CDATA[SYSTEMERROR]]></err_code>
+	<err_code_des><![CDATA[系统错误]]></err_code_des>
+	<sign><![CDATA[76987903BF6F7F49EAC684ED0675F70B]]></sign>
+</xml>`))
+
+	assert.True(t, errors.Is(err, ErrSystemError))
+
+	// 签名正确，但 appid 与商户不一致
+	_, err = mch.ParsePayNotify([]byte(`<xml>
+	<appid><![CDATA[wx0000000000000000]]></appid>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[5d2b6c2a8db53831f7eda20af46e531c]]></nonce_str>
+	<out_trade_no><![CDATA[1409811653]]></out_trade_no>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<sign><![CDATA[235B5E382BB349BA484763D0D2B7455E]]></sign>
+	<total_fee>1</total_fee>
+</xml>`))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "appid mismatch")
+}
+
+func TestNotifyReply(t *testing.T) {
+	assert.Equal(t, []byte(`<xml><return_code><![CDATA[SUCCESS]]></return_code><return_msg><![CDATA[OK]]></return_msg></xml>`), NotifyReply(true, ""))
+	assert.Equal(t, []byte(`<xml><return_code><![CDATA[FAIL]]></return_code><return_msg><![CDATA[签名失败]]></return_msg></xml>`), NotifyReply(false, "签名失败"))
+}
+
+func TestPayNotifyHandler(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	var result *PayNotifyResult
+
+	handler := mch.PayNotifyHandler(func(ctx context.Context, r *PayNotifyResult) error {
+		if r.TotalFee != 1 {
+			return errors.New("金额不一致")
+		}
+
+		result = r
+
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notify/pay", bytes.NewReader(payNotifyBody)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, NotifyReply(true, ""), w.Body.Bytes())
+	assert.Equal(t, "1004400740201409030005092168", result.TransactionID)
+
+	// 签名错误时应答 FAIL，不调用回调
+	result = nil
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notify/pay", bytes.NewReader(bytes.Replace(payNotifyBody, []byte("<total_fee>1</total_fee>"), []byte("<total_fee>100</total_fee>"), 1))))
+
+	assert.Equal(t, NotifyReply(false, "FAIL"), w.Body.Bytes())
+	assert.Nil(t, result)
+
+	// 回调返回错误时应答 FAIL
+	handler = mch.PayNotifyHandler(func(ctx context.Context, r *PayNotifyResult) error {
+		return errors.New("订单不存在")
+	})
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notify/pay", bytes.NewReader(payNotifyBody)))
+
+	assert.Equal(t, NotifyReply(false, "FAIL"), w.Body.Bytes())
+}
+
+func TestPayNotifyHandlerForged(t *testing.T) {
+	var logged error
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithLogger(func(ctx context.Context, method, url string, reqBody, respBody []byte, err error) {
+		logged = err
+	}))
+
+	handler := mch.PayNotifyHandler(func(ctx context.Context, r *PayNotifyResult) error {
+		t.Fatal("forged notify must not reach the callback")
+
+		return nil
+	})
+
+	// 篡改金额并伪造签名的通知
+	forged := bytes.Replace(payNotifyBody, []byte("<total_fee>1</total_fee>"), []byte("<total_fee>100</total_fee>"), 1)
+	forged = bytes.Replace(forged, []byte("D7D96F3F228F627CF33E2A1F80C8581A"), []byte("00000000000000000000000000000000"), 1)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notify/pay", bytes.NewReader(forged)))
+
+	// 应答中不能包含正确的签名
+	assert.Equal(t, NotifyReply(false, "FAIL"), w.Body.Bytes())
+	assert.NotRegexp(t, "[0-9A-F]{32}", w.Body.String())
+
+	// 具体错误通过日志钩子记录
+	assert.NotNil(t, logged)
+}
+
+func TestParseRefundNotify(t *testing.T) {
+	body := []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<appid><![CDATA[wx2421b1c4370ec43b]]></appid>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<nonce_str><![CDATA[TeqClE3i0mvn3DrK]]></nonce_str>
+	<req_info><![CDATA[4gS8kbcHysCW7bHqyEU0M4GTNkgJQP6/zKHbA/E3CvwLlNgCKUkGRy0OpONZjd4saggSnB6Fr7dHRYn6tvu8XDRU6t9IC3GuUKHs3SXmFKkm5cy3YR0oWIZFU4C5LV9LU7U3hwvUSZNx1QcFQXX9yZz68Wq8pwf/DeZ6iOXy/XRulylo75C7n0p3dMm/yJamZ44ir2iwWwEis3Tiif9Y6foLxrFA+fESQK1aH/OEZhIrJPIlnrtoxGJVJfoWAOYrC13a52BaR7CHKmNhAtw60n+XBUPLx5VzwpHKf3zZB1EpCngiVGcxmEAy3I59wotsScP4iaUeObWqPs7RYdQCiFQ9oRo4/c6bUWocW6HfOJGyWXj3VNfZtjTp1J6R05bP/1PCNV9FIMlt+owfcjTPO4pmRx0SpuKPy7j80APUCyC4g/0FU2ppbw/jN3faXAOV/1+Vl5vrDWxg2hiWm9JCttJ5kAHD/9XB6hfM0BH4iwf/Z/FZO+ECvO2A9buqnpCeOYWsOZNN1Z2Ow9kfJXhiDs/N0UICa2lodyl44nBrbP3amju/Zm6yyyFr74jl2GUsGO3PBrqfP1mbX96WiG09BcjQp1PAw40kfw32o7LW8ZT7DakPEGf0Khhuy+xbdusziU/CihrSEIUJP2qlK2/WrM3MtKE7qMqGBMDTG/n/BB1B82zfpNEh1py0CKTS+ezCKQp4IlRnMZhAMtyOfcKLbMEwOF1u3TdfNh+GSXPbEdydvKTcrMddQ5bbUosAT0d+dcPSPlM8Ckq6OPWJfyaySg8x1PM39psr2UqhJGFQ/kcDLzCYt1gVX+qjOdMC0v0IBG+YszRCIvJkNGues9wip94bkBWQeHdtuES+XZS9wIR0jwIA5G+mJJD3tRW/JpCXeIVgW84XStyaniaekKdo/Q6lkmNwtztmzB0Ub6ct/rQPMdTzN/abK9lKoSRhUP5Hq3yjxpWFegmV3TtECOaAtSj8cubVTONJL2m2vzF7RpOCXbPq7TuRyVqYF1fTBJH50z8YV7B5zZ5f1JU2tCMvRaIe1jZ0yyZLytG/dONZ+ee7rjV3lKvcHiHEASz1EtvM]]></req_info>
+</xml>`)
+
+	data, err := ParseRefundNotify("192006250b4c09247ec02edce69f6a2d", body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RefundNotifyData{
+		AppID:               "wx2421b1c4370ec43b",
+		MchID:               "10000100",
+		TransactionID:       "4200000215201811190261405420",
+		OutTradeNO:          "71106718111915575302817",
+		RefundID:            "50000408942018111907145868882",
+		OutRefundNO:         "131811191610442717309",
+		TotalFee:            3960,
+		SettlementTotalFee:  3960,
+		RefundFee:           3960,
+		SettlementRefundFee: 3960,
+		RefundStatus:        RefundStatusSuccess,
+		SuccessTime:         time.Date(2018, 11, 19, 16, 24, 13, 0, beijing),
+		RefundRecvAccount:   "支付用户零钱",
+		RefundAccount:       "REFUND_SOURCE_RECHARGE_FUNDS",
+		RefundRequestSource: "API",
+	}, data)
+
+	// 错误的 apikey
+	_, err = ParseRefundNotify("0123456789abcdef0123456789abcdef", body)
+
+	assert.Equal(t, ErrInvalidReqInfo, err)
+}
+
+func TestParseRefundNotifyInvalid(t *testing.T) {
+	// 通信失败
+	_, err := ParseRefundNotify("192006250b4c09247ec02edce69f6a2d", []byte(`<xml>
+	<return_code>FAIL</return_code>
+	<return_msg>参数错误</return_msg>
+</xml>`))
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "参数错误"}, err)
+
+	// 缺少 req_info
+	_, err = ParseRefundNotify("192006250b4c09247ec02edce69f6a2d", []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+</xml>`))
+
+	assert.NotNil(t, err)
+
+	// 非 base64
+	_, err = ParseRefundNotify("192006250b4c09247ec02edce69f6a2d", []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<req_info>!!!</req_info>
+</xml>`))
+
+	assert.NotNil(t, err)
+
+	// 密文长度不是块大小的整数倍
+	_, err = ParseRefundNotify("192006250b4c09247ec02edce69f6a2d", []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<req_info>MTIzNDU2Nzg5MDEyMzQ1</req_info>
+</xml>`))
+
+	assert.Equal(t, ErrInvalidReqInfo, err)
+}
+
+func TestReplyBytes(t *testing.T) {
+	assert.Equal(t, "<xml><return_code><![CDATA[SUCCESS]]></return_code><return_msg><![CDATA[OK]]></return_msg></xml>", string(ReplyOK().Bytes()))
+}
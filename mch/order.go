@@ -1,7 +1,11 @@
 package mch
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/shenghui0779/gochat/wx"
 )
@@ -101,6 +105,47 @@ func UnifyOrder(data *OrderData) wx.Action {
 		}))
 }
 
+// OrderRequest 统一下单请求
+type OrderRequest = OrderData
+
+// OrderResponse 统一下单结果
+type OrderResponse struct {
+	TradeType string // 交易类型
+	PrepayID  string // 预支付交易会话标识，用于后续接口调用中使用，该值有效期为2小时
+	CodeURL   string // trade_type=NATIVE 时返回，用于生成二维码展示给用户扫码支付
+	MWebURL   string // trade_type=MWEB 时返回，支付跳转链接，有效期为5分钟
+}
+
+// UnifiedOrder 统一下单（支持 JSAPI、NATIVE、APP、MWEB），返回 prepay_id 及 code_url（NATIVE）、mweb_url（MWEB）
+// 请求前校验：total_fee 须大于0，trade_type=JSAPI 时 openid 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) UnifiedOrder(ctx context.Context, order *OrderRequest, options ...wx.HTTPOption) (*OrderResponse, error) {
+	if order.TotalFee <= 0 {
+		return nil, errors.New("gochat: total_fee must be positive")
+	}
+
+	if order.TradeType == TradeJSAPI && order.OpenID == "" {
+		return nil, errors.New("gochat: openid is required when trade_type is JSAPI")
+	}
+
+	r, err := mch.Do(ctx, UnifyOrder(order), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	return &OrderResponse{
+		TradeType: r["trade_type"],
+		PrepayID:  r["prepay_id"],
+		CodeURL:   r["code_url"],
+		MWebURL:   r["mweb_url"],
+	}, nil
+}
+
 // QueryOrderByTransactionID 根据微信订单号查询
 func QueryOrderByTransactionID(transactionID string) wx.Action {
 	return wx.NewAction(OrderQueryURL,
@@ -133,6 +178,140 @@ func QueryOrderByOutTradeNO(outTradeNO string) wx.Action {
 	)
 }
 
+// OrderCoupon 订单使用的代金券
+type OrderCoupon struct {
+	CouponType string // 代金券类型：CASH--充值代金券，NO_CASH--非充值优惠券
+	CouponID   string // 代金券ID
+	CouponFee  int    // 单个代金券支付金额，单位为分
+}
+
+// OrderQueryResult 订单查询结果
+type OrderQueryResult struct {
+	TransactionID      string         // 微信支付订单号
+	OutTradeNO         string         // 商户订单号
+	TradeState         string         // 交易状态，如：SUCCESS、REFUND、NOTPAY、CLOSED 等
+	TradeStateDesc     string         // 交易状态描述
+	DeviceInfo         string         // 设备号
+	OpenID             string         // 用户在商户appid下的唯一标识
+	IsSubscribe        string         // 用户是否关注公众账号，Y-关注，N-未关注
+	TradeType          string         // 交易类型
+	BankType           string         // 付款银行
+	TotalFee           int            // 订单总金额，单位为分
+	SettlementTotalFee int            // 应结订单金额（当订单使用了免充值型优惠券后返回），单位为分
+	FeeType            string         // 货币类型
+	CashFee            int            // 现金支付金额，单位为分
+	CashFeeType        string         // 现金支付货币类型
+	CouponFee          int            // 代金券金额，单位为分
+	CouponCount        int            // 代金券使用数量
+	Coupons            []*OrderCoupon // 代金券（由 coupon_type_$n、coupon_id_$n、coupon_fee_$n 组装）
+	Attach             string         // 附加数据
+	TimeEnd            time.Time      // 支付完成时间（北京时间），未支付时为零值
+}
+
+// QueryOrderByTransactionID 根据微信订单号查询订单
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError（如：ORDERNOTEXIST）
+func (mch *Mch) QueryOrderByTransactionID(ctx context.Context, transactionID string, options ...wx.HTTPOption) (*OrderQueryResult, error) {
+	return mch.queryOrder(ctx, QueryOrderByTransactionID(transactionID), options...)
+}
+
+// QueryOrderByOutTradeNO 根据商户订单号查询订单
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError（如：ORDERNOTEXIST）
+func (mch *Mch) QueryOrderByOutTradeNO(ctx context.Context, outTradeNO string, options ...wx.HTTPOption) (*OrderQueryResult, error) {
+	return mch.queryOrder(ctx, QueryOrderByOutTradeNO(outTradeNO), options...)
+}
+
+func (mch *Mch) queryOrder(ctx context.Context, action wx.Action, options ...wx.HTTPOption) (*OrderQueryResult, error) {
+	r, err := mch.Do(ctx, action, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	return parseOrderQueryResult(r)
+}
+
+// beijing 微信支付返回的时间均为北京时间
+var beijing = time.FixedZone("CST", 8*3600)
+
+func parseOrderQueryResult(r wx.WXML) (*OrderQueryResult, error) {
+	result := &OrderQueryResult{
+		TransactionID:  r["transaction_id"],
+		OutTradeNO:     r["out_trade_no"],
+		TradeState:     r["trade_state"],
+		TradeStateDesc: r["trade_state_desc"],
+		DeviceInfo:     r["device_info"],
+		OpenID:         r["openid"],
+		IsSubscribe:    r["is_subscribe"],
+		TradeType:      r["trade_type"],
+		BankType:       r["bank_type"],
+		FeeType:        r["fee_type"],
+		CashFeeType:    r["cash_fee_type"],
+		Attach:         r["attach"],
+	}
+
+	var err error
+
+	fees := []struct {
+		key  string
+		dest *int
+	}{
+		{"total_fee", &result.TotalFee},
+		{"settlement_total_fee", &result.SettlementTotalFee},
+		{"cash_fee", &result.CashFee},
+		{"coupon_fee", &result.CouponFee},
+		{"coupon_count", &result.CouponCount},
+	}
+
+	for _, v := range fees {
+		if *v.dest, err = atoi(r, v.key); err != nil {
+			return nil, err
+		}
+	}
+
+	// 代金券字段以 _$n 为下标（从0开始）平铺在应答中
+	for i := 0; i < result.CouponCount; i++ {
+		coupon := &OrderCoupon{
+			CouponType: r[fmt.Sprintf("coupon_type_%d", i)],
+			CouponID:   r[fmt.Sprintf("coupon_id_%d", i)],
+		}
+
+		if coupon.CouponFee, err = atoi(r, fmt.Sprintf("coupon_fee_%d", i)); err != nil {
+			return nil, err
+		}
+
+		result.Coupons = append(result.Coupons, coupon)
+	}
+
+	if v := r["time_end"]; v != "" {
+		if result.TimeEnd, err = time.ParseInLocation("20060102150405", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid time_end: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// atoi 解析应答中的整型字段，字段不存在时返回0
+func atoi(m wx.WXML, key string) (int, error) {
+	v, ok := m[key]
+
+	if !ok || v == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(v)
+
+	if err != nil {
+		return 0, fmt.Errorf("gochat: invalid %s: %w", key, err)
+	}
+
+	return i, nil
+}
+
 // CloseOrder 关闭订单【注意：订单生成后不能马上调用关单接口，最短调用时间间隔为5分钟。】
 func CloseOrder(outTradeNO string) wx.Action {
 	return wx.NewAction(OrderCloseURL,
@@ -148,3 +327,21 @@ func CloseOrder(outTradeNO string) wx.Action {
 		}),
 	)
 }
+
+// CloseOrder 关闭订单（如：用户放弃支付后释放库存），result_code 为 SUCCESS 时返回 nil
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError，
+// 可通过 errors.Is 判断 ErrOrderPaid、ErrOrderClosed、ErrSystemError（可重试），或通过 errors.As 获取 err_code 自行处理。
+// 【注意：订单生成后不能马上调用关单接口，最短调用时间间隔为5分钟。】
+func (mch *Mch) CloseOrder(ctx context.Context, outTradeNO string, options ...wx.HTTPOption) error {
+	r, err := mch.Do(ctx, CloseOrder(outTradeNO), options...)
+
+	if err != nil {
+		return err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return newResultError(r)
+	}
+
+	return nil
+}
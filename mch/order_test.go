@@ -2,7 +2,13 @@ package mch
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shenghui0779/gochat/wx"
@@ -73,6 +79,493 @@ func TestUnifyOrder(t *testing.T) {
 	}, r)
 }
 
+func TestUnifiedOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", wx.WXML{
+		"appid":            "wx2421b1c4370ec43b",
+		"mch_id":           "10000100",
+		"nonce_str":        "1add1a30ac87aa2db72f57a2375d8fec",
+		"trade_type":       "JSAPI",
+		"body":             "JSAPI支付测试",
+		"out_trade_no":     "1415659990",
+		"total_fee":        "1",
+		"fee_type":         "CNY",
+		"spbill_create_ip": "14.23.150.211",
+		"notify_url":       "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		"openid":           "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		"sign_type":        "MD5",
+		"sign":             "D1F84BB017A115B56E9A35709A8B6618",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>DB5B305838FD41937B670DDDD4F0A344</sign>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950874</prepay_id>
+	<trade_type>JSAPI</trade_type>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "1add1a30ac87aa2db72f57a2375d8fec"
+	}
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO:     "1415659990",
+		TotalFee:       1,
+		SpbillCreateIP: "14.23.150.211",
+		TradeType:      TradeJSAPI,
+		Body:           "JSAPI支付测试",
+		NotifyURL:      "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		OpenID:         "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "wx201411101639507cbf6ffd8b0779950874", r.PrepayID)
+}
+
+func TestUnifiedOrderNative(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", wx.WXML{
+		"appid":            "wx2421b1c4370ec43b",
+		"mch_id":           "10000100",
+		"nonce_str":        "1add1a30ac87aa2db72f57a2375d8fec",
+		"trade_type":       "NATIVE",
+		"body":             "NATIVE支付测试",
+		"out_trade_no":     "1415659990",
+		"total_fee":        "1",
+		"fee_type":         "CNY",
+		"spbill_create_ip": "14.23.150.211",
+		"notify_url":       "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		"product_id":       "12235413214070356458058",
+		"sign_type":        "MD5",
+		"sign":             "416D78209EDAC2B002FAC317CBF61D30",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>9FAC366B04A6C78957C37F0CC93F55D8</sign>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950874</prepay_id>
+	<trade_type>NATIVE</trade_type>
+	<code_url><![CDATA[weixin://wxpay/bizpayurl/up?pr=NwY5Mz9&groupid=00]]></code_url>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "1add1a30ac87aa2db72f57a2375d8fec"
+	}
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderRequest{
+		OutTradeNO:     "1415659990",
+		TotalFee:       1,
+		SpbillCreateIP: "14.23.150.211",
+		TradeType:      TradeNative,
+		Body:           "NATIVE支付测试",
+		NotifyURL:      "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		ProductID:      "12235413214070356458058",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &OrderResponse{
+		TradeType: TradeNative,
+		PrepayID:  "wx201411101639507cbf6ffd8b0779950874",
+		CodeURL:   "weixin://wxpay/bizpayurl/up?pr=NwY5Mz9&groupid=00",
+	}, r)
+}
+
+func TestUnifiedOrderMWEB(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", wx.WXML{
+		"appid":            "wx2421b1c4370ec43b",
+		"mch_id":           "10000100",
+		"nonce_str":        "1add1a30ac87aa2db72f57a2375d8fec",
+		"trade_type":       "MWEB",
+		"body":             "H5支付测试",
+		"out_trade_no":     "1415659990",
+		"total_fee":        "1",
+		"fee_type":         "CNY",
+		"spbill_create_ip": "14.23.150.211",
+		"notify_url":       "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		"scene_info":       `{"h5_info":{"type":"Wap","wap_url":"https://pay.qq.com","wap_name":"腾讯充值"}}`,
+		"attach":           "ATTACH",
+		"time_expire":      "20141110163950",
+		"sign_type":        "MD5",
+		"sign":             "51ADC27636E7A8770EB1851B1C753EA7",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>6FC49093BEADB3D631D47298D2316660</sign>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950874</prepay_id>
+	<trade_type>MWEB</trade_type>
+	<mweb_url><![CDATA[https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx2016121516420242444321ca0631331346&package=1405458241]]></mweb_url>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "1add1a30ac87aa2db72f57a2375d8fec"
+	}
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderRequest{
+		OutTradeNO:     "1415659990",
+		TotalFee:       1,
+		SpbillCreateIP: "14.23.150.211",
+		TradeType:      TradeMWEB,
+		Body:           "H5支付测试",
+		NotifyURL:      "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		Attach:         "ATTACH",
+		TimeExpire:     "20141110163950",
+		SceneInfo:      `{"h5_info":{"type":"Wap","wap_url":"https://pay.qq.com","wap_name":"腾讯充值"}}`,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &OrderResponse{
+		TradeType: TradeMWEB,
+		PrepayID:  "wx201411101639507cbf6ffd8b0779950874",
+		MWebURL:   "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx2016121516420242444321ca0631331346&package=1405458241",
+	}, r)
+}
+
+func TestUnifiedOrderValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 校验失败时不发起请求
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = wx.NewMockHTTPClient(ctrl)
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderRequest{
+		OutTradeNO: "1415659990",
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Nil(t, r)
+	assert.EqualError(t, err, "gochat: total_fee must be positive")
+
+	r, err = mch.UnifiedOrder(context.TODO(), &OrderRequest{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+	})
+
+	assert.Nil(t, r)
+	assert.EqualError(t, err, "gochat: openid is required when trade_type is JSAPI")
+}
+
+func TestUnifiedOrderWithHMacSHA256(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", wx.WXML{
+		"appid":            "wx2421b1c4370ec43b",
+		"mch_id":           "10000100",
+		"nonce_str":        "1add1a30ac87aa2db72f57a2375d8fec",
+		"trade_type":       "JSAPI",
+		"body":             "JSAPI支付测试",
+		"out_trade_no":     "1415659990",
+		"total_fee":        "1",
+		"fee_type":         "CNY",
+		"spbill_create_ip": "14.23.150.211",
+		"notify_url":       "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		"openid":           "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		"sign_type":        "HMAC-SHA256",
+		"sign":             "F1FCB2EA065D76909A62B901FE72872F115FCAA2FF5A5F455FB065C2AF4EC2CF",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>2F040135380538B7CF4B54EB437EC078CC881B7E6F040349AD32BCBFB56E9A38</sign>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950874</prepay_id>
+	<trade_type>JSAPI</trade_type>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(SignHMacSHA256))
+
+	mch.nonce = func(size int) string {
+		return "1add1a30ac87aa2db72f57a2375d8fec"
+	}
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO:     "1415659990",
+		TotalFee:       1,
+		SpbillCreateIP: "14.23.150.211",
+		TradeType:      TradeJSAPI,
+		Body:           "JSAPI支付测试",
+		NotifyURL:      "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		OpenID:         "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "wx201411101639507cbf6ffd8b0779950874", r.PrepayID)
+}
+
+func TestUnifiedOrderWithHMacSHA256SignMismatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 应答使用MD5签名，与请求的签名类型不一致
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>DB5B305838FD41937B670DDDD4F0A344</sign>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950874</prepay_id>
+	<trade_type>JSAPI</trade_type>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(SignHMacSHA256))
+	mch.client = client
+
+	_, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO:     "1415659990",
+		TotalFee:       1,
+		SpbillCreateIP: "14.23.150.211",
+		TradeType:      TradeJSAPI,
+		Body:           "JSAPI支付测试",
+		NotifyURL:      "http://wxpay.wxutil.com/pub_v2/pay/notify.v2.php",
+		OpenID:         "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	var signErr *ErrInvalidSign
+
+	assert.True(t, errors.As(err, &signErr))
+	assert.Equal(t, SignHMacSHA256, signErr.SignType)
+	assert.Equal(t, "DB5B305838FD41937B670DDDD4F0A344", signErr.Got)
+	assert.NotContains(t, err.Error(), "2F040135380538B7CF4B54EB437EC078CC881B7E6F040349AD32BCBFB56E9A38")
+}
+
+func TestUnifiedOrderTamperedResponse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// prepay_id 被篡改
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>DB5B305838FD41937B670DDDD4F0A344</sign>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950875</prepay_id>
+	<trade_type>JSAPI</trade_type>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Nil(t, r)
+
+	var signErr *ErrInvalidSign
+
+	assert.True(t, errors.As(err, &signErr))
+	assert.Equal(t, SignMD5, signErr.SignType)
+	assert.Equal(t, "DB5B305838FD41937B670DDDD4F0A344", signErr.Got)
+	assert.Equal(t, []string{"appid", "mch_id", "nonce_str", "prepay_id", "result_code", "return_code", "return_msg", "trade_type"}, signErr.Fields)
+}
+
+func TestUnifiedOrderUnsignedResponse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", gomock.Any()).Times(2).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<result_code>SUCCESS</result_code>
+	<prepay_id>wx201411101639507cbf6ffd8b0779950874</prepay_id>
+	<trade_type>JSAPI</trade_type>
+</xml>`), nil)
+
+	data := &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	}
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = client
+
+	_, err := mch.UnifiedOrder(context.TODO(), data)
+
+	var signErr *ErrInvalidSign
+
+	assert.True(t, errors.As(err, &signErr))
+	assert.Empty(t, signErr.Got)
+	assert.Equal(t, "gochat: sign missing, signed fields: appid,mch_id,nonce_str,prepay_id,result_code,return_code,return_msg,trade_type", err.Error())
+
+	// skip the verification
+	mch = New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSkipSignVerify(OrderUnifyURL))
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "wx201411101639507cbf6ffd8b0779950874", r.PrepayID)
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestUnifiedOrderWithDebugFunc(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body: ioutil.NopCloser(strings.NewReader(`<xml>
+	<return_code>FAIL</return_code>
+	<return_msg>签名错误</return_msg>
+</xml>`)),
+			}, nil
+		}),
+	}
+
+	var info *wx.DebugInfo
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithDebugFunc(func(ctx context.Context, v *wx.DebugInfo) {
+		info = v
+	}))
+
+	_, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.NotNil(t, err)
+	assert.NotNil(t, info)
+	assert.Equal(t, "https://api.mch.weixin.qq.com/pay/unifiedorder", info.URL)
+	assert.Contains(t, string(info.RequestBody), "<out_trade_no><![CDATA[1415659990]]></out_trade_no>")
+	assert.Contains(t, string(info.RequestBody), "<sign>")
+	assert.NotContains(t, string(info.RequestBody), "192006250b4c09247ec02edce69f6a2d")
+	assert.Contains(t, string(info.ResponseBody), "签名错误")
+}
+
+func TestUnifiedOrderReturnFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", gomock.Any()).Return([]byte(`<xml>
+	<return_code>FAIL</return_code>
+	<return_msg>签名错误</return_msg>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Nil(t, r)
+
+	var retErr *ReturnError
+
+	assert.True(t, errors.As(err, &retErr))
+	assert.Equal(t, "FAIL", retErr.ReturnCode)
+	assert.Equal(t, "签名错误", err.Error())
+}
+
+func TestUnifiedOrderResultFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/unifiedorder", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>9905E63ECD12F9D1811A7406E45FFF23</sign>
+	<result_code>FAIL</result_code>
+	<err_code>ORDERPAID</err_code>
+	<err_code_des>该订单已支付</err_code_des>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = client
+
+	r, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Nil(t, r)
+
+	var resErr *ResultError
+
+	assert.True(t, errors.As(err, &resErr))
+	assert.Equal(t, "ORDERPAID", resErr.ErrCode)
+	assert.Equal(t, "该订单已支付", resErr.ErrCodeDes)
+	assert.Equal(t, "ORDERPAID|该订单已支付", err.Error())
+}
+
 func TestQueryOrderByTransactionID(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -142,6 +635,56 @@ func TestQueryOrderByTransactionID(t *testing.T) {
 	}, r)
 }
 
+func TestQueryOrderByTransactionIDWithHMacSHA256(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"mch_id":         "10000100",
+		"transaction_id": "1008450740201411110005820873",
+		"nonce_str":      "ec2316275641faa3aacf3cc599e8730f",
+		"sign_type":      "HMAC-SHA256",
+		"sign":           "B310BA75416AB9C2061C86F265D852ADCC762BA21FDB895E5DB8720EF39D22CC",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<device_info>1000</device_info>
+	<nonce_str>TN55wO9Pba5yENl8</nonce_str>
+	<sign>6514616492769EB52BFF1C828C4961375477FD0AC78BE942792E5378E950E58D</sign>
+	<result_code>SUCCESS</result_code>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<is_subscribe>Y</is_subscribe>
+	<trade_type>APP</trade_type>
+	<bank_type>CCB_DEBIT</bank_type>
+	<total_fee>1</total_fee>
+	<fee_type>CNY</fee_type>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<attach>订单额外描述</attach>
+	<time_end>20141111170043</time_end>
+	<trade_state>SUCCESS</trade_state>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(SignHMacSHA256))
+
+	mch.nonce = func(size int) string {
+		return "ec2316275641faa3aacf3cc599e8730f"
+	}
+	mch.client = client
+	mch.tlsClient = client
+
+	r, err := mch.Do(context.TODO(), QueryOrderByTransactionID("1008450740201411110005820873"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "6514616492769EB52BFF1C828C4961375477FD0AC78BE942792E5378E950E58D", r["sign"])
+	assert.Equal(t, "SUCCESS", r["trade_state"])
+}
+
 func TestQueryOrderByOutTradeNO(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -211,6 +754,120 @@ func TestQueryOrderByOutTradeNO(t *testing.T) {
 	}, r)
 }
 
+func TestMchQueryOrderByTransactionID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"mch_id":         "10000100",
+		"transaction_id": "1008450740201411110005820873",
+		"nonce_str":      "ec2316275641faa3aacf3cc599e8730f",
+		"sign_type":      "MD5",
+		"sign":           "CA9B10C422366B6647827F0E6C18A4D8",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>TN55wO9Pba5yENl8</nonce_str>
+	<sign>C7A07B8AD9801B51F705B763604CF93E</sign>
+	<result_code>SUCCESS</result_code>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<is_subscribe>Y</is_subscribe>
+	<trade_type>JSAPI</trade_type>
+	<bank_type>CMC</bank_type>
+	<total_fee>100</total_fee>
+	<fee_type>CNY</fee_type>
+	<cash_fee>70</cash_fee>
+	<cash_fee_type>CNY</cash_fee_type>
+	<coupon_fee>30</coupon_fee>
+	<coupon_count>2</coupon_count>
+	<coupon_type_0>CASH</coupon_type_0>
+	<coupon_id_0>10000</coupon_id_0>
+	<coupon_fee_0>10</coupon_fee_0>
+	<coupon_type_1>NO_CASH</coupon_type_1>
+	<coupon_id_1>10001</coupon_id_1>
+	<coupon_fee_1>20</coupon_fee_1>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<attach>订单额外描述</attach>
+	<time_end>20141111170043</time_end>
+	<trade_state>SUCCESS</trade_state>
+	<trade_state_desc>支付成功</trade_state_desc>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "ec2316275641faa3aacf3cc599e8730f"
+	}))
+	mch.client = client
+
+	r, err := mch.QueryOrderByTransactionID(context.TODO(), "1008450740201411110005820873")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &OrderQueryResult{
+		TransactionID:  "1008450740201411110005820873",
+		OutTradeNO:     "1415757673",
+		TradeState:     TradeStateSuccess,
+		TradeStateDesc: "支付成功",
+		OpenID:         "oUpF8uN95-Ptaags6E_roPHg7AG0",
+		IsSubscribe:    "Y",
+		TradeType:      TradeJSAPI,
+		BankType:       "CMC",
+		TotalFee:       100,
+		FeeType:        "CNY",
+		CashFee:        70,
+		CashFeeType:    "CNY",
+		CouponFee:      30,
+		CouponCount:    2,
+		Coupons: []*OrderCoupon{
+			{CouponType: "CASH", CouponID: "10000", CouponFee: 10},
+			{CouponType: "NO_CASH", CouponID: "10001", CouponFee: 20},
+		},
+		Attach:  "订单额外描述",
+		TimeEnd: time.Date(2014, 11, 11, 17, 0, 43, 0, time.FixedZone("CST", 8*3600)),
+	}, r)
+	assert.Equal(t, int64(1415696443), r.TimeEnd.Unix())
+}
+
+func TestMchQueryOrderByOutTradeNO(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", wx.WXML{
+		"appid":        "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"out_trade_no": "1415757673",
+		"nonce_str":    "ec2316275641faa3aacf3cc599e8730f",
+		"sign_type":    "MD5",
+		"sign":         "5F222EA3F23200DD4E86C4C42E96698D",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>IITRi8Iabbblz1Jc</nonce_str>
+	<sign>9905E63ECD12F9D1811A7406E45FFF23</sign>
+	<result_code>FAIL</result_code>
+	<err_code>ORDERPAID</err_code>
+	<err_code_des>该订单已支付</err_code_des>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "ec2316275641faa3aacf3cc599e8730f"
+	}))
+	mch.client = client
+
+	r, err := mch.QueryOrderByOutTradeNO(context.TODO(), "1415757673")
+
+	assert.Nil(t, r)
+	assert.Equal(t, &ResultError{ErrCode: "ORDERPAID", ErrCodeDes: "该订单已支付"}, err)
+}
+
 func TestCloseOrder(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -257,3 +914,88 @@ func TestCloseOrder(t *testing.T) {
 		"result_msg":  "OK",
 	}, r)
 }
+
+func TestMchCloseOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/closeorder", wx.WXML{
+		"appid":        "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"out_trade_no": "1415983244",
+		"nonce_str":    "4ca93f17ddf3443ceabf72f26d64fe0e",
+		"sign_type":    "MD5",
+		"sign":         "72D4DE9625257C606558F1027331C516",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>BFK89FC6rxKCOjLX</nonce_str>
+	<sign>808C1D11E84411F8DF1DF1ADC960B491</sign>
+	<result_code>SUCCESS</result_code>
+	<result_msg>OK</result_msg>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "4ca93f17ddf3443ceabf72f26d64fe0e"
+	}))
+	mch.client = client
+
+	assert.Nil(t, mch.CloseOrder(context.TODO(), "1415983244"))
+}
+
+func TestMchCloseOrderResultFail(t *testing.T) {
+	cases := []struct {
+		errCode    string
+		errCodeDes string
+		sign       string
+		target     error
+	}{
+		{"ORDERPAID", "该订单已支付", "563F72A1DBAEF1FD1499C2FE763F9A89", ErrOrderPaid},
+		{"ORDERCLOSED", "订单已关闭", "F431E347DAB2336E3413B4FE54BFC152", ErrOrderClosed},
+		{"SYSTEMERROR", "系统错误", "8836D4370DCA4DD015CFEAB593F79C3C", ErrSystemError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.errCode, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			client := wx.NewMockHTTPClient(ctrl)
+
+			client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/closeorder", gomock.Any()).Return([]byte(fmt.Sprintf(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>BFK89FC6rxKCOjLX</nonce_str>
+	<sign>%s</sign>
+	<result_code>FAIL</result_code>
+	<err_code>%s</err_code>
+	<err_code_des>%s</err_code_des>
+</xml>`, c.sign, c.errCode, c.errCodeDes)), nil)
+
+			mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+			mch.client = client
+
+			err := mch.CloseOrder(context.TODO(), "1415983244")
+
+			assert.True(t, errors.Is(err, c.target))
+
+			var resErr *ResultError
+
+			assert.True(t, errors.As(err, &resErr))
+			assert.Equal(t, c.errCode, resErr.ErrCode)
+			assert.Equal(t, c.errCodeDes, resErr.ErrCodeDes)
+
+			for _, other := range cases {
+				if other.errCode != c.errCode {
+					assert.False(t, errors.Is(err, other.target))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package mch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// MaxProfitSharingReceivers 单次分账请求的最大接收方数目
+const MaxProfitSharingReceivers = 50
+
+// ProfitSharingReceiver 分账接收方（请求分账时以JSON格式放入 receivers 字段）
+type ProfitSharingReceiver struct {
+	Type        ProfitSharingReceiverType `json:"type"`        // 分账接收方类型（MERCHANT_ID、PERSONAL_OPENID）
+	Account     string                    `json:"account"`     // 分账接收方帐号（类型是MERCHANT_ID时，是商户号；类型是PERSONAL_OPENID时，是个人openid）
+	Amount      int                       `json:"amount"`      // 分账金额，单位：分
+	Description string                    `json:"description"` // 分账的原因描述，分账账单中需要体现
+}
+
+// ProfitSharingData 分账数据
+type ProfitSharingData struct {
+	TransactionID string                   // 微信支付订单号
+	OutOrderNO    string                   // 商户系统内部的分账单号（同一分账单号多次请求等同一次）
+	Receivers     []*ProfitSharingReceiver // 分账接收方列表，不超过50个
+}
+
+// ProfitSharing 请求单次分账（请求后订单剩余的待分账金额将解冻给商户）
+func ProfitSharing(data *ProfitSharingData) wx.Action {
+	return profitSharing(ProfitSharingURL, data)
+}
+
+// MultiProfitSharing 请求多次分账（分账完成后需调用 ProfitSharingFinish 解冻剩余资金）
+func MultiProfitSharing(data *ProfitSharingData) wx.Action {
+	return profitSharing(MultiProfitSharingURL, data)
+}
+
+func profitSharing(reqURL string, data *ProfitSharingData) wx.Action {
+	return wx.NewAction(reqURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithTLS(),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			receivers, err := json.Marshal(data.Receivers)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return wx.WXML{
+				"appid":          appid,
+				"mch_id":         mchid,
+				"nonce_str":      nonce,
+				"transaction_id": data.TransactionID,
+				"out_order_no":   data.OutOrderNO,
+				"receivers":      string(receivers),
+				"sign_type":      SignHMacSHA256, // 分账接口仅支持HMAC-SHA256签名
+			}, nil
+		}),
+	)
+}
+
+// ProfitSharingQuery 查询分账结果
+func ProfitSharingQuery(transactionID, outOrderNO string) wx.Action {
+	return wx.NewAction(ProfitSharingQueryURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			return wx.WXML{
+				"mch_id":         mchid,
+				"transaction_id": transactionID,
+				"out_order_no":   outOrderNO,
+				"nonce_str":      nonce,
+				"sign_type":      SignHMacSHA256,
+			}, nil
+		}),
+	)
+}
+
+// ProfitSharingFinish 完结分账（不需要进行分账的订单，可直接调用本接口将订单的金额全部解冻给商户）
+func ProfitSharingFinish(transactionID, outOrderNO, description string) wx.Action {
+	return wx.NewAction(ProfitSharingFinishURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithTLS(),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			return wx.WXML{
+				"appid":          appid,
+				"mch_id":         mchid,
+				"nonce_str":      nonce,
+				"transaction_id": transactionID,
+				"out_order_no":   outOrderNO,
+				"description":    description,
+				"sign_type":      SignHMacSHA256,
+			}, nil
+		}),
+	)
+}
+
+// ProfitSharingRelation 分账接收方关系（添加分账接收方时以JSON格式放入 receiver 字段）
+type ProfitSharingRelation struct {
+	Type           ProfitSharingReceiverType `json:"type"`                      // 分账接收方类型（MERCHANT_ID、PERSONAL_OPENID）
+	Account        string                    `json:"account"`                   // 分账接收方帐号
+	Name           string                    `json:"name,omitempty"`            // 分账接收方全称（类型是MERCHANT_ID时必填，是商户全称）
+	RelationType   ProfitSharingRelationType `json:"relation_type"`             // 与分账方的关系类型
+	CustomRelation string                    `json:"custom_relation,omitempty"` // 自定义的分账关系（relation_type 为 CUSTOM 时必填）
+}
+
+// AddProfitSharingReceiver 添加分账接收方
+func AddProfitSharingReceiver(receiver *ProfitSharingRelation) wx.Action {
+	return profitSharingReceiver(ProfitSharingAddReceiverURL, receiver)
+}
+
+// RemoveProfitSharingReceiver 删除分账接收方
+func RemoveProfitSharingReceiver(receiverType ProfitSharingReceiverType, account string) wx.Action {
+	return profitSharingReceiver(ProfitSharingRemoveReceiverURL, &struct {
+		Type    ProfitSharingReceiverType `json:"type"`
+		Account string                    `json:"account"`
+	}{
+		Type:    receiverType,
+		Account: account,
+	})
+}
+
+func profitSharingReceiver(reqURL string, receiver interface{}) wx.Action {
+	return wx.NewAction(reqURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			b, err := json.Marshal(receiver)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return wx.WXML{
+				"appid":     appid,
+				"mch_id":    mchid,
+				"nonce_str": nonce,
+				"receiver":  string(b),
+				"sign_type": SignHMacSHA256,
+			}, nil
+		}),
+	)
+}
+
+// ProfitSharingRequest 分账请求参数
+type ProfitSharingRequest = ProfitSharingData
+
+// ProfitSharingResponse 分账应答
+type ProfitSharingResponse struct {
+	TransactionID string // 微信支付订单号
+	OutOrderNO    string // 商户分账单号
+	OrderID       string // 微信分账单号
+	Status        string // 分账单状态（ACCEPTED、PROCESSING、FINISHED、CLOSED），完结分账的应答不含该字段
+}
+
+// ProfitSharing 请求单次分账（需加载商户证书），始终使用HMAC-SHA256签名
+// 请求前校验：transaction_id、out_order_no 必填，receivers 为1~50个且分账金额须大于0
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) ProfitSharing(ctx context.Context, req *ProfitSharingRequest, options ...wx.HTTPOption) (*ProfitSharingResponse, error) {
+	if err := validateProfitSharing(req); err != nil {
+		return nil, err
+	}
+
+	return mch.profitSharing(ctx, ProfitSharing(req), options...)
+}
+
+// MultiProfitSharing 请求多次分账（需加载商户证书），校验规则同 ProfitSharing
+func (mch *Mch) MultiProfitSharing(ctx context.Context, req *ProfitSharingRequest, options ...wx.HTTPOption) (*ProfitSharingResponse, error) {
+	if err := validateProfitSharing(req); err != nil {
+		return nil, err
+	}
+
+	return mch.profitSharing(ctx, MultiProfitSharing(req), options...)
+}
+
+// ProfitSharingFinish 完结分账（需加载商户证书），将订单剩余的待分账金额全部解冻给商户
+func (mch *Mch) ProfitSharingFinish(ctx context.Context, transactionID, outOrderNO, description string, options ...wx.HTTPOption) (*ProfitSharingResponse, error) {
+	if transactionID == "" || outOrderNO == "" {
+		return nil, errors.New("gochat: transaction_id and out_order_no are required")
+	}
+
+	return mch.profitSharing(ctx, ProfitSharingFinish(transactionID, outOrderNO, description), options...)
+}
+
+// validateProfitSharing 分账请求的参数校验
+func validateProfitSharing(req *ProfitSharingRequest) error {
+	if req.TransactionID == "" || req.OutOrderNO == "" {
+		return errors.New("gochat: transaction_id and out_order_no are required")
+	}
+
+	if len(req.Receivers) == 0 || len(req.Receivers) > MaxProfitSharingReceivers {
+		return errors.New("gochat: receivers must be between 1 and 50")
+	}
+
+	for _, v := range req.Receivers {
+		if v.Amount <= 0 {
+			return errors.New("gochat: receiver amount must be positive")
+		}
+	}
+
+	return nil
+}
+
+func (mch *Mch) profitSharing(ctx context.Context, action wx.Action, options ...wx.HTTPOption) (*ProfitSharingResponse, error) {
+	r, err := mch.Do(ctx, action, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	return &ProfitSharingResponse{
+		TransactionID: r["transaction_id"],
+		OutOrderNO:    r["out_order_no"],
+		OrderID:       r["order_id"],
+		Status:        r["status"],
+	}, nil
+}
+
+// ProfitSharingResult 分账接收方的分账结果
+type ProfitSharingResult struct {
+	Type        ProfitSharingReceiverType // 分账接收方类型
+	Account     string                    // 分账接收方帐号
+	Amount      int                       // 分账金额，单位：分
+	Description string                    // 分账描述
+	Result      string                    // 分账结果（PENDING、SUCCESS、CLOSED）
+	FinishTime  time.Time                 // 分账完成时间（北京时间）
+	FailReason  string                    // 分账失败原因
+}
+
+// ProfitSharingInfo 分账结果
+type ProfitSharingInfo struct {
+	TransactionID string                 // 微信支付订单号
+	OutOrderNO    string                 // 商户分账单号
+	OrderID       string                 // 微信分账单号
+	Status        string                 // 分账单状态（ACCEPTED、PROCESSING、FINISHED、CLOSED）
+	CloseReason   string                 // 关单原因
+	Receivers     []*ProfitSharingResult // 分账接收方列表
+	Amount        int                    // 完结分账的金额，单位：分（仅完结分账时返回）
+	Description   string                 // 完结分账的描述（仅完结分账时返回）
+}
+
+// ProfitSharingQuery 查询分账结果，应答中JSON格式的 receivers 解析为 Receivers
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) ProfitSharingQuery(ctx context.Context, transactionID, outOrderNO string, options ...wx.HTTPOption) (*ProfitSharingInfo, error) {
+	r, err := mch.Do(ctx, ProfitSharingQuery(transactionID, outOrderNO), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	info := &ProfitSharingInfo{
+		TransactionID: r["transaction_id"],
+		OutOrderNO:    r["out_order_no"],
+		OrderID:       r["order_id"],
+		Status:        r["status"],
+		CloseReason:   r["close_reason"],
+		Description:   r["description"],
+	}
+
+	if info.Amount, err = atoi(r, "amount"); err != nil {
+		return nil, err
+	}
+
+	if info.Receivers, err = parseProfitSharingResults(r["receivers"]); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// parseProfitSharingResults 解析分账查询应答中JSON格式的 receivers，finish_time 格式：yyyyMMddHHmmss
+func parseProfitSharingResults(s string) ([]*ProfitSharingResult, error) {
+	results := make([]*ProfitSharingResult, 0)
+
+	if s == "" {
+		return results, nil
+	}
+
+	var receivers []struct {
+		Type        ProfitSharingReceiverType `json:"type"`
+		Account     string                    `json:"account"`
+		Amount      int                       `json:"amount"`
+		Description string                    `json:"description"`
+		Result      string                    `json:"result"`
+		FinishTime  string                    `json:"finish_time"`
+		FailReason  string                    `json:"fail_reason"`
+	}
+
+	if err := json.Unmarshal([]byte(s), &receivers); err != nil {
+		return nil, fmt.Errorf("gochat: invalid receivers: %w", err)
+	}
+
+	for _, v := range receivers {
+		result := &ProfitSharingResult{
+			Type:        v.Type,
+			Account:     v.Account,
+			Amount:      v.Amount,
+			Description: v.Description,
+			Result:      v.Result,
+			FailReason:  v.FailReason,
+		}
+
+		if v.FinishTime != "" {
+			t, err := time.ParseInLocation("20060102150405", v.FinishTime, beijing)
+
+			if err != nil {
+				return nil, fmt.Errorf("gochat: invalid receivers finish_time: %w", err)
+			}
+
+			result.FinishTime = t
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// AddProfitSharingReceiver 添加分账接收方（请求分账前需先添加接收方），始终使用HMAC-SHA256签名
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) AddProfitSharingReceiver(ctx context.Context, receiver *ProfitSharingRelation, options ...wx.HTTPOption) error {
+	if receiver.RelationType == RelationCustom && receiver.CustomRelation == "" {
+		return errors.New("gochat: custom_relation is required when relation_type is CUSTOM")
+	}
+
+	return mch.execProfitSharingReceiver(ctx, AddProfitSharingReceiver(receiver), options...)
+}
+
+// RemoveProfitSharingReceiver 删除分账接收方，始终使用HMAC-SHA256签名
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) RemoveProfitSharingReceiver(ctx context.Context, receiverType ProfitSharingReceiverType, account string, options ...wx.HTTPOption) error {
+	return mch.execProfitSharingReceiver(ctx, RemoveProfitSharingReceiver(receiverType, account), options...)
+}
+
+func (mch *Mch) execProfitSharingReceiver(ctx context.Context, action wx.Action, options ...wx.HTTPOption) error {
+	r, err := mch.Do(ctx, action, options...)
+
+	if err != nil {
+		return err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return newResultError(r)
+	}
+
+	return nil
+}
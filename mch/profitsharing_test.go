@@ -0,0 +1,330 @@
+package mch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfitSharing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// receivers 以JSON字符串放入XML，且无论客户端默认签名类型如何，均使用HMAC-SHA256签名
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/profitsharing", wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"mch_id":         "10000100",
+		"nonce_str":      "50780e0cca98c8c8e814883e5caa672e",
+		"transaction_id": "4208450740201411110007820472",
+		"out_order_no":   "P20150806125346",
+		"receivers":      `[{"type":"MERCHANT_ID","account":"190001001","amount":100,"description":"分到商户"},{"type":"PERSONAL_OPENID","account":"86693952","amount":888,"description":"分到个人"}]`,
+		"sign_type":      "HMAC-SHA256",
+		"sign":           "04F431DE15EE50D59EFF50FC4C679D2AA6E8436383AABF6339C547E966F51EFE",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_id>10000100</mch_id>
+	<appid>wx2421b1c4370ec43b</appid>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<transaction_id>4208450740201411110007820472</transaction_id>
+	<out_order_no>P20150806125346</out_order_no>
+	<order_id>3008450740201411110007820472</order_id>
+	<status>FINISHED</status>
+	<sign>6618F9520569E2419850C2E82D6A3AA7E4DB0906AE97B3DEFE32D8354A9B2CFB</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	resp, err := mch.ProfitSharing(context.TODO(), &ProfitSharingRequest{
+		TransactionID: "4208450740201411110007820472",
+		OutOrderNO:    "P20150806125346",
+		Receivers: []*ProfitSharingReceiver{
+			{Type: ProfitSharingMerchantID, Account: "190001001", Amount: 100, Description: "分到商户"},
+			{Type: ProfitSharingPersonalOpenID, Account: "86693952", Amount: 888, Description: "分到个人"},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &ProfitSharingResponse{
+		TransactionID: "4208450740201411110007820472",
+		OutOrderNO:    "P20150806125346",
+		OrderID:       "3008450740201411110007820472",
+		Status:        ProfitSharingStatusFinished,
+	}, resp)
+}
+
+func TestMultiProfitSharing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/multiprofitsharing", wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"mch_id":         "10000100",
+		"nonce_str":      "50780e0cca98c8c8e814883e5caa672e",
+		"transaction_id": "4208450740201411110007820472",
+		"out_order_no":   "P20150806125346",
+		"receivers":      `[{"type":"MERCHANT_ID","account":"190001001","amount":100,"description":"分到商户"},{"type":"PERSONAL_OPENID","account":"86693952","amount":888,"description":"分到个人"}]`,
+		"sign_type":      "HMAC-SHA256",
+		"sign":           "04F431DE15EE50D59EFF50FC4C679D2AA6E8436383AABF6339C547E966F51EFE",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_id>10000100</mch_id>
+	<appid>wx2421b1c4370ec43b</appid>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<transaction_id>4208450740201411110007820472</transaction_id>
+	<out_order_no>P20150806125346</out_order_no>
+	<order_id>3008450740201411110007820472</order_id>
+	<status>FINISHED</status>
+	<sign>6618F9520569E2419850C2E82D6A3AA7E4DB0906AE97B3DEFE32D8354A9B2CFB</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	r, err := mch.Do(context.TODO(), MultiProfitSharing(&ProfitSharingData{
+		TransactionID: "4208450740201411110007820472",
+		OutOrderNO:    "P20150806125346",
+		Receivers: []*ProfitSharingReceiver{
+			{Type: ProfitSharingMerchantID, Account: "190001001", Amount: 100, Description: "分到商户"},
+			{Type: ProfitSharingPersonalOpenID, Account: "86693952", Amount: 888, Description: "分到个人"},
+		},
+	}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "3008450740201411110007820472", r["order_id"])
+}
+
+func TestProfitSharingValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 校验失败时不发起请求
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = wx.NewMockHTTPClient(ctrl)
+
+	receivers := make([]*ProfitSharingReceiver, 0, MaxProfitSharingReceivers+1)
+
+	for i := 0; i <= MaxProfitSharingReceivers; i++ {
+		receivers = append(receivers, &ProfitSharingReceiver{Type: ProfitSharingMerchantID, Account: "190001001", Amount: 1, Description: "分到商户"})
+	}
+
+	cases := []struct {
+		req *ProfitSharingRequest
+		err string
+	}{
+		{&ProfitSharingRequest{OutOrderNO: "P20150806125346", Receivers: receivers[:1]}, "gochat: transaction_id and out_order_no are required"},
+		{&ProfitSharingRequest{TransactionID: "4208450740201411110007820472", OutOrderNO: "P20150806125346"}, "gochat: receivers must be between 1 and 50"},
+		{&ProfitSharingRequest{TransactionID: "4208450740201411110007820472", OutOrderNO: "P20150806125346", Receivers: receivers}, "gochat: receivers must be between 1 and 50"},
+		{&ProfitSharingRequest{TransactionID: "4208450740201411110007820472", OutOrderNO: "P20150806125346", Receivers: []*ProfitSharingReceiver{{Type: ProfitSharingMerchantID, Account: "190001001"}}}, "gochat: receiver amount must be positive"},
+	}
+
+	for _, c := range cases {
+		_, err := mch.ProfitSharing(context.TODO(), c.req)
+
+		assert.EqualError(t, err, c.err)
+
+		_, err = mch.MultiProfitSharing(context.TODO(), c.req)
+
+		assert.EqualError(t, err, c.err)
+	}
+}
+
+func TestProfitSharingQuery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/profitsharingquery", wx.WXML{
+		"mch_id":         "10000100",
+		"nonce_str":      "50780e0cca98c8c8e814883e5caa672e",
+		"transaction_id": "4208450740201411110007820472",
+		"out_order_no":   "P20150806125346",
+		"sign_type":      "HMAC-SHA256",
+		"sign":           "F0ABDCD2F031B8E9AFC3C9EBB4743971729AEF7E3D094FFCA1B3B4B04B7F1173",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<transaction_id>4208450740201411110007820472</transaction_id>
+	<out_order_no>P20150806125346</out_order_no>
+	<order_id>3008450740201411110007820472</order_id>
+	<status>FINISHED</status>
+	<receivers><![CDATA[[{"type":"MERCHANT_ID","account":"190001001","amount":100,"description":"分到商户","result":"SUCCESS","finish_time":"20180608170132"},{"type":"PERSONAL_OPENID","account":"86693952","amount":888,"description":"分到个人","result":"CLOSED","fail_reason":"ACCOUNT_ABNORMAL"}]]]></receivers>
+	<sign>14D444D0DF6ABCA8409816CF94DEC8E39159AC24AD11464149D3F2226A50587A</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	info, err := mch.ProfitSharingQuery(context.TODO(), "4208450740201411110007820472", "P20150806125346")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &ProfitSharingInfo{
+		TransactionID: "4208450740201411110007820472",
+		OutOrderNO:    "P20150806125346",
+		OrderID:       "3008450740201411110007820472",
+		Status:        ProfitSharingStatusFinished,
+		Receivers: []*ProfitSharingResult{
+			{Type: ProfitSharingMerchantID, Account: "190001001", Amount: 100, Description: "分到商户", Result: ProfitSharingResultSuccess, FinishTime: time.Date(2018, 6, 8, 17, 1, 32, 0, beijing)},
+			{Type: ProfitSharingPersonalOpenID, Account: "86693952", Amount: 888, Description: "分到个人", Result: ProfitSharingResultClosed, FailReason: "ACCOUNT_ABNORMAL"},
+		},
+	}, info)
+}
+
+func TestAddProfitSharingReceiver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/profitsharingaddreceiver", wx.WXML{
+		"appid":     "wx2421b1c4370ec43b",
+		"mch_id":    "10000100",
+		"nonce_str": "50780e0cca98c8c8e814883e5caa672e",
+		"receiver":  `{"type":"MERCHANT_ID","account":"190001001","name":"示例商户全称","relation_type":"SERVICE_PROVIDER"}`,
+		"sign_type": "HMAC-SHA256",
+		"sign":      "3B67E805A4396F15A5021AF688AD6D64719EFD6A2424FA748C33516498005303",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_id>10000100</mch_id>
+	<appid>wx2421b1c4370ec43b</appid>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<receiver><![CDATA[{"type":"MERCHANT_ID","account":"190001001","name":"示例商户全称","relation_type":"SERVICE_PROVIDER"}]]></receiver>
+	<sign>8FA02519A0F864D8D3329C7E250B14318B1398A7D40DAD349D59F7722B5B6DE7</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	err := mch.AddProfitSharingReceiver(context.TODO(), &ProfitSharingRelation{
+		Type:         ProfitSharingMerchantID,
+		Account:      "190001001",
+		Name:         "示例商户全称",
+		RelationType: RelationServiceProvider,
+	})
+
+	assert.Nil(t, err)
+
+	// 自定义关系须填写 custom_relation
+	err = mch.AddProfitSharingReceiver(context.TODO(), &ProfitSharingRelation{
+		Type:         ProfitSharingPersonalOpenID,
+		Account:      "86693952",
+		RelationType: RelationCustom,
+	})
+
+	assert.EqualError(t, err, "gochat: custom_relation is required when relation_type is CUSTOM")
+}
+
+func TestRemoveProfitSharingReceiver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/profitsharingremovereceiver", wx.WXML{
+		"appid":     "wx2421b1c4370ec43b",
+		"mch_id":    "10000100",
+		"nonce_str": "50780e0cca98c8c8e814883e5caa672e",
+		"receiver":  `{"type":"PERSONAL_OPENID","account":"86693952"}`,
+		"sign_type": "HMAC-SHA256",
+		"sign":      "5258FC1A54EBAF9A1101190656212FCA6249B771890905F416541EE9E780DB07",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_id>10000100</mch_id>
+	<appid>wx2421b1c4370ec43b</appid>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<receiver><![CDATA[{"type":"PERSONAL_OPENID","account":"86693952"}]]></receiver>
+	<sign>3EF4AF197F1B1FB381133168CC803E5B0967A6A01ECF8C449F216E2AEDF263B5</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.client = client
+
+	err := mch.RemoveProfitSharingReceiver(context.TODO(), ProfitSharingPersonalOpenID, "86693952")
+
+	assert.Nil(t, err)
+}
+
+func TestProfitSharingFinish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/profitsharingfinish", wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"mch_id":         "10000100",
+		"nonce_str":      "50780e0cca98c8c8e814883e5caa672e",
+		"transaction_id": "4208450740201411110007820472",
+		"out_order_no":   "P20150806125346",
+		"description":    "分账已完成",
+		"sign_type":      "HMAC-SHA256",
+		"sign":           "9FC88F65DD7E093DD2E18FF6ECB8D3433A86D4D7F38E266E5E7065BB214D9ECB",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_id>10000100</mch_id>
+	<appid>wx2421b1c4370ec43b</appid>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<transaction_id>4208450740201411110007820472</transaction_id>
+	<out_order_no>P20150806125346</out_order_no>
+	<order_id>3008450740201411110007820472</order_id>
+	<sign>7C6781BD8A9C42B883D4B39D135C94331FAAAE4F65FD42C4DA3F9C4473DB2C37</sign>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	resp, err := mch.ProfitSharingFinish(context.TODO(), "4208450740201411110007820472", "P20150806125346", "分账已完成")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &ProfitSharingResponse{
+		TransactionID: "4208450740201411110007820472",
+		OutOrderNO:    "P20150806125346",
+		OrderID:       "3008450740201411110007820472",
+	}, resp)
+}
@@ -1,7 +1,12 @@
 package mch
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/shenghui0779/gochat/wx"
 )
@@ -19,8 +24,9 @@ type RedpackData struct {
 	ActName     string // 活动名称；注意：敏感词会被转义成字符*
 	Remark      string // 备注信息
 	// 选填参数
-	SceneID  string // 发放红包使用场景，红包金额大于200或者小于1元时必传
-	RiskInfo string // 活动信息，urlencode(posttime=xx&mobile=xx&deviceid=xx。posttime：用户操作的时间戳；mobile：业务系统账号的手机号，国家代码-手机号，不需要+号；deviceid：MAC地址或者设备唯一标识；clientversion：用户操作的客户端版本
+	SceneID  string         // 发放红包使用场景，红包金额大于200或者小于1元时必传
+	AmtType  RedpackAmtType // 裂变红包的金额设置方式，默认：ALL_RAND（仅用于裂变红包）
+	RiskInfo string         // 活动信息，urlencode(posttime=xx&mobile=xx&deviceid=xx。posttime：用户操作的时间戳；mobile：业务系统账号的手机号，国家代码-手机号，不需要+号；deviceid：MAC地址或者设备唯一标识；clientversion：用户操作的客户端版本
 }
 
 // SendNormalRedpack 发放普通红包
@@ -73,13 +79,17 @@ func SendGroupRedpack(data *RedpackData) wx.Action {
 				"re_openid":    data.ReOpenID,
 				"total_amount": strconv.Itoa(data.TotalAmount),
 				"total_num":    strconv.Itoa(data.TotalNum),
-				"amt_type":     "ALL_RAND",
+				"amt_type":     string(RedpackAmtAllRand),
 				"wishing":      data.Wishing,
 				"act_name":     data.ActName,
 				"remark":       data.Remark,
 				"sign_type":    SignMD5,
 			}
 
+			if data.AmtType != "" {
+				body["amt_type"] = string(data.AmtType)
+			}
+
 			if data.SceneID != "" {
 				body["scene_id"] = data.SceneID
 			}
@@ -124,9 +134,95 @@ func SendMinipRedpack(data *RedpackData) wx.Action {
 	)
 }
 
+// RedpackRequest 红包发放请求
+type RedpackRequest = RedpackData
+
+// RedpackResponse 红包发放结果
+type RedpackResponse struct {
+	MchBillNO   string // 商户订单号
+	ReOpenID    string // 接受红包的用户openid
+	TotalAmount int    // 付款金额，单位：分
+	SendListID  string // 红包订单的微信单号
+}
+
+// SendRedpack 发放普通红包（需加载商户证书），返回红包订单的微信单号（send_listid）
+// 请求前校验：total_amount 须大于0，total_num 须为1（多人领取请使用 SendGroupRedpack），total_amount 大于200元时 scene_id 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) SendRedpack(ctx context.Context, req *RedpackRequest, options ...wx.HTTPOption) (*RedpackResponse, error) {
+	if req.TotalAmount <= 0 {
+		return nil, errors.New("gochat: total_amount must be positive")
+	}
+
+	if req.TotalNum != 1 {
+		return nil, errors.New("gochat: total_num must be 1 for normal redpack")
+	}
+
+	if err := validateRedpackScene(req); err != nil {
+		return nil, err
+	}
+
+	return mch.sendRedpack(ctx, SendNormalRedpack(req), options...)
+}
+
+// SendGroupRedpack 发放裂变红包（需加载商户证书），返回红包订单的微信单号（send_listid）
+// 请求前校验：total_amount 须大于0，total_num 须为3~20（包括分享者），total_amount 大于200元时 scene_id 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) SendGroupRedpack(ctx context.Context, req *RedpackRequest, options ...wx.HTTPOption) (*RedpackResponse, error) {
+	if req.TotalAmount <= 0 {
+		return nil, errors.New("gochat: total_amount must be positive")
+	}
+
+	if req.TotalNum < 3 || req.TotalNum > 20 {
+		return nil, errors.New("gochat: total_num must be between 3 and 20 for group redpack")
+	}
+
+	if err := validateRedpackScene(req); err != nil {
+		return nil, err
+	}
+
+	return mch.sendRedpack(ctx, SendGroupRedpack(req), options...)
+}
+
+// validateRedpackScene 红包金额大于200元时 scene_id 必填
+func validateRedpackScene(req *RedpackRequest) error {
+	if req.TotalAmount > 20000 && req.SceneID == "" {
+		return errors.New("gochat: scene_id is required when total_amount is greater than 20000")
+	}
+
+	return nil
+}
+
+func (mch *Mch) sendRedpack(ctx context.Context, action wx.Action, options ...wx.HTTPOption) (*RedpackResponse, error) {
+	r, err := mch.Do(ctx, action, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	resp := &RedpackResponse{
+		MchBillNO:  r["mch_billno"],
+		ReOpenID:   r["re_openid"],
+		SendListID: r["send_listid"],
+	}
+
+	if resp.TotalAmount, err = atoi(r, "total_amount"); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 // QueryRedpackByBillNO 查询红包记录
 func QueryRedpackByBillNO(billNO string) wx.Action {
-	return wx.NewAction(RedpackQueryURL,
+	return queryRedpack(billNO, nil)
+}
+
+func queryRedpack(billNO string, dest *[]*RedpackReceiver) wx.Action {
+	options := []wx.ActionOption{
 		wx.WithMethod(wx.MethodPost),
 		wx.WithTLS(),
 		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
@@ -139,5 +235,140 @@ func QueryRedpackByBillNO(billNO string) wx.Action {
 				"sign_type":  SignMD5,
 			}, nil
 		}),
-	)
+	}
+
+	if dest != nil {
+		options = append(options, wx.WithDecode(func(resp []byte) error {
+			return parseRedpackReceivers(resp, dest)
+		}))
+	}
+
+	return wx.NewAction(RedpackQueryURL, options...)
+}
+
+// RedpackReceiver 红包领取记录
+type RedpackReceiver struct {
+	OpenID  string    // 领取红包的openid
+	Amount  int       // 领取金额，单位：分
+	RcvTime time.Time // 领取红包的时间（北京时间）
+}
+
+// RedpackInfo 红包记录
+type RedpackInfo struct {
+	MchBillNO    string             // 商户订单号
+	DetailID     string             // 红包单号
+	Status       string             // 红包状态（SENDING、SENT、FAILED、RECEIVED、RFUND_ING、REFUND）
+	SendType     string             // 发放类型（API、UPLOAD、ACTIVITY）
+	HBType       string             // 红包类型（GROUP、NORMAL）
+	TotalNum     int                // 红包个数
+	TotalAmount  int                // 红包总金额，单位：分
+	Reason       string             // 发送失败原因
+	SendTime     time.Time          // 红包发送时间（北京时间）
+	RefundTime   time.Time          // 红包退款时间（北京时间）
+	RefundAmount int                // 红包退款金额，单位：分
+	Wishing      string             // 祝福语
+	Remark       string             // 活动描述
+	ActName      string             // 活动名称
+	HBList       []*RedpackReceiver // 裂变红包的领取列表
+}
+
+// QueryRedpack 查询红包记录（需加载商户证书），领取列表（hblist）解析为 HBList
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) QueryRedpack(ctx context.Context, mchBillNO string, options ...wx.HTTPOption) (*RedpackInfo, error) {
+	receivers := make([]*RedpackReceiver, 0)
+
+	r, err := mch.Do(ctx, queryRedpack(mchBillNO, &receivers), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	info := &RedpackInfo{
+		MchBillNO: r["mch_billno"],
+		DetailID:  r["detail_id"],
+		Status:    r["status"],
+		SendType:  r["send_type"],
+		HBType:    r["hb_type"],
+		Reason:    r["reason"],
+		Wishing:   r["wishing"],
+		Remark:    r["remark"],
+		ActName:   r["act_name"],
+		HBList:    receivers,
+	}
+
+	fees := []struct {
+		key  string
+		dest *int
+	}{
+		{"total_num", &info.TotalNum},
+		{"total_amount", &info.TotalAmount},
+		{"refund_amount", &info.RefundAmount},
+	}
+
+	for _, f := range fees {
+		if *f.dest, err = atoi(r, f.key); err != nil {
+			return nil, err
+		}
+	}
+
+	times := []struct {
+		key  string
+		dest *time.Time
+	}{
+		{"send_time", &info.SendTime},
+		{"refund_time", &info.RefundTime},
+	}
+
+	for _, t := range times {
+		v := r[t.key]
+
+		if v == "" {
+			continue
+		}
+
+		if *t.dest, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid %s: %w", t.key, err)
+		}
+	}
+
+	return info, nil
+}
+
+// parseRedpackReceivers 解析红包查询应答中嵌套的领取列表：<hblist><hbinfo>...</hbinfo></hblist>
+func parseRedpackReceivers(resp []byte, dest *[]*RedpackReceiver) error {
+	var result struct {
+		HBInfo []struct {
+			OpenID  string `xml:"openid"`
+			Amount  string `xml:"amount"`
+			RcvTime string `xml:"rcv_time"`
+		} `xml:"hblist>hbinfo"`
+	}
+
+	err := xml.Unmarshal(resp, &result)
+
+	if err != nil {
+		return err
+	}
+
+	for _, v := range result.HBInfo {
+		receiver := &RedpackReceiver{OpenID: v.OpenID}
+
+		if receiver.Amount, err = strconv.Atoi(v.Amount); err != nil {
+			return fmt.Errorf("gochat: invalid hblist amount: %w", err)
+		}
+
+		if v.RcvTime != "" {
+			if receiver.RcvTime, err = time.ParseInLocation("2006-01-02 15:04:05", v.RcvTime, beijing); err != nil {
+				return fmt.Errorf("gochat: invalid hblist rcv_time: %w", err)
+			}
+		}
+
+		*dest = append(*dest, receiver)
+	}
+
+	return nil
 }
@@ -2,7 +2,9 @@ package mch
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shenghui0779/gochat/wx"
@@ -76,6 +78,61 @@ func TestSendNormalRedpack(t *testing.T) {
 	}, r)
 }
 
+func TestSendNormalRedpackWithHMACSignType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 红包接口仅支持MD5签名，客户端指定的HMAC-SHA256签名不生效
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/sendredpack", wx.WXML{
+		"wxappid":      "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"mch_billno":   "0010010404201411170000046545",
+		"send_name":    "send_name",
+		"re_openid":    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		"total_amount": "200",
+		"total_num":    "1",
+		"wishing":      "恭喜发财",
+		"client_ip":    "127.0.0.1",
+		"act_name":     "新年红包",
+		"remark":       "新年红包",
+		"scene_id":     "PRODUCT_2",
+		"risk_info":    "posttime%3d123123412%26clientversion%3d234134%26mobile%3d122344545%26deviceid%3dIOS",
+		"nonce_str":    "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":    "MD5",
+		"sign":         "C9BB9D2CBE57D6E3A28BD220AFA2248D",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<mch_billno>0010010404201411170000046545</mch_billno>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSignType(SignHMacSHA256))
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	_, err := mch.Do(context.TODO(), SendNormalRedpack(&RedpackData{
+		MchBillNO:   "0010010404201411170000046545",
+		SendName:    "send_name",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 200,
+		TotalNum:    1,
+		Wishing:     "恭喜发财",
+		ClientIP:    "127.0.0.1",
+		ActName:     "新年红包",
+		Remark:      "新年红包",
+		SceneID:     "PRODUCT_2",
+		RiskInfo:    "posttime%3d123123412%26clientversion%3d234134%26mobile%3d122344545%26deviceid%3dIOS",
+	}))
+
+	assert.Nil(t, err)
+}
+
 func TestSendGroupRedpack(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -142,6 +199,140 @@ func TestSendGroupRedpack(t *testing.T) {
 	}, r)
 }
 
+func TestMchSendRedpack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/sendredpack", wx.WXML{
+		"wxappid":      "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"mch_billno":   "0010010404201411170000046545",
+		"send_name":    "send_name",
+		"re_openid":    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		"total_amount": "200",
+		"total_num":    "1",
+		"wishing":      "恭喜发财",
+		"client_ip":    "127.0.0.1",
+		"act_name":     "新年红包",
+		"remark":       "新年红包",
+		"scene_id":     "PRODUCT_2",
+		"risk_info":    "posttime%3d123123412%26clientversion%3d234134%26mobile%3d122344545%26deviceid%3dIOS",
+		"nonce_str":    "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":    "MD5",
+		"sign":         "C9BB9D2CBE57D6E3A28BD220AFA2248D",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<wxappid>wx2421b1c4370ec43b</wxappid>
+	<mch_id>10000100</mch_id>
+	<mch_billno>0010010404201411170000046545</mch_billno>
+	<re_openid>onqOjjmM1tad-3ROpncN-yUfa6uI</re_openid>
+	<total_amount>200</total_amount>
+	<send_listid>100000000020150520314766074200</send_listid>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}))
+	mch.tlsClient = client
+
+	r, err := mch.SendRedpack(context.TODO(), &RedpackRequest{
+		MchBillNO:   "0010010404201411170000046545",
+		SendName:    "send_name",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 200,
+		TotalNum:    1,
+		Wishing:     "恭喜发财",
+		ClientIP:    "127.0.0.1",
+		ActName:     "新年红包",
+		Remark:      "新年红包",
+		SceneID:     "PRODUCT_2",
+		RiskInfo:    "posttime%3d123123412%26clientversion%3d234134%26mobile%3d122344545%26deviceid%3dIOS",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RedpackResponse{
+		MchBillNO:   "0010010404201411170000046545",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 200,
+		SendListID:  "100000000020150520314766074200",
+	}, r)
+}
+
+func TestMchSendGroupRedpack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/sendgroupredpack", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<wxappid>wx2421b1c4370ec43b</wxappid>
+	<mch_id>10000100</mch_id>
+	<mch_billno>0010010404201411170000046545</mch_billno>
+	<re_openid>onqOjjmM1tad-3ROpncN-yUfa6uI</re_openid>
+	<total_amount>600</total_amount>
+	<send_listid>100000000020150520314766074201</send_listid>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = client
+
+	r, err := mch.SendGroupRedpack(context.TODO(), &RedpackRequest{
+		MchBillNO:   "0010010404201411170000046545",
+		SendName:    "send_name",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 600,
+		TotalNum:    3,
+		Wishing:     "恭喜发财",
+		ActName:     "新年红包",
+		Remark:      "新年红包",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "100000000020150520314766074201", r.SendListID)
+	assert.Equal(t, 600, r.TotalAmount)
+}
+
+func TestMchSendRedpackValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 校验失败时不发送请求
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = wx.NewMockHTTPClient(ctrl)
+
+	req := &RedpackRequest{
+		MchBillNO:   "0010010404201411170000046545",
+		SendName:    "send_name",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 200,
+		TotalNum:    2,
+		Wishing:     "恭喜发财",
+		ClientIP:    "127.0.0.1",
+		ActName:     "新年红包",
+		Remark:      "新年红包",
+	}
+
+	_, err := mch.SendRedpack(context.TODO(), req)
+
+	assert.EqualError(t, err, "gochat: total_num must be 1 for normal redpack")
+
+	_, err = mch.SendGroupRedpack(context.TODO(), req)
+
+	assert.EqualError(t, err, "gochat: total_num must be between 3 and 20 for group redpack")
+
+	req.TotalNum = 1
+	req.TotalAmount = 0
+
+	_, err = mch.SendRedpack(context.TODO(), req)
+
+	assert.EqualError(t, err, "gochat: total_amount must be positive")
+}
+
 func TestSendMinipRedpack(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -261,3 +452,183 @@ func TestQueryRedpackByBillNO(t *testing.T) {
 		"send_time":    "2016-08-08 21:49:22",
 	}, r)
 }
+
+func TestMchSendGroupRedpackConst(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/sendgroupredpack", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		assert.Equal(t, "CONST", body["amt_type"])
+		assert.Equal(t, "PRODUCT_4", body["scene_id"])
+
+		return []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>SUCCESS</result_code>
+	<wxappid>wx2421b1c4370ec43b</wxappid>
+	<mch_id>10000100</mch_id>
+	<mch_billno>0010010404201411170000046546</mch_billno>
+	<re_openid>onqOjjmM1tad-3ROpncN-yUfa6uI</re_openid>
+	<total_amount>30000</total_amount>
+	<send_listid>100000000020150520314766074202</send_listid>
+</xml>`), nil
+	})
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = client
+
+	r, err := mch.SendGroupRedpack(context.TODO(), &RedpackRequest{
+		MchBillNO:   "0010010404201411170000046546",
+		SendName:    "send_name",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 30000,
+		TotalNum:    3,
+		Wishing:     "恭喜发财",
+		ActName:     "新年红包",
+		Remark:      "新年红包",
+		SceneID:     RedpackScene4,
+		AmtType:     RedpackAmtConst,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RedpackResponse{
+		MchBillNO:   "0010010404201411170000046546",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 30000,
+		SendListID:  "100000000020150520314766074202",
+	}, r)
+}
+
+func TestMchSendRedpackSceneRequired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 校验失败时不发送请求
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = wx.NewMockHTTPClient(ctrl)
+
+	req := &RedpackRequest{
+		MchBillNO:   "0010010404201411170000046545",
+		SendName:    "send_name",
+		ReOpenID:    "onqOjjmM1tad-3ROpncN-yUfa6uI",
+		TotalAmount: 20001,
+		TotalNum:    1,
+		Wishing:     "恭喜发财",
+		ClientIP:    "127.0.0.1",
+		ActName:     "新年红包",
+		Remark:      "新年红包",
+	}
+
+	_, err := mch.SendRedpack(context.TODO(), req)
+
+	assert.EqualError(t, err, "gochat: scene_id is required when total_amount is greater than 20000")
+
+	req.TotalNum = 3
+
+	_, err = mch.SendGroupRedpack(context.TODO(), req)
+
+	assert.EqualError(t, err, "gochat: scene_id is required when total_amount is greater than 20000")
+}
+
+func TestMchQueryRedpack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/gethbinfo", wx.WXML{
+		"appid":      "wx2421b1c4370ec43b",
+		"mch_id":     "10000100",
+		"mch_billno": "9010080799701411170000046603",
+		"bill_type":  "MCHT",
+		"nonce_str":  "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":  "MD5",
+		"sign":       "231F70D63D64EB36C1BE83E7E598B280",
+	}).Return([]byte(`<xml>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<return_msg><![CDATA[OK]]></return_msg>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<mch_billno><![CDATA[9010080799701411170000046603]]></mch_billno>
+	<detail_id><![CDATA[10000417012016080830956240040]]></detail_id>
+	<status><![CDATA[RECEIVED]]></status>
+	<send_type><![CDATA[API]]></send_type>
+	<hb_type><![CDATA[GROUP]]></hb_type>
+	<total_num>3</total_num>
+	<total_amount>300</total_amount>
+	<send_time><![CDATA[2016-08-08 21:49:22]]></send_time>
+	<wishing><![CDATA[恭喜发财]]></wishing>
+	<act_name><![CDATA[新年红包]]></act_name>
+	<remark><![CDATA[新年红包]]></remark>
+	<hblist>
+		<hbinfo>
+			<openid><![CDATA[ohO4GtzOAAYMp2yapORH3dQB3W18]]></openid>
+			<amount>120</amount>
+			<rcv_time><![CDATA[2016-08-08 21:49:46]]></rcv_time>
+		</hbinfo>
+		<hbinfo>
+			<openid><![CDATA[ohO4GtzOAAYMp2yapORH3dQB3W19]]></openid>
+			<amount>100</amount>
+			<rcv_time><![CDATA[2016-08-08 21:50:11]]></rcv_time>
+		</hbinfo>
+		<hbinfo>
+			<openid><![CDATA[ohO4GtzOAAYMp2yapORH3dQB3W20]]></openid>
+			<amount>80</amount>
+			<rcv_time><![CDATA[2016-08-08 21:52:03]]></rcv_time>
+		</hbinfo>
+	</hblist>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	info, err := mch.QueryRedpack(context.TODO(), "9010080799701411170000046603")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RedpackInfo{
+		MchBillNO:   "9010080799701411170000046603",
+		DetailID:    "10000417012016080830956240040",
+		Status:      RedpackStatusReceived,
+		SendType:    RedpackSendTypeAPI,
+		HBType:      RedpackTypeGroup,
+		TotalNum:    3,
+		TotalAmount: 300,
+		SendTime:    time.Date(2016, 8, 8, 21, 49, 22, 0, beijing),
+		Wishing:     "恭喜发财",
+		Remark:      "新年红包",
+		ActName:     "新年红包",
+		HBList: []*RedpackReceiver{
+			{OpenID: "ohO4GtzOAAYMp2yapORH3dQB3W18", Amount: 120, RcvTime: time.Date(2016, 8, 8, 21, 49, 46, 0, beijing)},
+			{OpenID: "ohO4GtzOAAYMp2yapORH3dQB3W19", Amount: 100, RcvTime: time.Date(2016, 8, 8, 21, 50, 11, 0, beijing)},
+			{OpenID: "ohO4GtzOAAYMp2yapORH3dQB3W20", Amount: 80, RcvTime: time.Date(2016, 8, 8, 21, 52, 3, 0, beijing)},
+		},
+	}, info)
+}
+
+func TestMchQueryRedpackNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/gethbinfo", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>FAIL</result_code>
+	<err_code>NOT_FOUND</err_code>
+	<err_code_des>指定单号数据不存在</err_code_des>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = client
+
+	info, err := mch.QueryRedpack(context.TODO(), "9010080799701411170000046603")
+
+	assert.Nil(t, info)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -1,7 +1,11 @@
 package mch
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/shenghui0779/gochat/wx"
 )
@@ -19,6 +23,123 @@ type RefundData struct {
 	NotifyURL     string // 异步接收微信支付退款结果通知的回调地址，通知URL必须为外网可访问的url，不允许带参数
 }
 
+// RefundRequest 申请退款请求，TransactionID 和 OutTradeNO 二选一（同时存在时优先使用 TransactionID）
+type RefundRequest struct {
+	TransactionID string // 微信订单号
+	OutTradeNO    string // 商户订单号
+	RefundData
+}
+
+// RefundCoupon 退款代金券
+type RefundCoupon struct {
+	CouponType      string // 代金券类型：CASH--充值代金券，NO_CASH--非充值优惠券
+	CouponRefundID  string // 退款代金券ID
+	CouponRefundFee int    // 单个退款代金券支付金额，单位为分
+}
+
+// RefundResponse 申请退款结果
+type RefundResponse struct {
+	TransactionID       string          // 微信订单号
+	OutTradeNO          string          // 商户订单号
+	OutRefundNO         string          // 商户退款单号
+	RefundID            string          // 微信退款单号
+	RefundFee           int             // 退款总金额，单位为分
+	SettlementRefundFee int             // 应结退款金额，去掉非充值代金券退款金额后的退款金额
+	TotalFee            int             // 订单总金额，单位为分
+	CashFee             int             // 现金支付金额，单位为分
+	CashRefundFee       int             // 现金退款金额，单位为分
+	CouponRefundFee     int             // 代金券退款总金额，单位为分
+	CouponRefundCount   int             // 退款代金券使用数量
+	Coupons             []*RefundCoupon // 退款代金券（由 coupon_type_$n、coupon_refund_id_$n、coupon_refund_fee_$n 组装）
+}
+
+// RefundResult 退款申请结果
+//
+// Deprecated: use RefundResponse instead.
+type RefundResult = RefundResponse
+
+// Refund 申请退款（需加载商户证书）
+// 请求前校验：out_trade_no 和 transaction_id 不能同时为空，refund_fee 须大于0且不能大于 total_fee
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError，
+// 可通过 errors.Is 判断 ErrNotEnough（余额不足）、ErrBizError（业务错误，可用相同参数重试）等
+func (mch *Mch) Refund(ctx context.Context, req *RefundRequest, options ...wx.HTTPOption) (*RefundResponse, error) {
+	if req.TransactionID == "" && req.OutTradeNO == "" {
+		return nil, errors.New("gochat: transaction_id or out_trade_no is required")
+	}
+
+	if req.RefundFee <= 0 {
+		return nil, errors.New("gochat: refund_fee must be positive")
+	}
+
+	if req.RefundFee > req.TotalFee {
+		return nil, errors.New("gochat: refund_fee must not be greater than total_fee")
+	}
+
+	action := RefundByOutTradeNO(req.OutTradeNO, &req.RefundData)
+
+	if req.TransactionID != "" {
+		action = RefundByTransactionID(req.TransactionID, &req.RefundData)
+	}
+
+	r, err := mch.Do(ctx, action, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	return parseRefundResponse(r)
+}
+
+func parseRefundResponse(r wx.WXML) (*RefundResponse, error) {
+	result := &RefundResponse{
+		TransactionID: r["transaction_id"],
+		OutTradeNO:    r["out_trade_no"],
+		OutRefundNO:   r["out_refund_no"],
+		RefundID:      r["refund_id"],
+	}
+
+	var err error
+
+	fees := []struct {
+		key  string
+		dest *int
+	}{
+		{"refund_fee", &result.RefundFee},
+		{"settlement_refund_fee", &result.SettlementRefundFee},
+		{"total_fee", &result.TotalFee},
+		{"cash_fee", &result.CashFee},
+		{"cash_refund_fee", &result.CashRefundFee},
+		{"coupon_refund_fee", &result.CouponRefundFee},
+		{"coupon_refund_count", &result.CouponRefundCount},
+	}
+
+	for _, v := range fees {
+		if *v.dest, err = atoi(r, v.key); err != nil {
+			return nil, err
+		}
+	}
+
+	// 代金券字段以 _$n 为下标（从0开始）平铺在应答中
+	for i := 0; i < result.CouponRefundCount; i++ {
+		coupon := &RefundCoupon{
+			CouponType:     r[fmt.Sprintf("coupon_type_%d", i)],
+			CouponRefundID: r[fmt.Sprintf("coupon_refund_id_%d", i)],
+		}
+
+		if coupon.CouponRefundFee, err = atoi(r, fmt.Sprintf("coupon_refund_fee_%d", i)); err != nil {
+			return nil, err
+		}
+
+		result.Coupons = append(result.Coupons, coupon)
+	}
+
+	return result, nil
+}
+
 // RefundByTransactionID 根据微信订单号退款
 func RefundByTransactionID(transactionID string, data *RefundData) wx.Action {
 	return wx.NewAction(RefundApplyURL,
@@ -95,6 +216,158 @@ func RefundByOutTradeNO(outTradeNO string, data *RefundData) wx.Action {
 	)
 }
 
+// RefundQueryKey 退款查询条件，四选一，同时存在时优先级为：RefundID > OutRefundNO > TransactionID > OutTradeNO
+type RefundQueryKey struct {
+	RefundID      string // 微信退款单号
+	OutRefundNO   string // 商户退款单号
+	TransactionID string // 微信订单号
+	OutTradeNO    string // 商户订单号
+	Offset        int    // 分页偏移量（订单的退款超过10笔时，可根据 total_refund_count 分页查询）
+}
+
+// Refund 单笔退款信息
+type Refund struct {
+	OutRefundNO         string          // 商户退款单号
+	RefundID            string          // 微信退款单号
+	RefundChannel       string          // 退款渠道（ORIGINAL、BALANCE 等）
+	RefundFee           int             // 申请退款金额，单位为分
+	SettlementRefundFee int             // 退款金额，去掉非充值代金券退款金额后的退款金额
+	CouponRefundFee     int             // 代金券退款总金额，单位为分
+	CouponRefundCount   int             // 退款代金券使用数量
+	Coupons             []*RefundCoupon // 退款代金券（由 coupon_type_$n_$m、coupon_refund_id_$n_$m、coupon_refund_fee_$n_$m 组装）
+	RefundStatus        string          // 退款状态（SUCCESS、REFUNDCLOSE、PROCESSING、CHANGE）
+	RefundAccount       string          // 退款资金来源
+	RefundRecvAccount   string          // 退款入账账户（如：招商银行信用卡0403、支付用户零钱）
+	RefundSuccessTime   time.Time       // 退款成功时间（北京时间），未成功时为零值
+}
+
+// RefundQueryResult 退款查询结果
+type RefundQueryResult struct {
+	TransactionID      string    // 微信订单号
+	OutTradeNO         string    // 商户订单号
+	TotalFee           int       // 订单总金额，单位为分
+	SettlementTotalFee int       // 应结订单金额，单位为分
+	CashFee            int       // 现金支付金额，单位为分
+	TotalRefundCount   int       // 订单总共已发生的部分退款次数，大于10次时需通过 offset 分页查询
+	RefundCount        int       // 当前返回的退款笔数
+	Refunds            []*Refund // 退款记录（由 out_refund_no_$n、refund_fee_$n、refund_status_$n 等组装）
+}
+
+// QueryRefund 查询退款，by.Offset 为分页偏移量（订单的退款超过10笔时，可根据 total_refund_count 分页查询）
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError（如：REFUNDNOTEXIST）
+func (mch *Mch) QueryRefund(ctx context.Context, by RefundQueryKey, options ...wx.HTTPOption) (*RefundQueryResult, error) {
+	var (
+		action wx.Action
+		offset []int
+	)
+
+	if by.Offset > 0 {
+		offset = append(offset, by.Offset)
+	}
+
+	switch {
+	case by.RefundID != "":
+		action = QueryRefundByRefundID(by.RefundID, offset...)
+	case by.OutRefundNO != "":
+		action = QueryRefundByOutRefundNO(by.OutRefundNO, offset...)
+	case by.TransactionID != "":
+		action = QueryRefundByTransactionID(by.TransactionID, offset...)
+	case by.OutTradeNO != "":
+		action = QueryRefundByOutTradeNO(by.OutTradeNO, offset...)
+	default:
+		return nil, errors.New("gochat: one of refund_id, out_refund_no, transaction_id and out_trade_no is required")
+	}
+
+	r, err := mch.Do(ctx, action, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	return parseRefundQueryResult(r)
+}
+
+func parseRefundQueryResult(r wx.WXML) (*RefundQueryResult, error) {
+	result := &RefundQueryResult{
+		TransactionID: r["transaction_id"],
+		OutTradeNO:    r["out_trade_no"],
+	}
+
+	var err error
+
+	fees := []struct {
+		key  string
+		dest *int
+	}{
+		{"total_fee", &result.TotalFee},
+		{"settlement_total_fee", &result.SettlementTotalFee},
+		{"cash_fee", &result.CashFee},
+		{"total_refund_count", &result.TotalRefundCount},
+		{"refund_count", &result.RefundCount},
+	}
+
+	for _, v := range fees {
+		if *v.dest, err = atoi(r, v.key); err != nil {
+			return nil, err
+		}
+	}
+
+	// 退款字段以 _$n 为下标（从0开始），退款代金券字段以 _$n_$m 为下标平铺在应答中
+	for n := 0; n < result.RefundCount; n++ {
+		refund := &Refund{
+			OutRefundNO:       r[fmt.Sprintf("out_refund_no_%d", n)],
+			RefundID:          r[fmt.Sprintf("refund_id_%d", n)],
+			RefundChannel:     r[fmt.Sprintf("refund_channel_%d", n)],
+			RefundStatus:      r[fmt.Sprintf("refund_status_%d", n)],
+			RefundAccount:     r[fmt.Sprintf("refund_account_%d", n)],
+			RefundRecvAccount: r[fmt.Sprintf("refund_recv_accout_%d", n)], // 微信文档中的字段名即为 accout
+		}
+
+		fees := []struct {
+			key  string
+			dest *int
+		}{
+			{fmt.Sprintf("refund_fee_%d", n), &refund.RefundFee},
+			{fmt.Sprintf("settlement_refund_fee_%d", n), &refund.SettlementRefundFee},
+			{fmt.Sprintf("coupon_refund_fee_%d", n), &refund.CouponRefundFee},
+			{fmt.Sprintf("coupon_refund_count_%d", n), &refund.CouponRefundCount},
+		}
+
+		for _, v := range fees {
+			if *v.dest, err = atoi(r, v.key); err != nil {
+				return nil, err
+			}
+		}
+
+		for m := 0; m < refund.CouponRefundCount; m++ {
+			coupon := &RefundCoupon{
+				CouponType:     r[fmt.Sprintf("coupon_type_%d_%d", n, m)],
+				CouponRefundID: r[fmt.Sprintf("coupon_refund_id_%d_%d", n, m)],
+			}
+
+			if coupon.CouponRefundFee, err = atoi(r, fmt.Sprintf("coupon_refund_fee_%d_%d", n, m)); err != nil {
+				return nil, err
+			}
+
+			refund.Coupons = append(refund.Coupons, coupon)
+		}
+
+		if v := r[fmt.Sprintf("refund_success_time_%d", n)]; v != "" {
+			if refund.RefundSuccessTime, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+				return nil, fmt.Errorf("gochat: invalid refund_success_time_%d: %w", n, err)
+			}
+		}
+
+		result.Refunds = append(result.Refunds, refund)
+	}
+
+	return result, nil
+}
+
 // QueryRefundByRefundID 根据微信退款单号查询退款信息
 func QueryRefundByRefundID(refundID string, offset ...int) wx.Action {
 	return wx.NewAction(RefundQueryURL,
@@ -2,7 +2,22 @@ package mch
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shenghui0779/gochat/wx"
@@ -368,3 +383,734 @@ func TestQueryRefundByOutTradeNO(t *testing.T) {
 		"transaction_id":  "1008450740201411110005820873",
 	}, r)
 }
+
+func TestMchRefund(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// transaction_id 优先于 out_trade_no
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/refund", wx.WXML{
+		"appid":          "wx2421b1c4370ec43b",
+		"mch_id":         "10000100",
+		"out_refund_no":  "1415701182",
+		"total_fee":      "100",
+		"refund_fee":     "100",
+		"refund_desc":    "商品已售完",
+		"transaction_id": "4008450740201411110005820873",
+		"nonce_str":      "6cefdb308e1e2e8aabd48cf79e546a02",
+		"sign_type":      "MD5",
+		"sign":           "BA8E3DC6991C21B65F81EB1B72C08B38",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>NfsMFbUFpdbEhPXP</nonce_str>
+	<sign>E1CDBB3D61EA0EB05E817232D78BE5CA</sign>
+	<result_code>SUCCESS</result_code>
+	<transaction_id>4008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<out_refund_no>1415701182</out_refund_no>
+	<refund_id>2008450740201411110000174436</refund_id>
+	<refund_fee>100</refund_fee>
+	<settlement_refund_fee>95</settlement_refund_fee>
+	<total_fee>100</total_fee>
+	<cash_fee>80</cash_fee>
+	<cash_refund_fee>80</cash_refund_fee>
+	<coupon_refund_fee>20</coupon_refund_fee>
+	<coupon_refund_count>2</coupon_refund_count>
+	<coupon_type_0>CASH</coupon_type_0>
+	<coupon_refund_id_0>10000</coupon_refund_id_0>
+	<coupon_refund_fee_0>15</coupon_refund_fee_0>
+	<coupon_type_1>NO_CASH</coupon_type_1>
+	<coupon_refund_id_1>10001</coupon_refund_id_1>
+	<coupon_refund_fee_1>5</coupon_refund_fee_1>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "6cefdb308e1e2e8aabd48cf79e546a02"
+	}))
+	mch.tlsClient = client
+
+	r, err := mch.Refund(context.TODO(), &RefundRequest{
+		TransactionID: "4008450740201411110005820873",
+		OutTradeNO:    "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    100,
+			RefundFee:   100,
+			RefundDesc:  "商品已售完",
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RefundResponse{
+		TransactionID:       "4008450740201411110005820873",
+		OutTradeNO:          "1415757673",
+		OutRefundNO:         "1415701182",
+		RefundID:            "2008450740201411110000174436",
+		RefundFee:           100,
+		SettlementRefundFee: 95,
+		TotalFee:            100,
+		CashFee:             80,
+		CashRefundFee:       80,
+		CouponRefundFee:     20,
+		CouponRefundCount:   2,
+		Coupons: []*RefundCoupon{
+			{CouponType: "CASH", CouponRefundID: "10000", CouponRefundFee: 15},
+			{CouponType: "NO_CASH", CouponRefundID: "10001", CouponRefundFee: 5},
+		},
+	}, r)
+}
+
+func TestMchRefundValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 校验失败时不发送请求
+	client := wx.NewMockHTTPClient(ctrl)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.tlsClient = client
+
+	_, err := mch.Refund(context.TODO(), &RefundRequest{
+		RefundData: RefundData{OutRefundNO: "1415701182", TotalFee: 1, RefundFee: 1},
+	})
+
+	assert.EqualError(t, err, "gochat: transaction_id or out_trade_no is required")
+
+	_, err = mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{OutRefundNO: "1415701182", TotalFee: 1},
+	})
+
+	assert.EqualError(t, err, "gochat: refund_fee must be positive")
+
+	_, err = mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{OutRefundNO: "1415701182", TotalFee: 1, RefundFee: 2},
+	})
+
+	assert.EqualError(t, err, "gochat: refund_fee must not be greater than total_fee")
+}
+
+func TestMchRefundResultFail(t *testing.T) {
+	cases := []struct {
+		errCode    string
+		errCodeDes string
+		sign       string
+		target     error
+	}{
+		{"NOTENOUGH", "基本账户余额不足，请充值后重新发起", "5742B5B547E7ADC77171A7332B32B208", ErrNotEnough},
+		{"ERROR", "申请退款业务发生错误", "6D11CBA9D06098DF437C09463F3360DF", ErrBizError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.errCode, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			client := wx.NewMockHTTPClient(ctrl)
+
+			client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/secapi/pay/refund", gomock.Any()).Return([]byte(fmt.Sprintf(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>NfsMFbUFpdbEhPXP</nonce_str>
+	<sign>%s</sign>
+	<result_code>FAIL</result_code>
+	<err_code>%s</err_code>
+	<err_code_des>%s</err_code_des>
+</xml>`, c.sign, c.errCode, c.errCodeDes)), nil)
+
+			mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+			mch.tlsClient = client
+
+			r, err := mch.Refund(context.TODO(), &RefundRequest{
+				OutTradeNO: "1415757673",
+				RefundData: RefundData{OutRefundNO: "1415701182", TotalFee: 1, RefundFee: 1},
+			})
+
+			assert.Nil(t, r)
+			assert.True(t, errors.Is(err, c.target))
+			assert.Equal(t, &ResultError{ErrCode: c.errCode, ErrCodeDes: c.errCodeDes}, err)
+		})
+	}
+}
+
+func TestMchQueryRefund(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/refundquery", wx.WXML{
+		"appid":        "wx2421b1c4370ec43b",
+		"mch_id":       "10000100",
+		"out_trade_no": "1415757673",
+		"offset":       "10",
+		"nonce_str":    "0b9f35f484df17a732e537c37708d1d0",
+		"sign_type":    "MD5",
+		"sign":         "842EFC3B166D99ECB6E92C2FAAFEC815",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>TeqClE3i0mvn3DrK</nonce_str>
+	<sign>B4E30D7B204010C8BF33D5C8C3A59C2C</sign>
+	<result_code>SUCCESS</result_code>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<total_fee>1000</total_fee>
+	<cash_fee>900</cash_fee>
+	<total_refund_count>13</total_refund_count>
+	<refund_count>3</refund_count>
+	<out_refund_no_0>1415701182</out_refund_no_0>
+	<refund_id_0>2008450740201411110000174436</refund_id_0>
+	<refund_channel_0>ORIGINAL</refund_channel_0>
+	<refund_fee_0>100</refund_fee_0>
+	<settlement_refund_fee_0>100</settlement_refund_fee_0>
+	<coupon_refund_fee_0>10</coupon_refund_fee_0>
+	<coupon_refund_count_0>1</coupon_refund_count_0>
+	<coupon_type_0_0>CASH</coupon_type_0_0>
+	<coupon_refund_id_0_0>10000</coupon_refund_id_0_0>
+	<coupon_refund_fee_0_0>10</coupon_refund_fee_0_0>
+	<refund_status_0>SUCCESS</refund_status_0>
+	<refund_account_0>REFUND_SOURCE_UNSETTLED_FUNDS</refund_account_0>
+	<refund_recv_accout_0>支付用户的零钱</refund_recv_accout_0>
+	<refund_success_time_0>2016-07-25 15:26:26</refund_success_time_0>
+	<out_refund_no_1>1415701183</out_refund_no_1>
+	<refund_id_1>2008450740201411110000174437</refund_id_1>
+	<refund_fee_1>200</refund_fee_1>
+	<settlement_refund_fee_1>200</settlement_refund_fee_1>
+	<refund_status_1>PROCESSING</refund_status_1>
+	<refund_recv_accout_1>招商银行信用卡0403</refund_recv_accout_1>
+	<out_refund_no_2>1415701184</out_refund_no_2>
+	<refund_id_2>2008450740201411110000174438</refund_id_2>
+	<refund_fee_2>300</refund_fee_2>
+	<settlement_refund_fee_2>300</settlement_refund_fee_2>
+	<refund_status_2>CHANGE</refund_status_2>
+	<refund_recv_accout_2>招商银行信用卡0403</refund_recv_accout_2>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "0b9f35f484df17a732e537c37708d1d0"
+	}))
+	mch.client = client
+
+	r, err := mch.QueryRefund(context.TODO(), RefundQueryKey{OutTradeNO: "1415757673", Offset: 10})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &RefundQueryResult{
+		TransactionID:    "1008450740201411110005820873",
+		OutTradeNO:       "1415757673",
+		TotalFee:         1000,
+		CashFee:          900,
+		TotalRefundCount: 13,
+		RefundCount:      3,
+		Refunds: []*Refund{
+			{
+				OutRefundNO:         "1415701182",
+				RefundID:            "2008450740201411110000174436",
+				RefundChannel:       RefundChannelOriginal,
+				RefundFee:           100,
+				SettlementRefundFee: 100,
+				CouponRefundFee:     10,
+				CouponRefundCount:   1,
+				Coupons: []*RefundCoupon{
+					{CouponType: CouponTypeCash, CouponRefundID: "10000", CouponRefundFee: 10},
+				},
+				RefundStatus:      RefundStatusSuccess,
+				RefundAccount:     "REFUND_SOURCE_UNSETTLED_FUNDS",
+				RefundRecvAccount: "支付用户的零钱",
+				RefundSuccessTime: time.Date(2016, 7, 25, 15, 26, 26, 0, beijing),
+			},
+			{
+				OutRefundNO:         "1415701183",
+				RefundID:            "2008450740201411110000174437",
+				RefundFee:           200,
+				SettlementRefundFee: 200,
+				RefundStatus:        RefundStatusProcessing,
+				RefundRecvAccount:   "招商银行信用卡0403",
+			},
+			{
+				OutRefundNO:         "1415701184",
+				RefundID:            "2008450740201411110000174438",
+				RefundFee:           300,
+				SettlementRefundFee: 300,
+				RefundStatus:        RefundStatusChange,
+				RefundRecvAccount:   "招商银行信用卡0403",
+			},
+		},
+	}, r)
+}
+
+func TestMchQueryRefundWithoutKey(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	r, err := mch.QueryRefund(context.TODO(), RefundQueryKey{})
+
+	assert.Nil(t, r)
+	assert.NotNil(t, err)
+}
+
+// newCertTestServer 返回请求客户端证书的测试服务，以及将 api.mch.weixin.qq.com 的请求转发到测试服务的 http.Client
+func newCertTestServer(t *testing.T, peerCerts *[][]byte) (*httptest.Server, *http.Client) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw []byte
+
+		if len(r.TLS.PeerCertificates) != 0 {
+			raw = r.TLS.PeerCertificates[0].Raw
+		}
+
+		*peerCerts = append(*peerCerts, raw)
+
+		if r.URL.Path != "/secapi/pay/refund" {
+			w.Write([]byte(`<xml>
+	<return_code>FAIL</return_code>
+	<return_msg>NOT_FOUND</return_msg>
+</xml>`))
+
+			return
+		}
+
+		w.Write([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>NfsMFbUFpdbEhPXP</nonce_str>
+	<sign>DF0FE19C59F29CA163DDEC52CD1346A9</sign>
+	<result_code>SUCCESS</result_code>
+	<transaction_id>4008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<out_refund_no>1415701182</out_refund_no>
+	<refund_id>2008450740201411110000174436</refund_id>
+	<refund_fee>1</refund_fee>
+</xml>`))
+	}))
+
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+
+	dialer := new(net.Dialer)
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, ts.Listener.Addr().String())
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	return ts, client
+}
+
+// newTestCertPEM 生成内存中的自签名证书
+func newTestCertPEM(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	assert.Nil(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "10000100"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+
+	assert.Nil(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestRefundWithCert(t *testing.T) {
+	req := &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	}
+
+	assert.True(t, RefundByOutTradeNO(req.OutTradeNO, &req.RefundData).TLS())
+
+	peerCerts := make([][]byte, 0)
+
+	ts, client := newCertTestServer(t, &peerCerts)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client))
+
+	assert.Nil(t, mch.LoadCertFromPemBlock(certPemBlock, keyPemBlock))
+
+	r, err := mch.Refund(context.TODO(), req)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(peerCerts))
+	assert.NotEmpty(t, peerCerts[0])
+	assert.Equal(t, &RefundResponse{
+		TransactionID: "4008450740201411110005820873",
+		OutTradeNO:    "1415757673",
+		OutRefundNO:   "1415701182",
+		RefundID:      "2008450740201411110000174436",
+		RefundFee:     1,
+	}, r)
+}
+
+func TestWithCertPEMBlock(t *testing.T) {
+	certPEM, keyPEM := newTestCertPEM(t)
+
+	peerCerts := make([][]byte, 0)
+
+	ts, client := newCertTestServer(t, &peerCerts)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithCertPEMBlock(certPEM, keyPEM))
+
+	// 非证书接口不携带证书
+	_, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "NOT_FOUND"}, err)
+
+	_, err = mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	})
+
+	assert.Nil(t, err)
+
+	block, _ := pem.Decode(certPEM)
+
+	assert.Equal(t, [][]byte{nil, block.Bytes}, peerCerts)
+}
+
+func TestWithCertificate(t *testing.T) {
+	certPEM, keyPEM := newTestCertPEM(t)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+
+	assert.Nil(t, err)
+
+	peerCerts := make([][]byte, 0)
+
+	ts, client := newCertTestServer(t, &peerCerts)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithCertificate(cert))
+
+	_, err = mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{cert.Certificate[0]}, peerCerts)
+}
+
+func TestWithCertPEMBlockInvalid(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithCertPEMBlock([]byte("CERT"), []byte("KEY")))
+
+	_, err := mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "gochat: invalid merchant certificate")
+}
+
+func TestWithHTTPClientCustomTransport(t *testing.T) {
+	certPEM, keyPEM := newTestCertPEM(t)
+
+	requests := 0
+
+	client := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			requests++
+
+			return nil, errors.New("unexpected request")
+		}),
+	}
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithCertPEMBlock(certPEM, keyPEM))
+
+	// 证书无法配置到自定义的 RoundTripper，需要证书的请求返回错误（不发送不带证书的请求）
+	_, err := mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	})
+
+	assert.EqualError(t, err, "gochat: tls config (eg: the merchant certificate) cannot be applied to the transport mch.roundTripFunc, which must be an *http.Transport")
+	assert.Equal(t, 0, requests)
+}
+
+func TestWithTransport(t *testing.T) {
+	certPEM, keyPEM := newTestCertPEM(t)
+
+	peerCerts := make([][]byte, 0)
+
+	ts, client := newCertTestServer(t, &peerCerts)
+
+	defer ts.Close()
+
+	handshakes := 0
+
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		handshakes++
+
+		return nil
+	}
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithTransport(transport), WithCertPEMBlock(certPEM, keyPEM))
+
+	_, err := mch.UnifiedOrder(context.TODO(), &OrderData{
+		OutTradeNO: "1415659990",
+		TotalFee:   1,
+		TradeType:  TradeJSAPI,
+		OpenID:     "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "NOT_FOUND"}, err)
+
+	_, err = mch.Refund(context.TODO(), &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	})
+
+	assert.Nil(t, err)
+
+	// 两个请求均使用了注入的 transport（证书合并到其 TLSClientConfig）
+	assert.Equal(t, 2, handshakes)
+
+	block, _ := pem.Decode(certPEM)
+
+	assert.Equal(t, [][]byte{nil, block.Bytes}, peerCerts)
+}
+
+func TestReloadCertificate(t *testing.T) {
+	certPEM1, keyPEM1 := newTestCertPEM(t)
+	certPEM2, keyPEM2 := newTestCertPEM(t)
+
+	cert2, err := tls.X509KeyPair(certPEM2, keyPEM2)
+
+	assert.Nil(t, err)
+
+	peerCerts := make([][]byte, 0)
+
+	ts, client := newCertTestServer(t, &peerCerts)
+
+	defer ts.Close()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithCertPEMBlock(certPEM1, keyPEM1))
+
+	req := &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	}
+
+	_, err = mch.Refund(context.TODO(), req)
+
+	assert.Nil(t, err)
+
+	// 证书轮换
+	assert.Nil(t, mch.ReloadCertificate(cert2))
+
+	_, err = mch.Refund(context.TODO(), req)
+
+	assert.Nil(t, err)
+
+	block1, _ := pem.Decode(certPEM1)
+	block2, _ := pem.Decode(certPEM2)
+
+	assert.Equal(t, [][]byte{block1.Bytes, block2.Bytes}, peerCerts)
+
+	// 无效证书
+	assert.NotNil(t, mch.ReloadCertificate(tls.Certificate{}))
+}
+
+func TestReloadCertificateConcurrently(t *testing.T) {
+	certPEM1, keyPEM1 := newTestCertPEM(t)
+	certPEM2, keyPEM2 := newTestCertPEM(t)
+
+	cert1, err := tls.X509KeyPair(certPEM1, keyPEM1)
+
+	assert.Nil(t, err)
+
+	cert2, err := tls.X509KeyPair(certPEM2, keyPEM2)
+
+	assert.Nil(t, err)
+
+	var mutex sync.Mutex
+
+	peerCerts := make(map[string]int)
+
+	// 第一个请求在证书轮换后才返回
+	inflight := make(chan struct{})
+	release := make(chan struct{})
+
+	first := true
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+
+		peerCerts[string(r.TLS.PeerCertificates[0].Raw)]++
+
+		block := first
+		first = false
+
+		mutex.Unlock()
+
+		if block {
+			close(inflight)
+			<-release
+		}
+
+		w.Write([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>NfsMFbUFpdbEhPXP</nonce_str>
+	<sign>DF0FE19C59F29CA163DDEC52CD1346A9</sign>
+	<result_code>SUCCESS</result_code>
+	<transaction_id>4008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<out_refund_no>1415701182</out_refund_no>
+	<refund_id>2008450740201411110000174436</refund_id>
+	<refund_fee>1</refund_fee>
+</xml>`))
+	}))
+
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+
+	defer ts.Close()
+
+	dialer := new(net.Dialer)
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, ts.Listener.Addr().String())
+			},
+		},
+	}
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithHTTPClient(client), WithCertificate(cert1))
+
+	req := &RefundRequest{
+		OutTradeNO: "1415757673",
+		RefundData: RefundData{
+			OutRefundNO: "1415701182",
+			TotalFee:    1,
+			RefundFee:   1,
+		},
+	}
+
+	done := make(chan error)
+
+	go func() {
+		_, err := mch.Refund(context.TODO(), req)
+
+		done <- err
+	}()
+
+	<-inflight
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+
+		go func(n int) {
+			defer wg.Done()
+
+			if n%2 == 0 {
+				assert.Nil(t, mch.ReloadCertificate(cert2))
+			} else {
+				assert.Nil(t, mch.ReloadCertificate(cert1))
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := mch.Refund(context.TODO(), req)
+
+			assert.Nil(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Nil(t, mch.ReloadCertificate(cert2))
+
+	_, err = mch.Refund(context.TODO(), req)
+
+	assert.Nil(t, err)
+
+	// 进行中的请求不受影响
+	close(release)
+
+	assert.Nil(t, <-done)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	total := 0
+
+	for raw, n := range peerCerts {
+		assert.True(t, raw == string(cert1.Certificate[0]) || raw == string(cert2.Certificate[0]))
+
+		total += n
+	}
+
+	assert.Equal(t, 12, total)
+	assert.True(t, peerCerts[string(cert2.Certificate[0])] >= 1)
+}
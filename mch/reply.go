@@ -34,3 +34,10 @@ func ReplyFail(msg string) *Reply {
 		ReturnMsg:  CDATA(msg),
 	}
 }
+
+// Bytes returns the xml body of reply, eg: ReplyOK().Bytes() 用于支付结果通知的应答
+func (r *Reply) Bytes() []byte {
+	b, _ := xml.Marshal(r) // 仅包含字符串字段，编码不会出错
+
+	return b
+}
@@ -0,0 +1,270 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// ReportData 交易保障数据
+type ReportData struct {
+	// 必填参数
+	InterfaceURL string // 上报对应的接口的完整URL，如：https://api.mch.weixin.qq.com/pay/unifiedorder
+	ExecuteTime  int    // 接口耗时情况，单位为毫秒
+	ReturnCode   string // 通信标识（SUCCESS/FAIL），非交易标识
+	ResultCode   string // 业务结果（SUCCESS/FAIL）
+	UserIP       string // 发起接口调用时的机器IP
+	Time         string // 商户调用该接口时商户自己的系统时间，格式为yyyyMMddHHmmss
+	// 选填参数
+	DeviceInfo string // 微信支付分配的终端设备号，商户自定义
+	ReturnMsg  string // 返回信息，如非空，为错误原因
+	ErrCode    string // 错误代码
+	ErrCodeDes string // 错误代码描述
+	OutTradeNO string // 商户系统内部的订单号（刷卡支付等接口建议上报）
+}
+
+// Report 交易保障（商户在调用微信支付提供的相关接口时，上报接口耗时及返回结果，以便微信支付进行服务质量保障）
+// 注意：接口耗时的字段名为 execute_time_（以下划线结尾）
+func Report(data *ReportData) wx.Action {
+	return wx.NewAction(ReportURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithWXML(func(appid, mchid, nonce string) (wx.WXML, error) {
+			body := wx.WXML{
+				"appid":         appid,
+				"mch_id":        mchid,
+				"nonce_str":     nonce,
+				"interface_url": data.InterfaceURL,
+				"execute_time_": strconv.Itoa(data.ExecuteTime),
+				"return_code":   data.ReturnCode,
+				"result_code":   data.ResultCode,
+				"user_ip":       data.UserIP,
+				"time":          data.Time,
+			}
+
+			if data.DeviceInfo != "" {
+				body["device_info"] = data.DeviceInfo
+			}
+
+			if data.ReturnMsg != "" {
+				body["return_msg"] = data.ReturnMsg
+			}
+
+			if data.ErrCode != "" {
+				body["err_code"] = data.ErrCode
+			}
+
+			if data.ErrCodeDes != "" {
+				body["err_code_des"] = data.ErrCodeDes
+			}
+
+			if data.OutTradeNO != "" {
+				body["out_trade_no"] = data.OutTradeNO
+			}
+
+			return body, nil
+		}),
+	)
+}
+
+// ReportRequest 交易保障请求
+type ReportRequest = ReportData
+
+// Report 交易保障，上报接口耗时及返回结果（应答不含签名）
+// 请求前校验：interface_url、user_ip、time 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) Report(ctx context.Context, req *ReportRequest, options ...wx.HTTPOption) error {
+	if req.InterfaceURL == "" {
+		return errors.New("gochat: interface_url is required")
+	}
+
+	if req.UserIP == "" {
+		return errors.New("gochat: user_ip is required")
+	}
+
+	if req.Time == "" {
+		return errors.New("gochat: time is required")
+	}
+
+	r, err := mch.Do(ctx, Report(req), options...)
+
+	if err != nil {
+		return err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return newResultError(r)
+	}
+
+	return nil
+}
+
+// DefaultReportQueueSize 自动上报（WithAutoReport）队列的默认容量
+const DefaultReportQueueSize = 1000
+
+// 自动上报的超时时间
+var (
+	reportTimeout      = 5 * time.Second  // 单次上报的超时时间
+	reportDrainTimeout = 30 * time.Second // Close 等待队列中剩余数据上报完成的最长时间
+)
+
+// reporter 自动上报：接口调用结束后异步上报，队列已满时丢弃
+type reporter struct {
+	userIP string
+	queue  chan *ReportData
+	done   chan struct{}
+	ctx    context.Context // 放弃上报剩余数据时取消，中止正在进行的上报
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func newReporter(userIP string, queueSize int) *reporter {
+	if queueSize <= 0 {
+		queueSize = DefaultReportQueueSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &reporter{
+		userIP: userIP,
+		queue:  make(chan *ReportData, queueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start 启动上报协程，上报失败时忽略（尽力而为）
+func (r *reporter) start(mch *Mch) {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		for {
+			select {
+			case data := <-r.queue:
+				r.send(mch, data)
+			case <-r.done:
+				// 停止前上报队列中剩余的数据，放弃时丢弃
+				for r.ctx.Err() == nil {
+					select {
+					case data := <-r.queue:
+						r.send(mch, data)
+					default:
+						return
+					}
+				}
+
+				return
+			}
+		}
+	}()
+}
+
+// send 上报一次，超时时间为 reportTimeout；已放弃上报时丢弃
+func (r *reporter) send(mch *Mch, data *ReportData) {
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, reportTimeout)
+
+	defer cancel()
+
+	mch.Report(ctx, data)
+}
+
+// add 加入上报队列，不阻塞调用方：已停止或队列已满时丢弃
+func (r *reporter) add(data *ReportData) {
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	select {
+	case r.queue <- data:
+	default:
+	}
+}
+
+// stop 停止上报，等待队列中剩余的数据上报完成；ctx 结束时放弃上报剩余的数据（中止正在进行的上报）并返回 ctx 的错误
+func (r *reporter) stop(ctx context.Context) error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+
+	finished := make(chan struct{})
+
+	go func() {
+		r.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+
+		return ctx.Err()
+	}
+}
+
+// reportData 根据接口调用结果生成上报数据
+func (mch *Mch) reportData(reqURL string, start time.Time, result wx.WXML, err error) *ReportData {
+	data := &ReportData{
+		InterfaceURL: reqURL,
+		ExecuteTime:  int(time.Since(start) / time.Millisecond),
+		UserIP:       mch.reporter.userIP,
+		Time:         time.Unix(mch.timestamp(), 0).In(beijing).Format("20060102150405"),
+	}
+
+	if err != nil {
+		data.ReturnCode = ResultFail
+		data.ResultCode = ResultFail
+		data.ReturnMsg = err.Error()
+
+		return data
+	}
+
+	data.ReturnCode = ResultSuccess
+	data.ResultCode = result["result_code"]
+	data.DeviceInfo = result["device_info"]
+	data.ErrCode = result["err_code"]
+	data.ErrCodeDes = result["err_code_des"]
+	data.OutTradeNO = result["out_trade_no"]
+
+	// 部分接口的应答不含 result_code
+	if data.ResultCode == "" {
+		data.ResultCode = ResultSuccess
+	}
+
+	return data
+}
+
+// Close stops the automatic report (WithAutoReport) and waits for the queued reports to be sent, it is safe to be called multiple times.
+// It gives up the remaining reports after 30 seconds (see Shutdown), and is a no-op when the automatic report is not enabled.
+func (mch *Mch) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), reportDrainTimeout)
+
+	defer cancel()
+
+	return mch.Shutdown(ctx)
+}
+
+// Shutdown stops the automatic report (WithAutoReport) and waits for the queued reports to be sent until ctx is done,
+// then the remaining reports are dropped (the in-flight one is canceled) and the error of ctx is returned.
+// Each report is sent with a timeout of 5 seconds. It is a no-op when the automatic report is not enabled.
+func (mch *Mch) Shutdown(ctx context.Context) error {
+	if mch.reporter == nil {
+		return nil
+	}
+
+	return mch.reporter.stop(ctx)
+}
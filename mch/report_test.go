@@ -0,0 +1,213 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 应答不含签名
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/payitil/report", wx.WXML{
+		"appid":         "wx2421b1c4370ec43b",
+		"mch_id":        "10000100",
+		"nonce_str":     "50780e0cca98c8c8e814883e5caa672e",
+		"interface_url": "https://api.mch.weixin.qq.com/pay/micropay",
+		"execute_time_": "1000",
+		"return_code":   "SUCCESS",
+		"result_code":   "SUCCESS",
+		"user_ip":       "8.8.8.8",
+		"time":          "20141111170043",
+		"out_trade_no":  "1415757673",
+		"sign":          "E199E3F4B77244B508C410BAE781345A",
+	}).Return([]byte(`<xml>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<result_code><![CDATA[SUCCESS]]></result_code>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+
+	err := mch.Report(context.TODO(), &ReportRequest{
+		InterfaceURL: MicropayURL,
+		ExecuteTime:  1000,
+		ReturnCode:   ResultSuccess,
+		ResultCode:   ResultSuccess,
+		UserIP:       "8.8.8.8",
+		Time:         "20141111170043",
+		OutTradeNO:   "1415757673",
+	})
+
+	assert.Nil(t, err)
+}
+
+func TestReportValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	mch.client = wx.NewMockHTTPClient(ctrl)
+
+	assert.EqualError(t, mch.Report(context.TODO(), &ReportRequest{UserIP: "8.8.8.8", Time: "20141111170043"}), "gochat: interface_url is required")
+	assert.EqualError(t, mch.Report(context.TODO(), &ReportRequest{InterfaceURL: MicropayURL, Time: "20141111170043"}), "gochat: user_ip is required")
+	assert.EqualError(t, mch.Report(context.TODO(), &ReportRequest{InterfaceURL: MicropayURL, UserIP: "8.8.8.8"}), "gochat: time is required")
+}
+
+func TestAutoReport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	reports := make([]wx.WXML, 0, 2)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/orderquery", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<device_info>1000</device_info>
+	<nonce_str>TN55wO9Pba5yENl8</nonce_str>
+	<sign>07EACC03ED8DD7F1BAB6BBE1853EF998</sign>
+	<result_code>SUCCESS</result_code>
+	<openid>oUpF8uN95-Ptaags6E_roPHg7AG0</openid>
+	<is_subscribe>Y</is_subscribe>
+	<trade_type>APP</trade_type>
+	<bank_type>CCB_DEBIT</bank_type>
+	<total_fee>1</total_fee>
+	<fee_type>CNY</fee_type>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<out_trade_no>1415757673</out_trade_no>
+	<attach>订单额外描述</attach>
+	<time_end>20141111170043</time_end>
+	<trade_state>SUCCESS</trade_state>
+</xml>`), nil)
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/closeorder", gomock.Any()).Return(nil, errors.New("dial tcp: i/o timeout")).Times(2)
+	// 上报请求本身不再上报
+	client.EXPECT().PostXML(gomock.Any(), "https://api.mch.weixin.qq.com/payitil/report", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		reports = append(reports, body)
+
+		return []byte(`<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code></xml>`), nil
+	}).Times(2)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithAutoReport("8.8.8.8", 10), WithTimestampFunc(func() int64 {
+		return 1415696443
+	}))
+
+	mch.nonce = func(size int) string {
+		return "ec2316275641faa3aacf3cc599e8730f"
+	}
+	mch.client = client
+
+	_, err := mch.QueryOrderByOutTradeNO(context.TODO(), "1415757673")
+
+	assert.Nil(t, err)
+
+	_, err = mch.Do(context.TODO(), CloseOrder("1415757673"))
+
+	assert.EqualError(t, err, "dial tcp: i/o timeout")
+
+	// 停止时等待队列中的数据上报完成
+	assert.Nil(t, mch.Close())
+	assert.Nil(t, mch.Close())
+
+	assert.Len(t, reports, 2)
+
+	for _, v := range reports {
+		assert.NotEmpty(t, v["execute_time_"])
+		assert.Equal(t, "8.8.8.8", v["user_ip"])
+		assert.Equal(t, "20141111170043", v["time"])
+	}
+
+	assert.Equal(t, OrderQueryURL, reports[0]["interface_url"])
+	assert.Equal(t, "SUCCESS", reports[0]["return_code"])
+	assert.Equal(t, "SUCCESS", reports[0]["result_code"])
+	assert.Equal(t, "1000", reports[0]["device_info"])
+	assert.Equal(t, "1415757673", reports[0]["out_trade_no"])
+
+	assert.Equal(t, OrderCloseURL, reports[1]["interface_url"])
+	assert.Equal(t, "FAIL", reports[1]["return_code"])
+	assert.Equal(t, "FAIL", reports[1]["result_code"])
+	assert.Equal(t, "dial tcp: i/o timeout", reports[1]["return_msg"])
+
+	// 停止后不再上报
+	_, err = mch.Do(context.TODO(), CloseOrder("1415757673"))
+
+	assert.NotNil(t, err)
+}
+
+func TestReporterQueueFull(t *testing.T) {
+	r := newReporter("8.8.8.8", 1)
+
+	// 队列已满时丢弃，不阻塞调用方
+	r.add(&ReportData{InterfaceURL: OrderQueryURL})
+	r.add(&ReportData{InterfaceURL: OrderCloseURL})
+
+	assert.Len(t, r.queue, 1)
+	assert.Equal(t, OrderQueryURL, (<-r.queue).InterfaceURL)
+
+	// 默认容量
+	assert.Equal(t, DefaultReportQueueSize, cap(newReporter("8.8.8.8", 0).queue))
+}
+
+func TestAutoReportShutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/pay/closeorder", gomock.Any()).Return(nil, errors.New("dial tcp: i/o timeout")).Times(2)
+
+	started := make(chan struct{}, 2)
+
+	// 上报请求挂起，直至超时或放弃上报
+	client.EXPECT().PostXML(gomock.Any(), "https://api.mch.weixin.qq.com/payitil/report", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		_, ok := ctx.Deadline()
+
+		assert.True(t, ok)
+
+		started <- struct{}{}
+
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}).Times(1)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithAutoReport("8.8.8.8", 10))
+	mch.client = client
+
+	for i := 0; i < 2; i++ {
+		_, err := mch.Do(context.TODO(), CloseOrder("1415757673"))
+
+		assert.EqualError(t, err, "dial tcp: i/o timeout")
+	}
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+
+	defer cancel()
+
+	now := time.Now()
+
+	// 超过等待时间后放弃剩余的上报，不阻塞退出
+	assert.Equal(t, context.DeadlineExceeded, mch.Shutdown(ctx))
+	assert.True(t, time.Since(now) < time.Second)
+
+	// 已放弃时立即返回
+	assert.Nil(t, mch.Close())
+}
@@ -0,0 +1,92 @@
+package mch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// signKey 返回请求签名（及应答验签）使用的秘钥：仿真测试模式下为仿真测试验签秘钥（首次使用时获取并缓存），否则为商户的 apikey
+func (mch *Mch) signKey(ctx context.Context, options ...wx.HTTPOption) (string, error) {
+	if !mch.sandbox {
+		return mch.apikey, nil
+	}
+
+	if v, ok := mch.sandboxKey.Load().(string); ok && v != "" {
+		return v, nil
+	}
+
+	// 并发请求仅获取一次（不随单个请求的 ctx 取消），获取失败时不缓存，下次请求重新获取
+	v, err := wx.SingleflightDo(ctx, &mch.sandboxGroup, mch.mchid, wx.DefaultTokenRefreshTimeout, func(ctx context.Context) (interface{}, error) {
+		if v, ok := mch.sandboxKey.Load().(string); ok && v != "" {
+			return v, nil
+		}
+
+		key, err := mch.getSandboxSignKey(ctx, options...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		mch.sandboxKey.Store(key)
+
+		return key, nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return v.(string), nil
+}
+
+// verifyKey 返回通知验签使用的秘钥：仿真测试模式下为已缓存的仿真测试验签秘钥，否则为商户的 apikey
+func (mch *Mch) verifyKey() string {
+	if mch.sandbox {
+		if v, ok := mch.sandboxKey.Load().(string); ok && v != "" {
+			return v
+		}
+	}
+
+	return mch.apikey
+}
+
+// getSandboxSignKey 获取仿真测试验签秘钥（使用商户的 apikey 进行MD5签名，应答不含签名）
+func (mch *Mch) getSandboxSignKey(ctx context.Context, options ...wx.HTTPOption) (string, error) {
+	m := wx.WXML{
+		"mch_id":    mch.mchid,
+		"nonce_str": mch.nonce(16),
+	}
+
+	m["sign"] = mch.SignWithMD5(m, true)
+
+	resp, err := mch.client.PostXML(ctx, mch.endpoint(SandboxSignKeyURL), m, mch.httpOptions(options)...)
+
+	if err != nil {
+		return "", err
+	}
+
+	// XML解析
+	result, err := wx.ParseXML2Map(resp)
+
+	if err != nil {
+		return "", err
+	}
+
+	if result["return_code"] != ResultSuccess {
+		return "", newReturnError(result)
+	}
+
+	if err = mch.verifyIdentity(result); err != nil {
+		return "", err
+	}
+
+	key := result["sandbox_signkey"]
+
+	if key == "" {
+		return "", errors.New("gochat: sandbox_signkey is empty")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,271 @@
+package mch
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+// anyContext 匹配任意 context.Context（获取仿真测试验签秘钥时使用不随调用方取消的 ctx）
+var anyContext = gomock.AssignableToTypeOf(reflect.TypeOf((*context.Context)(nil)).Elem())
+
+var testSandboxSignKeyBody = wx.WXML{
+	"mch_id":    "10000100",
+	"nonce_str": "50780e0cca98c8c8e814883e5caa672e",
+	"sign":      "919E3E7A8F9395615C7072255AD96445",
+}
+
+const testSandboxSignKeyResp = `<xml>
+	<return_code><![CDATA[SUCCESS]]></return_code>
+	<return_msg><![CDATA[ok]]></return_msg>
+	<mch_id><![CDATA[10000100]]></mch_id>
+	<sandbox_signkey><![CDATA[8ff5b4c7f0d3e8a2a4a8c3b1e7f6d9c2]]></sandbox_signkey>
+</xml>`
+
+func TestSandboxQueryOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		// 首次请求时获取仿真测试验签秘钥（使用商户的 apikey 签名）
+		client.EXPECT().PostXML(anyContext, "https://api.mch.weixin.qq.com/sandboxnew/pay/getsignkey", testSandboxSignKeyBody).Return([]byte(testSandboxSignKeyResp), nil),
+		// 请求及应答均使用仿真测试验签秘钥签名，之后的请求使用缓存的秘钥
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/sandboxnew/pay/orderquery", wx.WXML{
+			"appid":        "wx2421b1c4370ec43b",
+			"mch_id":       "10000100",
+			"out_trade_no": "1415757673",
+			"nonce_str":    "50780e0cca98c8c8e814883e5caa672e",
+			"sign_type":    "MD5",
+			"sign":         "69E41315103D83C103FA67F8E25A44C1",
+		}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<return_msg>OK</return_msg>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<nonce_str>6cefdb308e1e2e8aabd48cf79e546a02</nonce_str>
+	<sign>B0FE23BC27675EF1BB51E9B8DCD0C754</sign>
+	<result_code>SUCCESS</result_code>
+	<out_trade_no>1415757673</out_trade_no>
+	<transaction_id>1008450740201411110005820873</transaction_id>
+	<trade_state>SUCCESS</trade_state>
+	<total_fee>1</total_fee>
+	<time_end>20141111170043</time_end>
+</xml>`), nil).Times(2),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSandbox())
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+	mch.tlsClient = client
+
+	for i := 0; i < 2; i++ {
+		r, err := mch.QueryOrderByOutTradeNO(context.TODO(), "1415757673")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "1008450740201411110005820873", r.TransactionID)
+		assert.Equal(t, TradeStateSuccess, r.TradeState)
+	}
+}
+
+func TestSandboxDownloadBill(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().PostXML(anyContext, "https://api.mch.weixin.qq.com/sandboxnew/pay/getsignkey", testSandboxSignKeyBody).Return([]byte(testSandboxSignKeyResp), nil),
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/sandboxnew/pay/downloadbill", wx.WXML{
+			"appid":     "wx2421b1c4370ec43b",
+			"mch_id":    "10000100",
+			"bill_date": "20141110",
+			"bill_type": "ALL",
+			"nonce_str": "50780e0cca98c8c8e814883e5caa672e",
+			"sign":      "A2A282558226E83DE9132BB28CC9655B",
+		}, gomock.Any()).Return([]byte(`<xml>
+	<return_code><![CDATA[FAIL]]></return_code>
+	<return_msg><![CDATA[No Bill Exist]]></return_msg>
+</xml>`), nil),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSandbox())
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+
+	_, err := mch.DownloadBill(context.TODO(), "20141110", BillTypeAll, false)
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "No Bill Exist"}, err)
+}
+
+func TestSandboxSignKeyConcurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 并发请求仅获取一次
+	client.EXPECT().PostXML(anyContext, SandboxSignKeyURL, testSandboxSignKeyBody).DoAndReturn(func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+
+		return []byte(testSandboxSignKeyResp), nil
+	}).Times(1)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSandbox())
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+
+	var wg sync.WaitGroup
+
+	keys := make([]string, 10)
+
+	for i := range keys {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			key, err := mch.signKey(context.TODO())
+
+			assert.Nil(t, err)
+
+			keys[i] = key
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, key := range keys {
+		assert.Equal(t, "8ff5b4c7f0d3e8a2a4a8c3b1e7f6d9c2", key)
+	}
+
+	// 通知验签使用缓存的秘钥
+	assert.Equal(t, "8ff5b4c7f0d3e8a2a4a8c3b1e7f6d9c2", mch.verifyKey())
+}
+
+func TestSandboxSignKeyCanceledCaller(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	client.EXPECT().PostXML(anyContext, SandboxSignKeyURL, testSandboxSignKeyBody).DoAndReturn(func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		close(started)
+		<-release
+
+		// 不随调用方取消
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return []byte(testSandboxSignKeyResp), nil
+	}).Times(1)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSandbox())
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	first := make(chan error, 1)
+
+	go func() {
+		_, err := mch.signKey(ctx)
+
+		first <- err
+	}()
+
+	<-started
+
+	second := make(chan string, 1)
+
+	go func() {
+		key, err := mch.signKey(context.TODO())
+
+		assert.Nil(t, err)
+
+		second <- key
+	}()
+
+	// 等待第二个请求加入
+	time.Sleep(50 * time.Millisecond)
+
+	// 首个请求取消，不影响其他请求
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-first)
+
+	close(release)
+
+	assert.Equal(t, "8ff5b4c7f0d3e8a2a4a8c3b1e7f6d9c2", <-second)
+}
+
+func TestSandboxSignKeyFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().PostXML(anyContext, SandboxSignKeyURL, testSandboxSignKeyBody).Return([]byte(`<xml>
+	<return_code><![CDATA[FAIL]]></return_code>
+	<return_msg><![CDATA[签名错误]]></return_msg>
+</xml>`), nil),
+		// 获取失败时不缓存，下次请求重新获取
+		client.EXPECT().PostXML(anyContext, SandboxSignKeyURL, testSandboxSignKeyBody).Return([]byte(testSandboxSignKeyResp), nil),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSandbox())
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+	mch.client = client
+
+	_, err := mch.Do(context.TODO(), QueryOrderByOutTradeNO("1415757673"))
+
+	assert.Equal(t, &ReturnError{ReturnCode: "FAIL", ReturnMsg: "签名错误"}, err)
+	assert.Equal(t, "192006250b4c09247ec02edce69f6a2d", mch.verifyKey())
+
+	key, err := mch.signKey(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "8ff5b4c7f0d3e8a2a4a8c3b1e7f6d9c2", key)
+}
+
+func TestSandboxEndpoint(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithSandbox())
+
+	assert.Equal(t, "https://api.mch.weixin.qq.com/sandboxnew/pay/unifiedorder", mch.endpoint(OrderUnifyURL))
+	assert.Equal(t, "https://api.mch.weixin.qq.com/sandboxnew/pay/refund", mch.endpoint(RefundApplyURL))
+	assert.Equal(t, "https://api.mch.weixin.qq.com/sandboxnew/pay/getsignkey", mch.endpoint(SandboxSignKeyURL))
+	assert.Equal(t, "https://fraud.mch.weixin.qq.com/risk/getpublickey", mch.endpoint("https://fraud.mch.weixin.qq.com/risk/getpublickey"))
+
+	// 非仿真测试模式下原样返回
+	mch = New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	assert.Equal(t, OrderUnifyURL, mch.endpoint(OrderUnifyURL))
+	assert.Equal(t, "192006250b4c09247ec02edce69f6a2d", mch.verifyKey())
+}
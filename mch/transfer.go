@@ -1,8 +1,12 @@
 package mch
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/shenghui0779/gochat/wx"
 )
@@ -68,6 +72,53 @@ func TransferToBalance(data *TransferBalanceData) wx.Action {
 	)
 }
 
+// TransferRequest 付款到零钱请求
+type TransferRequest = TransferBalanceData
+
+// TransferResponse 付款到零钱结果
+type TransferResponse struct {
+	PartnerTradeNO string    // 商户订单号
+	PaymentNO      string    // 微信付款单号
+	PaymentTime    time.Time // 付款成功时间（北京时间）
+}
+
+// TransferToBalance 付款到零钱（需加载商户证书），返回微信付款单号（payment_no）
+// 请求前校验：amount 须大于0，check_name 为 FORCE_CHECK 时 re_user_name 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+// 【注意：当返回错误码为“SYSTEMERROR”时，请务必使用原商户订单号重试，否则可能造成重复支付等资金风险。】
+func (mch *Mch) TransferToBalance(ctx context.Context, req *TransferRequest, options ...wx.HTTPOption) (*TransferResponse, error) {
+	if req.Amount <= 0 {
+		return nil, errors.New("gochat: amount must be positive")
+	}
+
+	if req.CheckName == TransferForceCheck && req.ReUserName == "" {
+		return nil, errors.New("gochat: re_user_name is required when check_name is FORCE_CHECK")
+	}
+
+	r, err := mch.Do(ctx, TransferToBalance(req), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	resp := &TransferResponse{
+		PartnerTradeNO: r["partner_trade_no"],
+		PaymentNO:      r["payment_no"],
+	}
+
+	if v := r["payment_time"]; v != "" {
+		if resp.PaymentTime, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid payment_time: %w", err)
+		}
+	}
+
+	return resp, nil
+}
+
 // QueryTransferBalanceOrder 查询付款到零钱订单
 func QueryTransferBalanceOrder(partnerTradeNO string) wx.Action {
 	return wx.NewAction(TransferBalanceOrderQueryURL,
@@ -85,6 +136,223 @@ func QueryTransferBalanceOrder(partnerTradeNO string) wx.Action {
 	)
 }
 
+// TransferInfo 付款到零钱订单信息
+type TransferInfo struct {
+	PartnerTradeNO string    // 商户订单号
+	DetailID       string    // 付款单号（微信付款单号）
+	Status         string    // 转账状态（SUCCESS、FAILED、PROCESSING）
+	Reason         string    // 失败原因（转账失败时返回）
+	OpenID         string    // 收款用户openid
+	TransferName   string    // 收款用户姓名
+	PaymentAmount  int       // 付款金额，单位：分
+	TransferTime   time.Time // 发起转账的时间（北京时间）
+	PaymentTime    time.Time // 企业付款成功时间（北京时间）
+	Desc           string    // 企业付款备注
+}
+
+// QueryTransfer 查询付款到零钱订单（需加载商户证书），用于处理付款超时等状态不明确的订单
+// 订单不存在时返回的 *ResultError 可通过 errors.Is(err, ErrNotFound) 判断（可作为终态处理），以区别于网络等可重试的错误
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) QueryTransfer(ctx context.Context, partnerTradeNO string, options ...wx.HTTPOption) (*TransferInfo, error) {
+	r, err := mch.Do(ctx, QueryTransferBalanceOrder(partnerTradeNO), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	info := &TransferInfo{
+		PartnerTradeNO: r["partner_trade_no"],
+		DetailID:       r["detail_id"],
+		Status:         r["status"],
+		Reason:         r["reason"],
+		OpenID:         r["openid"],
+		TransferName:   r["transfer_name"],
+		Desc:           r["desc"],
+	}
+
+	if info.PaymentAmount, err = atoi(r, "payment_amount"); err != nil {
+		return nil, err
+	}
+
+	times := []struct {
+		key  string
+		dest *time.Time
+	}{
+		{"transfer_time", &info.TransferTime},
+		{"payment_time", &info.PaymentTime},
+	}
+
+	for _, t := range times {
+		v := r[t.key]
+
+		if v == "" {
+			continue
+		}
+
+		if *t.dest, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid %s: %w", t.key, err)
+		}
+	}
+
+	return info, nil
+}
+
+// GetRSAPublicKey 获取付款到银行卡的RSA加密公钥（需加载商户证书），获取成功后缓存，之后直接返回缓存的公钥
+// 微信返回的公钥曾为 PKCS#1（RSA PUBLIC KEY）与 PKIX（PUBLIC KEY）格式，加密时均支持
+func (mch *Mch) GetRSAPublicKey(ctx context.Context, options ...wx.HTTPOption) ([]byte, error) {
+	if v, ok := mch.rsaKey.Load().([]byte); ok {
+		return v, nil
+	}
+
+	r, err := mch.Do(ctx, RSAPublicKey(), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	key := []byte(r["pub_key"])
+
+	// 校验公钥，避免缓存无效的公钥
+	if _, err = wx.ParseRSAPublicKey(key); err != nil {
+		return nil, err
+	}
+
+	mch.rsaKey.Store(key)
+
+	return key, nil
+}
+
+// PayBankRequest 付款到银行卡请求（EncBankNO、EncTrueName 为明文，请求时使用RSA公钥加密）
+type PayBankRequest = TransferBankCardData
+
+// PayBankResponse 付款到银行卡结果
+type PayBankResponse struct {
+	PartnerTradeNO string // 商户企业付款单号
+	PaymentNO      string // 微信企业付款单号
+	Amount         int    // 代付金额，单位：分
+	CmmsAmt        int    // 手续费金额，单位：分
+}
+
+// PayToBank 付款到银行卡（需加载商户证书），收款方银行卡号及用户名使用 GetRSAPublicKey 获取的公钥加密
+// 请求前校验：amount 须大于0，enc_bank_no、enc_true_name、bank_code 必填
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+// 【注意：当返回错误码为“SYSTEMERROR”时，请务必使用原商户订单号重试，否则可能造成重复支付等资金风险。】
+func (mch *Mch) PayToBank(ctx context.Context, req *PayBankRequest, options ...wx.HTTPOption) (*PayBankResponse, error) {
+	if req.Amount <= 0 {
+		return nil, errors.New("gochat: amount must be positive")
+	}
+
+	if req.EncBankNO == "" || req.EncTrueName == "" || req.BankCode == "" {
+		return nil, errors.New("gochat: enc_bank_no, enc_true_name and bank_code are required")
+	}
+
+	publicKey, err := mch.GetRSAPublicKey(ctx, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := mch.Do(ctx, TransferToBankCard(req, publicKey), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	resp := &PayBankResponse{
+		PartnerTradeNO: r["partner_trade_no"],
+		PaymentNO:      r["payment_no"],
+	}
+
+	if resp.Amount, err = atoi(r, "amount"); err != nil {
+		return nil, err
+	}
+
+	if resp.CmmsAmt, err = atoi(r, "cmms_amt"); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// PayBankInfo 付款到银行卡订单信息
+type PayBankInfo struct {
+	PartnerTradeNO string    // 商户企业付款单号
+	PaymentNO      string    // 微信企业付款单号
+	BankNOMD5      string    // 收款用户银行卡号（MD5加密）
+	TrueNameMD5    string    // 收款人真实姓名（MD5加密）
+	Amount         int       // 代付金额，单位：分
+	Status         string    // 代付订单状态（PROCESSING、SUCCESS、FAILED、BANK_FAIL）
+	CmmsAmt        int       // 手续费金额，单位：分
+	CreateTime     time.Time // 商户下单时间（北京时间）
+	PaySuccTime    time.Time // 成功付款时间（北京时间）
+	Reason         string    // 失败原因
+}
+
+// QueryPayToBank 查询付款到银行卡订单（需加载商户证书）
+// return_code 不为 SUCCESS 时返回 *ReturnError；result_code 不为 SUCCESS 时返回 *ResultError
+func (mch *Mch) QueryPayToBank(ctx context.Context, partnerTradeNO string, options ...wx.HTTPOption) (*PayBankInfo, error) {
+	r, err := mch.Do(ctx, QueryTransferBankCardOrder(partnerTradeNO), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r["result_code"] != ResultSuccess {
+		return nil, newResultError(r)
+	}
+
+	info := &PayBankInfo{
+		PartnerTradeNO: r["partner_trade_no"],
+		PaymentNO:      r["payment_no"],
+		BankNOMD5:      r["bank_no_md5"],
+		TrueNameMD5:    r["true_name_md5"],
+		Status:         r["status"],
+		Reason:         r["reason"],
+	}
+
+	if info.Amount, err = atoi(r, "amount"); err != nil {
+		return nil, err
+	}
+
+	if info.CmmsAmt, err = atoi(r, "cmms_amt"); err != nil {
+		return nil, err
+	}
+
+	times := []struct {
+		key  string
+		dest *time.Time
+	}{
+		{"create_time", &info.CreateTime},
+		{"pay_succ_time", &info.PaySuccTime},
+	}
+
+	for _, t := range times {
+		v := r[t.key]
+
+		if v == "" {
+			continue
+		}
+
+		if *t.dest, err = time.ParseInLocation("2006-01-02 15:04:05", v, beijing); err != nil {
+			return nil, fmt.Errorf("gochat: invalid %s: %w", t.key, err)
+		}
+	}
+
+	return info, nil
+}
+
 // TransferToBankCard 付款到银行卡【注意：当返回错误码为“SYSTEMERROR”时，请务必使用原商户订单号重试，否则可能造成重复支付等资金风险。】
 func TransferToBankCard(data *TransferBankCardData, publicKey []byte) wx.Action {
 	return wx.NewAction(TransferToBankCardURL,
@@ -100,8 +368,8 @@ func TransferToBankCard(data *TransferBankCardData, publicKey []byte) wx.Action
 				"sign_type":        SignMD5,
 			}
 
-			// 收款方银行卡号加密
-			b, err := wx.RSAEncrypt([]byte(data.EncBankNO), publicKey)
+			// 收款方银行卡号加密（RSA_PKCS1_OAEP_PADDING）
+			b, err := wx.RSAEncryptOAEP([]byte(data.EncBankNO), publicKey)
 
 			if err != nil {
 				return nil, err
@@ -109,8 +377,8 @@ func TransferToBankCard(data *TransferBankCardData, publicKey []byte) wx.Action
 
 			body["enc_bank_no"] = base64.StdEncoding.EncodeToString(b)
 
-			// 收款方用户名加密
-			b, err = wx.RSAEncrypt([]byte(data.EncTrueName), publicKey)
+			// 收款方用户名加密（RSA_PKCS1_OAEP_PADDING）
+			b, err = wx.RSAEncryptOAEP([]byte(data.EncTrueName), publicKey)
 
 			if err != nil {
 				return nil, err
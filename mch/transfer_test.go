@@ -2,7 +2,16 @@ package mch
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shenghui0779/gochat/wx"
@@ -70,6 +79,89 @@ func TestTransferToBalance(t *testing.T) {
 	}, r)
 }
 
+func TestMchTransferToBalance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tlsClient := wx.NewMockHTTPClient(ctrl)
+
+	tlsClient.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/promotion/transfers", wx.WXML{
+		"mch_appid":        "wx2421b1c4370ec43b",
+		"mchid":            "10000100",
+		"partner_trade_no": "100000982014120919616",
+		"openid":           "ohO4Gt7wVPxIT1A9GjFaMYMiZY1s",
+		"check_name":       "FORCE_CHECK",
+		"re_user_name":     "张三",
+		"amount":           "100",
+		"desc":             "节日快乐!",
+		"spbill_create_ip": "10.2.3.10",
+		"nonce_str":        "3PG2J4ILTKCH16CQ2502SI8ZNMTM67VS",
+		"sign_type":        "MD5",
+		"sign":             "97CD9C3C88B189B60C230677CE0FC3BB",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<mch_appid>wx2421b1c4370ec43b</mch_appid>
+	<mchid>10000100</mchid>
+	<nonce_str>lxuDzMnRjpcXzxLx0q</nonce_str>
+	<result_code>SUCCESS</result_code>
+	<partner_trade_no>100000982014120919616</partner_trade_no>
+	<payment_no>1000018301201505190181489473</payment_no>
+	<payment_time>2015-05-19 15:26:59</payment_time>
+</xml>`), nil)
+
+	req := &TransferRequest{
+		PartnerTradeNO: "100000982014120919616",
+		OpenID:         "ohO4Gt7wVPxIT1A9GjFaMYMiZY1s",
+		CheckName:      TransferForceCheck,
+		Amount:         100,
+		Desc:           "节日快乐!",
+		ReUserName:     "张三",
+		SpbillCreateIP: "10.2.3.10",
+	}
+
+	assert.True(t, TransferToBalance(req).TLS())
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d", WithNonceFunc(func(size int) string {
+		return "3PG2J4ILTKCH16CQ2502SI8ZNMTM67VS"
+	}))
+
+	// 付款到零钱须使用证书，不能走普通客户端
+	mch.client = wx.NewMockHTTPClient(ctrl)
+	mch.tlsClient = tlsClient
+
+	r, err := mch.TransferToBalance(context.TODO(), req)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &TransferResponse{
+		PartnerTradeNO: "100000982014120919616",
+		PaymentNO:      "1000018301201505190181489473",
+		PaymentTime:    time.Date(2015, 5, 19, 15, 26, 59, 0, beijing),
+	}, r)
+}
+
+func TestMchTransferToBalanceValidate(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	_, err := mch.TransferToBalance(context.TODO(), &TransferRequest{
+		PartnerTradeNO: "100000982014120919616",
+		OpenID:         "ohO4Gt7wVPxIT1A9GjFaMYMiZY1s",
+		CheckName:      TransferNoCheck,
+		Desc:           "节日快乐!",
+	})
+
+	assert.EqualError(t, err, "gochat: amount must be positive")
+
+	_, err = mch.TransferToBalance(context.TODO(), &TransferRequest{
+		PartnerTradeNO: "100000982014120919616",
+		OpenID:         "ohO4Gt7wVPxIT1A9GjFaMYMiZY1s",
+		CheckName:      TransferForceCheck,
+		Amount:         100,
+		Desc:           "节日快乐!",
+	})
+
+	assert.EqualError(t, err, "gochat: re_user_name is required when check_name is FORCE_CHECK")
+}
+
 func TestQueryTransferBalanceOrder(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -290,3 +382,297 @@ DQIDAQAB
 -----END PUBLIC KEY-----`,
 	}, r)
 }
+
+func TestMchQueryTransfer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo", wx.WXML{
+		"appid":            "wx2421b1c4370ec43b",
+		"mch_id":           "10000100",
+		"partner_trade_no": "1000005901201407261446939628",
+		"nonce_str":        "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":        "MD5",
+		"sign":             "DF0024F9502E233115C0198912B4EB5D",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<result_code>SUCCESS</result_code>
+	<detail_id>1000000000201503283103439304</detail_id>
+	<partner_trade_no>1000005901201407261446939628</partner_trade_no>
+	<status>SUCCESS</status>
+	<payment_amount>650</payment_amount>
+	<openid>oxTWIuGaIt6gTKsQRLau2M0yL16E</openid>
+	<transfer_name>测试</transfer_name>
+	<transfer_time>2015-04-21 20:00:00</transfer_time>
+	<payment_time>2015-04-21 20:00:03</payment_time>
+	<desc>福利测试</desc>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	info, err := mch.QueryTransfer(context.TODO(), "1000005901201407261446939628")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &TransferInfo{
+		PartnerTradeNO: "1000005901201407261446939628",
+		DetailID:       "1000000000201503283103439304",
+		Status:         TransferStatusSuccess,
+		OpenID:         "oxTWIuGaIt6gTKsQRLau2M0yL16E",
+		TransferName:   "测试",
+		PaymentAmount:  650,
+		TransferTime:   time.Date(2015, 4, 21, 20, 0, 0, 0, beijing),
+		PaymentTime:    time.Date(2015, 4, 21, 20, 0, 3, 0, beijing),
+		Desc:           "福利测试",
+	}, info)
+}
+
+func TestMchQueryTransferFailed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<appid>wx2421b1c4370ec43b</appid>
+	<mch_id>10000100</mch_id>
+	<result_code>SUCCESS</result_code>
+	<detail_id>1000000000201503283103439304</detail_id>
+	<partner_trade_no>1000005901201407261446939628</partner_trade_no>
+	<status>FAILED</status>
+	<reason>余额不足</reason>
+	<payment_amount>650</payment_amount>
+	<openid>oxTWIuGaIt6gTKsQRLau2M0yL16E</openid>
+	<transfer_time>2015-04-21 20:00:00</transfer_time>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.tlsClient = client
+
+	info, err := mch.QueryTransfer(context.TODO(), "1000005901201407261446939628")
+
+	assert.Nil(t, err)
+	assert.Equal(t, TransferStatusFailed, info.Status)
+	assert.Equal(t, "余额不足", info.Reason)
+	assert.True(t, info.PaymentTime.IsZero())
+}
+
+func TestMchQueryTransferNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<result_code>FAIL</result_code>
+	<err_code>NOT_FOUND</err_code>
+	<err_code_des>指定单号数据不存在</err_code_des>
+</xml>`), nil),
+		client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo", gomock.Any()).Return(nil, context.DeadlineExceeded),
+	)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.tlsClient = client
+
+	// 订单不存在
+	info, err := mch.QueryTransfer(context.TODO(), "1000005901201407261446939628")
+
+	assert.Nil(t, info)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.EqualError(t, err, "NOT_FOUND|指定单号数据不存在")
+
+	// 网络错误
+	info, err = mch.QueryTransfer(context.TODO(), "1000005901201407261446939628")
+
+	assert.Nil(t, info)
+	assert.False(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestMchPayToBank(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	block, _ := pem.Decode(privateKey)
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	assert.Nil(t, err)
+
+	// 微信返回 PKCS#1 格式的公钥
+	pubKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 公钥仅获取一次
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://fraud.mch.weixin.qq.com/risk/getpublickey", gomock.Any()).Return([]byte(fmt.Sprintf(`<xml>
+	<return_code>SUCCESS</return_code>
+	<mch_id>10000100</mch_id>
+	<result_code>SUCCESS</result_code>
+	<pub_key>%s</pub_key>
+</xml>`, pubKey)), nil).Times(1)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaysptrans/pay_bank", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body wx.WXML, options ...wx.HTTPOption) ([]byte, error) {
+		assert.Equal(t, "10000100", body["mch_id"])
+		assert.Equal(t, "1212121221278", body["partner_trade_no"])
+		assert.Equal(t, "1002", body["bank_code"])
+		assert.Equal(t, "500", body["amount"])
+		assert.Nil(t, verifySign("192006250b4c09247ec02edce69f6a2d", body, SignMD5))
+
+		// RSA_PKCS1_OAEP_PADDING
+		for k, v := range map[string]string{"enc_bank_no": "6221882600114166800", "enc_true_name": "张三"} {
+			cipherText, err := base64.StdEncoding.DecodeString(body[k])
+
+			assert.Nil(t, err)
+
+			plainText, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, cipherText, nil)
+
+			assert.Nil(t, err)
+			assert.Equal(t, v, string(plainText))
+		}
+
+		return []byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<mch_id>10000100</mch_id>
+	<result_code>SUCCESS</result_code>
+	<partner_trade_no>1212121221278</partner_trade_no>
+	<amount>500</amount>
+	<payment_no>10000600500852017030900000020006012</payment_no>
+	<cmms_amt>1</cmms_amt>
+</xml>`), nil
+	}).Times(2)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.tlsClient = client
+
+	for i := 0; i < 2; i++ {
+		resp, err := mch.PayToBank(context.TODO(), &PayBankRequest{
+			PartnerTradeNO: "1212121221278",
+			EncBankNO:      "6221882600114166800",
+			EncTrueName:    "张三",
+			BankCode:       "1002",
+			Amount:         500,
+			Desc:           "test",
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, &PayBankResponse{
+			PartnerTradeNO: "1212121221278",
+			PaymentNO:      "10000600500852017030900000020006012",
+			Amount:         500,
+			CmmsAmt:        1,
+		}, resp)
+	}
+}
+
+func TestMchPayToBankValidate(t *testing.T) {
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	_, err := mch.PayToBank(context.TODO(), &PayBankRequest{
+		PartnerTradeNO: "1212121221278",
+		EncBankNO:      "6221882600114166800",
+		EncTrueName:    "张三",
+		BankCode:       "1002",
+	})
+
+	assert.EqualError(t, err, "gochat: amount must be positive")
+
+	_, err = mch.PayToBank(context.TODO(), &PayBankRequest{
+		PartnerTradeNO: "1212121221278",
+		EncBankNO:      "6221882600114166800",
+		Amount:         500,
+	})
+
+	assert.EqualError(t, err, "gochat: enc_bank_no, enc_true_name and bank_code are required")
+}
+
+func TestMchGetRSAPublicKeyInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 无效的公钥不缓存
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://fraud.mch.weixin.qq.com/risk/getpublickey", gomock.Any()).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<mch_id>10000100</mch_id>
+	<result_code>SUCCESS</result_code>
+	<pub_key>invalid</pub_key>
+</xml>`), nil).Times(2)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.tlsClient = client
+
+	for i := 0; i < 2; i++ {
+		_, err := mch.GetRSAPublicKey(context.TODO())
+
+		assert.EqualError(t, err, "gochat: invalid rsa public key")
+	}
+}
+
+func TestMchQueryPayToBank(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().PostXML(gomock.AssignableToTypeOf(context.TODO()), "https://api.mch.weixin.qq.com/mmpaysptrans/query_bank", wx.WXML{
+		"mch_id":           "10000100",
+		"partner_trade_no": "1212121221278",
+		"nonce_str":        "50780e0cca98c8c8e814883e5caa672e",
+		"sign_type":        "MD5",
+		"sign":             "F5F586AE6B1BDB6756D2B1AD0A01BADA",
+	}).Return([]byte(`<xml>
+	<return_code>SUCCESS</return_code>
+	<mch_id>10000100</mch_id>
+	<result_code>SUCCESS</result_code>
+	<partner_trade_no>1212121221278</partner_trade_no>
+	<payment_no>10000600500852017030900000020006012</payment_no>
+	<bank_no_md5>2260AB5EF3D290E28EFD3F74FF7A29A0</bank_no_md5>
+	<true_name_md5>7F25B325D37790764ABA55DAD8D09B76</true_name_md5>
+	<amount>500</amount>
+	<status>SUCCESS</status>
+	<cmms_amt>1</cmms_amt>
+	<create_time>2017-03-09 15:04:04</create_time>
+	<pay_succ_time>2017-03-09 15:05:10</pay_succ_time>
+</xml>`), nil)
+
+	mch := New("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+
+	mch.nonce = func(size int) string {
+		return "50780e0cca98c8c8e814883e5caa672e"
+	}
+
+	mch.tlsClient = client
+
+	info, err := mch.QueryPayToBank(context.TODO(), "1212121221278")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &PayBankInfo{
+		PartnerTradeNO: "1212121221278",
+		PaymentNO:      "10000600500852017030900000020006012",
+		BankNOMD5:      "2260AB5EF3D290E28EFD3F74FF7A29A0",
+		TrueNameMD5:    "7F25B325D37790764ABA55DAD8D09B76",
+		Amount:         500,
+		Status:         TransferStatusSuccess,
+		CmmsAmt:        1,
+		CreateTime:     time.Date(2017, 3, 9, 15, 4, 4, 0, beijing),
+		PaySuccTime:    time.Date(2017, 3, 9, 15, 5, 10, 0, beijing),
+	}, info)
+}
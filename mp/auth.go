@@ -18,7 +18,7 @@ const (
 type AuthSession struct {
 	SessionKey string `json:"session_key"`
 	OpenID     string `json:"openid"`
-	UnionID    string `json:"unionid"`
+	UnionID    string `json:"unionid"` // 满足 UnionID 下发条件时返回
 }
 
 // AccessToken 小程序access_token
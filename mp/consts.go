@@ -2,9 +2,10 @@ package mp
 
 // auth
 const (
-	AccessTokenURL  = "https://api.weixin.qq.com/cgi-bin/token"
-	Code2SessionURL = "https://api.weixin.qq.com/sns/jscode2session"
-	PaidUnionURL    = "https://api.weixin.qq.com/wxa/getpaidunionid"
+	AccessTokenURL       = "https://api.weixin.qq.com/cgi-bin/token"
+	StableAccessTokenURL = "https://api.weixin.qq.com/cgi-bin/stable_token"
+	Code2SessionURL      = "https://api.weixin.qq.com/sns/jscode2session"
+	PaidUnionURL         = "https://api.weixin.qq.com/wxa/getpaidunionid"
 )
 
 // msg
@@ -23,6 +24,12 @@ const (
 	QRCodeGetUnlimitURL = "https://api.weixin.qq.com/wxa/getwxacodeunlimit"
 )
 
+// link
+const (
+	URLSchemeGenerateURL = "https://api.weixin.qq.com/wxa/generatescheme"
+	URLLinkGenerateURL   = "https://api.weixin.qq.com/wxa/generate_urllink"
+)
+
 // media
 const (
 	MediaUploadURL = "https://api.weixin.qq.com/cgi-bin/media/upload"
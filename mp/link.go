@@ -0,0 +1,127 @@
+package mp
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// 生成 URL Scheme / URL Link 的常见错误码（可通过 wx.IsCode 判断）
+const (
+	ErrCodeInvalidPath           = 40165 // path 不存在或非法
+	ErrCodeInvalidQuery          = 40212 // query 非法
+	ErrCodeLinkFrequencyLimit    = 44990 // 生成频率过快（超过100次/秒）
+	ErrCodeLinkQuotaReached      = 44993 // 单天生成数量超过上限
+	ErrCodeMiniProgramNotRelease = 85079 // 小程序未发布
+	ErrCodeInvalidExpireTime     = 85401 // 到期时间非法（须在1分钟到1年之间）
+	ErrCodeInvalidExpireInterval = 85402 // 到期天数非法（须在1到365天之间）
+)
+
+// URLSchemeRequest URL Scheme 生成参数
+type URLSchemeRequest struct {
+	Path       string // 小程序页面路径，必须是已经发布的小程序存在的页面，不可携带 query；为空时跳转小程序主页
+	Query      string // 进入小程序时的 query，最大1024个字符
+	EnvVersion string // 要打开的小程序版本：release（正式版，默认）、trial（体验版）、develop（开发版）
+	IsExpire   bool   // 生成的 scheme 码类型，到期失效：true，永久有效：false
+	ExpireTime int64  // 到期失效的 scheme 码的失效时间（Unix 时间戳），最长有效期为1年
+}
+
+// URLScheme URL Scheme
+type URLScheme struct {
+	OpenLink string `json:"openlink"`
+}
+
+// GenerateURLScheme 获取小程序 scheme 码（适用于短信、邮件、外部网页、微信内等拉起小程序的业务场景）
+func GenerateURLScheme(dest *URLScheme, req *URLSchemeRequest) wx.Action {
+	return wx.NewAction(URLSchemeGenerateURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			jumpWxa := wx.X{
+				"path":  req.Path,
+				"query": req.Query,
+			}
+
+			if req.EnvVersion != "" {
+				jumpWxa["env_version"] = req.EnvVersion
+			}
+
+			params := wx.X{"jump_wxa": jumpWxa}
+
+			if req.IsExpire {
+				params["is_expire"] = true
+				params["expire_time"] = req.ExpireTime
+			}
+
+			return wx.MarshalWithNoEscapeHTML(params)
+		}),
+		wx.WithDecode(func(resp []byte) error {
+			return json.Unmarshal(resp, dest)
+		}),
+	)
+}
+
+// GenerateURLScheme 获取小程序 scheme 码（通过 Exec 执行，自动获取普通AccessToken），返回 openlink
+// 微信返回错误时返回 *wx.APIError（常见错误码见 ErrCodeInvalidPath 等）
+func (mp *MP) GenerateURLScheme(ctx context.Context, req *URLSchemeRequest, options ...wx.HTTPOption) (string, error) {
+	scheme := new(URLScheme)
+
+	if err := mp.Exec(ctx, GenerateURLScheme(scheme, req), options...); err != nil {
+		return "", err
+	}
+
+	return scheme.OpenLink, nil
+}
+
+// URLLinkRequest URL Link 生成参数
+type URLLinkRequest struct {
+	Path       string // 小程序页面路径，必须是已经发布的小程序存在的页面，不可携带 query；为空时跳转小程序主页
+	Query      string // 进入小程序时的 query，最大1024个字符
+	EnvVersion string // 要打开的小程序版本：release（正式版，默认）、trial（体验版）、develop（开发版）
+	IsExpire   bool   // 生成的 URL Link 类型，到期失效：true，永久有效：false
+	ExpireTime int64  // 到期失效的 URL Link 的失效时间（Unix 时间戳），最长有效期为1年
+}
+
+// URLLink URL Link
+type URLLink struct {
+	URLLink string `json:"url_link"`
+}
+
+// GenerateURLLink 获取小程序 URL Link（适用于短信、邮件、网页、微信内等拉起小程序的业务场景）
+func GenerateURLLink(dest *URLLink, req *URLLinkRequest) wx.Action {
+	return wx.NewAction(URLLinkGenerateURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			params := wx.X{
+				"path":  req.Path,
+				"query": req.Query,
+			}
+
+			if req.EnvVersion != "" {
+				params["env_version"] = req.EnvVersion
+			}
+
+			if req.IsExpire {
+				params["is_expire"] = true
+				params["expire_time"] = req.ExpireTime
+			}
+
+			return wx.MarshalWithNoEscapeHTML(params)
+		}),
+		wx.WithDecode(func(resp []byte) error {
+			return json.Unmarshal(resp, dest)
+		}),
+	)
+}
+
+// GenerateURLLink 获取小程序 URL Link（通过 Exec 执行，自动获取普通AccessToken），返回 url_link
+// 微信返回错误时返回 *wx.APIError（常见错误码见 ErrCodeInvalidPath 等）
+func (mp *MP) GenerateURLLink(ctx context.Context, req *URLLinkRequest, options ...wx.HTTPOption) (string, error) {
+	link := new(URLLink)
+
+	if err := mp.Exec(ctx, GenerateURLLink(link, req), options...); err != nil {
+		return "", err
+	}
+
+	return link.URLLink, nil
+}
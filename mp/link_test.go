@@ -0,0 +1,91 @@
+package mp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateURLScheme(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/generatescheme?access_token=ACCESS_TOKEN", []byte(`{"expire_time":1606737600,"is_expire":true,"jump_wxa":{"path":"/pages/publishHomework/publishHomework","query":"a=1&b=2"}}`)).Return([]byte(`{
+		"errcode": 0,
+		"errmsg": "ok",
+		"openlink": "weixin://dl/business/?t=XTSkBZlzqmn"
+	}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	openlink, err := mp.GenerateURLScheme(context.TODO(), &URLSchemeRequest{
+		Path:       "/pages/publishHomework/publishHomework",
+		Query:      "a=1&b=2",
+		IsExpire:   true,
+		ExpireTime: 1606737600,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "weixin://dl/business/?t=XTSkBZlzqmn", openlink)
+}
+
+func TestGenerateURLSchemeError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/generatescheme?access_token=ACCESS_TOKEN", []byte(`{"jump_wxa":{"env_version":"trial","path":"/pages/index/index","query":""}}`)).Return([]byte(`{
+		"errcode": 40165,
+		"errmsg": "invalid weapp pagepath"
+	}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	openlink, err := mp.GenerateURLScheme(context.TODO(), &URLSchemeRequest{
+		Path:       "/pages/index/index",
+		EnvVersion: "trial",
+	})
+
+	assert.Empty(t, openlink)
+	assert.True(t, wx.IsCode(err, ErrCodeInvalidPath))
+}
+
+func TestGenerateURLLink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/generate_urllink?access_token=ACCESS_TOKEN", []byte(`{"expire_time":1606737600,"is_expire":true,"path":"/pages/publishHomework/publishHomework","query":"a=1&b=2"}`)).Return([]byte(`{
+		"errcode": 0,
+		"errmsg": "ok",
+		"url_link": "https://wxaurl.cn/BQZRrcFCPvg"
+	}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	link, err := mp.GenerateURLLink(context.TODO(), &URLLinkRequest{
+		Path:       "/pages/publishHomework/publishHomework",
+		Query:      "a=1&b=2",
+		IsExpire:   true,
+		ExpireTime: 1606737600,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://wxaurl.cn/BQZRrcFCPvg", link)
+}
@@ -1,7 +1,9 @@
 package mp
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/shenghui0779/gochat/wx"
 )
@@ -21,8 +23,16 @@ type UniformMessage struct {
 	OATemplateMessage *OATemplateMessage // 公众号模板消息相关的信息，可以参考公众号模板消息接口；有此节点并且没有 MPTemplateMessage 节点时，发送公众号模板消息
 }
 
+// 跳转小程序类型
+const (
+	MinipStateDeveloper = "developer" // 开发版
+	MinipStateTrial     = "trial"     // 体验版
+	MinipStateFormal    = "formal"    // 正式版
+)
+
 // SubscribeMessage 小程序订阅消息
 type SubscribeMessage struct {
+	ToUser     string      // 接收者openid（仅用于 MP.SendSubscribeMessage）
 	TemplateID string      // 所需下发的订阅模板ID
 	Page       string      // 点击模板卡片后的跳转页面，仅限本小程序内的页面。支持带参数,（示例index?foo=bar）。该字段不填则模板无跳转
 	Data       MessageBody // 模板内容，格式形如：{"key1": {"value": any}, "key2": {"value": any}}
@@ -120,6 +130,12 @@ func SendSubscribeMessage(openID string, msg *SubscribeMessage) wx.Action {
 			}
 
 			if msg.MinipState != "" {
+				switch msg.MinipState {
+				case MinipStateDeveloper, MinipStateTrial, MinipStateFormal:
+				default:
+					return nil, fmt.Errorf("gochat: invalid miniprogram_state: %s", msg.MinipState)
+				}
+
 				params["miniprogram_state"] = msg.MinipState
 			}
 
@@ -132,6 +148,11 @@ func SendSubscribeMessage(openID string, msg *SubscribeMessage) wx.Action {
 	)
 }
 
+// SendSubscribeMessage 发送订阅消息（接收者为 msg.ToUser）
+func (mp *MP) SendSubscribeMessage(ctx context.Context, msg *SubscribeMessage, options ...wx.HTTPOption) error {
+	return mp.Exec(ctx, SendSubscribeMessage(msg.ToUser, msg), options...)
+}
+
 // SendTemplateMessage 发送模板消息（已废弃，请使用订阅消息）
 func SendTemplateMessage(openID string, msg *TemplateMessage) wx.Action {
 	return wx.NewAction(TemplateMessageSendURL,
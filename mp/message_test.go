@@ -116,6 +116,44 @@ func TestSendSubscribeMessage(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestMPSendSubscribeMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/subscribe/send?access_token=ACCESS_TOKEN", []byte(`{"data":{"thing1":{"value":"TIT创意园"}},"lang":"zh_CN","miniprogram_state":"trial","page":"index","template_id":"TEMPLATE_ID","touser":"OPENID"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	msg := &SubscribeMessage{
+		ToUser:     "OPENID",
+		TemplateID: "TEMPLATE_ID",
+		Page:       "index",
+		Data: MessageBody{
+			"thing1": {
+				"value": "TIT创意园",
+			},
+		},
+		MinipState: MinipStateTrial,
+		Lang:       "zh_CN",
+	}
+
+	err := mp.SendSubscribeMessage(context.TODO(), msg)
+
+	assert.Nil(t, err)
+
+	// 不合法的 miniprogram_state 不会发送请求
+	msg.MinipState = "release"
+
+	err = mp.SendSubscribeMessage(context.TODO(), msg)
+
+	assert.NotNil(t, err)
+}
+
 func TestSendTemplateMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -2,12 +2,16 @@ package mp
 
 import (
 	"context"
+	"crypto/aes"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
 	"github.com/shenghui0779/gochat/event"
 	"github.com/shenghui0779/gochat/wx"
@@ -16,17 +20,105 @@ import (
 
 // MP 微信小程序
 type MP struct {
+	*wx.AccessTokenManager
+
 	appid          string
 	appsecret      string
 	token          string
 	encodingAESKey string
 	nonce          func(size int) string
 	client         wx.HTTPClient
+	stableToken    bool
+}
+
+// Option configures how we set up the MP
+type Option func(mp *MP)
+
+// WithHTTPClient specifies the *http.Client to send requests (eg: to customize connection pooling, dial timeout, proxy, etc.).
+func WithHTTPClient(c *http.Client) Option {
+	return func(mp *MP) {
+		mp.client = wx.NewHTTPClientWith(c)
+	}
+}
+
+// WithTransport specifies the *http.Transport to send requests (eg: to customize proxy, dial timeout, tls, etc.).
+func WithTransport(t *http.Transport) Option {
+	return func(mp *MP) {
+		mp.client = wx.NewHTTPClientWith(&http.Client{Transport: t})
+	}
+}
+
+// WithTokenStore specifies the store of access_token (default: in-memory store, refreshed 5 minutes before it expires),
+// eg: a redis store for multi-instance deployments; nil means fetching from wechat every time.
+func WithTokenStore(store wx.AccessTokenStore) Option {
+	return func(mp *MP) {
+		mp.SetTokenStore(store)
+	}
+}
+
+// WithTokenSafetyMargin specifies the margin to refresh access_token before it expires (default: 5 minutes, eg: refreshed at 6900s when expires_in is 7200s),
+// which applies to the token store implementing wx.AccessTokenMarginSetter (eg: the default in-memory store), regardless of the order of WithTokenStore.
+func WithTokenSafetyMargin(d time.Duration) Option {
+	return func(mp *MP) {
+		mp.SetTokenSafetyMargin(d)
+	}
+}
+
+// WithStableToken specifies fetching access_token by the stable endpoint (cgi-bin/stable_token, force_refresh=false),
+// which doesn't invalidate the access_token held by other services.
+func WithStableToken() Option {
+	return func(mp *MP) {
+		mp.stableToken = true
+	}
+}
+
+// WithAccessTokenFunc specifies the func to get access_token (eg: from a central token service),
+// when set, the SDK never fetches access_token itself and the token store is ignored.
+func WithAccessTokenFunc(f func(ctx context.Context) (string, error)) Option {
+	return func(mp *MP) {
+		mp.SetAccessTokenFunc(f)
+	}
+}
+
+// WithDebugFunc specifies the hook for tracing all the http requests of the MP (access_token and secret are redacted).
+func WithDebugFunc(f wx.DebugFunc) Option {
+	return func(mp *MP) {
+		mp.AddHTTPOptions(wx.WithDebugFunc(f))
+	}
+}
+
+// WithLogger specifies the hook for logging all the http requests of the MP, the url and bodies are passed raw (callers should redact the sensitive fields).
+func WithLogger(f wx.LoggerFunc) Option {
+	return func(mp *MP) {
+		mp.AddHTTPOptions(wx.WithLogger(f))
+	}
+}
+
+// WithMetrics specifies the hook for collecting metrics (eg: prometheus) of all the http requests of the MP.
+func WithMetrics(m wx.Metrics) Option {
+	return func(mp *MP) {
+		mp.AddHTTPOptions(wx.WithMetrics(m))
+	}
+}
+
+// WithLimiter specifies the rate limiter for all the http requests of the MP (eg: wx.NewTokenBucketLimiter),
+// which is consulted before each request, so that the api quotas are not exceeded (errcode 45009).
+func WithLimiter(l wx.Limiter) Option {
+	return func(mp *MP) {
+		mp.AddHTTPOptions(wx.WithLimiter(l))
+	}
+}
+
+// WithNonceFunc specifies the func to generate the nonce (eg: for testing), default is a random hex string of the size.
+func WithNonceFunc(f func(size int) string) Option {
+	return func(mp *MP) {
+		mp.nonce = f
+	}
 }
 
 // New returns new wechat mini program
-func New(appid, appsecret string) *MP {
-	return &MP{
+func New(appid, appsecret string, options ...Option) *MP {
+	mp := &MP{
 		appid:     appid,
 		appsecret: appsecret,
 		nonce: func(size int) string {
@@ -37,6 +129,14 @@ func New(appid, appsecret string) *MP {
 		},
 		client: wx.NewHTTPClient(),
 	}
+
+	mp.AccessTokenManager = wx.NewAccessTokenManager(appid, mp.fetchAccessToken)
+
+	for _, f := range options {
+		f(mp)
+	}
+
+	return mp
 }
 
 // SetServerConfig 设置服务器配置
@@ -46,18 +146,19 @@ func (mp *MP) SetServerConfig(token, encodingAESKey string) {
 	mp.encodingAESKey = encodingAESKey
 }
 
-// Code2Session 获取小程序授权的session_key
+// Code2Session 获取小程序授权的session_key（登录凭证校验）
+// [参考](https://developers.weixin.qq.com/miniprogram/dev/api-backend/open-api/login/auth.code2Session.html)
 func (mp *MP) Code2Session(ctx context.Context, code string, options ...wx.HTTPOption) (*AuthSession, error) {
-	resp, err := mp.client.Get(ctx, fmt.Sprintf("%s?appid=%s&secret=%s&js_code=%s&grant_type=authorization_code", Code2SessionURL, mp.appid, mp.appsecret, code), options...)
+	reqURL := fmt.Sprintf("%s?appid=%s&secret=%s&js_code=%s&grant_type=authorization_code", Code2SessionURL, mp.appid, mp.appsecret, code)
+
+	resp, err := mp.client.Get(ctx, reqURL, mp.HTTPOptions(options)...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	r := gjson.ParseBytes(resp)
-
-	if code := r.Get("errcode").Int(); code != 0 {
-		return nil, fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return nil, err
 	}
 
 	session := new(AuthSession)
@@ -69,18 +170,74 @@ func (mp *MP) Code2Session(ctx context.Context, code string, options ...wx.HTTPO
 	return session, nil
 }
 
-// AccessToken 获取小程序的access_token
+// AccessToken 获取小程序的access_token（优先从 AccessTokenStore 获取，默认存储在内存中），
+// 从 AccessTokenStore 获取时 ExpiresIn 为剩余有效期（存储未实现 wx.AccessTokenTTLGetter 时为0）
 func (mp *MP) AccessToken(ctx context.Context, options ...wx.HTTPOption) (*AccessToken, error) {
-	resp, err := mp.client.Get(ctx, fmt.Sprintf("%s?appid=%s&secret=%s&grant_type=client_credential", AccessTokenURL, mp.appid, mp.appsecret), options...)
+	token, ttl, err := mp.Token(ctx, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessToken{Token: token, ExpiresIn: int64(ttl / time.Second)}, nil
+}
+
+// fetchAccessToken 从微信获取access_token（由 wx.AccessTokenManager 缓存及刷新）
+func (mp *MP) fetchAccessToken(ctx context.Context, options ...wx.HTTPOption) (string, time.Duration, error) {
+	if mp.stableToken {
+		token, err := mp.StableAccessToken(ctx, false, options...)
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		return token.Token, time.Duration(token.ExpiresIn) * time.Second, nil
+	}
+
+	reqURL := fmt.Sprintf("%s?appid=%s&secret=%s&grant_type=client_credential", AccessTokenURL, mp.appid, mp.appsecret)
+
+	resp, err := mp.client.Get(ctx, reqURL, mp.HTTPOptions(options)...)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return "", 0, err
+	}
+
+	token := new(AccessToken)
+
+	if err = json.Unmarshal(resp, token); err != nil {
+		return "", 0, err
+	}
+
+	return token.Token, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// StableAccessToken 获取稳定版access_token（cgi-bin/stable_token），不会使其他服务持有的access_token失效
+// forceRefresh 为 true 时强制刷新，会使上一个access_token失效（每日限额20次，且间隔需大于30秒），请仅在必要时使用
+// [参考](https://developers.weixin.qq.com/doc/offiaccount/Basic_Information/getStableAccessToken.html)
+func (mp *MP) StableAccessToken(ctx context.Context, forceRefresh bool, options ...wx.HTTPOption) (*AccessToken, error) {
+	body, err := json.Marshal(wx.X{
+		"grant_type":    "client_credential",
+		"appid":         mp.appid,
+		"secret":        mp.appsecret,
+		"force_refresh": forceRefresh,
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	r := gjson.ParseBytes(resp)
+	resp, err := mp.client.Post(ctx, StableAccessTokenURL, body, mp.HTTPOptions(options)...)
 
-	if code := r.Get("errcode").Int(); code != 0 {
-		return nil, fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wx.DecodeAPIError(StableAccessTokenURL, resp); err != nil {
+		return nil, err
 	}
 
 	token := new(AccessToken)
@@ -92,24 +249,32 @@ func (mp *MP) AccessToken(ctx context.Context, options ...wx.HTTPOption) (*Acces
 	return token, nil
 }
 
-// DecryptAuthInfo 解密授权信息
-func (mp *MP) DecryptAuthInfo(dest AuthInfo, sessionKey, iv, encryptedData string) error {
+// ErrAppIDMismatch 解密数据中的 watermark.appid 与小程序的 appid 不一致
+var ErrAppIDMismatch = errors.New("gochat: appid mismatch")
+
+// DecryptData 解密开放数据（如：手机号、用户信息），AES-128-CBC，PKCS#7填充，并校验 watermark.appid
+// [参考](https://developers.weixin.qq.com/miniprogram/dev/framework/open-ability/signature.html)
+func (mp *MP) DecryptData(sessionKey, encryptedData, iv string) ([]byte, error) {
 	key, err := base64.StdEncoding.DecodeString(sessionKey)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	ivb, err := base64.StdEncoding.DecodeString(iv)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	cipherText, err := base64.StdEncoding.DecodeString(encryptedData)
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if len(cipherText) == 0 || len(cipherText)%aes.BlockSize != 0 {
+		return nil, errors.New("gochat: invalid encrypted data")
 	}
 
 	cbc := wx.NewCBCCrypto(key, ivb, wx.PKCS7)
@@ -117,18 +282,25 @@ func (mp *MP) DecryptAuthInfo(dest AuthInfo, sessionKey, iv, encryptedData strin
 	b, err := cbc.Decrypt(cipherText)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := json.Unmarshal(b, dest); err != nil {
-		return err
+	if appid := gjson.GetBytes(b, "watermark.appid").String(); appid != mp.appid {
+		return nil, fmt.Errorf("%w, want: %s, got: %s", ErrAppIDMismatch, mp.appid, appid)
 	}
 
-	if dest.AppID() != mp.appid {
-		return fmt.Errorf("appid mismatch, want: %s, got: %s", mp.appid, dest.AppID())
+	return b, nil
+}
+
+// DecryptAuthInfo 解密授权信息
+func (mp *MP) DecryptAuthInfo(dest AuthInfo, sessionKey, iv, encryptedData string) error {
+	b, err := mp.DecryptData(sessionKey, encryptedData, iv)
+
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return json.Unmarshal(b, dest)
 }
 
 // Do exec action
@@ -138,9 +310,11 @@ func (mp *MP) Do(ctx context.Context, accessToken string, action wx.Action, opti
 		err  error
 	)
 
+	reqURL := action.URL(accessToken)
+
 	switch action.Method() {
 	case wx.MethodGet:
-		resp, err = mp.client.Get(ctx, action.URL(accessToken), options...)
+		resp, err = mp.client.Get(ctx, reqURL, mp.HTTPOptions(options)...)
 	case wx.MethodPost:
 		var body []byte
 
@@ -150,19 +324,17 @@ func (mp *MP) Do(ctx context.Context, accessToken string, action wx.Action, opti
 			return err
 		}
 
-		resp, err = mp.client.Post(ctx, action.URL(accessToken), body, options...)
+		resp, err = mp.client.Post(ctx, reqURL, body, mp.HTTPOptions(options)...)
 	case wx.MethodUpload:
-		resp, err = mp.client.Upload(ctx, action.URL(accessToken), action.UploadForm(), options...)
+		resp, err = mp.client.Upload(ctx, reqURL, action.UploadForm(), mp.HTTPOptions(options)...)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	r := gjson.ParseBytes(resp)
-
-	if code := r.Get("errcode").Int(); code != 0 {
-		return fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return err
 	}
 
 	if action.Decode() == nil {
@@ -172,6 +344,14 @@ func (mp *MP) Do(ctx context.Context, accessToken string, action wx.Action, opti
 	return action.Decode()(resp)
 }
 
+// Exec 执行Action（自动通过 AccessToken 方法获取access_token）
+// 若返回 40001/42001（如：access_token 已被其它实例刷新），则强制刷新access_token后重试一次
+func (mp *MP) Exec(ctx context.Context, action wx.Action, options ...wx.HTTPOption) error {
+	return mp.DoWithToken(ctx, func(accessToken string) error {
+		return mp.Do(ctx, accessToken, action, options...)
+	}, options...)
+}
+
 // VerifyEventSign 验证事件消息签名
 // 验证消息来自微信服务器，使用：signature、timestamp、nonce；若验证成功，请原样返回echostr参数内容
 // 验证事件消息签名，使用：msg_signature、timestamp、nonce、msg_encrypt
@@ -2,13 +2,32 @@ package mp
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/stretchr/testify/assert"
 )
 
+// anyContext 匹配任意 context.Context（刷新AccessToken时使用不随调用方取消的 ctx）
+var anyContext = gomock.AssignableToTypeOf(reflect.TypeOf((*context.Context)(nil)).Elem())
+
+func TestWithNonceFunc(t *testing.T) {
+	mp := New("APPID", "APPSECRET", WithNonceFunc(func(size int) string {
+		return "Wm3WZYTPz0wzccnW"
+	}))
+
+	assert.Equal(t, "Wm3WZYTPz0wzccnW", mp.nonce(16))
+
+	// 默认为随机的十六进制字符串
+	assert.Len(t, New("APPID", "APPSECRET").nonce(16), 16)
+}
+
 func TestCode2Session(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -36,13 +55,37 @@ func TestCode2Session(t *testing.T) {
 	}, authSession)
 }
 
+func TestCode2SessionError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/sns/jscode2session?appid=APPID&secret=APPSECRET&js_code=JSCODE&grant_type=authorization_code").Return([]byte(`{
+		"errcode": 40029,
+		"errmsg": "invalid code"
+	}`), nil)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	authSession, err := mp.Code2Session(context.TODO(), "JSCODE")
+
+	assert.Nil(t, authSession)
+	assert.Equal(t, &wx.APIError{
+		ErrCode:    40029,
+		ErrMsg:     "invalid code",
+		RequestURL: "https://api.weixin.qq.com/sns/jscode2session",
+	}, err)
+}
+
 func TestAccessToken(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	client := wx.NewMockHTTPClient(ctrl)
 
-	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{
 		"access_token": "ACCESS_TOKEN",
 		"expires_in": 7200,
 		"errcode": 0,
@@ -59,6 +102,319 @@ func TestAccessToken(t *testing.T) {
 		Token:     "ACCESS_TOKEN",
 		ExpiresIn: 7200,
 	}, accessToken)
+
+	// 命中缓存时返回剩余有效期（默认提前5分钟刷新）
+	accessToken, err = mp.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.True(t, accessToken.ExpiresIn > 6890 && accessToken.ExpiresIn <= 6900)
+}
+
+func TestDoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN").Return([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`), nil)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	err := mp.Do(context.TODO(), "ACCESS_TOKEN", wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.True(t, wx.IsCode(err, wx.ErrCodeInvalidCredential))
+	assert.Equal(t, &wx.APIError{
+		ErrCode:    40001,
+		ErrMsg:     "invalid credential",
+		RequestURL: "https://api.weixin.qq.com/cgi-bin/test",
+	}, err)
+}
+
+func TestExec(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN1","expires_in":7200}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN1").Return([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`), nil),
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN2","expires_in":7200}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN2").Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil),
+	)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	err := mp.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.Nil(t, err)
+
+	// the refreshed token is cached
+	accessToken, err := mp.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN2", accessToken.Token)
+}
+
+func TestExecRetryOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil).Times(2)
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN").Return([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`), nil).Times(2)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	err := mp.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.True(t, wx.IsCode(err, wx.ErrCodeAccessTokenExpired))
+}
+
+func TestDecryptData(t *testing.T) {
+	sessionKey := "tiihtNczf5v6AKRyjwEUhQ=="
+	iv := "r7BXXKkLb8qrSNn05n0qiA=="
+	encryptedData := "CiyLU1Aw2KjvrjMdj8YKliAjtP4gsMZMQmRzooG2xrDcvSnxIMXFufNstNGTyaGS9uT5geRa0W4oTOb1WT7fJlAC+oNPdbB+3hVbJSRgv+4lGOETKUQz6OYStslQ142dNCuabNPGBzlooOmB231qMM85d2/fV6ChevvXvQP8Hkue1poOFtnEtpyxVLW1zAo6/1Xx1COxFvrc2d7UL/lmHInNlxuacJXwu0fjpXfz/YqYzBIBzD6WUfTIF9GRHpOn/Hz7saL8xz+W//FRAUid1OksQaQx4CMs8LOddcQhULW4ucetDf96JcR3g0gfRK4PC7E/r7Z6xNrXd2UIeorGj5Ef7b1pJAYB6Y5anaHqZ9J6nKEBvB4DnNLIVWSgARns/8wR2SiRS7MNACwTyrGvt9ts8p12PKFdlqYTopNHR1Vf7XjfhQlVsAJdNiKdYmYVoKlaRv85IfVunYzO0IKXsyl7JCUjCpoG20f0a04COwfneQAGGwd5oa+T8yO5hzuyDb/XcxxmK01EpqOyuxINew=="
+
+	mp := New("wx4f4bc4dec97d474b", "APPSECRET")
+
+	b, err := mp.DecryptData(sessionKey, encryptedData, iv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"openId":"oGZUI0egBJY1zhBYw2KhdUfwVJJE","nickName":"Band","gender":1,"language":"zh_CN","city":"Guangzhou","province":"Guangdong","country":"CN","avatarUrl":"http://wx.qlogo.cn/mmopen/vi_32/aSKcBBPpibyKNicHNTMM0qJVh8Kjgiak2AHWr8MHM4WgMEm7GFhsf8OYrySdbvAMvTsw3mo8ibKicsnfN5pRjl1p8HQ/0","unionId":"ocMvos6NjeKLIBqg5Mr9QjxrP1FA","watermark":{"timestamp":1477314187,"appid":"wx4f4bc4dec97d474b"}}`, string(b))
+
+	dest := new(UserInfo)
+
+	assert.Nil(t, mp.DecryptAuthInfo(dest, sessionKey, iv, encryptedData))
+	assert.Equal(t, "oGZUI0egBJY1zhBYw2KhdUfwVJJE", dest.OpenID)
+	assert.Equal(t, "ocMvos6NjeKLIBqg5Mr9QjxrP1FA", dest.UnionID)
+
+	// appid mismatch
+	_, err = New("APPID", "APPSECRET").DecryptData(sessionKey, encryptedData, iv)
+
+	assert.True(t, errors.Is(err, ErrAppIDMismatch))
+}
+
+func TestAccessTokenConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+
+		return []byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil
+	}).Times(1)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	var wg sync.WaitGroup
+
+	tokens := make([]string, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			accessToken, err := mp.AccessToken(context.TODO())
+
+			if err == nil {
+				tokens[i] = accessToken.Token
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, token := range tokens {
+		assert.Equal(t, "ACCESS_TOKEN", token)
+	}
+}
+
+func TestAccessTokenCanceledCaller(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		close(started)
+		<-release
+
+		// 首个调用方取消不影响刷新
+		assert.Nil(t, ctx.Err())
+
+		return []byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil
+	}).Times(1)
+
+	mp := New("APPID", "APPSECRET", WithTokenStore(nil))
+	mp.client = client
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	first := make(chan error, 1)
+
+	go func() {
+		_, err := mp.AccessToken(ctx)
+
+		first <- err
+	}()
+
+	<-started
+
+	second := make(chan *AccessToken, 1)
+
+	go func() {
+		accessToken, err := mp.AccessToken(context.TODO())
+
+		assert.Nil(t, err)
+
+		second <- accessToken
+	}()
+
+	// 等待第二个调用方加入同一次刷新
+	time.Sleep(50 * time.Millisecond)
+
+	// 首个调用方按自身的 ctx 返回
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-first)
+
+	close(release)
+
+	assert.Equal(t, "ACCESS_TOKEN", (<-second).Token)
+}
+
+func TestStableAccessToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(anyContext, "https://api.weixin.qq.com/cgi-bin/stable_token", []byte(`{"appid":"APPID","force_refresh":true,"grant_type":"client_credential","secret":"APPSECRET"}`)).Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	accessToken, err := mp.StableAccessToken(context.TODO(), true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessToken{
+		Token:     "ACCESS_TOKEN",
+		ExpiresIn: 7200,
+	}, accessToken)
+}
+
+func TestWithStableToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(anyContext, "https://api.weixin.qq.com/cgi-bin/stable_token", []byte(`{"appid":"APPID","force_refresh":false,"grant_type":"client_credential","secret":"APPSECRET"}`)).Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithStableToken())
+	mp.client = client
+
+	accessToken, err := mp.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessToken{
+		Token:     "ACCESS_TOKEN",
+		ExpiresIn: 7200,
+	}, accessToken)
+}
+
+func TestDoWithTokenRetry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN1","expires_in":7200}`), nil),
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN2","expires_in":7200}`), nil),
+	)
+
+	mp := New("APPID", "APPSECRET")
+	mp.client = client
+
+	tokens := make([]string, 0, 2)
+
+	err := mp.DoWithToken(context.TODO(), func(accessToken string) error {
+		tokens = append(tokens, accessToken)
+
+		if len(tokens) == 1 {
+			return wx.NewAPIError("https://api.weixin.qq.com/cgi-bin/test", wx.ErrCodeInvalidCredential, "invalid credential")
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ACCESS_TOKEN1", "ACCESS_TOKEN2"}, tokens)
+
+	// other errors are not retried
+	calls := 0
+
+	err = mp.DoWithToken(context.TODO(), func(accessToken string) error {
+		calls++
+
+		return wx.NewAPIError("https://api.weixin.qq.com/cgi-bin/test", wx.ErrCodeQuotaReached, "api freq out of limit")
+	})
+
+	assert.True(t, wx.IsCode(err, wx.ErrCodeQuotaReached))
+	assert.Equal(t, 1, calls)
+}
+
+func TestWithAccessTokenFunc(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// never fetch access_token
+	gomock.InOrder(
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN1").Return([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN2").Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil),
+	)
+
+	calls := 0
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		calls++
+
+		return fmt.Sprintf("ACCESS_TOKEN%d", calls), nil
+	}))
+	mp.client = client
+
+	err := mp.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	// fail fast
+	tokenErr := errors.New("token service unavailable")
+
+	mp = New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "", tokenErr
+	}))
+	mp.client = client
+
+	err = mp.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.True(t, errors.Is(err, tokenErr))
 }
 
 func TestVerifyEventSign(t *testing.T) {
@@ -85,3 +441,74 @@ func TestDecryptEventMessage(t *testing.T) {
 		"URL":          "http://182.92.100.180/webhook",
 	}, msg)
 }
+
+// clockTokenStore 使用假时钟的 AccessTokenStore，实现 wx.AccessTokenMarginSetter
+type clockTokenStore struct {
+	now      time.Time
+	token    string
+	expireAt time.Time
+	margin   time.Duration
+}
+
+func (s *clockTokenStore) Get(ctx context.Context) (string, error) {
+	if !s.now.Before(s.expireAt.Add(-s.margin)) {
+		return "", nil
+	}
+
+	return s.token, nil
+}
+
+func (s *clockTokenStore) Set(ctx context.Context, token string, ttl time.Duration) error {
+	s.token = token
+	s.expireAt = s.now.Add(ttl)
+
+	return nil
+}
+
+func (s *clockTokenStore) SetMargin(d time.Duration) {
+	s.margin = d
+}
+
+func TestTokenSafetyMargin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil),
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?appid=APPID&secret=APPSECRET&grant_type=client_credential").Return([]byte(`{"access_token":"ACCESS_TOKEN2","expires_in":7200}`), nil),
+	)
+
+	store := &clockTokenStore{now: time.Unix(1600000000, 0)}
+
+	// WithTokenSafetyMargin 与 WithTokenStore 的顺序无关
+	mp := New("APPID", "APPSECRET", WithTokenSafetyMargin(300*time.Second), WithTokenStore(store))
+	mp.client = client
+
+	assert.Equal(t, 300*time.Second, store.margin)
+
+	accessToken, err := mp.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", accessToken.Token)
+
+	store.now = store.now.Add(6899 * time.Second)
+
+	accessToken, err = mp.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", accessToken.Token)
+
+	// 6900秒时刷新
+	store.now = store.now.Add(time.Second)
+
+	accessToken, err = mp.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN2", accessToken.Token)
+
+	mp.SetTokenSafetyMargin(time.Minute)
+
+	assert.Equal(t, time.Minute, store.margin)
+}
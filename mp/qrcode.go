@@ -1,6 +1,10 @@
 package mp
 
-import "github.com/shenghui0779/gochat/wx"
+import (
+	"context"
+
+	"github.com/shenghui0779/gochat/wx"
+)
 
 type qrcodeSettings struct {
 	page      string
@@ -174,3 +178,54 @@ func GetUnlimitQRCode(dest *QRCode, scene string, options ...QRCodeOption) wx.Ac
 		}),
 	)
 }
+
+// QRCodeColor 小程序码线条颜色（RGB）
+type QRCodeColor struct {
+	R int
+	G int
+	B int
+}
+
+// QRCodeUnlimit 小程序码（数量不限）参数
+type QRCodeUnlimit struct {
+	Scene     string       // 最大32个可见字符，只支持数字，大小写英文以及部分特殊字符：!#$&'()*+,/:;=?@-._~
+	Page      string       // 必须是已经发布的小程序存在的页面，根路径前不要填加 /，不填则默认跳主页面
+	Width     int          // 二维码的宽度，单位 px，最小 280px，最大 1280px，默认 430px
+	AutoColor bool         // 自动配置线条颜色
+	LineColor *QRCodeColor // AutoColor 为 false 时生效
+	IsHyaline bool         // 是否需要透明底色
+}
+
+// GetUnlimitedQRCode 获取小程序码（数量不限）（通过 Exec 执行，自动获取普通AccessToken），返回图片内容
+// 微信返回错误（JSON）时返回 *wx.APIError
+func (mp *MP) GetUnlimitedQRCode(ctx context.Context, req *QRCodeUnlimit, options ...wx.HTTPOption) ([]byte, error) {
+	opts := make([]QRCodeOption, 0, 5)
+
+	if req.Page != "" {
+		opts = append(opts, WithQRCodePage(req.Page))
+	}
+
+	if req.Width != 0 {
+		opts = append(opts, WithQRCodeWidth(req.Width))
+	}
+
+	if req.AutoColor {
+		opts = append(opts, WithQRCodeAutoColor())
+	}
+
+	if req.LineColor != nil {
+		opts = append(opts, WithQRCodeLineColor(req.LineColor.R, req.LineColor.G, req.LineColor.B))
+	}
+
+	if req.IsHyaline {
+		opts = append(opts, WithQRCodeIsHyaline())
+	}
+
+	qrcode := new(QRCode)
+
+	if err := mp.Exec(ctx, GetUnlimitQRCode(qrcode, req.Scene, opts...), options...); err != nil {
+		return nil, err
+	}
+
+	return qrcode.Buffer, nil
+}
@@ -2,6 +2,7 @@ package mp
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -91,3 +92,54 @@ func TestGetUnlimitQRCode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "BUFFER", string(dest.Buffer))
 }
+
+func TestGetUnlimitedQRCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	png := []byte("\x89PNG\r\n\x1a\nBUFFER")
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/getwxacodeunlimit?access_token=ACCESS_TOKEN", []byte(`{"is_hyaline":true,"line_color":{"b":0,"g":0,"r":255},"page":"pages/index/index","scene":"a=1","width":430}`)).Return(png, nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	b, err := mp.GetUnlimitedQRCode(context.TODO(), &QRCodeUnlimit{
+		Scene:     "a=1",
+		Page:      "pages/index/index",
+		Width:     430,
+		LineColor: &QRCodeColor{R: 255},
+		IsHyaline: true,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, png, b)
+}
+
+func TestGetUnlimitedQRCodeError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/getwxacodeunlimit?access_token=ACCESS_TOKEN", []byte(`{"scene":"a=1"}`)).Return([]byte(`{"errcode":41030,"errmsg":"invalid page"}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	b, err := mp.GetUnlimitedQRCode(context.TODO(), &QRCodeUnlimit{Scene: "a=1"})
+
+	assert.Nil(t, b)
+
+	var apiErr *wx.APIError
+
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 41030, apiErr.ErrCode)
+	assert.Equal(t, "invalid page", apiErr.ErrMsg)
+}
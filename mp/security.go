@@ -1,6 +1,7 @@
 package mp
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/shenghui0779/gochat/wx"
@@ -58,3 +59,109 @@ func MsgSecCheck(content string) wx.Action {
 		}),
 	)
 }
+
+// 内容安全（2.0版本）的场景值
+const (
+	SecSceneProfile = 1 // 资料
+	SecSceneComment = 2 // 评论
+	SecSceneForum   = 3 // 论坛
+	SecSceneSocial  = 4 // 社交日志
+)
+
+// 内容安全（2.0版本）的建议
+const (
+	SecSuggestPass   = "pass"   // 通过
+	SecSuggestReview = "review" // 需人工审核
+	SecSuggestRisky  = "risky"  // 有风险
+)
+
+// SecCheckDetail 内容安全（2.0版本）的详细检测结果
+type SecCheckDetail struct {
+	Strategy string `json:"strategy"` // 策略类型
+	ErrCode  int    `json:"errcode"`  // 错误码，仅当该值为0时，该项结果有效
+	Suggest  string `json:"suggest"`  // 建议，有 risky、pass、review 三种值
+	Label    int    `json:"label"`    // 命中标签枚举值，100 正常；10001 广告；20001 时政；20002 色情；20003 辱骂；20006 违法犯罪；20008 欺诈；20012 低俗；20013 版权；21000 其他
+	Prob     int    `json:"prob"`     // 0-100，代表置信度，越高代表越有可能属于当前返回的标签（label）
+	Level    int    `json:"level"`    // 命中的自定义关键词的级别
+	Keyword  string `json:"keyword"`  // 命中的自定义关键词
+}
+
+// SecCheckResult 内容安全（2.0版本）的检测结果
+type SecCheckResult struct {
+	TraceID string            `json:"trace_id"` // 唯一请求标识，标记单次请求
+	Suggest string            `json:"-"`        // 综合结果（result.suggest），有 risky、pass、review 三种值
+	Label   int               `json:"-"`        // 综合结果（result.label），命中标签枚举值
+	Detail  []*SecCheckDetail `json:"detail"`   // 详细检测结果
+}
+
+// MsgSecCheckV2 检查一段文本是否含有违法违规内容（2.0版本，用户需在近两小时访问过小程序）
+func MsgSecCheckV2(dest *SecCheckResult, openid, content string, scene int) wx.Action {
+	return wx.NewAction(MsgSecCheckURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(wx.X{
+				"content": content,
+				"version": 2,
+				"scene":   scene,
+				"openid":  openid,
+			})
+		}),
+		wx.WithDecode(func(resp []byte) error {
+			if err := json.Unmarshal(resp, dest); err != nil {
+				return err
+			}
+
+			r := gjson.GetBytes(resp, "result")
+
+			dest.Suggest = r.Get("suggest").String()
+			dest.Label = int(r.Get("label").Int())
+
+			return nil
+		}),
+	)
+}
+
+// MsgSecCheck 检查一段文本是否含有违法违规内容（2.0版本，通过 Exec 执行，自动获取普通AccessToken）
+// 返回的 Suggest 为 risky 或 review 时，应拦截或人工审核该内容
+func (mp *MP) MsgSecCheck(ctx context.Context, openid, content string, scene int, options ...wx.HTTPOption) (*SecCheckResult, error) {
+	result := new(SecCheckResult)
+
+	if err := mp.Exec(ctx, MsgSecCheckV2(result, openid, content, scene), options...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// MediaSecCheckAsyncV2 异步校验图片/音频是否含有违法违规内容（2.0版本，检测结果在30分钟内推送至消息服务器）
+func MediaSecCheckAsyncV2(dest *MediaSecAsyncResult, openid string, mediaType SecMediaType, mediaURL string, scene int) wx.Action {
+	return wx.NewAction(MediaCheckAsyncURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return wx.MarshalWithNoEscapeHTML(wx.X{
+				"media_url":  mediaURL,
+				"media_type": mediaType,
+				"version":    2,
+				"scene":      scene,
+				"openid":     openid,
+			})
+		}),
+		wx.WithDecode(func(resp []byte) error {
+			dest.TraceID = gjson.GetBytes(resp, "trace_id").String()
+
+			return nil
+		}),
+	)
+}
+
+// MediaCheckAsync 异步校验图片/音频是否含有违法违规内容（2.0版本，通过 Exec 执行，自动获取普通AccessToken），返回 trace_id
+// 检测结果通过消息推送（wxa_media_check 事件）下发，可通过 trace_id 匹配
+func (mp *MP) MediaCheckAsync(ctx context.Context, openid string, mediaType SecMediaType, mediaURL string, scene int, options ...wx.HTTPOption) (string, error) {
+	result := new(MediaSecAsyncResult)
+
+	if err := mp.Exec(ctx, MediaSecCheckAsyncV2(result, openid, mediaType, mediaURL, scene), options...); err != nil {
+		return "", err
+	}
+
+	return result.TraceID, nil
+}
@@ -63,3 +63,89 @@ func TestMsgSecCheck(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestMsgSecCheckV2(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/msg_sec_check?access_token=ACCESS_TOKEN", []byte(`{"content":"hello world!","openid":"OPENID","scene":2,"version":2}`)).Return([]byte(`{
+		"errcode": 0,
+		"errmsg": "ok",
+		"result": {
+			"suggest": "risky",
+			"label": 20001
+		},
+		"detail": [
+			{
+				"strategy": "content_model",
+				"errcode": 0,
+				"suggest": "risky",
+				"label": 20006,
+				"prob": 90
+			},
+			{
+				"strategy": "keyword",
+				"errcode": 0,
+				"suggest": "pass",
+				"label": 20006,
+				"level": 20,
+				"keyword": "命中的关键词1"
+			}
+		],
+		"trace_id": "60ae120f-371d5872-7941a05b"
+	}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	result, err := mp.MsgSecCheck(context.TODO(), "OPENID", "hello world!", SecSceneComment)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &SecCheckResult{
+		TraceID: "60ae120f-371d5872-7941a05b",
+		Suggest: SecSuggestRisky,
+		Label:   20001,
+		Detail: []*SecCheckDetail{
+			{
+				Strategy: "content_model",
+				Suggest:  "risky",
+				Label:    20006,
+				Prob:     90,
+			},
+			{
+				Strategy: "keyword",
+				Suggest:  "pass",
+				Label:    20006,
+				Level:    20,
+				Keyword:  "命中的关键词1",
+			},
+		},
+	}, result)
+}
+
+func TestMediaCheckAsyncV2(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/wxa/media_check_async?access_token=ACCESS_TOKEN", []byte(`{"media_type":2,"media_url":"https://developers.weixin.qq.com/miniprogram/assets/images/head_global_z_@all.png","openid":"OPENID","scene":1,"version":2}`)).Return([]byte(`{
+		"errcode": 0,
+		"errmsg": "ok",
+		"trace_id": "967e945cd8a3e458f3c74dcb886068e9"
+	}`), nil)
+
+	mp := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	mp.client = client
+
+	traceID, err := mp.MediaCheckAsync(context.TODO(), "OPENID", SecMediaImage, "https://developers.weixin.qq.com/miniprogram/assets/images/head_global_z_@all.png", SecSceneProfile)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "967e945cd8a3e458f3c74dcb886068e9", traceID)
+}
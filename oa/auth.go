@@ -1,7 +1,10 @@
 package oa
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 
 	"github.com/shenghui0779/gochat/wx"
 )
@@ -51,6 +54,9 @@ type AuthUser struct {
 	Privilege  []string `json:"privilege"`
 }
 
+// UserInfo 网页授权（snsapi_userinfo）用户信息，区别于关注用户信息（SubscriberInfo）
+type UserInfo = AuthUser
+
 // GetAuthUser 获取授权用户信息（注意：使用网页授权的access_token）
 func GetAuthUser(dest *AuthUser, openid string) wx.Action {
 	return wx.NewAction(SnsUserInfoURL,
@@ -72,11 +78,21 @@ const (
 	JSAPITicket TicketType = "jsapi"
 )
 
-// JSSDKSign JS-SDK签名
+// JSSDKSign JS-SDK签名（字段与 wx.config 一致）
 type JSSDKSign struct {
-	Signature string `json:"signature"`
-	Noncestr  string `json:"noncestr"`
+	AppID     string `json:"appId"`
 	Timestamp int64  `json:"timestamp"`
+	Noncestr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+// SignJSSDK 生成 JS-SDK 签名，对 jsapi_ticket、noncestr、timestamp、url 按字典序拼接后进行 SHA1 签名
+// [参考](https://developers.weixin.qq.com/doc/offiaccount/OA_Web_Apps/JS-SDK.html#62)
+func SignJSSDK(ticket, nonce, url string, timestamp int64) string {
+	h := sha1.New()
+	h.Write([]byte(fmt.Sprintf("jsapi_ticket=%s&noncestr=%s&timestamp=%d&url=%s", ticket, nonce, timestamp, url)))
+
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // JSSDKTicket 公众号 JS-SDK ticket
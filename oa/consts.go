@@ -14,8 +14,9 @@ const AuthorizeURL = "https://open.weixin.qq.com/connect/oauth2/authorize"
 
 // cgi-bin
 const (
-	CgiBinAccessTokenURL = "https://api.weixin.qq.com/cgi-bin/token"
-	CgiBinTicketURL      = "https://api.weixin.qq.com/cgi-bin/ticket/getticket"
+	CgiBinAccessTokenURL       = "https://api.weixin.qq.com/cgi-bin/token"
+	CgiBinStableAccessTokenURL = "https://api.weixin.qq.com/cgi-bin/stable_token"
+	CgiBinTicketURL            = "https://api.weixin.qq.com/cgi-bin/ticket/getticket"
 )
 
 // menu
@@ -47,6 +48,16 @@ const (
 	UserRemarkSetURL      = "https://api.weixin.qq.com/cgi-bin/user/info/updateremark"
 )
 
+// tag
+const (
+	TagCreateURL         = "https://api.weixin.qq.com/cgi-bin/tags/create"
+	TagListURL           = "https://api.weixin.qq.com/cgi-bin/tags/get"
+	TagUpdateURL         = "https://api.weixin.qq.com/cgi-bin/tags/update"
+	TagDeleteURL         = "https://api.weixin.qq.com/cgi-bin/tags/delete"
+	TagBatchTaggingURL   = "https://api.weixin.qq.com/cgi-bin/tags/members/batchtagging"
+	TagBatchUntaggingURL = "https://api.weixin.qq.com/cgi-bin/tags/members/batchuntagging"
+)
+
 // message
 const (
 	TemplateListURL         = "https://api.weixin.qq.com/cgi-bin/template/get_all_private_template"
@@ -64,12 +75,14 @@ const (
 
 // media
 const (
-	MediaUploadURL     = "https://api.weixin.qq.com/cgi-bin/media/upload"
-	MediaGetURL        = "https://api.weixin.qq.com/cgi-bin/media/get"
-	NewsAddURL         = "https://api.weixin.qq.com/cgi-bin/material/add_news"
-	NewsImageUploadURL = "https://api.weixin.qq.com/cgi-bin/media/uploadimg"
-	MaterialAddURL     = "https://api.weixin.qq.com/cgi-bin/material/add_material"
-	MaterialDeleteURL  = "https://api.weixin.qq.com/cgi-bin/material/del_material"
+	MediaUploadURL      = "https://api.weixin.qq.com/cgi-bin/media/upload"
+	MediaGetURL         = "https://api.weixin.qq.com/cgi-bin/media/get"
+	NewsAddURL          = "https://api.weixin.qq.com/cgi-bin/material/add_news"
+	NewsImageUploadURL  = "https://api.weixin.qq.com/cgi-bin/media/uploadimg"
+	MaterialAddURL      = "https://api.weixin.qq.com/cgi-bin/material/add_material"
+	MaterialGetURL      = "https://api.weixin.qq.com/cgi-bin/material/get_material"
+	MaterialDeleteURL   = "https://api.weixin.qq.com/cgi-bin/material/del_material"
+	MaterialBatchGetURL = "https://api.weixin.qq.com/cgi-bin/material/batchget_material"
 )
 
 // image
@@ -1,8 +1,12 @@
 package oa
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/tidwall/gjson"
@@ -17,6 +21,7 @@ var (
 	MediaVoice MediaType = "voice" // 音频
 	MediaVideo MediaType = "video" // 视频
 	MediaThumb MediaType = "thumb" // 缩略图
+	MediaNews  MediaType = "news"  // 图文（仅用于获取永久素材列表）
 )
 
 // MediaUploadResult 临时素材上传结果
@@ -110,51 +115,115 @@ func UploadNewsImageByURL(dest *MaterialAddResult, filename, resourceURL string)
 	)
 }
 
-// AddMaterial 新增其他类型永久素材（支持图片、音频、缩略图）
-func AddMaterial(dest *MaterialAddResult, mediaType MediaType, filename string) wx.Action {
+// AddMaterial 新增其他类型永久素材（支持图片、音频、视频、缩略图）
+// 视频素材需通过 VideoDescription 附带 description 表单项，如：AddMaterial(dest, MediaVideo, filename, VideoDescription(title, introduction))
+func AddMaterial(dest *MaterialAddResult, mediaType MediaType, filename string, options ...wx.UploadOption) wx.Action {
 	return wx.NewAction(MaterialAddURL,
 		wx.WithMethod(wx.MethodUpload),
 		wx.WithQuery("type", string(mediaType)),
-		wx.WithUploadForm("media", filename),
+		wx.WithUploadForm("media", filename, options...),
 		wx.WithDecode(func(resp []byte) error {
 			return json.Unmarshal(resp, dest)
 		}),
 	)
 }
 
-// AddMaterialByURL 新增其他类型永久素材（支持图片、音频、缩略图）
-func AddMaterialByURL(dest *MaterialAddResult, mediaType MediaType, filename, resourceURL string) wx.Action {
-	return wx.NewAction(MaterialAddURL,
-		wx.WithMethod(wx.MethodUpload),
-		wx.WithQuery("type", string(mediaType)),
-		wx.WithUploadForm("media", filename, wx.WithResourceURL(resourceURL)),
-		wx.WithDecode(func(resp []byte) error {
-			return json.Unmarshal(resp, dest)
-		}),
-	)
+// AddMaterialByURL 新增其他类型永久素材（支持图片、音频、视频、缩略图）
+// 视频素材需通过 VideoDescription 附带 description 表单项
+func AddMaterialByURL(dest *MaterialAddResult, mediaType MediaType, filename, resourceURL string, options ...wx.UploadOption) wx.Action {
+	return AddMaterial(dest, mediaType, filename, append([]wx.UploadOption{wx.WithResourceURL(resourceURL)}, options...)...)
 }
 
 // UploadVideo 上传视频永久素材
 func UploadVideo(dest *MaterialAddResult, filename, title, introduction string) wx.Action {
-	return wx.NewAction(MaterialAddURL,
-		wx.WithMethod(wx.MethodUpload),
-		wx.WithQuery("type", string(MediaVideo)),
-		wx.WithUploadForm("media", filename, wx.WithExtraField("description", fmt.Sprintf(`{"title":"%s", "introduction":"%s"}`, title, introduction))),
+	return AddMaterial(dest, MediaVideo, filename, VideoDescription(title, introduction))
+}
+
+// UploadVideoByURL 上传视频永久素材
+func UploadVideoByURL(dest *MaterialAddResult, filename, title, introduction, resourceURL string) wx.Action {
+	return AddMaterialByURL(dest, MediaVideo, filename, resourceURL, VideoDescription(title, introduction))
+}
+
+// VideoDescription 视频永久素材的 description 表单项
+func VideoDescription(title, introduction string) wx.UploadOption {
+	b, _ := json.Marshal(wx.X{"title": title, "introduction": introduction}) // 字符串编码不会出错
+
+	return wx.WithFormPart(wx.FormPart{
+		FieldName:   "description",
+		ContentType: "application/json",
+		Content:     b,
+	})
+}
+
+// MaterialNewsItem 永久图文素材中的图文
+type MaterialNewsItem struct {
+	NewsArticle
+	URL string `json:"url"`
+}
+
+// Material 永久素材（图文素材返回 NewsItem，视频素材返回 Title、Description、DownURL，其他类型素材返回素材内容 Buffer）
+type Material struct {
+	NewsItem    []*MaterialNewsItem `json:"news_item"`
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	DownURL     string              `json:"down_url"`
+	Buffer      []byte              `json:"-"`
+}
+
+// GetMaterial 获取永久素材
+func GetMaterial(dest *Material, mediaID string) wx.Action {
+	return wx.NewAction(MaterialGetURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(wx.X{"media_id": mediaID})
+		}),
 		wx.WithDecode(func(resp []byte) error {
+			// 图片、音频、缩略图素材直接返回素材内容
+			if len(resp) == 0 || resp[0] != '{' || !gjson.ValidBytes(resp) {
+				dest.Buffer = resp
+
+				return nil
+			}
+
 			return json.Unmarshal(resp, dest)
 		}),
 	)
 }
 
-// UploadVideoByURL 上传视频永久素材
-func UploadVideoByURL(dest *MaterialAddResult, filename, title, introduction, resourceURL string) wx.Action {
-	return wx.NewAction(MaterialAddURL,
-		wx.WithMethod(wx.MethodUpload),
-		wx.WithQuery("type", string(MediaVideo)),
-		wx.WithUploadForm("media", filename,
-			wx.WithExtraField("description", fmt.Sprintf(`{"title":"%s", "introduction":"%s"}`, title, introduction)),
-			wx.WithResourceURL(resourceURL),
-		),
+// MaterialNewsContent 永久图文素材内容
+type MaterialNewsContent struct {
+	NewsItem   []*MaterialNewsItem `json:"news_item"`
+	CreateTime int64               `json:"create_time"`
+	UpdateTime int64               `json:"update_time"`
+}
+
+// MaterialListItem 永久素材列表项（图文素材返回 Content，其他类型素材返回 Name、URL）
+type MaterialListItem struct {
+	MediaID    string               `json:"media_id"`
+	Name       string               `json:"name"`
+	URL        string               `json:"url"`
+	Content    *MaterialNewsContent `json:"content"`
+	UpdateTime int64                `json:"update_time"`
+}
+
+// MaterialList 永久素材列表
+type MaterialList struct {
+	TotalCount int                 `json:"total_count"`
+	ItemCount  int                 `json:"item_count"`
+	Item       []*MaterialListItem `json:"item"`
+}
+
+// BatchGetMaterial 获取永久素材列表（素材类型：图片、视频、语音、图文；offset 从0开始，count 取值在1到20之间）
+func BatchGetMaterial(dest *MaterialList, mediaType MediaType, offset, count int) wx.Action {
+	return wx.NewAction(MaterialBatchGetURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(wx.X{
+				"type":   mediaType,
+				"offset": offset,
+				"count":  count,
+			})
+		}),
 		wx.WithDecode(func(resp []byte) error {
 			return json.Unmarshal(resp, dest)
 		}),
@@ -170,3 +239,78 @@ func DeleteMaterial(mediaID string) wx.Action {
 		}),
 	)
 }
+
+// MediaDownloadTo 下载临时素材并写入 w（流式写入，不会将素材整体读入内存）
+// 视频素材返回的是包含 video_url 的JSON，将原样写入 w；微信返回错误时返回 *wx.APIError
+func (oa *OA) MediaDownloadTo(ctx context.Context, mediaID string, w io.Writer, options ...wx.HTTPOption) error {
+	action := wx.NewAction(MediaGetURL,
+		wx.WithMethod(wx.MethodGet),
+		wx.WithQuery("media_id", mediaID),
+	)
+
+	return oa.DoWithToken(ctx, func(accessToken string) error {
+		reqURL := action.URL(accessToken)
+
+		body, header, err := oa.client.GetStream(ctx, reqURL, oa.HTTPOptions(options)...)
+
+		if err != nil {
+			return err
+		}
+
+		defer body.Close()
+
+		if !isJSONContent(header.Get("Content-Type")) {
+			_, err = io.Copy(w, body)
+
+			return err
+		}
+
+		b, err := ioutil.ReadAll(body)
+
+		if err != nil {
+			return err
+		}
+
+		if err = wx.DecodeAPIError(reqURL, b); err != nil {
+			return err
+		}
+
+		_, err = w.Write(b)
+
+		return err
+	}, options...)
+}
+
+// UploadMedia 上传临时素材（通过 Exec 执行，自动获取普通AccessToken），返回 media_id 与 created_at
+func (oa *OA) UploadMedia(ctx context.Context, mediaType MediaType, filename string, options ...wx.HTTPOption) (*MediaUploadResult, error) {
+	result := new(MediaUploadResult)
+
+	if err := oa.Exec(ctx, UploadMedia(result, mediaType, filename), options...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetMedia 获取临时素材（整体读入内存，较大的素材请使用 MediaDownloadTo）
+// 视频素材返回的是包含 video_url 的JSON；微信返回错误时返回 *wx.APIError
+func (oa *OA) GetMedia(ctx context.Context, mediaID string, options ...wx.HTTPOption) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	if err := oa.MediaDownloadTo(ctx, mediaID, buf, options...); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isJSONContent 微信接口出错时返回JSON，Content-Type 为 application/json 或 text/plain
+func isJSONContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || mediaType == "text/plain"
+}
@@ -1,7 +1,11 @@
 package oa
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -183,6 +187,35 @@ func TestAddMaterial(t *testing.T) {
 	}, dest)
 }
 
+func TestAddMaterialVideo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Upload(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/add_material?access_token=ACCESS_TOKEN&type=video", wx.NewUploadForm("media", "test.mp4", wx.WithFormPart(wx.FormPart{
+		FieldName:   "description",
+		ContentType: "application/json",
+		Content:     []byte(`{"introduction":"INTRODUCTION","title":"TITLE"}`),
+	}))).Return([]byte(`{
+		"errcode": 0,
+		"errmsg": "ok",
+		"media_id": "MEDIA_ID"
+	  }`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := new(MaterialAddResult)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", AddMaterial(dest, MediaVideo, "test.mp4", VideoDescription("TITLE", "INTRODUCTION")))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &MaterialAddResult{
+		MediaID: "MEDIA_ID",
+	}, dest)
+}
+
 func TestAddMaterialByURL(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -218,7 +251,11 @@ func TestUploadVideo(t *testing.T) {
 
 	client := wx.NewMockHTTPClient(ctrl)
 
-	client.EXPECT().Upload(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/add_material?access_token=ACCESS_TOKEN&type=video", wx.NewUploadForm("media", "test.mp4", wx.WithExtraField("description", `{"title":"TITLE", "introduction":"INTRODUCTION"}`))).Return([]byte(`{
+	client.EXPECT().Upload(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/add_material?access_token=ACCESS_TOKEN&type=video", wx.NewUploadForm("media", "test.mp4", wx.WithFormPart(wx.FormPart{
+		FieldName:   "description",
+		ContentType: "application/json",
+		Content:     []byte(`{"introduction":"INTRODUCTION","title":"TITLE"}`),
+	}))).Return([]byte(`{
 		"errcode": 0,
 		"errmsg": "ok",
 		"media_id": "MEDIA_ID",
@@ -247,7 +284,11 @@ func TestUploadVideoByURL(t *testing.T) {
 
 	client.EXPECT().Upload(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/add_material?access_token=ACCESS_TOKEN&type=video",
 		wx.NewUploadForm("media", "test.mp4",
-			wx.WithExtraField("description", `{"title":"TITLE", "introduction":"INTRODUCTION"}`),
+			wx.WithFormPart(wx.FormPart{
+				FieldName:   "description",
+				ContentType: "application/json",
+				Content:     []byte(`{"introduction":"INTRODUCTION","title":"TITLE"}`),
+			}),
 			wx.WithResourceURL("https://media.test.com/test.mp4"),
 		),
 	).Return([]byte(`{
@@ -286,3 +327,308 @@ func TestDeleteMaterial(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestGetMaterial(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/get_material?access_token=ACCESS_TOKEN", []byte(`{"media_id":"MEDIA_ID"}`)).Return([]byte(`{
+		"title": "TITLE",
+		"description": "DESCRIPTION",
+		"down_url": "DOWN_URL"
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := new(Material)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", GetMaterial(dest, "MEDIA_ID"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Material{
+		Title:       "TITLE",
+		Description: "DESCRIPTION",
+		DownURL:     "DOWN_URL",
+	}, dest)
+}
+
+func TestGetMaterialBuffer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/get_material?access_token=ACCESS_TOKEN", []byte(`{"media_id":"MEDIA_ID"}`)).Return([]byte("IMAGE"), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := new(Material)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", GetMaterial(dest, "MEDIA_ID"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Material{Buffer: []byte("IMAGE")}, dest)
+}
+
+func TestBatchGetMaterial(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/batchget_material?access_token=ACCESS_TOKEN", []byte(`{"count":2,"offset":10,"type":"image"}`)).Return([]byte(`{
+		"total_count": 12,
+		"item_count": 2,
+		"item": [
+			{
+				"media_id": "MEDIA_ID1",
+				"name": "NAME1",
+				"update_time": 1600000000,
+				"url": "URL1"
+			},
+			{
+				"media_id": "MEDIA_ID2",
+				"name": "NAME2",
+				"update_time": 1600000001,
+				"url": "URL2"
+			}
+		]
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := new(MaterialList)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", BatchGetMaterial(dest, MediaImage, 10, 2))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &MaterialList{
+		TotalCount: 12,
+		ItemCount:  2,
+		Item: []*MaterialListItem{
+			{
+				MediaID:    "MEDIA_ID1",
+				Name:       "NAME1",
+				URL:        "URL1",
+				UpdateTime: 1600000000,
+			},
+			{
+				MediaID:    "MEDIA_ID2",
+				Name:       "NAME2",
+				URL:        "URL2",
+				UpdateTime: 1600000001,
+			},
+		},
+	}, dest)
+}
+
+func TestBatchGetMaterialNews(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/material/batchget_material?access_token=ACCESS_TOKEN", []byte(`{"count":1,"offset":0,"type":"news"}`)).Return([]byte(`{
+		"total_count": 1,
+		"item_count": 1,
+		"item": [
+			{
+				"media_id": "MEDIA_ID",
+				"content": {
+					"news_item": [
+						{
+							"title": "TITLE",
+							"thumb_media_id": "THUMB_MEDIA_ID",
+							"show_cover_pic": 1,
+							"author": "AUTHOR",
+							"digest": "DIGEST",
+							"content": "CONTENT",
+							"url": "URL",
+							"content_source_url": "CONTETN_SOURCE_URL"
+						}
+					]
+				},
+				"update_time": 1600000000
+			}
+		]
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := new(MaterialList)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", BatchGetMaterial(dest, MediaNews, 0, 1))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &MaterialList{
+		TotalCount: 1,
+		ItemCount:  1,
+		Item: []*MaterialListItem{
+			{
+				MediaID: "MEDIA_ID",
+				Content: &MaterialNewsContent{
+					NewsItem: []*MaterialNewsItem{
+						{
+							NewsArticle: NewsArticle{
+								Title:            "TITLE",
+								ThumbMediaID:     "THUMB_MEDIA_ID",
+								ShowCoverPic:     1,
+								Author:           "AUTHOR",
+								Digest:           "DIGEST",
+								Content:          "CONTENT",
+								ContentSourceURL: "CONTETN_SOURCE_URL",
+							},
+							URL: "URL",
+						},
+					},
+				},
+				UpdateTime: 1600000000,
+			},
+		},
+	}, dest)
+}
+
+func TestMediaDownloadTo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	header := make(http.Header)
+	header.Set("Content-Type", "voice/speex")
+
+	client.EXPECT().GetStream(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/media/get?access_token=ACCESS_TOKEN&media_id=MEDIA_ID").Return(ioutil.NopCloser(strings.NewReader("VOICE")), header, nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	buf := new(bytes.Buffer)
+
+	err := oa.MediaDownloadTo(context.TODO(), "MEDIA_ID", buf)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "VOICE", buf.String())
+}
+
+func TestMediaDownloadToError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json; encoding=utf-8")
+
+	client.EXPECT().GetStream(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/media/get?access_token=ACCESS_TOKEN&media_id=MEDIA_ID").Return(ioutil.NopCloser(strings.NewReader(`{"errcode":40007,"errmsg":"invalid media_id"}`)), header, nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	buf := new(bytes.Buffer)
+
+	err := oa.MediaDownloadTo(context.TODO(), "MEDIA_ID", buf)
+
+	assert.True(t, wx.IsCode(err, 40007))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestOAUploadMedia(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Upload(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", wx.NewUploadForm("media", "test.jpg")).Return([]byte(`{
+		"type": "image",
+		"media_id": "MEDIA_ID",
+		"created_at": 1606717010
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	result, err := oa.UploadMedia(context.TODO(), MediaImage, "test.jpg")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &MediaUploadResult{
+		Type:      "image",
+		MediaID:   "MEDIA_ID",
+		CreatedAt: 1606717010,
+	}, result)
+}
+
+func TestOAUploadMediaError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Upload(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", wx.NewUploadForm("media", "test.jpg")).Return([]byte(`{"errcode":40004,"errmsg":"invalid media type"}`), nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	result, err := oa.UploadMedia(context.TODO(), MediaImage, "test.jpg")
+
+	assert.Nil(t, result)
+	assert.True(t, wx.IsCode(err, 40004))
+}
+
+func TestGetMedia(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	header := make(http.Header)
+	header.Set("Content-Type", "image/jpeg")
+
+	client.EXPECT().GetStream(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/media/get?access_token=ACCESS_TOKEN&media_id=MEDIA_ID").Return(ioutil.NopCloser(bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xe0})), header, nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	b, err := oa.GetMedia(context.TODO(), "MEDIA_ID")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff, 0xd8, 0xff, 0xe0}, b)
+}
+
+func TestGetMediaError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain")
+
+	client.EXPECT().GetStream(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/media/get?access_token=ACCESS_TOKEN&media_id=MEDIA_ID").Return(ioutil.NopCloser(strings.NewReader(`{"errcode":40007,"errmsg":"invalid media_id"}`)), header, nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	b, err := oa.GetMedia(context.TODO(), "MEDIA_ID")
+
+	assert.Nil(t, b)
+	assert.True(t, wx.IsCode(err, 40007))
+	assert.Equal(t, "invalid media_id", err.(*wx.APIError).ErrMsg)
+}
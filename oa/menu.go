@@ -1,7 +1,9 @@
 package oa
 
 import (
+	"context"
 	"encoding/json"
+	"strconv"
 
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/tidwall/gjson"
@@ -79,7 +81,12 @@ func CreateMenu(buttons ...*MenuButton) wx.Action {
 
 // CreateConditionalMenu 创建个性化菜单
 func CreateConditionalMenu(matchRule *MenuMatchRule, buttons ...*MenuButton) wx.Action {
-	return wx.NewAction(MenuAddConditionalURL,
+	return createConditionalMenu(nil, matchRule, buttons...)
+}
+
+// createConditionalMenu 创建个性化菜单，dest 不为空时解析返回的 menuid（微信返回的 menuid 为字符串）
+func createConditionalMenu(dest *int64, matchRule *MenuMatchRule, buttons ...*MenuButton) wx.Action {
+	options := []wx.ActionOption{
 		wx.WithMethod(wx.MethodPost),
 		wx.WithBody(func() ([]byte, error) {
 			return json.Marshal(wx.X{
@@ -87,7 +94,17 @@ func CreateConditionalMenu(matchRule *MenuMatchRule, buttons ...*MenuButton) wx.
 				"matchrule": matchRule,
 			})
 		}),
-	)
+	}
+
+	if dest != nil {
+		options = append(options, wx.WithDecode(func(resp []byte) error {
+			*dest = gjson.GetBytes(resp, "menuid").Int()
+
+			return nil
+		}))
+	}
+
+	return wx.NewAction(MenuAddConditionalURL, options...)
 }
 
 // TryMatchMenu 测试匹配个性化菜单
@@ -240,3 +257,52 @@ func MinipButton(name, appid, pagepath, redirectURL string) *MenuButton {
 		Pagepath: pagepath,
 	}
 }
+
+// CreateMenu 创建自定义菜单（通过 Exec 执行，自动获取普通AccessToken），一级菜单最多3个，二级菜单（SubButton）最多5个
+func (oa *OA) CreateMenu(ctx context.Context, buttons []*MenuButton, options ...wx.HTTPOption) error {
+	return oa.Exec(ctx, CreateMenu(buttons...), options...)
+}
+
+// GetMenu 查询自定义菜单（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) GetMenu(ctx context.Context, options ...wx.HTTPOption) (*MenuInfo, error) {
+	info := new(MenuInfo)
+
+	if err := oa.Exec(ctx, GetMenu(info), options...); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// DeleteMenu 删除自定义菜单（同时删除全部个性化菜单）
+func (oa *OA) DeleteMenu(ctx context.Context, options ...wx.HTTPOption) error {
+	return oa.Exec(ctx, DeleteMenu(), options...)
+}
+
+// CreateConditionalMenu 创建个性化菜单（通过 Exec 执行，自动获取普通AccessToken），返回菜单ID（menuid）
+// 注意：须先创建默认菜单（CreateMenu），matchRule 中至少有一个字段不为空
+func (oa *OA) CreateConditionalMenu(ctx context.Context, buttons []*MenuButton, matchRule *MenuMatchRule, options ...wx.HTTPOption) (int64, error) {
+	var menuID int64
+
+	if err := oa.Exec(ctx, createConditionalMenu(&menuID, matchRule, buttons...), options...); err != nil {
+		return 0, err
+	}
+
+	return menuID, nil
+}
+
+// DeleteConditionalMenu 删除个性化菜单（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) DeleteConditionalMenu(ctx context.Context, menuID int64, options ...wx.HTTPOption) error {
+	return oa.Exec(ctx, DeleteConditionalMenu(strconv.FormatInt(menuID, 10)), options...)
+}
+
+// TryMatchMenu 测试个性化菜单匹配结果（通过 Exec 执行，自动获取普通AccessToken），userID 可以是粉丝的OpenID，也可以是粉丝的微信号
+func (oa *OA) TryMatchMenu(ctx context.Context, userID string, options ...wx.HTTPOption) ([]*MenuButton, error) {
+	buttons := make([]*MenuButton, 0)
+
+	if err := oa.Exec(ctx, TryMatchMenu(&buttons, userID), options...); err != nil {
+		return nil, err
+	}
+
+	return buttons, nil
+}
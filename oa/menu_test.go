@@ -263,3 +263,237 @@ func TestDeleteConditionalMenu(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestOACreateMenu(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/menu/create?access_token=ACCESS_TOKEN", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body []byte, options ...wx.HTTPOption) ([]byte, error) {
+		// 两级菜单：一级菜单无 type，子菜单位于 sub_button
+		assert.JSONEq(t, `{
+			"button": [
+				{
+					"type": "click",
+					"name": "今日歌曲",
+					"key": "V1001_TODAY_MUSIC"
+				},
+				{
+					"name": "菜单",
+					"sub_button": [
+						{
+							"type": "view",
+							"name": "搜索",
+							"url": "http://www.soso.com/"
+						},
+						{
+							"type": "miniprogram",
+							"name": "wxa",
+							"url": "http://mp.weixin.qq.com",
+							"appid": "wx286b93c14bbf93aa",
+							"pagepath": "pages/lunar/index"
+						},
+						{
+							"type": "scancode_push",
+							"name": "扫码推事件",
+							"key": "rselfmenu_0_1"
+						}
+					]
+				}
+			]
+		}`, string(body))
+
+		return []byte(`{"errcode":0,"errmsg":"ok"}`), nil
+	})
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.CreateMenu(context.TODO(), []*MenuButton{
+		ClickButton("今日歌曲", "V1001_TODAY_MUSIC"),
+		GroupButton("菜单",
+			ViewButton("搜索", "http://www.soso.com/"),
+			MinipButton("wxa", "wx286b93c14bbf93aa", "pages/lunar/index", "http://mp.weixin.qq.com"),
+			ScanCodePushButton("扫码推事件", "rselfmenu_0_1"),
+		),
+	})
+
+	assert.Nil(t, err)
+}
+
+func TestOAGetMenu(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/menu/get?access_token=ACCESS_TOKEN").Return([]byte(`{
+		"menu": {
+			"button": [
+				{
+					"name": "菜单",
+					"sub_button": [
+						{
+							"type": "scancode_push",
+							"name": "扫码推事件",
+							"key": "rselfmenu_0_1",
+							"sub_button": []
+						}
+					]
+				}
+			]
+		}
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	info, err := oa.GetMenu(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, &MenuInfo{
+		Menu: Menu{
+			Button: []*MenuButton{
+				{
+					Name: "菜单",
+					SubButton: []*MenuButton{
+						{
+							Type:      ButtonScanCodePush,
+							Name:      "扫码推事件",
+							Key:       "rselfmenu_0_1",
+							SubButton: []*MenuButton{},
+						},
+					},
+				},
+			},
+		},
+	}, info)
+}
+
+func TestOADeleteMenu(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/menu/delete?access_token=ACCESS_TOKEN").Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	assert.Nil(t, oa.DeleteMenu(context.TODO()))
+}
+
+func TestOACreateConditionalMenu(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/menu/addconditional?access_token=ACCESS_TOKEN", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body []byte, options ...wx.HTTPOption) ([]byte, error) {
+		// 未设置的匹配规则不做匹配，不出现在 matchrule 中
+		assert.JSONEq(t, `{
+			"button": [
+				{
+					"type": "click",
+					"name": "今日歌曲",
+					"key": "V1001_TODAY_MUSIC"
+				},
+				{
+					"name": "菜单",
+					"sub_button": [
+						{
+							"type": "view",
+							"name": "搜索",
+							"url": "http://www.soso.com/"
+						}
+					]
+				}
+			],
+			"matchrule": {
+				"tag_id": "2",
+				"sex": "1",
+				"country": "中国",
+				"province": "广东",
+				"city": "广州",
+				"client_platform_type": "2",
+				"language": "zh_CN"
+			}
+		}`, string(body))
+
+		return []byte(`{"menuid":"208379533"}`), nil
+	})
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	menuID, err := oa.CreateConditionalMenu(context.TODO(), []*MenuButton{
+		ClickButton("今日歌曲", "V1001_TODAY_MUSIC"),
+		GroupButton("菜单", ViewButton("搜索", "http://www.soso.com/")),
+	}, &MenuMatchRule{
+		TagID:              "2",
+		Sex:                "1",
+		Country:            "中国",
+		Province:           "广东",
+		City:               "广州",
+		ClientPlatformType: "2",
+		Language:           "zh_CN",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(208379533), menuID)
+}
+
+func TestOADeleteConditionalMenu(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/menu/delconditional?access_token=ACCESS_TOKEN", []byte(`{"menuid":"208379533"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	assert.Nil(t, oa.DeleteConditionalMenu(context.TODO(), 208379533))
+}
+
+func TestOATryMatchMenu(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/menu/trymatch?access_token=ACCESS_TOKEN", []byte(`{"user_id":"weixin"}`)).Return([]byte(`{
+		"button": [
+			{
+				"type": "view",
+				"name": "tx",
+				"url": "http://www.qq.com/",
+				"sub_button": []
+			}
+		]
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	buttons, err := oa.TryMatchMenu(context.TODO(), "weixin")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*MenuButton{
+		{
+			Type:      ButtonView,
+			Name:      "tx",
+			URL:       "http://www.qq.com/",
+			SubButton: []*MenuButton{},
+		},
+	}, buttons)
+}
@@ -1,7 +1,9 @@
 package oa
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/tidwall/gjson"
@@ -37,8 +39,14 @@ func DeleteTemplate(templateID string) wx.Action {
 	)
 }
 
-// MessageBody 消息内容体
-type MessageBody map[string]map[string]string
+// TemplateDataItem 模板内容项
+type TemplateDataItem struct {
+	Value string `json:"value"`           // 内容
+	Color string `json:"color,omitempty"` // 字体颜色，不填默认为黑色
+}
+
+// MessageBody 消息内容体（编码时按 key 排序）
+type MessageBody map[string]TemplateDataItem
 
 // MessageMinip 跳转小程序
 type MessageMinip struct {
@@ -48,6 +56,7 @@ type MessageMinip struct {
 
 // TemplateMessage 公众号模板消息
 type TemplateMessage struct {
+	ToUser      string        // 接收者openid（仅用于 OA.SendTemplateMessage）
 	TemplateID  string        // 模板ID
 	URL         string        // 模板跳转链接（海外帐号没有跳转能力）
 	MiniProgram *MessageMinip // 跳转小程序
@@ -56,7 +65,22 @@ type TemplateMessage struct {
 
 // SendTemplateMessage 发送模板消息
 func SendTemplateMessage(openID string, msg *TemplateMessage) wx.Action {
-	return wx.NewAction(TemplateMessageSendURL,
+	return sendTemplateMessage(openID, msg, nil)
+}
+
+// SendTemplateMessage 发送模板消息（接收者为 msg.ToUser），返回消息id（msgid）
+func (oa *OA) SendTemplateMessage(ctx context.Context, msg *TemplateMessage, options ...wx.HTTPOption) (int64, error) {
+	var msgID int64
+
+	if err := oa.Exec(ctx, sendTemplateMessage(msg.ToUser, msg, &msgID), options...); err != nil {
+		return 0, err
+	}
+
+	return msgID, nil
+}
+
+func sendTemplateMessage(openID string, msg *TemplateMessage, msgID *int64) wx.Action {
+	options := []wx.ActionOption{
 		wx.WithMethod(wx.MethodPost),
 		wx.WithBody(func() ([]byte, error) {
 			params := wx.X{
@@ -75,7 +99,17 @@ func SendTemplateMessage(openID string, msg *TemplateMessage) wx.Action {
 
 			return json.Marshal(params)
 		}),
-	)
+	}
+
+	if msgID != nil {
+		options = append(options, wx.WithDecode(func(resp []byte) error {
+			*msgID = gjson.GetBytes(resp, "msgid").Int()
+
+			return nil
+		}))
+	}
+
+	return wx.NewAction(TemplateMessageSendURL, options...)
 }
 
 // SendSubscribeMessage 发送一次性订阅消息
@@ -382,6 +416,82 @@ func SendKFMinipMessage(openID string, msg *KFMinipMessage, kfAccount ...string)
 	)
 }
 
+// KFMessage 客服消息内容，可选：KFTextMessage、KFImageMessage、KFVoiceMessage、KFNewsMessage、KFMinipMessage
+type KFMessage interface {
+	kfMsgType() string
+}
+
+// KFTextMessage 客服文本消息
+type KFTextMessage struct {
+	Content string `json:"content"` // 文本消息内容（支持插入跳小程序的文字链）
+}
+
+// KFImageMessage 客服图片消息
+type KFImageMessage struct {
+	MediaID string `json:"media_id"` // 图片的媒体ID，通过素材接口上传获得
+}
+
+// KFVoiceMessage 客服语音消息
+type KFVoiceMessage struct {
+	MediaID string `json:"media_id"` // 语音的媒体ID，通过素材接口上传获得
+}
+
+// KFNewsMessage 客服图文消息（点击跳转到外链，图文消息条数限制在1条以内）
+type KFNewsMessage struct {
+	Articles []*KFArticle `json:"articles"`
+}
+
+func (m *KFTextMessage) kfMsgType() string  { return "text" }
+func (m *KFImageMessage) kfMsgType() string { return "image" }
+func (m *KFVoiceMessage) kfMsgType() string { return "voice" }
+func (m *KFNewsMessage) kfMsgType() string  { return "news" }
+func (m *KFMinipMessage) kfMsgType() string { return "miniprogrampage" }
+
+// CustomMessage 客服消息，msgtype 由 Content 的类型决定，消息内容以 msgtype 为键
+type CustomMessage struct {
+	ToUser    string    // 接收者openid
+	Content   KFMessage // 消息内容
+	KFAccount string    // 以某个客服帐号来发消息（可选）
+}
+
+// MarshalJSON 序列化为 message/custom/send 的请求体
+func (m *CustomMessage) MarshalJSON() ([]byte, error) {
+	if m.Content == nil {
+		return nil, errors.New("gochat: custom message content is required")
+	}
+
+	msgType := m.Content.kfMsgType()
+
+	data := wx.X{
+		"touser":  m.ToUser,
+		"msgtype": msgType,
+		msgType:   m.Content,
+	}
+
+	if m.KFAccount != "" {
+		data["customservice"] = wx.X{
+			"kf_account": m.KFAccount,
+		}
+	}
+
+	return json.Marshal(data)
+}
+
+// SendCustomMessage 发送客服消息
+func SendCustomMessage(msg *CustomMessage) wx.Action {
+	return wx.NewAction(KFMessageSendURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(msg)
+		}),
+	)
+}
+
+// SendCustomMessage 发送客服消息（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) SendCustomMessage(ctx context.Context, msg *CustomMessage, options ...wx.HTTPOption) error {
+	return oa.Exec(ctx, SendCustomMessage(msg), options...)
+}
+
 // TypeCommand 输入状态命令
 type TypeCommand string
 
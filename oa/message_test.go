@@ -2,6 +2,7 @@ package oa
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -68,7 +69,7 @@ func TestSendTemplateMessage(t *testing.T) {
 
 	client := wx.NewMockHTTPClient(ctrl)
 
-	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/template/send?access_token=ACCESS_TOKEN", []byte(`{"data":{"first":{"color":"#173177","value":"恭喜你购买成功！"},"keyword1":{"color":"#173177","value":"巧克力"},"remark":{"color":"#173177","value":"欢迎再次购买！"}},"miniprogram":{"appid":"xiaochengxuappid12345","pagepath":"index?foo=bar"},"template_id":"ngqIpbwh8bUfcSsECmogfXcV14J0tQlEpBO27izEYtY","touser":"OPENID","url":"http://weixin.qq.com/download"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/template/send?access_token=ACCESS_TOKEN", []byte(`{"data":{"first":{"value":"恭喜你购买成功！","color":"#173177"},"keyword1":{"value":"巧克力","color":"#173177"},"remark":{"value":"欢迎再次购买！","color":"#173177"}},"miniprogram":{"appid":"xiaochengxuappid12345","pagepath":"index?foo=bar"},"template_id":"ngqIpbwh8bUfcSsECmogfXcV14J0tQlEpBO27izEYtY","touser":"OPENID","url":"http://weixin.qq.com/download"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
 
 	oa := New("APPID", "APPSECRET")
 	oa.client = client
@@ -82,16 +83,16 @@ func TestSendTemplateMessage(t *testing.T) {
 		},
 		Data: MessageBody{
 			"first": {
-				"value": "恭喜你购买成功！",
-				"color": "#173177",
+				Value: "恭喜你购买成功！",
+				Color: "#173177",
 			},
 			"keyword1": {
-				"value": "巧克力",
-				"color": "#173177",
+				Value: "巧克力",
+				Color: "#173177",
 			},
 			"remark": {
-				"value": "欢迎再次购买！",
-				"color": "#173177",
+				Value: "欢迎再次购买！",
+				Color: "#173177",
 			},
 		},
 	}
@@ -101,13 +102,49 @@ func TestSendTemplateMessage(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestOASendTemplateMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/template/send?access_token=ACCESS_TOKEN", []byte(`{"data":{"first":{"value":"恭喜你购买成功！","color":"#173177"},"keyword1":{"value":"巧克力"},"remark":{"value":"欢迎再次购买！","color":"#173177"}},"template_id":"ngqIpbwh8bUfcSsECmogfXcV14J0tQlEpBO27izEYtY","touser":"OPENID","url":"http://weixin.qq.com/download"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok","msgid":200228332}`), nil)
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "ACCESS_TOKEN", nil
+	}))
+	oa.client = client
+
+	msgID, err := oa.SendTemplateMessage(context.TODO(), &TemplateMessage{
+		ToUser:     "OPENID",
+		TemplateID: "ngqIpbwh8bUfcSsECmogfXcV14J0tQlEpBO27izEYtY",
+		URL:        "http://weixin.qq.com/download",
+		Data: MessageBody{
+			"first": {
+				Value: "恭喜你购买成功！",
+				Color: "#173177",
+			},
+			"keyword1": {
+				Value: "巧克力",
+			},
+			"remark": {
+				Value: "欢迎再次购买！",
+				Color: "#173177",
+			},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200228332), msgID)
+}
+
 func TestSendSubscribeMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	client := wx.NewMockHTTPClient(ctrl)
 
-	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/template/subscribe?access_token=ACCESS_TOKEN", []byte(`{"data":{"content":{"color":"COLOR","value":"VALUE"}},"miniprogram":{"appid":"xiaochengxuappid12345","pagepath":"index?foo=bar"},"scene":"SCENE","template_id":"TEMPLATE_ID","title":"TITLE","touser":"OPENID","url":"URL"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/template/subscribe?access_token=ACCESS_TOKEN", []byte(`{"data":{"content":{"value":"VALUE","color":"COLOR"}},"miniprogram":{"appid":"xiaochengxuappid12345","pagepath":"index?foo=bar"},"scene":"SCENE","template_id":"TEMPLATE_ID","title":"TITLE","touser":"OPENID","url":"URL"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
 
 	oa := New("APPID", "APPSECRET")
 	oa.client = client
@@ -121,8 +158,8 @@ func TestSendSubscribeMessage(t *testing.T) {
 		},
 		Data: MessageBody{
 			"content": {
-				"value": "VALUE",
-				"color": "COLOR",
+				Value: "VALUE",
+				Color: "COLOR",
 			},
 		},
 	}
@@ -338,6 +375,74 @@ func TestSendKFMinipMessage(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestOASendCustomTextMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=ACCESS_TOKEN", []byte(`{"customservice":{"kf_account":"test1@kftest"},"msgtype":"text","text":{"content":"Hello World"},"touser":"OPENID"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.SendCustomMessage(context.TODO(), &CustomMessage{
+		ToUser:    "OPENID",
+		Content:   &KFTextMessage{Content: "Hello World"},
+		KFAccount: "test1@kftest",
+	})
+
+	assert.Nil(t, err)
+}
+
+func TestOASendCustomMinipMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=ACCESS_TOKEN", []byte(`{"miniprogrampage":{"title":"title","appid":"appid","pagepath":"pagepath","thumb_media_id":"thumb_media_id"},"msgtype":"miniprogrampage","touser":"OPENID"}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.SendCustomMessage(context.TODO(), &CustomMessage{
+		ToUser: "OPENID",
+		Content: &KFMinipMessage{
+			Title:        "title",
+			AppID:        "appid",
+			Pagepath:     "pagepath",
+			ThumbMediaID: "thumb_media_id",
+		},
+	})
+
+	assert.Nil(t, err)
+}
+
+func TestCustomMessageMarshal(t *testing.T) {
+	cases := []struct {
+		content KFMessage
+		want    string
+	}{
+		{&KFImageMessage{MediaID: "MEDIA_ID"}, `{"image":{"media_id":"MEDIA_ID"},"msgtype":"image","touser":"OPENID"}`},
+		{&KFVoiceMessage{MediaID: "MEDIA_ID"}, `{"msgtype":"voice","touser":"OPENID","voice":{"media_id":"MEDIA_ID"}}`},
+		{&KFNewsMessage{Articles: []*KFArticle{{Title: "Happy Day", Description: "Is Really A Happy Day", URL: "URL", PicURL: "PIC_URL"}}}, `{"msgtype":"news","news":{"articles":[{"title":"Happy Day","description":"Is Really A Happy Day","url":"URL","picurl":"PIC_URL"}]},"touser":"OPENID"}`},
+	}
+
+	for _, c := range cases {
+		b, err := json.Marshal(&CustomMessage{ToUser: "OPENID", Content: c.content})
+
+		assert.Nil(t, err)
+		assert.Equal(t, c.want, string(b))
+	}
+
+	_, err := json.Marshal(&CustomMessage{ToUser: "OPENID"})
+
+	assert.Contains(t, err.Error(), "gochat: custom message content is required")
+}
+
 func TestSetTyping(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
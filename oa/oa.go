@@ -3,21 +3,23 @@ package oa
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/shenghui0779/gochat/event"
 	"github.com/shenghui0779/gochat/wx"
-	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
 )
 
 // OA 微信公众号
 type OA struct {
+	*wx.AccessTokenManager
+
 	appid          string
 	appsecret      string
 	originid       string
@@ -25,11 +27,98 @@ type OA struct {
 	encodingAESKey string
 	nonce          func(size int) string
 	client         wx.HTTPClient
+	ticketGroup    singleflight.Group
+	stableToken    bool
+}
+
+// Option configures how we set up the OA
+type Option func(oa *OA)
+
+// WithHTTPClient specifies the *http.Client to send requests (eg: to customize connection pooling, dial timeout, proxy, etc.).
+func WithHTTPClient(c *http.Client) Option {
+	return func(oa *OA) {
+		oa.client = wx.NewHTTPClientWith(c)
+	}
+}
+
+// WithTransport specifies the *http.Transport to send requests (eg: to customize proxy, dial timeout, tls, etc.).
+func WithTransport(t *http.Transport) Option {
+	return func(oa *OA) {
+		oa.client = wx.NewHTTPClientWith(&http.Client{Transport: t})
+	}
+}
+
+// WithTokenStore specifies the store of 普通AccessToken (default: in-memory store, refreshed 5 minutes before it expires),
+// eg: a redis store for multi-instance deployments; nil means fetching from wechat every time.
+func WithTokenStore(store wx.AccessTokenStore) Option {
+	return func(oa *OA) {
+		oa.SetTokenStore(store)
+	}
+}
+
+// WithTokenSafetyMargin specifies the margin to refresh 普通AccessToken before it expires (default: 5 minutes, eg: refreshed at 6900s when expires_in is 7200s),
+// which applies to the token store implementing wx.AccessTokenMarginSetter (eg: the default in-memory store), regardless of the order of WithTokenStore.
+func WithTokenSafetyMargin(d time.Duration) Option {
+	return func(oa *OA) {
+		oa.SetTokenSafetyMargin(d)
+	}
+}
+
+// WithStableToken specifies fetching 普通AccessToken by the stable endpoint (cgi-bin/stable_token, force_refresh=false),
+// which doesn't invalidate the 普通AccessToken held by other services.
+func WithStableToken() Option {
+	return func(oa *OA) {
+		oa.stableToken = true
+	}
+}
+
+// WithAccessTokenFunc specifies the func to get 普通AccessToken (eg: from a central token service),
+// when set, the SDK never fetches 普通AccessToken itself and the token store is ignored.
+func WithAccessTokenFunc(f func(ctx context.Context) (string, error)) Option {
+	return func(oa *OA) {
+		oa.SetAccessTokenFunc(f)
+	}
+}
+
+// WithDebugFunc specifies the hook for tracing all the http requests of the OA (access_token and secret are redacted).
+func WithDebugFunc(f wx.DebugFunc) Option {
+	return func(oa *OA) {
+		oa.AddHTTPOptions(wx.WithDebugFunc(f))
+	}
+}
+
+// WithLogger specifies the hook for logging all the http requests of the OA, the url and bodies are passed raw (callers should redact the sensitive fields).
+func WithLogger(f wx.LoggerFunc) Option {
+	return func(oa *OA) {
+		oa.AddHTTPOptions(wx.WithLogger(f))
+	}
+}
+
+// WithMetrics specifies the hook for collecting metrics (eg: prometheus) of all the http requests of the OA.
+func WithMetrics(m wx.Metrics) Option {
+	return func(oa *OA) {
+		oa.AddHTTPOptions(wx.WithMetrics(m))
+	}
+}
+
+// WithLimiter specifies the rate limiter for all the http requests of the OA (eg: wx.NewTokenBucketLimiter),
+// which is consulted before each request, so that the api quotas are not exceeded (errcode 45009).
+func WithLimiter(l wx.Limiter) Option {
+	return func(oa *OA) {
+		oa.AddHTTPOptions(wx.WithLimiter(l))
+	}
+}
+
+// WithNonceFunc specifies the func to generate the nonce (eg: for testing), default is a random hex string of the size.
+func WithNonceFunc(f func(size int) string) Option {
+	return func(oa *OA) {
+		oa.nonce = f
+	}
 }
 
 // New returns new OA
-func New(appid, appsecret string) *OA {
-	return &OA{
+func New(appid, appsecret string, options ...Option) *OA {
+	oa := &OA{
 		appid:     appid,
 		appsecret: appsecret,
 		nonce: func(size int) string {
@@ -40,6 +129,14 @@ func New(appid, appsecret string) *OA {
 		},
 		client: wx.NewHTTPClient(),
 	}
+
+	oa.AccessTokenManager = wx.NewAccessTokenManager(appid, oa.fetchAccessToken)
+
+	for _, f := range options {
+		f(oa)
+	}
+
+	return oa
 }
 
 // SetOriginID 设置原始ID（开发者微信号）
@@ -68,16 +165,16 @@ func (oa *OA) AuthURL(scope AuthScope, redirectURL string, state ...string) stri
 
 // Code2AuthToken 获取网页授权AccessToken
 func (oa *OA) Code2AuthToken(ctx context.Context, code string, options ...wx.HTTPOption) (*AuthToken, error) {
-	resp, err := oa.client.Get(ctx, fmt.Sprintf("%s?appid=%s&secret=%s&code=%s&grant_type=authorization_code", SnsCode2TokenURL, oa.appid, oa.appsecret, code), options...)
+	reqURL := fmt.Sprintf("%s?appid=%s&secret=%s&code=%s&grant_type=authorization_code", SnsCode2TokenURL, oa.appid, oa.appsecret, code)
+
+	resp, err := oa.client.Get(ctx, reqURL, oa.HTTPOptions(options)...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	r := gjson.ParseBytes(resp)
-
-	if code := r.Get("errcode").Int(); code != 0 {
-		return nil, fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return nil, err
 	}
 
 	token := new(AuthToken)
@@ -91,16 +188,16 @@ func (oa *OA) Code2AuthToken(ctx context.Context, code string, options ...wx.HTT
 
 // RefreshAuthToken 刷新网页授权AccessToken
 func (oa *OA) RefreshAuthToken(ctx context.Context, refreshToken string, options ...wx.HTTPOption) (*AuthToken, error) {
-	resp, err := oa.client.Get(ctx, fmt.Sprintf("%s?appid=%s&grant_type=refresh_token&refresh_token=%s", SnsRefreshAccessTokenURL, oa.appid, refreshToken), options...)
+	reqURL := fmt.Sprintf("%s?appid=%s&grant_type=refresh_token&refresh_token=%s", SnsRefreshAccessTokenURL, oa.appid, refreshToken)
+
+	resp, err := oa.client.Get(ctx, reqURL, oa.HTTPOptions(options)...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	r := gjson.ParseBytes(resp)
-
-	if code := r.Get("errcode").Int(); code != 0 {
-		return nil, fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return nil, err
 	}
 
 	token := new(AuthToken)
@@ -112,18 +209,74 @@ func (oa *OA) RefreshAuthToken(ctx context.Context, refreshToken string, options
 	return token, nil
 }
 
-// AccessToken 获取普通AccessToken
+// AccessToken 获取普通AccessToken（优先从 AccessTokenStore 获取，默认存储在内存中），
+// 从 AccessTokenStore 获取时 ExpiresIn 为剩余有效期（存储未实现 wx.AccessTokenTTLGetter 时为0）
 func (oa *OA) AccessToken(ctx context.Context, options ...wx.HTTPOption) (*AccessToken, error) {
-	resp, err := oa.client.Get(ctx, fmt.Sprintf("%s?grant_type=client_credential&appid=%s&secret=%s", CgiBinAccessTokenURL, oa.appid, oa.appsecret), options...)
+	token, ttl, err := oa.Token(ctx, options...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	r := gjson.ParseBytes(resp)
+	return &AccessToken{Token: token, ExpiresIn: int64(ttl / time.Second)}, nil
+}
+
+// fetchAccessToken 从微信获取普通AccessToken（由 wx.AccessTokenManager 缓存及刷新）
+func (oa *OA) fetchAccessToken(ctx context.Context, options ...wx.HTTPOption) (string, time.Duration, error) {
+	if oa.stableToken {
+		token, err := oa.StableAccessToken(ctx, false, options...)
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		return token.Token, time.Duration(token.ExpiresIn) * time.Second, nil
+	}
+
+	reqURL := fmt.Sprintf("%s?grant_type=client_credential&appid=%s&secret=%s", CgiBinAccessTokenURL, oa.appid, oa.appsecret)
 
-	if code := r.Get("errcode").Int(); code != 0 {
-		return nil, fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	resp, err := oa.client.Get(ctx, reqURL, oa.HTTPOptions(options)...)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return "", 0, err
+	}
+
+	token := new(AccessToken)
+
+	if err = json.Unmarshal(resp, token); err != nil {
+		return "", 0, err
+	}
+
+	return token.Token, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// StableAccessToken 获取稳定版普通AccessToken（cgi-bin/stable_token），不会使其他服务持有的普通AccessToken失效
+// forceRefresh 为 true 时强制刷新，会使上一个普通AccessToken失效（每日限额20次，且间隔需大于30秒），请仅在必要时使用
+// [参考](https://developers.weixin.qq.com/doc/offiaccount/Basic_Information/getStableAccessToken.html)
+func (oa *OA) StableAccessToken(ctx context.Context, forceRefresh bool, options ...wx.HTTPOption) (*AccessToken, error) {
+	body, err := json.Marshal(wx.X{
+		"grant_type":    "client_credential",
+		"appid":         oa.appid,
+		"secret":        oa.appsecret,
+		"force_refresh": forceRefresh,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := oa.client.Post(ctx, CgiBinStableAccessTokenURL, body, oa.HTTPOptions(options)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wx.DecodeAPIError(CgiBinStableAccessTokenURL, resp); err != nil {
+		return nil, err
 	}
 
 	token := new(AccessToken)
@@ -142,29 +295,31 @@ func (oa *OA) Do(ctx context.Context, accessToken string, action wx.Action, opti
 		err  error
 	)
 
+	reqURL := action.URL(accessToken)
+
 	switch action.Method() {
 	case wx.MethodGet:
-		resp, err = oa.client.Get(ctx, action.URL(accessToken), options...)
+		resp, err = oa.client.Get(ctx, reqURL, oa.HTTPOptions(options)...)
 	case wx.MethodPost:
-		body, err := action.Body()
+		var body []byte
+
+		body, err = action.Body()
 
 		if err != nil {
 			return err
 		}
 
-		resp, err = oa.client.Post(ctx, action.URL(accessToken), body, options...)
+		resp, err = oa.client.Post(ctx, reqURL, body, oa.HTTPOptions(options)...)
 	case wx.MethodUpload:
-		resp, err = oa.client.Upload(ctx, action.URL(accessToken), action.UploadForm(), options...)
+		resp, err = oa.client.Upload(ctx, reqURL, action.UploadForm(), oa.HTTPOptions(options)...)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	r := gjson.ParseBytes(resp)
-
-	if code := r.Get("errcode").Int(); code != 0 {
-		return fmt.Errorf("%d|%s", code, r.Get("errmsg").String())
+	if err = wx.DecodeAPIError(reqURL, resp); err != nil {
+		return err
 	}
 
 	if action.Decode() == nil {
@@ -174,6 +329,14 @@ func (oa *OA) Do(ctx context.Context, accessToken string, action wx.Action, opti
 	return action.Decode()(resp)
 }
 
+// Exec 执行Action（自动通过 AccessToken 方法获取AccessToken）
+// 若返回 40001/42001（如：AccessToken 已被其它实例刷新），则强制刷新AccessToken后重试一次
+func (oa *OA) Exec(ctx context.Context, action wx.Action, options ...wx.HTTPOption) error {
+	return oa.DoWithToken(ctx, func(accessToken string) error {
+		return oa.Do(ctx, accessToken, action, options...)
+	}, options...)
+}
+
 // VerifyEventSign 验证消息事件签名
 // 验证消息来自微信服务器，使用：signature、timestamp、nonce；若验证成功，请原样返回echostr参数内容
 // 验证事件消息签名，使用：msg_signature、timestamp、nonce、msg_encrypt
@@ -184,9 +347,26 @@ func (oa *OA) VerifyEventSign(signature string, items ...string) bool {
 	return signStr == signature
 }
 
+// DecryptMessage 安全模式（aes）消息解密，返回消息XML
+// 使用 EncodingAESKey 进行 AES-256-CBC 解密，去除16字节随机串与4字节内容长度，并校验末尾的AppID
+func (oa *OA) DecryptMessage(encodingAESKey, encrypt string) ([]byte, error) {
+	return event.Decrypt(oa.appid, encodingAESKey, encrypt)
+}
+
+// EncryptMessage 安全模式（aes）消息加密，返回base64编码的密文（用于被动回复消息的 Encrypt）
+func (oa *OA) EncryptMessage(encodingAESKey string, msg []byte) (string, error) {
+	cipherText, err := event.Encrypt(oa.appid, encodingAESKey, oa.nonce(16), msg)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
 // DecryptEventMessage 事件消息解密
 func (oa *OA) DecryptEventMessage(encrypt string) (wx.WXML, error) {
-	b, err := event.Decrypt(oa.appid, oa.encodingAESKey, encrypt)
+	b, err := oa.DecryptMessage(oa.encodingAESKey, encrypt)
 
 	if err != nil {
 		return nil, err
@@ -203,7 +383,11 @@ func (oa *OA) Reply(openid string, reply event.Reply) (*event.ReplyMessage, erro
 		return nil, err
 	}
 
-	// 消息加密
+	return oa.EncryptReply(body)
+}
+
+// EncryptReply 加密被动回复消息（安全模式），body 为明文XML（如：ReplyText 生成的消息）
+func (oa *OA) EncryptReply(body []byte) (*event.ReplyMessage, error) {
 	cipherText, err := event.Encrypt(oa.appid, oa.encodingAESKey, oa.nonce(16), body)
 
 	if err != nil {
@@ -213,17 +397,47 @@ func (oa *OA) Reply(openid string, reply event.Reply) (*event.ReplyMessage, erro
 	return event.BuildReply(oa.token, oa.nonce(16), base64.StdEncoding.EncodeToString(cipherText)), nil
 }
 
-// JSSDKSign 生成 JS-SDK 签名
+// JSAPITicket 获取 JS-SDK jsapi_ticket（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) JSAPITicket(ctx context.Context, options ...wx.HTTPOption) (string, error) {
+	// 并发调用共享同一次请求，请求不随调用方的 ctx 取消
+	v, err := wx.SingleflightDo(ctx, &oa.ticketGroup, "jsapi_ticket:"+oa.appid, wx.DefaultTokenRefreshTimeout, func(ctx context.Context) (interface{}, error) {
+		ticket := new(JSSDKTicket)
+
+		if err := oa.Exec(ctx, GetJSSDKTicket(ticket, JSAPITicket), options...); err != nil {
+			return nil, err
+		}
+
+		return ticket.Ticket, nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return v.(string), nil
+}
+
+// UserInfo 获取网页授权用户信息（sns/userinfo），需 snsapi_userinfo 授权
+// 注意：accessToken 为网页授权的access_token（见 Code2AuthToken），而非普通AccessToken；用户无需关注公众号
+func (oa *OA) UserInfo(ctx context.Context, accessToken, openid string, options ...wx.HTTPOption) (*UserInfo, error) {
+	info := new(UserInfo)
+
+	if err := oa.Do(ctx, accessToken, GetAuthUser(info, openid), options...); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// JSSDKSign 生成 JS-SDK 签名（返回结果可直接用于 wx.config）
 func (oa *OA) JSSDKSign(jsapiTicket, url string) *JSSDKSign {
 	noncestr := oa.nonce(16)
 	now := time.Now().Unix()
 
-	h := sha1.New()
-	h.Write([]byte(fmt.Sprintf("jsapi_ticket=%s&noncestr=%s&timestamp=%d&url=%s", jsapiTicket, noncestr, now, url)))
-
 	return &JSSDKSign{
-		Signature: hex.EncodeToString(h.Sum(nil)),
-		Noncestr:  noncestr,
+		AppID:     oa.appid,
 		Timestamp: now,
+		Noncestr:  noncestr,
+		Signature: SignJSSDK(jsapiTicket, noncestr, url, now),
 	}
 }
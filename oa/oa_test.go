@@ -2,18 +2,29 @@ package oa
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/event"
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/stretchr/testify/assert"
 )
 
+// anyContext 匹配任意 context.Context（刷新AccessToken时使用不随调用方取消的 ctx）
+var anyContext = gomock.AssignableToTypeOf(reflect.TypeOf((*context.Context)(nil)).Elem())
+
 func TestAuthURL(t *testing.T) {
-	oa := New("APPID", "APPSECRET")
-	oa.nonce = func(size int) string {
+	oa := New("APPID", "APPSECRET", WithNonceFunc(func(size int) string {
 		return "STATE"
-	}
+	}))
 
 	assert.Equal(t, "https://open.weixin.qq.com/connect/oauth2/authorize?appid=APPID&redirect_uri=RedirectURL&response_type=code&scope=snsapi_base&state=STATE#wechat_redirect", oa.AuthURL(ScopeSnsapiBase, "RedirectURL"))
 	assert.Equal(t, "https://open.weixin.qq.com/connect/oauth2/authorize?appid=APPID&redirect_uri=RedirectURL&response_type=code&scope=snsapi_userinfo&state=STATE#wechat_redirect", oa.AuthURL(ScopeSnsapiUser, "RedirectURL"))
@@ -83,7 +94,7 @@ func TestAccessToken(t *testing.T) {
 
 	client := wx.NewMockHTTPClient(ctrl)
 
-	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{
 		"access_token": "39_VzXkFDAJsEVTWbXUZDU3NqHtP6mzcAA7RJvcy1o9e-7fdJ-UuxPYLdBFMiGhpdoeKqVWMGqBe8ldUrMasRv1z_T8RmHKDiybC29wZ_vexHlyQ5YDGb33rff1mBNpOLM9f5nv7oag8UYBSc79ASMcAAADVP",
 		"expires_in": 7200
 	}`), nil)
@@ -98,6 +109,470 @@ func TestAccessToken(t *testing.T) {
 		Token:     "39_VzXkFDAJsEVTWbXUZDU3NqHtP6mzcAA7RJvcy1o9e-7fdJ-UuxPYLdBFMiGhpdoeKqVWMGqBe8ldUrMasRv1z_T8RmHKDiybC29wZ_vexHlyQ5YDGb33rff1mBNpOLM9f5nv7oag8UYBSc79ASMcAAADVP",
 		ExpiresIn: 7200,
 	}, accessToken)
+
+	// 命中缓存时返回剩余有效期（默认提前5分钟刷新）
+	accessToken, err = oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.True(t, accessToken.ExpiresIn > 6890 && accessToken.ExpiresIn <= 6900)
+}
+
+func TestAccessTokenWithStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+
+		return []byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil
+	}).Times(1)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	var wg sync.WaitGroup
+
+	tokens := make([]string, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			accessToken, err := oa.AccessToken(context.TODO())
+
+			if err == nil {
+				tokens[i] = accessToken.Token
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, token := range tokens {
+		assert.Equal(t, "ACCESS_TOKEN", token)
+	}
+}
+
+func TestAccessTokenWithoutStoreConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+
+		return []byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil
+	}).Times(1)
+
+	oa := New("APPID", "APPSECRET", WithTokenStore(nil))
+	oa.client = client
+
+	var wg sync.WaitGroup
+
+	tokens := make([]string, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			accessToken, err := oa.AccessToken(context.TODO())
+
+			if err == nil {
+				tokens[i] = accessToken.Token
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, token := range tokens {
+		assert.Equal(t, "ACCESS_TOKEN", token)
+	}
+}
+
+func TestAccessTokenConcurrencyError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+
+		return []byte(`{"errcode":40013,"errmsg":"invalid appid"}`), nil
+	}).Times(1)
+
+	oa := New("APPID", "APPSECRET", WithTokenStore(nil))
+	oa.client = client
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, 50)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			_, errs[i] = oa.AccessToken(context.TODO())
+		}(i)
+	}
+
+	wg.Wait()
+
+	// 所有等待者均收到同一次请求的错误
+	for _, err := range errs {
+		assert.True(t, wx.IsCode(err, 40013))
+	}
+
+	// 错误不会被缓存，下一次调用重新请求
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil).Times(1)
+
+	accessToken, err := oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", accessToken.Token)
+}
+
+func TestAccessTokenCanceledCaller(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		close(started)
+		<-release
+
+		// 首个调用方取消不影响刷新
+		assert.Nil(t, ctx.Err())
+
+		return []byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil
+	}).Times(1)
+
+	oa := New("APPID", "APPSECRET", WithTokenStore(nil))
+	oa.client = client
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	first := make(chan error, 1)
+
+	go func() {
+		_, err := oa.AccessToken(ctx)
+
+		first <- err
+	}()
+
+	<-started
+
+	second := make(chan *AccessToken, 1)
+
+	go func() {
+		accessToken, err := oa.AccessToken(context.TODO())
+
+		assert.Nil(t, err)
+
+		second <- accessToken
+	}()
+
+	// 等待第二个调用方加入同一次刷新
+	time.Sleep(50 * time.Millisecond)
+
+	// 首个调用方按自身的 ctx 返回
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-first)
+
+	close(release)
+
+	assert.Equal(t, "ACCESS_TOKEN", (<-second).Token)
+}
+
+func TestAccessTokenWithoutStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil).Times(2)
+
+	oa := New("APPID", "APPSECRET", WithTokenStore(nil))
+	oa.client = client
+
+	for i := 0; i < 2; i++ {
+		accessToken, err := oa.AccessToken(context.TODO())
+
+		assert.Nil(t, err)
+		assert.Equal(t, &AccessToken{
+			Token:     "ACCESS_TOKEN",
+			ExpiresIn: 7200,
+		}, accessToken)
+	}
+}
+
+func TestJSAPITicketConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil).Times(1)
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=ACCESS_TOKEN&type=jsapi").DoAndReturn(func(ctx context.Context, reqURL string, options ...wx.HTTPOption) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+
+		return []byte(`{"errcode":0,"errmsg":"ok","ticket":"TICKET","expires_in":7200}`), nil
+	}).Times(1)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	var wg sync.WaitGroup
+
+	tickets := make([]string, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			tickets[i], _ = oa.JSAPITicket(context.TODO())
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, ticket := range tickets {
+		assert.Equal(t, "TICKET", ticket)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	var reqURL string
+
+	oa := New("APPID", "APPSECRET", WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			reqURL = req.URL.String()
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(strings.NewReader(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`)),
+			}, nil
+		}),
+	}))
+
+	accessToken, err := oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET", reqURL)
+	assert.Equal(t, &AccessToken{
+		Token:     "ACCESS_TOKEN",
+		ExpiresIn: 7200,
+	}, accessToken)
+}
+
+func TestDoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN").Return([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.True(t, wx.IsCode(err, wx.ErrCodeInvalidCredential))
+	assert.Equal(t, &wx.APIError{
+		ErrCode:    40001,
+		ErrMsg:     "invalid credential",
+		RequestURL: "https://api.weixin.qq.com/cgi-bin/test",
+	}, err)
+}
+
+func TestExec(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN1","expires_in":7200}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN1").Return([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`), nil),
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN2","expires_in":7200}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN2").Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil),
+	)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.Nil(t, err)
+
+	// the refreshed token is cached
+	accessToken, err := oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN2", accessToken.Token)
+}
+
+func TestExecRetryOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil).Times(2)
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN").Return([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`), nil).Times(2)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.True(t, wx.IsCode(err, wx.ErrCodeAccessTokenExpired))
+}
+
+func TestStableAccessToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(anyContext, "https://api.weixin.qq.com/cgi-bin/stable_token", []byte(`{"appid":"APPID","force_refresh":true,"grant_type":"client_credential","secret":"APPSECRET"}`)).Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	accessToken, err := oa.StableAccessToken(context.TODO(), true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessToken{
+		Token:     "ACCESS_TOKEN",
+		ExpiresIn: 7200,
+	}, accessToken)
+}
+
+func TestWithStableToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(anyContext, "https://api.weixin.qq.com/cgi-bin/stable_token", []byte(`{"appid":"APPID","force_refresh":false,"grant_type":"client_credential","secret":"APPSECRET"}`)).Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+
+	oa := New("APPID", "APPSECRET", WithStableToken())
+	oa.client = client
+
+	accessToken, err := oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessToken{
+		Token:     "ACCESS_TOKEN",
+		ExpiresIn: 7200,
+	}, accessToken)
+}
+
+func TestDoWithTokenRetry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN1","expires_in":7200}`), nil),
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN2","expires_in":7200}`), nil),
+	)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	tokens := make([]string, 0, 2)
+
+	err := oa.DoWithToken(context.TODO(), func(accessToken string) error {
+		tokens = append(tokens, accessToken)
+
+		if len(tokens) == 1 {
+			return wx.NewAPIError("https://api.weixin.qq.com/cgi-bin/test", wx.ErrCodeInvalidCredential, "invalid credential")
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ACCESS_TOKEN1", "ACCESS_TOKEN2"}, tokens)
+
+	// other errors are not retried
+	calls := 0
+
+	err = oa.DoWithToken(context.TODO(), func(accessToken string) error {
+		calls++
+
+		return wx.NewAPIError("https://api.weixin.qq.com/cgi-bin/test", wx.ErrCodeQuotaReached, "api freq out of limit")
+	})
+
+	assert.True(t, wx.IsCode(err, wx.ErrCodeQuotaReached))
+	assert.Equal(t, 1, calls)
+}
+
+func TestWithAccessTokenFunc(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// never fetch access_token
+	gomock.InOrder(
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN1").Return([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/test?access_token=ACCESS_TOKEN2").Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil),
+	)
+
+	calls := 0
+
+	oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		calls++
+
+		return fmt.Sprintf("ACCESS_TOKEN%d", calls), nil
+	}))
+	oa.client = client
+
+	err := oa.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	// fail fast
+	tokenErr := errors.New("token service unavailable")
+
+	oa = New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "", tokenErr
+	}))
+	oa.client = client
+
+	err = oa.Exec(context.TODO(), wx.NewAction("https://api.weixin.qq.com/cgi-bin/test", wx.WithMethod(wx.MethodGet)))
+
+	assert.True(t, errors.Is(err, tokenErr))
 }
 
 func TestVerifyEventSign(t *testing.T) {
@@ -125,6 +600,35 @@ func TestDecryptEventMessage(t *testing.T) {
 	}, msg)
 }
 
+func TestEncryptMessage(t *testing.T) {
+	oa := New("wx1def0e9e5891b338", "APPSECRET")
+
+	oa.nonce = func(size int) string {
+		return "343a802b6073aae5"
+	}
+
+	msg := []byte("<xml><ToUserName><![CDATA[oB4tA6ANthOfuQ5XSlkdPsWOVUsY]]></ToUserName><FromUserName><![CDATA[gh_3ad31c0ba9b5]]></FromUserName><CreateTime>1606902602</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[ILoveGochat]]></Content></xml>")
+
+	encrypt, err := oa.EncryptMessage("jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY1U", msg)
+
+	assert.Nil(t, err)
+
+	b, err := oa.DecryptMessage("jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY1U", encrypt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, msg, b)
+
+	// appid 不匹配
+	_, err = New("wx2421b1c4370ec43b", "APPSECRET").DecryptMessage("jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY1U", encrypt)
+
+	assert.NotNil(t, err)
+
+	// EncodingAESKey 不合法
+	_, err = oa.DecryptMessage("jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY", encrypt)
+
+	assert.True(t, errors.Is(err, event.ErrInvalidEncodingAESKey))
+}
+
 // 签名涉及时间戳，结果会变化（已通过「微信公众平台接口调试工具」测试）
 // func TestReply(t *testing.T) {
 // 	oa := New("wx1def0e9e5891b338", "APPSECRET")
@@ -146,14 +650,346 @@ func TestDecryptEventMessage(t *testing.T) {
 // 	}, msg)
 // }
 
-// 签名涉及时间戳，结果会变化（已通过固定时间戳验证）
-// func TestJSSDKSign(t *testing.T) {
-// 	oa := New("APPID", "APPSECRET")
-// 	oa.nonce = func(size int) string {
-// 		return "Wm3WZYTPz0wzccnW"
-// 	}
+func TestSignJSSDK(t *testing.T) {
+	sign := SignJSSDK("sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg", "Wm3WZYTPz0wzccnW", "http://mp.weixin.qq.com?params=value", 1414587457)
 
-// 	sign := oa.JSSDKSign("sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg", "http://mp.weixin.qq.com?params=value")
+	assert.Equal(t, "0f9de62fce790f9a083d5c99e95740ceb90c27ed", sign)
+}
 
-// 	assert.Equal(t, "0f9de62fce790f9a083d5c99e95740ceb90c27ed", sign.Signature)
-// }
+func TestJSSDKSign(t *testing.T) {
+	oa := New("APPID", "APPSECRET", WithNonceFunc(func(size int) string {
+		return "Wm3WZYTPz0wzccnW"
+	}))
+
+	sign := oa.JSSDKSign("sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg", "http://mp.weixin.qq.com?params=value")
+
+	// 签名涉及时间戳，结果会变化
+	assert.Equal(t, "APPID", sign.AppID)
+	assert.Equal(t, "Wm3WZYTPz0wzccnW", sign.Noncestr)
+	assert.Equal(t, SignJSSDK("sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg", "Wm3WZYTPz0wzccnW", "http://mp.weixin.qq.com?params=value", sign.Timestamp), sign.Signature)
+}
+
+func TestJSAPITicket(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=ACCESS_TOKEN&type=jsapi").Return([]byte(`{
+		"errcode": 0,
+		"errmsg": "ok",
+		"ticket": "bxLdikRXVbTPdHSM05e5u5sUoXNKd8-41ZO3MhKoyN5OfkWITDGgnr2fwJ0m9E8NYzWKVZvdVtaUgWvsdshFKA",
+		"expires_in": 7200
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	ticket, err := oa.JSAPITicket(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "bxLdikRXVbTPdHSM05e5u5sUoXNKd8-41ZO3MhKoyN5OfkWITDGgnr2fwJ0m9E8NYzWKVZvdVtaUgWvsdshFKA", ticket)
+}
+
+func TestUserInfo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	// 使用网页授权的access_token，不获取普通AccessToken
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/sns/userinfo?access_token=AUTH_ACCESS_TOKEN&lang=zh_CN&openid=OPENID").Return([]byte(`{
+		"openid": "OPENID",
+		"nickname": "NICKNAME",
+		"sex": 2,
+		"province": "PROVINCE",
+		"city": "CITY",
+		"country": "COUNTRY",
+		"headimgurl": "https://thirdwx.qlogo.cn/mmopen/g3MonUZtNHkdmzicIlibx6iaFqAc56vxLSUfpb6n5WKSYVY0ChQKkiaJSgQ1dZuTOgvLLrhJbERQQ4eMsv84eavHiaiceqxibJxCfHe/46",
+		"privilege": [],
+		"unionid": "o6_bmasdasdsad6_2sgVt7hMZOPfL"
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	info, err := oa.UserInfo(context.TODO(), "AUTH_ACCESS_TOKEN", "OPENID")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &UserInfo{
+		OpenID:     "OPENID",
+		UnionID:    "o6_bmasdasdsad6_2sgVt7hMZOPfL",
+		Nickname:   "NICKNAME",
+		Sex:        SexFemale,
+		Province:   "PROVINCE",
+		City:       "CITY",
+		Country:    "COUNTRY",
+		HeadImgURL: "https://thirdwx.qlogo.cn/mmopen/g3MonUZtNHkdmzicIlibx6iaFqAc56vxLSUfpb6n5WKSYVY0ChQKkiaJSgQ1dZuTOgvLLrhJbERQQ4eMsv84eavHiaiceqxibJxCfHe/46",
+		Privilege:  []string{},
+	}, info)
+}
+
+func TestUserInfoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/sns/userinfo?access_token=AUTH_ACCESS_TOKEN&lang=zh_CN&openid=OPENID").Return([]byte(`{"errcode":40003,"errmsg":"invalid openid"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	info, err := oa.UserInfo(context.TODO(), "AUTH_ACCESS_TOKEN", "OPENID")
+
+	assert.Nil(t, info)
+	assert.True(t, wx.IsCode(err, 40003))
+}
+
+func TestGetUserInfo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&lang=zh_CN&openid=OPENID").Return([]byte(`{
+		"subscribe": 1,
+		"openid": "OPENID",
+		"nickname": "Band",
+		"sex": 1,
+		"language": "zh_CN",
+		"city": "广州",
+		"province": "广东",
+		"country": "中国",
+		"headimgurl": "http://thirdwx.qlogo.cn/mmopen/g3MonUZtNHkdmzicIlibx6iaFqAc56vxLSUfpb6n5WKSYVY0ChQKkiaJSgQ1dZuTOgvLLrhJbERQQ4eMsv84eavHiaiceqxibJxCfHe/0",
+		"subscribe_time": 1382694957,
+		"unionid": "o6_bmasdasdsad6_2sgVt7hMZOPfL",
+		"remark": "",
+		"groupid": 0,
+		"tagid_list": [128, 2],
+		"subscribe_scene": "ADD_SCENE_QR_CODE",
+		"qr_scene": 98765,
+		"qr_scene_str": ""
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	info, err := oa.GetUserInfo(context.TODO(), "OPENID")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &SubscriberInfo{
+		Subscribe:      1,
+		OpenID:         "OPENID",
+		NickName:       "Band",
+		Sex:            1,
+		Language:       "zh_CN",
+		City:           "广州",
+		Province:       "广东",
+		Country:        "中国",
+		HeadImgURL:     "http://thirdwx.qlogo.cn/mmopen/g3MonUZtNHkdmzicIlibx6iaFqAc56vxLSUfpb6n5WKSYVY0ChQKkiaJSgQ1dZuTOgvLLrhJbERQQ4eMsv84eavHiaiceqxibJxCfHe/0",
+		SubscribeTime:  1382694957,
+		UnionID:        "o6_bmasdasdsad6_2sgVt7hMZOPfL",
+		TagidList:      []int64{128, 2},
+		SubscribeScene: SceneQRCode,
+		QRScene:        98765,
+	}, info)
+}
+
+func TestBatchGetUserInfo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil)
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/user/info/batchget?access_token=ACCESS_TOKEN", []byte(`{"user_list":[{"lang":"zh_CN","openid":"OPENID1"},{"lang":"zh_CN","openid":"OPENID2"}]}`)).Return([]byte(`{
+		"user_info_list": [
+			{
+				"subscribe": 1,
+				"openid": "OPENID1",
+				"nickname": "iWithery",
+				"sex": 1,
+				"subscribe_time": 1434093047
+			},
+			{
+				"subscribe": 0,
+				"openid": "OPENID2"
+			}
+		]
+	}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	infos, err := oa.BatchGetUserInfo(context.TODO(), []string{"OPENID1", "OPENID2"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*SubscriberInfo{
+		{
+			Subscribe:     1,
+			OpenID:        "OPENID1",
+			NickName:      "iWithery",
+			Sex:           1,
+			SubscribeTime: 1434093047,
+		},
+		{
+			Subscribe: 0,
+			OpenID:    "OPENID2",
+		},
+	}, infos)
+}
+
+func TestBatchGetUserInfoLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 超过100个时不发起请求
+	oa := New("APPID", "APPSECRET")
+	oa.client = wx.NewMockHTTPClient(ctrl)
+
+	openids := make([]string, 0, MaxBatchGetSubscriberCount+1)
+
+	for i := 0; i <= MaxBatchGetSubscriberCount; i++ {
+		openids = append(openids, fmt.Sprintf("OPENID%d", i))
+	}
+
+	infos, err := oa.BatchGetUserInfo(context.TODO(), openids)
+
+	assert.Nil(t, infos)
+	assert.Equal(t, ErrBatchGetSubscriberLimit, err)
+
+	// 通过 Do 执行时同样校验
+	err = oa.Do(context.TODO(), "ACCESS_TOKEN", BatchGetSubscribers(&infos, openids...))
+
+	assert.Equal(t, ErrBatchGetSubscriberLimit, err)
+}
+
+func TestGetFollowers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil),
+		// 首次拉取不带 next_openid
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/user/get?access_token=ACCESS_TOKEN").Return([]byte(`{
+			"total": 3,
+			"count": 2,
+			"data": {
+				"openid": ["OPENID1", "OPENID2"]
+			},
+			"next_openid": "OPENID2"
+		}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/user/get?access_token=ACCESS_TOKEN&next_openid=OPENID2").Return([]byte(`{
+			"total": 3,
+			"count": 1,
+			"data": {
+				"openid": ["OPENID3"]
+			},
+			"next_openid": "OPENID3"
+		}`), nil),
+		client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/user/get?access_token=ACCESS_TOKEN&next_openid=OPENID3").Return([]byte(`{
+			"total": 3,
+			"count": 0,
+			"next_openid": ""
+		}`), nil),
+	)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	openids := make([]string, 0)
+
+	var nextOpenID string
+
+	for {
+		list, err := oa.GetFollowers(context.TODO(), nextOpenID)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, list.Total)
+
+		if list.Count == 0 {
+			break
+		}
+
+		openids = append(openids, list.Data.OpenID...)
+		nextOpenID = list.NextOpenID
+	}
+
+	assert.Equal(t, []string{"OPENID1", "OPENID2", "OPENID3"}, openids)
+}
+
+// clockTokenStore 使用假时钟的 AccessTokenStore，实现 wx.AccessTokenMarginSetter
+type clockTokenStore struct {
+	now      time.Time
+	token    string
+	expireAt time.Time
+	margin   time.Duration
+}
+
+func (s *clockTokenStore) Get(ctx context.Context) (string, error) {
+	if !s.now.Before(s.expireAt.Add(-s.margin)) {
+		return "", nil
+	}
+
+	return s.token, nil
+}
+
+func (s *clockTokenStore) Set(ctx context.Context, token string, ttl time.Duration) error {
+	s.token = token
+	s.expireAt = s.now.Add(ttl)
+
+	return nil
+}
+
+func (s *clockTokenStore) SetMargin(d time.Duration) {
+	s.margin = d
+}
+
+func TestTokenSafetyMargin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil),
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN2","expires_in":7200}`), nil),
+	)
+
+	store := &clockTokenStore{now: time.Unix(1600000000, 0)}
+
+	// WithTokenSafetyMargin 与 WithTokenStore 的顺序无关
+	oa := New("APPID", "APPSECRET", WithTokenSafetyMargin(300*time.Second), WithTokenStore(store))
+	oa.client = client
+
+	assert.Equal(t, 300*time.Second, store.margin)
+
+	accessToken, err := oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", accessToken.Token)
+
+	store.now = store.now.Add(6899 * time.Second)
+
+	accessToken, err = oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", accessToken.Token)
+
+	// 6900秒时刷新
+	store.now = store.now.Add(time.Second)
+
+	accessToken, err = oa.AccessToken(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN2", accessToken.Token)
+
+	oa.SetTokenSafetyMargin(time.Minute)
+
+	assert.Equal(t, time.Minute, store.margin)
+}
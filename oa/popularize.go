@@ -1,7 +1,9 @@
 package oa
 
 import (
+	"context"
 	"encoding/json"
+	"net/url"
 
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/tidwall/gjson"
@@ -58,6 +60,75 @@ func CreatePermQRCode(dest *QRCode, senceID int) wx.Action {
 	)
 }
 
+// QRCodeAction 二维码类型
+type QRCodeAction string
+
+// 微信支持的二维码类型
+const (
+	QRScene         QRCodeAction = "QR_SCENE"           // 临时的整型参数值
+	QRStrScene      QRCodeAction = "QR_STR_SCENE"       // 临时的字符串参数值
+	QRLimitScene    QRCodeAction = "QR_LIMIT_SCENE"     // 永久的整型参数值
+	QRLimitStrScene QRCodeAction = "QR_LIMIT_STR_SCENE" // 永久的字符串参数值
+)
+
+// QRCodeRequest 二维码创建参数
+type QRCodeRequest struct {
+	ActionName    QRCodeAction // 二维码类型
+	SceneID       int          // 场景值ID（QR_SCENE、QR_LIMIT_SCENE），临时二维码时为32位非0整型，永久二维码时最大值为100000
+	SceneStr      string       // 场景值ID（QR_STR_SCENE、QR_LIMIT_STR_SCENE），长度限制为1到64
+	ExpireSeconds int          // 临时二维码有效时间，最大不超过2592000秒（即30天），不填，则默认有效期为30秒；永久二维码忽略
+}
+
+// QRCodeResult 二维码创建结果（永久二维码的 ExpireSeconds 为0）
+type QRCodeResult = QRCode
+
+// CreateQRCode 创建二维码（支持临时及永久二维码，整型及字符串场景值）
+func CreateQRCode(dest *QRCodeResult, req *QRCodeRequest) wx.Action {
+	return wx.NewAction(QRCodeCreateURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			scene := wx.X{}
+
+			switch req.ActionName {
+			case QRStrScene, QRLimitStrScene:
+				scene["scene_str"] = req.SceneStr
+			default:
+				scene["scene_id"] = req.SceneID
+			}
+
+			params := wx.X{
+				"action_name": req.ActionName,
+				"action_info": wx.X{"scene": scene},
+			}
+
+			if (req.ActionName == QRScene || req.ActionName == QRStrScene) && req.ExpireSeconds > 0 {
+				params["expire_seconds"] = req.ExpireSeconds
+			}
+
+			return json.Marshal(params)
+		}),
+		wx.WithDecode(func(resp []byte) error {
+			return json.Unmarshal(resp, dest)
+		}),
+	)
+}
+
+// CreateQRCode 创建二维码（通过 Exec 执行，自动获取普通AccessToken），返回 ticket、url 及有效时间
+func (oa *OA) CreateQRCode(ctx context.Context, req *QRCodeRequest, options ...wx.HTTPOption) (*QRCodeResult, error) {
+	result := new(QRCodeResult)
+
+	if err := oa.Exec(ctx, CreateQRCode(result, req), options...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ShowQRCode 通过 ticket 换取二维码图片的链接（无需 AccessToken）
+func ShowQRCode(ticket string) string {
+	return QRCodeShowURL + "?ticket=" + url.QueryEscape(ticket)
+}
+
 // ShortURL 短链接
 type ShortURL struct {
 	URL string
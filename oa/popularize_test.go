@@ -63,6 +63,68 @@ func TestCreatePermQRCode(t *testing.T) {
 	}, dest)
 }
 
+func TestCreateQRCode(t *testing.T) {
+	cases := []struct {
+		name string
+		req  *QRCodeRequest
+		body string
+	}{
+		{
+			name: "temporary numeric scene",
+			req:  &QRCodeRequest{ActionName: QRScene, SceneID: 123, ExpireSeconds: 60},
+			body: `{"action_info":{"scene":{"scene_id":123}},"action_name":"QR_SCENE","expire_seconds":60}`,
+		},
+		{
+			name: "temporary string scene",
+			req:  &QRCodeRequest{ActionName: QRStrScene, SceneStr: "test", ExpireSeconds: 60},
+			body: `{"action_info":{"scene":{"scene_str":"test"}},"action_name":"QR_STR_SCENE","expire_seconds":60}`,
+		},
+		{
+			name: "permanent numeric scene",
+			req:  &QRCodeRequest{ActionName: QRLimitScene, SceneID: 123, ExpireSeconds: 60},
+			body: `{"action_info":{"scene":{"scene_id":123}},"action_name":"QR_LIMIT_SCENE"}`,
+		},
+		{
+			name: "permanent string scene",
+			req:  &QRCodeRequest{ActionName: QRLimitStrScene, SceneStr: "test"},
+			body: `{"action_info":{"scene":{"scene_str":"test"}},"action_name":"QR_LIMIT_STR_SCENE"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			client := wx.NewMockHTTPClient(ctrl)
+
+			client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/qrcode/create?access_token=ACCESS_TOKEN", []byte(c.body)).Return([]byte(`{
+				"ticket": "gQH47joAAAAAAAAAASxodHRwOi8vd2VpeGluLnFxLmNvbS9xL2taZ2Z3TVRtNzJXV1Brb3ZhYmJJAAIEZ23sUwMEmm3sUw==",
+				"expire_seconds": 60,
+				"url": "http://weixin.qq.com/q/kZgfwMTm72WWPkovabbI"
+			}`), nil)
+
+			oa := New("APPID", "APPSECRET", WithAccessTokenFunc(func(ctx context.Context) (string, error) {
+				return "ACCESS_TOKEN", nil
+			}))
+			oa.client = client
+
+			result, err := oa.CreateQRCode(context.TODO(), c.req)
+
+			assert.Nil(t, err)
+			assert.Equal(t, &QRCodeResult{
+				Ticket:        "gQH47joAAAAAAAAAASxodHRwOi8vd2VpeGluLnFxLmNvbS9xL2taZ2Z3TVRtNzJXV1Brb3ZhYmJJAAIEZ23sUwMEmm3sUw==",
+				ExpireSeconds: 60,
+				URL:           "http://weixin.qq.com/q/kZgfwMTm72WWPkovabbI",
+			}, result)
+		})
+	}
+}
+
+func TestShowQRCode(t *testing.T) {
+	assert.Equal(t, "https://mp.weixin.qq.com/cgi-bin/showqrcode?ticket=gQH47joAAAAAAAAAASxodHRwOi8vd2VpeGluLnFxLmNvbS9xL2taZ2Z3TVRtNzJXV1Brb3ZhYmJJAAIEZ23sUwMEmm3sUw%3D%3D", ShowQRCode("gQH47joAAAAAAAAAASxodHRwOi8vd2VpeGluLnFxLmNvbS9xL2taZ2Z3TVRtNzJXV1Brb3ZhYmJJAAIEZ23sUwMEmm3sUw=="))
+}
+
 func TestLong2ShortURL(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -242,3 +242,45 @@ func NewTransfer2KFReply(kfAccount ...string) event.Reply {
 
 	return r
 }
+
+// ReplyText 生成被动回复文本消息（明文XML），to 为用户 openid，from 为公众号原始ID
+func ReplyText(to, from, content string) []byte {
+	return replyBytes(NewTextReply(content), to, from)
+}
+
+// ReplyImage 生成被动回复图片消息（明文XML）
+func ReplyImage(to, from, mediaID string) []byte {
+	return replyBytes(NewImageReply(mediaID), to, from)
+}
+
+// ReplyVoice 生成被动回复语音消息（明文XML）
+func ReplyVoice(to, from, mediaID string) []byte {
+	return replyBytes(NewVoiceReply(mediaID), to, from)
+}
+
+// ReplyVideo 生成被动回复视频消息（明文XML）
+func ReplyVideo(to, from, mediaID, title, desc string) []byte {
+	return replyBytes(NewVideoReply(mediaID, title, desc), to, from)
+}
+
+// ReplyMusic 生成被动回复音乐消息（明文XML）
+func ReplyMusic(to, from, thumbMediaID, title, desc, musicURL, HQMusicURL string) []byte {
+	return replyBytes(NewMusicReply(thumbMediaID, title, desc, musicURL, HQMusicURL), to, from)
+}
+
+// ReplyNews 生成被动回复图文消息（明文XML），图文数不超过10条
+func ReplyNews(to, from string, articles ...*Article) []byte {
+	return replyBytes(NewNewsReply(len(articles), articles...), to, from)
+}
+
+// ReplyTransfer2KF 生成消息转发到客服的回复（明文XML）
+func ReplyTransfer2KF(to, from string, kfAccount ...string) []byte {
+	return replyBytes(NewTransfer2KFReply(kfAccount...), to, from)
+}
+
+// replyBytes 回复消息的结构均为字符串和整型字段，xml.Marshal 不会出错
+func replyBytes(reply event.Reply, to, from string) []byte {
+	b, _ := reply.Bytes(from, to)
+
+	return b
+}
@@ -0,0 +1,65 @@
+package oa
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shenghui0779/gochat/event"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyText(t *testing.T) {
+	now := time.Now().Unix()
+
+	b := ReplyText("oB4tA6ANthOfuQ5XSlkdPsWOVUsY", "gh_3ad31c0ba9b5", "ILoveGochat")
+
+	m, err := wx.ParseXML2Map(b)
+
+	assert.Nil(t, err)
+
+	createTime, err := strconv.ParseInt(m["CreateTime"], 10, 64)
+
+	assert.Nil(t, err)
+	assert.True(t, createTime >= now && createTime <= time.Now().Unix())
+	assert.Equal(t, fmt.Sprintf("<xml><FromUserName><![CDATA[gh_3ad31c0ba9b5]]></FromUserName><ToUserName><![CDATA[oB4tA6ANthOfuQ5XSlkdPsWOVUsY]]></ToUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[ILoveGochat]]></Content></xml>", createTime), string(b))
+}
+
+func TestReplyNews(t *testing.T) {
+	b := ReplyNews("oB4tA6ANthOfuQ5XSlkdPsWOVUsY", "gh_3ad31c0ba9b5", &Article{
+		Title:       "TITLE",
+		Description: "DESCRIPTION",
+		PicURL:      "PICURL",
+		URL:         "URL",
+	})
+
+	m, err := wx.ParseXML2Map(b)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "news", m["MsgType"])
+	assert.Equal(t, "1", m["ArticleCount"])
+	assert.Contains(t, string(b), "<Articles><item><Title><![CDATA[TITLE]]></Title><Description><![CDATA[DESCRIPTION]]></Description><PicUrl><![CDATA[PICURL]]></PicUrl><Url><![CDATA[URL]]></Url></item></Articles>")
+}
+
+func TestEncryptReply(t *testing.T) {
+	oa := New("wx1def0e9e5891b338", "APPSECRET", WithNonceFunc(func(size int) string {
+		return "af80b480c5e065a6"
+	}))
+	oa.SetOriginID("gh_3ad31c0ba9b5")
+	oa.SetServerConfig("2faf43d6343a802b6073aae5b3f2f109", "jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY1U")
+
+	body := ReplyText("oB4tA6ANthOfuQ5XSlkdPsWOVUsY", "gh_3ad31c0ba9b5", "OK")
+
+	msg, err := oa.EncryptReply(body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, wx.CDATA("af80b480c5e065a6"), msg.Nonce)
+	assert.Equal(t, wx.CDATA(event.SignWithSHA1("2faf43d6343a802b6073aae5b3f2f109", strconv.FormatInt(msg.TimeStamp, 10), "af80b480c5e065a6", string(msg.Encrypt))), msg.MsgSignature)
+
+	b, err := oa.DecryptMessage("jxAko083VoJ3lcPXJWzcGJ0M1tFVLgdD6qAq57GJY1U", string(msg.Encrypt))
+
+	assert.Nil(t, err)
+	assert.Equal(t, body, b)
+}
@@ -1,7 +1,9 @@
 package oa
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 
 	"github.com/shenghui0779/gochat/wx"
 	"github.com/tidwall/gjson"
@@ -10,6 +12,12 @@ import (
 // MaxSubscriberListCount 关注列表的最大数目
 const MaxSubscriberListCount = 10000
 
+// MaxBatchGetSubscriberCount 批量获取用户信息的最大数目
+const MaxBatchGetSubscriberCount = 100
+
+// ErrBatchGetSubscriberLimit 批量获取用户信息的openid超过100个
+var ErrBatchGetSubscriberLimit = errors.New("gochat: batchget user info supports at most 100 openids")
+
 // SubscribeScene 关注的渠道来源
 type SubscribeScene string
 
@@ -72,11 +80,15 @@ func GetSubscriberInfo(dest *SubscriberInfo, openid string) wx.Action {
 	)
 }
 
-// BatchGetSubscribers 批量关注用户信息
+// BatchGetSubscribers 批量关注用户信息（最多支持一次拉取100条，超过时返回 ErrBatchGetSubscriberLimit）
 func BatchGetSubscribers(dest *[]*SubscriberInfo, openids ...string) wx.Action {
 	return wx.NewAction(SubscriberBatchGetURL,
 		wx.WithMethod(wx.MethodPost),
 		wx.WithBody(func() ([]byte, error) {
+			if len(openids) > MaxBatchGetSubscriberCount {
+				return nil, ErrBatchGetSubscriberLimit
+			}
+
 			userList := make([]map[string]string, 0, len(openids))
 
 			for _, v := range openids {
@@ -94,15 +106,20 @@ func BatchGetSubscribers(dest *[]*SubscriberInfo, openids ...string) wx.Action {
 	)
 }
 
-// GetSubscriberList 获取关注用户列表
+// GetSubscriberList 获取关注用户列表（一次最多拉取10000个），nextOpenID 为空时从头开始拉取
 func GetSubscriberList(dest *SubscriberList, nextOpenID ...string) wx.Action {
-	return wx.NewAction(SubscriberListURL,
+	options := []wx.ActionOption{
 		wx.WithMethod(wx.MethodGet),
-		wx.WithQuery("next_openid", nextOpenID[0]),
 		wx.WithDecode(func(resp []byte) error {
 			return json.Unmarshal(resp, dest)
 		}),
-	)
+	}
+
+	if len(nextOpenID) != 0 && nextOpenID[0] != "" {
+		options = append(options, wx.WithQuery("next_openid", nextOpenID[0]))
+	}
+
+	return wx.NewAction(SubscriberListURL, options...)
 }
 
 // GetBlackList 获取用户黑名单列表
@@ -158,3 +175,42 @@ func SetUserRemark(openid, remark string) wx.Action {
 		}),
 	)
 }
+
+// GetUserInfo 获取关注用户信息（cgi-bin/user/info，通过 Exec 执行，自动获取普通AccessToken）
+// 注意：仅能获取关注者的信息，用户未关注时 Subscribe 为0，且拉取不到其余信息
+func (oa *OA) GetUserInfo(ctx context.Context, openid string, options ...wx.HTTPOption) (*SubscriberInfo, error) {
+	info := new(SubscriberInfo)
+
+	if err := oa.Exec(ctx, GetSubscriberInfo(info, openid), options...); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// BatchGetUserInfo 批量获取关注用户信息（通过 Exec 执行，自动获取普通AccessToken），openids 最多100个
+func (oa *OA) BatchGetUserInfo(ctx context.Context, openids []string, options ...wx.HTTPOption) ([]*SubscriberInfo, error) {
+	if len(openids) > MaxBatchGetSubscriberCount {
+		return nil, ErrBatchGetSubscriberLimit
+	}
+
+	infos := make([]*SubscriberInfo, 0, len(openids))
+
+	if err := oa.Exec(ctx, BatchGetSubscribers(&infos, openids...), options...); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// GetFollowers 获取关注用户列表（通过 Exec 执行，自动获取普通AccessToken）
+// nextOpenID 为空时从头开始拉取，之后传入上一次返回的 NextOpenID 继续拉取，直至返回的 Count 为0
+func (oa *OA) GetFollowers(ctx context.Context, nextOpenID string, options ...wx.HTTPOption) (*SubscriberList, error) {
+	list := new(SubscriberList)
+
+	if err := oa.Exec(ctx, GetSubscriberList(list, nextOpenID), options...); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
@@ -0,0 +1,159 @@
+package oa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/tidwall/gjson"
+)
+
+// MaxBatchTaggingCount 批量为用户打标签/取消标签的最大数目
+const MaxBatchTaggingCount = 50
+
+// ErrBatchTaggingLimit 批量为用户打标签/取消标签的openid超过50个
+var ErrBatchTaggingLimit = errors.New("gochat: batch tagging supports at most 50 openids")
+
+// Tag 用户标签
+type Tag struct {
+	ID    int64  `json:"id"`    // 标签id，由微信分配
+	Name  string `json:"name"`  // 标签名，UTF8编码
+	Count int    `json:"count"` // 此标签下粉丝数
+}
+
+// CreateTag 创建标签（一个公众号，最多可以创建100个标签）
+func CreateTag(dest *Tag, name string) wx.Action {
+	return wx.NewAction(TagCreateURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(wx.X{
+				"tag": wx.X{"name": name},
+			})
+		}),
+		wx.WithDecode(func(resp []byte) error {
+			return json.Unmarshal([]byte(gjson.GetBytes(resp, "tag").Raw), dest)
+		}),
+	)
+}
+
+// GetTags 获取公众号已创建的标签
+func GetTags(dest *[]*Tag) wx.Action {
+	return wx.NewAction(TagListURL,
+		wx.WithMethod(wx.MethodGet),
+		wx.WithDecode(func(resp []byte) error {
+			return json.Unmarshal([]byte(gjson.GetBytes(resp, "tags").Raw), dest)
+		}),
+	)
+}
+
+// UpdateTag 编辑标签
+func UpdateTag(tagID int64, name string) wx.Action {
+	return wx.NewAction(TagUpdateURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(wx.X{
+				"tag": wx.X{
+					"id":   tagID,
+					"name": name,
+				},
+			})
+		}),
+	)
+}
+
+// DeleteTag 删除标签（当某个标签下的粉丝超过10w时，后台不可直接删除标签）
+func DeleteTag(tagID int64) wx.Action {
+	return wx.NewAction(TagDeleteURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			return json.Marshal(wx.X{
+				"tag": wx.X{"id": tagID},
+			})
+		}),
+	)
+}
+
+// BatchTagging 批量为用户打标签（最多支持一次50个openid，超过时返回 ErrBatchTaggingLimit）
+func BatchTagging(tagID int64, openids ...string) wx.Action {
+	return wx.NewAction(TagBatchTaggingURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			if len(openids) > MaxBatchTaggingCount {
+				return nil, ErrBatchTaggingLimit
+			}
+
+			return json.Marshal(wx.X{
+				"openid_list": openids,
+				"tagid":       tagID,
+			})
+		}),
+	)
+}
+
+// BatchUntagging 批量为用户取消标签（最多支持一次50个openid，超过时返回 ErrBatchTaggingLimit）
+func BatchUntagging(tagID int64, openids ...string) wx.Action {
+	return wx.NewAction(TagBatchUntaggingURL,
+		wx.WithMethod(wx.MethodPost),
+		wx.WithBody(func() ([]byte, error) {
+			if len(openids) > MaxBatchTaggingCount {
+				return nil, ErrBatchTaggingLimit
+			}
+
+			return json.Marshal(wx.X{
+				"openid_list": openids,
+				"tagid":       tagID,
+			})
+		}),
+	)
+}
+
+// CreateTag 创建标签（通过 Exec 执行，自动获取普通AccessToken），返回微信分配的标签id和标签名
+func (oa *OA) CreateTag(ctx context.Context, name string, options ...wx.HTTPOption) (*Tag, error) {
+	tag := new(Tag)
+
+	if err := oa.Exec(ctx, CreateTag(tag, name), options...); err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+// GetTags 获取公众号已创建的标签（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) GetTags(ctx context.Context, options ...wx.HTTPOption) ([]*Tag, error) {
+	tags := make([]*Tag, 0)
+
+	if err := oa.Exec(ctx, GetTags(&tags), options...); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// UpdateTag 编辑标签（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) UpdateTag(ctx context.Context, tagID int64, name string, options ...wx.HTTPOption) error {
+	return oa.Exec(ctx, UpdateTag(tagID, name), options...)
+}
+
+// DeleteTag 删除标签（通过 Exec 执行，自动获取普通AccessToken）
+func (oa *OA) DeleteTag(ctx context.Context, tagID int64, options ...wx.HTTPOption) error {
+	return oa.Exec(ctx, DeleteTag(tagID), options...)
+}
+
+// BatchTagging 批量为用户打标签（通过 Exec 执行，自动获取普通AccessToken），openids 最多50个
+func (oa *OA) BatchTagging(ctx context.Context, tagID int64, openids []string, options ...wx.HTTPOption) error {
+	if len(openids) > MaxBatchTaggingCount {
+		return ErrBatchTaggingLimit
+	}
+
+	return oa.Exec(ctx, BatchTagging(tagID, openids...), options...)
+}
+
+// BatchUntagging 批量为用户取消标签（通过 Exec 执行，自动获取普通AccessToken），openids 最多50个
+func (oa *OA) BatchUntagging(ctx context.Context, tagID int64, openids []string, options ...wx.HTTPOption) error {
+	if len(openids) > MaxBatchTaggingCount {
+		return ErrBatchTaggingLimit
+	}
+
+	return oa.Exec(ctx, BatchUntagging(tagID, openids...), options...)
+}
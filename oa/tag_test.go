@@ -0,0 +1,182 @@
+package oa
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shenghui0779/gochat/wx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/create?access_token=ACCESS_TOKEN", []byte(`{"tag":{"name":"广东"}}`)).Return([]byte(`{"tag":{"id":134,"name":"广东"}}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := new(Tag)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", CreateTag(dest, "广东"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Tag{ID: 134, Name: "广东"}, dest)
+}
+
+func TestGetTags(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Get(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/get?access_token=ACCESS_TOKEN").Return([]byte(`{"tags":[{"id":1,"name":"每天一罐可乐星人","count":0},{"id":2,"name":"星标组","count":0},{"id":127,"name":"广东","count":5}]}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	dest := make([]*Tag, 0)
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", GetTags(&dest))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*Tag{
+		{ID: 1, Name: "每天一罐可乐星人"},
+		{ID: 2, Name: "星标组"},
+		{ID: 127, Name: "广东", Count: 5},
+	}, dest)
+}
+
+func TestUpdateTag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/update?access_token=ACCESS_TOKEN", []byte(`{"tag":{"id":134,"name":"广东人"}}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", UpdateTag(134, "广东人"))
+
+	assert.Nil(t, err)
+}
+
+func TestDeleteTag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/delete?access_token=ACCESS_TOKEN", []byte(`{"tag":{"id":134}}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", DeleteTag(134))
+
+	assert.Nil(t, err)
+}
+
+func TestBatchTagging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/members/batchtagging?access_token=ACCESS_TOKEN", []byte(`{"openid_list":["ocYxcuAEy30bX0NXmGn4ypqx3tI0","ocYxcuBt0mRugKZ7tGAHPnUaOW7Y"],"tagid":134}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", BatchTagging(134, "ocYxcuAEy30bX0NXmGn4ypqx3tI0", "ocYxcuBt0mRugKZ7tGAHPnUaOW7Y"))
+
+	assert.Nil(t, err)
+}
+
+func TestBatchUntagging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/members/batchuntagging?access_token=ACCESS_TOKEN", []byte(`{"openid_list":["ocYxcuAEy30bX0NXmGn4ypqx3tI0","ocYxcuBt0mRugKZ7tGAHPnUaOW7Y"],"tagid":134}`)).Return([]byte(`{"errcode":0,"errmsg":"ok"}`), nil)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.Do(context.TODO(), "ACCESS_TOKEN", BatchUntagging(134, "ocYxcuAEy30bX0NXmGn4ypqx3tI0", "ocYxcuBt0mRugKZ7tGAHPnUaOW7Y"))
+
+	assert.Nil(t, err)
+}
+
+func TestOACreateTag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil),
+		client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/create?access_token=ACCESS_TOKEN", []byte(`{"tag":{"name":"广东"}}`)).Return([]byte(`{"tag":{"id":134,"name":"广东"}}`), nil),
+	)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	tag, err := oa.CreateTag(context.TODO(), "广东")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Tag{ID: 134, Name: "广东"}, tag)
+}
+
+func TestOABatchTagging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := wx.NewMockHTTPClient(ctrl)
+
+	gomock.InOrder(
+		client.EXPECT().Get(anyContext, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET").Return([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil),
+		client.EXPECT().Post(gomock.AssignableToTypeOf(context.TODO()), "https://api.weixin.qq.com/cgi-bin/tags/members/batchtagging?access_token=ACCESS_TOKEN", gomock.Any()).DoAndReturn(func(ctx context.Context, reqURL string, body []byte, options ...wx.HTTPOption) ([]byte, error) {
+			assert.JSONEq(t, `{"openid_list":["OPENID1","OPENID2"],"tagid":134}`, string(body))
+
+			return []byte(`{"errcode":0,"errmsg":"ok"}`), nil
+		}),
+	)
+
+	oa := New("APPID", "APPSECRET")
+	oa.client = client
+
+	err := oa.BatchTagging(context.TODO(), 134, []string{"OPENID1", "OPENID2"})
+
+	assert.Nil(t, err)
+}
+
+func TestBatchTaggingLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// 超过50个时不发起请求
+	oa := New("APPID", "APPSECRET")
+	oa.client = wx.NewMockHTTPClient(ctrl)
+
+	openids := make([]string, 0, MaxBatchTaggingCount+1)
+
+	for i := 0; i <= MaxBatchTaggingCount; i++ {
+		openids = append(openids, fmt.Sprintf("OPENID%d", i))
+	}
+
+	assert.Equal(t, ErrBatchTaggingLimit, oa.BatchTagging(context.TODO(), 134, openids))
+	assert.Equal(t, ErrBatchTaggingLimit, oa.BatchUntagging(context.TODO(), 134, openids))
+
+	// 通过 Do 执行时同样校验
+	assert.Equal(t, ErrBatchTaggingLimit, oa.Do(context.TODO(), "ACCESS_TOKEN", BatchTagging(134, openids...)))
+	assert.Equal(t, ErrBatchTaggingLimit, oa.Do(context.TODO(), "ACCESS_TOKEN", BatchUntagging(134, openids...)))
+}
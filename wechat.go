@@ -1,22 +1,30 @@
 package gochat
 
 import (
+	"crypto/rsa"
+
+	"github.com/shenghui0779/gochat/apiv3"
 	"github.com/shenghui0779/gochat/mch"
 	"github.com/shenghui0779/gochat/mp"
 	"github.com/shenghui0779/gochat/oa"
 )
 
 // NewMch 微信商户
-func NewMch(appid, mchid, apikey string) *mch.Mch {
-	return mch.New(appid, mchid, apikey)
+func NewMch(appid, mchid, apikey string, options ...mch.Option) *mch.Mch {
+	return mch.New(appid, mchid, apikey, options...)
+}
+
+// NewAPIv3 微信支付APIv3（普通商户直连模式）
+func NewAPIv3(appid, mchid, apiV3Key, serialNo string, privateKey *rsa.PrivateKey, options ...apiv3.Option) *apiv3.APIv3 {
+	return apiv3.New(appid, mchid, apiV3Key, serialNo, privateKey, options...)
 }
 
 // NewPub 微信公众号
-func NewOA(appid, appsecret string) *oa.OA {
-	return oa.New(appid, appsecret)
+func NewOA(appid, appsecret string, options ...oa.Option) *oa.OA {
+	return oa.New(appid, appsecret, options...)
 }
 
 // NewMP 微信小程序
-func NewMP(appid, appsecret string) *mp.MP {
-	return mp.New(appid, appsecret)
+func NewMP(appid, appsecret string, options ...mp.Option) *mp.MP {
+	return mp.New(appid, appsecret, options...)
 }
@@ -2,11 +2,16 @@ package wx
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 // HTTPMethod http request method
@@ -20,6 +25,14 @@ const (
 
 // HTTPClient is the interface that do http request
 type HTTPClient interface {
+	BufferedHTTPClient
+
+	// GetStream sends an HTTP get request and returns the response body without buffering, the caller must close the body.
+	GetStream(ctx context.Context, reqURL string, options ...HTTPOption) (io.ReadCloser, http.Header, error)
+}
+
+// BufferedHTTPClient is the HTTPClient without GetStream, use NewStreamAdapter to turn it into HTTPClient.
+type BufferedHTTPClient interface {
 	// Get sends an HTTP get request
 	Get(ctx context.Context, reqURL string, options ...HTTPOption) ([]byte, error)
 
@@ -44,15 +57,60 @@ type UploadForm interface {
 	// ExtraFields returns extra fields for upload
 	ExtraFields() map[string]string
 
-	// Buffer returns the buffer of media
-	Buffer() ([]byte, error)
+	// Buffer returns the buffer of media, ctx is used when fetching the resource url
+	Buffer(ctx context.Context) ([]byte, error)
+}
+
+// FormPart is an additional part of the multipart upload form (eg: the json "description" of permanent video material)
+type FormPart struct {
+	FieldName   string
+	FileName    string // 为空时作为普通字段
+	ContentType string
+	Content     []byte
+}
+
+// FormField is an extra field of the upload form
+type FormField struct {
+	Key   string
+	Value string
 }
 
+// OrderedForm is the optional interface of UploadForm which keeps the insertion order of extra fields,
+// the extra fields of the UploadForm without it are written in key order.
+type OrderedForm interface {
+	UploadForm
+
+	// OrderedExtraFields returns the extra fields in insertion order
+	OrderedExtraFields() []FormField
+}
+
+// MultipartForm is the optional interface of UploadForm which has additional parts besides the media file
+type MultipartForm interface {
+	UploadForm
+
+	// Parts returns the additional parts in order
+	Parts() []FormPart
+}
+
+// DefaultUploadMaxSize is the default max size of upload media (10MB)
+const DefaultUploadMaxSize int64 = 10 << 20
+
+// ErrMediaTooLarge is returned when the upload media exceeds the max size
+var ErrMediaTooLarge = errors.New("gochat: media too large")
+
 type httpUpload struct {
 	fieldname   string
 	filename    string
 	resourceURL string
-	extraFields map[string]string
+	resource    []byte
+	reader      io.Reader
+	readOnce    sync.Once
+	readData    []byte
+	readErr     error
+	timeout     time.Duration
+	maxSize     int64
+	extraFields []FormField
+	parts       []FormPart
 }
 
 func (u *httpUpload) FieldName() string {
@@ -64,24 +122,53 @@ func (u *httpUpload) FileName() string {
 }
 
 func (u *httpUpload) ExtraFields() map[string]string {
+	m := make(map[string]string, len(u.extraFields))
+
+	for _, v := range u.extraFields {
+		m[v.Key] = v.Value
+	}
+
+	return m
+}
+
+func (u *httpUpload) OrderedExtraFields() []FormField {
 	return u.extraFields
 }
 
-func (u *httpUpload) Buffer() ([]byte, error) {
-	if len(u.resourceURL) != 0 {
-		resp, err := http.Get(u.resourceURL)
+func (u *httpUpload) Parts() []FormPart {
+	return u.parts
+}
+
+func (u *httpUpload) Buffer(ctx context.Context) ([]byte, error) {
+	return u.bufferWith(ctx, http.DefaultClient)
+}
 
-		if err != nil {
+// resourceFetcher is implemented by the form of NewUploadForm, so that Upload fetches the resource url
+// with its own http client (eg: proxy, tls, transport settings) instead of http.DefaultClient.
+type resourceFetcher interface {
+	bufferWith(ctx context.Context, client *http.Client) ([]byte, error)
+}
+
+func (u *httpUpload) bufferWith(ctx context.Context, client *http.Client) ([]byte, error) {
+	if u.resource != nil {
+		if err := u.checkSize(int64(len(u.resource))); err != nil {
 			return nil, err
 		}
 
-		defer resp.Body.Close()
+		return u.resource, nil
+	}
+
+	if u.reader != nil {
+		// the reader can be read only once, keep the data for resending (eg: retry with refreshed access_token or concurrent uploads)
+		u.readOnce.Do(func() {
+			u.readData, u.readErr = u.readAll(u.reader)
+		})
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("error http code: %d", resp.StatusCode)
-		}
+		return u.readData, u.readErr
+	}
 
-		return ioutil.ReadAll(resp.Body)
+	if len(u.resourceURL) != 0 {
+		return u.fetch(ctx, client)
 	}
 
 	path, err := filepath.Abs(u.filename)
@@ -90,23 +177,149 @@ func (u *httpUpload) Buffer() ([]byte, error) {
 		return nil, err
 	}
 
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = u.checkSize(info.Size()); err != nil {
+		return nil, err
+	}
+
 	return ioutil.ReadFile(path)
 }
 
+func (u *httpUpload) fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	if u.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, u.timeout)
+
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.resourceURL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error http code: %d", resp.StatusCode)
+	}
+
+	if err = u.checkSize(resp.ContentLength); err != nil {
+		return nil, err
+	}
+
+	return u.readAll(resp.Body)
+}
+
+// readAll reads at most maxSize bytes, one more byte is read to detect the overflow.
+func (u *httpUpload) readAll(r io.Reader) ([]byte, error) {
+	if u.maxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, u.maxSize+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = u.checkSize(int64(len(b))); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (u *httpUpload) checkSize(size int64) error {
+	if u.maxSize > 0 && size > u.maxSize {
+		return fmt.Errorf("%w: %s exceeds %d bytes", ErrMediaTooLarge, u.filename, u.maxSize)
+	}
+
+	return nil
+}
+
 // UploadOption configures how we set up the http upload from.
 type UploadOption func(u *httpUpload)
 
-// WithResourceURL specifies http upload by resource url.
+// WithResourceURL specifies http upload by resource url, which is fetched with the http client of Upload (eg: proxy, tls, transport settings of NewHTTPClientWith).
 func WithResourceURL(url string) UploadOption {
 	return func(u *httpUpload) {
 		u.resourceURL = url
 	}
 }
 
-// WithExtraField specifies the extra field to http upload from.
+// WithResourceBytes specifies http upload by in-memory content (eg: generated thumbnail), which takes precedence over resource url and local file.
+// The filename is still used for the multipart part.
+func WithResourceBytes(b []byte) UploadOption {
+	return func(u *httpUpload) {
+		u.resource = b
+		u.reader = nil
+	}
+}
+
+// WithResourceReader specifies http upload by reader (eg: object storage stream), which takes precedence over resource url and local file.
+// The reader is read once and the content is kept for resending. The filename is still used for the multipart part.
+func WithResourceReader(r io.Reader) UploadOption {
+	return func(u *httpUpload) {
+		u.reader = r
+		u.resource = nil
+	}
+}
+
+// WithReader specifies http upload by reader.
+//
+// Deprecated: use WithResourceReader instead.
+func WithReader(r io.Reader) UploadOption {
+	return WithResourceReader(r)
+}
+
+// WithResourceTimeout specifies the timeout for fetching the resource url, which is layered on the request context.
+func WithResourceTimeout(d time.Duration) UploadOption {
+	return func(u *httpUpload) {
+		u.timeout = d
+	}
+}
+
+// WithMaxSize specifies the max size (bytes) of upload media, default is DefaultUploadMaxSize; 0 means no limit.
+func WithMaxSize(n int64) UploadOption {
+	return func(u *httpUpload) {
+		u.maxSize = n
+	}
+}
+
+// WithExtraField specifies the extra field to http upload from, the extra fields are written in the order they were added,
+// and the value of an existing key is replaced in place.
 func WithExtraField(key, value string) UploadOption {
 	return func(u *httpUpload) {
-		u.extraFields[key] = value
+		for i, v := range u.extraFields {
+			if v.Key == key {
+				u.extraFields[i].Value = value
+
+				return
+			}
+		}
+
+		u.extraFields = append(u.extraFields, FormField{Key: key, Value: value})
+	}
+}
+
+// WithFormPart specifies an additional part to http upload form, the parts are written after the media file and extra fields in order.
+func WithFormPart(part FormPart) UploadOption {
+	return func(u *httpUpload) {
+		u.parts = append(u.parts, part)
 	}
 }
 
@@ -115,14 +328,12 @@ func NewUploadForm(fieldname, filename string, options ...UploadOption) UploadFo
 	form := &httpUpload{
 		fieldname: fieldname,
 		filename:  filename,
+		timeout:   defaultTimeout,
+		maxSize:   DefaultUploadMaxSize,
 	}
 
-	if len(options) != 0 {
-		form.extraFields = make(map[string]string)
-
-		for _, f := range options {
-			f(form)
-		}
+	for _, f := range options {
+		f(form)
 	}
 
 	return form
@@ -164,15 +375,22 @@ type wxapi struct {
 }
 
 func (a *wxapi) URL(accessToken ...string) string {
+	// 复制一份 query，避免同一个 Action 被并发执行时相互覆盖 access_token
+	query := make(url.Values, len(a.query)+1)
+
+	for k, v := range a.query {
+		query[k] = v
+	}
+
 	if len(accessToken) != 0 {
-		a.query.Set("access_token", accessToken[0])
+		query.Set("access_token", accessToken[0])
 	}
 
-	if len(a.query) == 0 {
+	if len(query) == 0 {
 		return a.reqURL
 	}
 
-	return fmt.Sprintf("%s?%s", a.reqURL, a.query.Encode())
+	return fmt.Sprintf("%s?%s", a.reqURL, query.Encode())
 }
 
 func (a *wxapi) Method() HTTPMethod {
@@ -0,0 +1,42 @@
+package wx
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionURL(t *testing.T) {
+	action := NewAction("https://api.weixin.qq.com/cgi-bin/user/info", WithQuery("openid", "OPENID"))
+
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", action.URL("ACCESS_TOKEN"))
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/user/info?openid=OPENID", action.URL())
+
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/token", NewAction("https://api.weixin.qq.com/cgi-bin/token").URL())
+}
+
+func TestActionURLConcurrent(t *testing.T) {
+	action := NewAction("https://api.weixin.qq.com/cgi-bin/user/info", WithQuery("openid", "OPENID"))
+
+	var wg sync.WaitGroup
+
+	urls := make([]string, 50)
+
+	for i := range urls {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			urls[i] = action.URL(fmt.Sprintf("TOKEN_%d", i))
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i, v := range urls {
+		assert.Equal(t, fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/user/info?access_token=TOKEN_%d&openid=OPENID", i), v)
+	}
+}
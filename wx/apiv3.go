@@ -0,0 +1,413 @@
+package wx
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// APIv3AuthSchema 微信支付APIv3认证类型
+const APIv3AuthSchema = "WECHATPAY2-SHA256-RSA2048"
+
+// apiv3Nonce returns the nonce for APIv3 signing
+var apiv3Nonce = func() string {
+	nonce := make([]byte, 16)
+	io.ReadFull(rand.Reader, nonce)
+
+	return hex.EncodeToString(nonce)
+}
+
+// apiv3Timestamp returns the timestamp for APIv3 signing
+var apiv3Timestamp = func() int64 {
+	return time.Now().Unix()
+}
+
+// BuildAuthHeader 生成微信支付APIv3请求的 Authorization 头（用商户私钥对「method\nurl\ntimestamp\nnonce\nbody\n」进行 SHA256-RSA 签名）
+// url 为请求的绝对URL（不含域名，包含查询参数），如：/v3/certificates，若传入完整URL，则自动去除域名
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_0.shtml)
+func BuildAuthHeader(privateKey *rsa.PrivateKey, mchID, serialNo, method, reqURL string, body []byte) (string, error) {
+	if privateKey == nil {
+		return "", errors.New("gochat: invalid rsa private key")
+	}
+
+	if u, err := url.Parse(reqURL); err == nil && len(u.Host) != 0 {
+		reqURL = u.RequestURI()
+	}
+
+	nonce := apiv3Nonce()
+	timestamp := apiv3Timestamp()
+
+	signStr := fmt.Sprintf("%s\n%s\n%d\n%s\n%s\n", method, reqURL, timestamp, nonce, body)
+
+	h := sha256.Sum256([]byte(signStr))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, h[:])
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`%s mchid="%s",nonce_str="%s",signature="%s",timestamp="%d",serial_no="%s"`, APIv3AuthSchema, mchID, nonce, base64.StdEncoding.EncodeToString(signature), timestamp, serialNo), nil
+}
+
+// VerifySignature 使用微信支付平台证书验证应答或回调的签名（即 Wechatpay-Signature 头，验签串为「timestamp\nnonce\nbody\n」）
+// timestamp 和 nonce 分别对应 Wechatpay-Timestamp 和 Wechatpay-Nonce 头
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_1.shtml)
+func VerifySignature(cert *x509.Certificate, timestamp, nonce, body, signature string) error {
+	if cert == nil {
+		return errors.New("gochat: invalid certificate")
+	}
+
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+
+	if !ok {
+		return errors.New("gochat: invalid rsa public key")
+	}
+
+	sign, err := base64.StdEncoding.DecodeString(signature)
+
+	if err != nil {
+		return err
+	}
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body)))
+
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, h[:], sign)
+}
+
+// DecryptNotify 使用APIv3密钥对回调通知中的 resource 进行 AEAD_AES_256_GCM 解密，返回明文JSON
+// ciphertext 为 Base64 编码的密文（resource.ciphertext），nonce 和 associatedData 分别对应 resource.nonce 和 resource.associated_data
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_2.shtml)
+func DecryptNotify(apiV3Key string, ciphertext, nonce, associatedData string) ([]byte, error) {
+	if len(apiV3Key) != 32 {
+		return nil, errors.New("gochat: invalid apiv3 key, length must be 32 bytes")
+	}
+
+	cipherText, err := base64.StdEncoding.DecodeString(ciphertext)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher([]byte(apiV3Key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+
+	if err != nil {
+		return nil, err
+	}
+
+	plainText, err := gcm.Open(nil, []byte(nonce), cipherText, []byte(associatedData))
+
+	if err != nil {
+		return nil, fmt.Errorf("gochat: apiv3 notify decrypt failed (authentication tag mismatch, please check the apiv3 key): %w", err)
+	}
+
+	return plainText, nil
+}
+
+// EncryptResource 使用APIv3密钥对明文进行 AEAD_AES_256_GCM 加密，返回 Base64 编码的密文（与微信回调通知 resource.ciphertext 格式一致）
+// 为 DecryptNotify 的逆过程，可用于构造回调通知进行测试
+func EncryptResource(apiV3Key, plaintext, nonce, associatedData string) (string, error) {
+	if len(apiV3Key) != 32 {
+		return "", errors.New("gochat: invalid apiv3 key, length must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher([]byte(apiV3Key))
+
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+
+	if err != nil {
+		return "", err
+	}
+
+	cipherText := gcm.Seal(nil, []byte(nonce), []byte(plaintext), []byte(associatedData))
+
+	return base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+// PlatformCertificate 微信支付平台证书
+type PlatformCertificate struct {
+	SerialNo    string            // 证书序列号（即 Wechatpay-Serial 头）
+	Certificate *x509.Certificate // 解密后的证书
+}
+
+// DecryptCertificates 解析「获取平台证书列表」（/v3/certificates）的应答，使用APIv3密钥解密各证书（AEAD_AES_256_GCM）
+// [参考](https://pay.weixin.qq.com/wiki/doc/apiv3/apis/wechatpay5_1.shtml)
+func DecryptCertificates(apiV3Key string, body []byte) ([]*PlatformCertificate, error) {
+	var resp struct {
+		Data []struct {
+			SerialNo           string `json:"serial_no"`
+			EncryptCertificate struct {
+				Algorithm      string `json:"algorithm"`
+				Nonce          string `json:"nonce"`
+				AssociatedData string `json:"associated_data"`
+				Ciphertext     string `json:"ciphertext"`
+			} `json:"encrypt_certificate"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	certs := make([]*PlatformCertificate, 0, len(resp.Data))
+
+	for _, v := range resp.Data {
+		pemData, err := DecryptNotify(apiV3Key, v.EncryptCertificate.Ciphertext, v.EncryptCertificate.Nonce, v.EncryptCertificate.AssociatedData)
+
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(pemData)
+
+		if block == nil {
+			return nil, fmt.Errorf("gochat: invalid platform certificate (serial_no: %s): no pem data", v.SerialNo)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+
+		if err != nil {
+			return nil, fmt.Errorf("gochat: invalid platform certificate (serial_no: %s): %w", v.SerialNo, err)
+		}
+
+		certs = append(certs, &PlatformCertificate{
+			SerialNo:    v.SerialNo,
+			Certificate: cert,
+		})
+	}
+
+	return certs, nil
+}
+
+// 平台证书的刷新策略：距上次更新超过12小时，或有证书将在24小时内过期时重新下载（新证书会在旧证书过期前启用）
+const (
+	platformCertRefreshInterval = 12 * time.Hour
+	platformCertRefreshBefore   = 24 * time.Hour
+)
+
+// platformCertMinDownloadInterval 缓存无需刷新时，未知序列号触发下载的最小间隔（避免伪造的回调通知反复触发下载）
+const platformCertMinDownloadInterval = time.Minute
+
+// 平台证书应答的签名头
+const (
+	headerWechatpayTimestamp = "Wechatpay-Timestamp"
+	headerWechatpayNonce     = "Wechatpay-Nonce"
+	headerWechatpaySignature = "Wechatpay-Signature"
+	headerWechatpaySerial    = "Wechatpay-Serial"
+)
+
+// PlatformCertFetcher 发送「获取平台证书列表」（/v3/certificates）请求（需签名 Authorization 头），返回成功应答的 body 和 header
+type PlatformCertFetcher func(ctx context.Context) ([]byte, http.Header, error)
+
+// PlatformCertCache 按序列号缓存的微信支付平台证书（并发安全），零值可直接使用
+type PlatformCertCache struct {
+	mu        sync.RWMutex
+	certs     map[string]*x509.Certificate
+	updatedAt time.Time
+	refreshAt time.Time
+	group     singleflight.Group
+}
+
+// Get 根据序列号获取缓存的证书，fresh 表示缓存是否无需刷新
+func (c *PlatformCertCache) Get(serialNo string, now time.Time) (cert *x509.Certificate, fresh bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.certs[serialNo], now.Before(c.refreshAt)
+}
+
+// Set 使用下载的证书替换缓存，并根据刷新策略计算下次刷新时间
+func (c *PlatformCertCache) Set(certs []*PlatformCertificate, now time.Time) {
+	m := make(map[string]*x509.Certificate, len(certs))
+	refreshAt := now.Add(platformCertRefreshInterval)
+
+	for _, v := range certs {
+		m[v.SerialNo] = v.Certificate
+
+		if t := v.Certificate.NotAfter.Add(-platformCertRefreshBefore); t.Before(refreshAt) {
+			refreshAt = t
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.certs = m
+	c.updatedAt = now
+	c.refreshAt = refreshAt
+}
+
+// Latest 返回当前有效且过期时间最晚的证书（即平台当前启用的证书），可用于加密敏感信息
+func (c *PlatformCertCache) Latest(now time.Time) (serialNo string, cert *x509.Certificate) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for k, v := range c.certs {
+		if now.Before(v.NotBefore) || !now.Before(v.NotAfter) {
+			continue
+		}
+
+		if cert == nil || v.NotAfter.After(cert.NotAfter) {
+			serialNo, cert = k, v
+		}
+	}
+
+	return
+}
+
+func (c *PlatformCertCache) lastUpdated() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.updatedAt
+}
+
+// NextRefresh 返回下次刷新时间（未缓存时为零值）
+func (c *PlatformCertCache) NextRefresh() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.refreshAt
+}
+
+// Lookup 根据序列号查找证书，优先使用本次下载的证书，其次使用缓存（用于验证「获取平台证书列表」应答的签名：首次下载时使用下载的证书验签）
+func (c *PlatformCertCache) Lookup(certs []*PlatformCertificate, serialNo string) *x509.Certificate {
+	for _, v := range certs {
+		if v.SerialNo == serialNo {
+			return v.Certificate
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.certs[serialNo]
+}
+
+// Download 下载平台证书：使用APIv3密钥解密各证书（AEAD_AES_256_GCM），并验证应答签名（首次下载时使用下载的证书验签），成功后替换缓存；
+// 并发调用只会发起一次下载，下载不随单个调用方的 ctx 取消（超时时间为 DefaultTokenRefreshTimeout）
+func (c *PlatformCertCache) Download(ctx context.Context, apiV3Key string, fetch PlatformCertFetcher, now time.Time) ([]*PlatformCertificate, error) {
+	v, err := SingleflightDo(ctx, &c.group, "certificates", DefaultTokenRefreshTimeout, func(ctx context.Context) (interface{}, error) {
+		return c.download(ctx, apiV3Key, fetch, now)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]*PlatformCertificate), nil
+}
+
+func (c *PlatformCertCache) download(ctx context.Context, apiV3Key string, fetch PlatformCertFetcher, now time.Time) ([]*PlatformCertificate, error) {
+	body, header, err := fetch(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := DecryptCertificates(apiV3Key, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serialNo := header.Get(headerWechatpaySerial)
+
+	cert := c.Lookup(certs, serialNo)
+
+	if cert == nil {
+		return nil, fmt.Errorf("gochat: platform certificate not found (serial_no: %s)", serialNo)
+	}
+
+	if err = VerifySignature(cert, header.Get(headerWechatpayTimestamp), header.Get(headerWechatpayNonce), string(body), header.Get(headerWechatpaySignature)); err != nil {
+		return nil, fmt.Errorf("gochat: platform certificates response signature verification failed (serial_no: %s): %w", serialNo, err)
+	}
+
+	c.Set(certs, now)
+
+	return certs, nil
+}
+
+// Certificate 根据序列号（即 Wechatpay-Serial 头）获取平台证书，优先使用缓存，缓存中不存在或需要刷新时自动下载；
+// 缓存无需刷新时，未知序列号（如：伪造的回调通知）最多每分钟触发一次下载；下载失败时，若缓存中的证书尚未过期则继续使用
+func (c *PlatformCertCache) Certificate(ctx context.Context, serialNo, apiV3Key string, fetch PlatformCertFetcher, now time.Time) (*x509.Certificate, error) {
+	cert, fresh := c.Get(serialNo, now)
+
+	if fresh {
+		if cert != nil {
+			return cert, nil
+		}
+
+		if now.Sub(c.lastUpdated()) < platformCertMinDownloadInterval {
+			return nil, fmt.Errorf("gochat: platform certificate not found (serial_no: %s)", serialNo)
+		}
+	}
+
+	if _, err := c.Download(ctx, apiV3Key, fetch, now); err != nil {
+		if cert != nil && now.Before(cert.NotAfter) {
+			return cert, nil
+		}
+
+		return nil, err
+	}
+
+	if cert, _ = c.Get(serialNo, now); cert == nil {
+		return nil, fmt.Errorf("gochat: platform certificate not found (serial_no: %s)", serialNo)
+	}
+
+	return cert, nil
+}
+
+// Current 获取当前有效且过期时间最晚的平台证书（可用于加密敏感信息），缓存中不存在或需要刷新时自动下载；
+// 下载失败时，若缓存中有当前有效的证书则继续使用
+func (c *PlatformCertCache) Current(ctx context.Context, apiV3Key string, fetch PlatformCertFetcher, now time.Time) (string, *x509.Certificate, error) {
+	if now.Before(c.NextRefresh()) {
+		if serialNo, cert := c.Latest(now); cert != nil {
+			return serialNo, cert, nil
+		}
+	}
+
+	if _, err := c.Download(ctx, apiV3Key, fetch, now); err != nil {
+		if serialNo, cert := c.Latest(now); cert != nil {
+			return serialNo, cert, nil
+		}
+
+		return "", nil, err
+	}
+
+	serialNo, cert := c.Latest(now)
+
+	if cert == nil {
+		return "", nil, errors.New("gochat: no valid platform certificate")
+	}
+
+	return serialNo, cert, nil
+}
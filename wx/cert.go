@@ -0,0 +1,46 @@
+package wx
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// LoadCertFromP12 loads the certificate from the p12(pfx) file (eg: the apiclient_cert.p12 of wechat pay, whose password is the mchid),
+// which can be used for the actions requiring TLS.
+func LoadCertFromP12(path, password string) (tls.Certificate, error) {
+	p12, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return LoadCertFromP12Bytes(p12, password)
+}
+
+// LoadCertFromP12Bytes loads the certificate from the p12(pfx) data in memory,
+// the error wraps pkcs12.ErrIncorrectPassword when the password is wrong.
+func LoadCertFromP12Bytes(p12 []byte, password string) (tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(p12, password)
+
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return tls.Certificate{}, fmt.Errorf("gochat: incorrect p12 password: %w", err)
+		}
+
+		return tls.Certificate{}, fmt.Errorf("gochat: invalid p12 data: %w", err)
+	}
+
+	pemData := make([]byte, 0)
+
+	for _, b := range blocks {
+		pemData = append(pemData, pem.EncodeToMemory(b)...)
+	}
+
+	// then use PEM data for tls to construct tls certificate:
+	return tls.X509KeyPair(pemData, pemData)
+}
@@ -0,0 +1,57 @@
+package wx
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pkcs12"
+)
+
+func TestLoadCertFromP12(t *testing.T) {
+	cert, err := LoadCertFromP12("testdata/apiclient_cert.p12", "1900000109")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cert.Certificate))
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1900000109", leaf.Subject.CommonName)
+
+	// 私钥与证书匹配
+	key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+
+	assert.True(t, ok)
+	assert.Equal(t, leaf.PublicKey, &key.PublicKey)
+
+	_, err = LoadCertFromP12("testdata/not_exist.p12", "1900000109")
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadCertFromP12Bytes(t *testing.T) {
+	p12, err := ioutil.ReadFile("testdata/apiclient_cert.p12")
+
+	assert.Nil(t, err)
+
+	cert, err := LoadCertFromP12Bytes(p12, "1900000109")
+
+	assert.Nil(t, err)
+	assert.NotNil(t, cert.PrivateKey)
+
+	// 密码错误
+	_, err = LoadCertFromP12Bytes(p12, "1900000110")
+
+	assert.True(t, errors.Is(err, pkcs12.ErrIncorrectPassword))
+	assert.Equal(t, "gochat: incorrect p12 password: pkcs12: decryption password incorrect", err.Error())
+
+	// 数据错误
+	_, err = LoadCertFromP12Bytes([]byte("invalid p12"), "1900000109")
+
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, pkcs12.ErrIncorrectPassword))
+}
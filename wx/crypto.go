@@ -6,9 +6,11 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 )
 
 // PaddingMode aes padding mode
@@ -164,18 +166,23 @@ func NewECBCrypto(key []byte, mode PaddingMode) AESCrypto {
 	}
 }
 
-// RSAEncrypt rsa encryption with public key
-func RSAEncrypt(data, publicKey []byte) ([]byte, error) {
+// ParseRSAPublicKey parses the PEM encoded rsa public key, both PKCS#1 (RSA PUBLIC KEY) and PKIX/PKCS#8 (PUBLIC KEY) encodings are supported.
+func ParseRSAPublicKey(publicKey []byte) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode(publicKey)
 
 	if block == nil {
 		return nil, errors.New("gochat: invalid rsa public key")
 	}
 
+	// 微信支付返回的公钥格式曾有变化，不依赖PEM类型，依次尝试 PKCS#1 与 PKIX
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
 	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("gochat: invalid rsa public key: %w", err)
 	}
 
 	key, ok := pubKey.(*rsa.PublicKey)
@@ -184,9 +191,58 @@ func RSAEncrypt(data, publicKey []byte) ([]byte, error) {
 		return nil, errors.New("gochat: invalid rsa public key")
 	}
 
+	return key, nil
+}
+
+// ParseRSAPrivateKey parses the PEM encoded rsa private key, both PKCS#1 (RSA PRIVATE KEY) and PKCS#8 (PRIVATE KEY, eg: apiclient_key.pem) encodings are supported.
+func ParseRSAPrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKey)
+
+	if block == nil {
+		return nil, errors.New("gochat: invalid rsa private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("gochat: invalid rsa private key: %w", err)
+	}
+
+	key, ok := pk.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("gochat: invalid rsa private key")
+	}
+
+	return key, nil
+}
+
+// RSAEncrypt rsa encryption (PKCS#1 v1.5 padding) with public key
+func RSAEncrypt(data, publicKey []byte) ([]byte, error) {
+	key, err := ParseRSAPublicKey(publicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return rsa.EncryptPKCS1v15(rand.Reader, key, data)
 }
 
+// RSAEncryptOAEP rsa encryption (OAEP padding with SHA-1, aka RSA_PKCS1_OAEP_PADDING) with public key
+func RSAEncryptOAEP(data, publicKey []byte) ([]byte, error) {
+	key, err := ParseRSAPublicKey(publicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, key, data, nil)
+}
+
 // RSADecrypt rsa decryption with private key
 func RSADecrypt(cipherText, privateKey []byte) ([]byte, error) {
 	block, _ := pem.Decode(privateKey)
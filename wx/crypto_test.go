@@ -2,6 +2,11 @@ package wx
 
 import (
 	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -89,6 +94,52 @@ func TestRSACrypto(t *testing.T) {
 	assert.Equal(t, "ILoveWechatPay", string(db))
 }
 
+func TestRSAEncryptOAEP(t *testing.T) {
+	block, _ := pem.Decode(privateKey)
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	assert.Nil(t, err)
+
+	// 公钥为 PKIX（PUBLIC KEY）或 PKCS#1（RSA PUBLIC KEY）格式
+	pkcs1Key := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+
+	for _, pubKey := range [][]byte{publicKey, pkcs1Key} {
+		eb, err := RSAEncryptOAEP([]byte("ILoveWechatPay"), pubKey)
+
+		assert.Nil(t, err)
+
+		db, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, eb, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "ILoveWechatPay", string(db))
+	}
+
+	_, err = RSAEncryptOAEP([]byte("ILoveWechatPay"), []byte("invalid key"))
+
+	assert.EqualError(t, err, "gochat: invalid rsa public key")
+}
+
+func TestParseRSAPrivateKey(t *testing.T) {
+	key, err := ParseRSAPrivateKey(privateKey)
+
+	assert.Nil(t, err)
+
+	// 商户API私钥（apiclient_key.pem）为 PKCS#8（PRIVATE KEY）格式
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+
+	assert.Nil(t, err)
+
+	pkcs8Key, err := ParseRSAPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, key, pkcs8Key)
+
+	_, err = ParseRSAPrivateKey([]byte("invalid key"))
+
+	assert.EqualError(t, err, "gochat: invalid rsa private key")
+}
+
 var (
 	privateKey []byte
 	publicKey  []byte
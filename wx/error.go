@@ -0,0 +1,79 @@
+package wx
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/tidwall/gjson"
+)
+
+// 常见的全局返回码
+// [参考](https://developers.weixin.qq.com/doc/offiaccount/Getting_Started/Global_Return_Code.html)
+const (
+	ErrCodeInvalidCredential  = 40001 // 获取 access_token 时 AppSecret 错误，或者 access_token 无效
+	ErrCodeAccessTokenExpired = 42001 // access_token 超时
+	ErrCodeQuotaReached       = 45009 // 接口调用超过限制
+)
+
+// APIError 微信API返回的业务错误（errcode != 0）
+type APIError struct {
+	ErrCode    int
+	ErrMsg     string
+	RequestURL string // 请求地址（不含查询参数，避免泄露 access_token 等）
+}
+
+// NewAPIError returns a new wechat api error
+func NewAPIError(reqURL string, errcode int, errmsg string) *APIError {
+	if u, err := url.Parse(reqURL); err == nil {
+		u.RawQuery = ""
+		u.Fragment = ""
+
+		reqURL = u.String()
+	}
+
+	return &APIError{
+		ErrCode:    errcode,
+		ErrMsg:     errmsg,
+		RequestURL: reqURL,
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d|%s", e.ErrCode, e.ErrMsg)
+}
+
+// Is reports whether target is an *APIError with the same errcode, so that errors.Is(err, &wx.APIError{ErrCode: 40001}) works.
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+
+	return ok && t.ErrCode == e.ErrCode
+}
+
+// DecodeAPIError returns an *APIError if the response body has a non-zero errcode, otherwise returns nil.
+func DecodeAPIError(reqURL string, resp []byte) error {
+	r := gjson.ParseBytes(resp)
+
+	if code := r.Get("errcode").Int(); code != 0 {
+		return NewAPIError(reqURL, int(code), r.Get("errmsg").String())
+	}
+
+	return nil
+}
+
+// IsCode reports whether err is (or wraps) an *APIError with one of the errcodes.
+func IsCode(err error, codes ...int) bool {
+	var e *APIError
+
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	for _, code := range codes {
+		if e.ErrCode == code {
+			return true
+		}
+	}
+
+	return false
+}
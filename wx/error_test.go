@@ -0,0 +1,40 @@
+package wx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIError(t *testing.T) {
+	err := NewAPIError("https://api.weixin.qq.com/cgi-bin/menu/get?access_token=ACCESS_TOKEN", 40001, "invalid credential")
+
+	assert.Equal(t, "40001|invalid credential", err.Error())
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/menu/get", err.RequestURL)
+
+	wrapped := fmt.Errorf("get menu: %w", err)
+
+	assert.True(t, IsCode(wrapped, ErrCodeInvalidCredential))
+	assert.True(t, IsCode(wrapped, ErrCodeAccessTokenExpired, ErrCodeInvalidCredential))
+	assert.False(t, IsCode(wrapped, ErrCodeQuotaReached))
+	assert.False(t, IsCode(errors.New("40001|invalid credential"), ErrCodeInvalidCredential))
+
+	assert.True(t, errors.Is(wrapped, &APIError{ErrCode: ErrCodeInvalidCredential}))
+	assert.False(t, errors.Is(wrapped, &APIError{ErrCode: ErrCodeQuotaReached}))
+}
+
+func TestDecodeAPIError(t *testing.T) {
+	err := DecodeAPIError("https://api.weixin.qq.com/sns/jscode2session?appid=APPID&secret=APPSECRET&js_code=JSCODE", []byte(`{"errcode":40029,"errmsg":"invalid code"}`))
+
+	var apiError *APIError
+
+	assert.True(t, errors.As(err, &apiError))
+	assert.Equal(t, 40029, apiError.ErrCode)
+	assert.Equal(t, "invalid code", apiError.ErrMsg)
+	assert.Equal(t, "https://api.weixin.qq.com/sns/jscode2session", apiError.RequestURL)
+
+	assert.Nil(t, DecodeAPIError("https://api.weixin.qq.com/cgi-bin/token", []byte(`{"errcode":0,"errmsg":"ok"}`)))
+	assert.Nil(t, DecodeAPIError("https://api.weixin.qq.com/cgi-bin/token", []byte(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`)))
+}
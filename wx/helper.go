@@ -25,19 +25,15 @@ func (c CDATA) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	}{string(c)}, start)
 }
 
-// FormatMap2XML format map to xml
+// FormatMap2XML format map to xml, the elements are sorted by key and the values are wrapped in CDATA
 func FormatMap2XML(m WXML) (string, error) {
 	var builder strings.Builder
 
 	builder.WriteString("<xml>")
 
-	for k, v := range m {
+	for _, k := range sortedKeys(m) {
 		builder.WriteString(fmt.Sprintf("<%s>", k))
-
-		if err := xml.EscapeText(&builder, []byte(v)); err != nil {
-			return "", err
-		}
-
+		writeCDATA(&builder, m[k])
 		builder.WriteString(fmt.Sprintf("</%s>", k))
 	}
 
@@ -46,7 +42,31 @@ func FormatMap2XML(m WXML) (string, error) {
 	return builder.String(), nil
 }
 
-// ParseXML2Map parse xml to map
+// Pretty returns the indented xml of m (eg: for debugging), the elements are sorted by key and the values are wrapped in CDATA
+func (m WXML) Pretty() string {
+	var builder strings.Builder
+
+	builder.WriteString("<xml>\n")
+
+	for _, k := range sortedKeys(m) {
+		builder.WriteString(fmt.Sprintf("  <%s>", k))
+		writeCDATA(&builder, m[k])
+		builder.WriteString(fmt.Sprintf("</%s>\n", k))
+	}
+
+	builder.WriteString("</xml>")
+
+	return builder.String()
+}
+
+// writeCDATA writes the value wrapped in CDATA, the "]]>" in value is split into two CDATA sections
+func writeCDATA(builder *strings.Builder, v string) {
+	builder.WriteString("<![CDATA[")
+	builder.WriteString(strings.Replace(v, "]]>", "]]]]><![CDATA[>", -1))
+	builder.WriteString("]]>")
+}
+
+// ParseXML2Map parse xml to map, the values can be either CDATA or plain text
 func ParseXML2Map(b []byte) (WXML, error) {
 	m := make(WXML)
 
@@ -26,6 +26,48 @@ func TestWXML(t *testing.T) {
 	assert.Equal(t, m, r)
 }
 
+func TestFormatMap2XML(t *testing.T) {
+	x, err := FormatMap2XML(WXML{
+		"body":      "腾讯充值中心-QQ会员充值",
+		"attach":    "a&b<c>d",
+		"total_fee": "1",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "<xml><attach><![CDATA[a&b<c>d]]></attach><body><![CDATA[腾讯充值中心-QQ会员充值]]></body><total_fee><![CDATA[1]]></total_fee></xml>", x)
+}
+
+func TestWXMLSpecialChars(t *testing.T) {
+	m := WXML{
+		"attach": `{"a":1,"b":"<x>&amp;</x>"}`,
+		"body":   "Gochat 😀 & <小程序>",
+		"detail": "a]]>b]]>",
+		"cdata":  "]]>",
+		"empty":  "",
+	}
+
+	x, err := FormatMap2XML(m)
+
+	assert.Nil(t, err)
+	assert.Contains(t, x, "<detail><![CDATA[a]]]]><![CDATA[>b]]]]><![CDATA[>]]></detail>")
+
+	r, err := ParseXML2Map([]byte(x))
+
+	assert.Nil(t, err)
+	assert.Equal(t, m, r)
+}
+
+func TestParseXML2MapPlainText(t *testing.T) {
+	r, err := ParseXML2Map([]byte("<xml><return_code><![CDATA[SUCCESS]]></return_code><attach>a&amp;b&lt;c</attach><total_fee>1</total_fee></xml>"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, WXML{
+		"return_code": "SUCCESS",
+		"attach":      "a&b<c",
+		"total_fee":   "1",
+	}, r)
+}
+
 func TestUint32Bytes(t *testing.T) {
 	i := uint32(250)
 	b := EncodeUint32ToBytes(i)
@@ -42,3 +84,26 @@ func TestMarshalWithNoEscapeHTML(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, `{"action":"long2short","long_url":"http://wap.koudaitong.com/v2/showcase/goods?alias=128wi9shh&spm=h56083&redirect_count=1"}`, string(b))
 }
+
+func TestWXMLPretty(t *testing.T) {
+	m := WXML{
+		"total_fee": "1",
+		"body":      "腾讯充值中心-QQ会员充值",
+		"attach":    "a]]>b",
+		"sign":      "9A0A8659F005D6984697E2CA0A9CF3B7",
+	}
+
+	x := m.Pretty()
+
+	assert.Equal(t, `<xml>
+  <attach><![CDATA[a]]]]><![CDATA[>b]]></attach>
+  <body><![CDATA[腾讯充值中心-QQ会员充值]]></body>
+  <sign><![CDATA[9A0A8659F005D6984697E2CA0A9CF3B7]]></sign>
+  <total_fee><![CDATA[1]]></total_fee>
+</xml>`, x)
+
+	r, err := ParseXML2Map([]byte(x))
+
+	assert.Nil(t, err)
+	assert.Equal(t, m, r)
+}
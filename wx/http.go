@@ -4,37 +4,167 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"mime/multipart"
 	"net"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/tidwall/gjson"
 )
 
 // defaultTimeout default http request timeout
 const defaultTimeout = 10 * time.Second
 
+// errcodeSystemBusy 微信返回的「系统繁忙，此时请开发者稍候再试」
+const errcodeSystemBusy = -1
+
 // httpSettings http request options
 type httpSettings struct {
-	headers map[string]string
-	cookies []*http.Cookie
-	close   bool
-	timeout time.Duration
+	headers       map[string]string
+	cookies       []*http.Cookie
+	close         bool
+	timeout       time.Duration
+	callTimeout   time.Duration
+	retryAttempts int
+	retryBackoff  BackoffFunc
+	debug         DebugFunc
+	logger        LoggerFunc
+	metrics       Metrics
+	limiter       Limiter
+}
+
+// DebugInfo is the information of one http request (each retry attempt is reported separately).
+// The access_token and secret in url, and the access_token, refresh_token, ticket and secret in request and response bodies are redacted.
+type DebugInfo struct {
+	Method       string
+	URL          string
+	RequestBody  []byte
+	ResponseBody []byte
+	StatusCode   int // 0 if no response
+	Duration     time.Duration
+	Err          error
+}
+
+// DebugFunc is the hook for tracing http requests.
+type DebugFunc func(ctx context.Context, info *DebugInfo)
+
+// LoggerFunc is the hook for logging http requests, the url and bodies are passed raw (not redacted),
+// so that the callers can redact them as needed (eg: access_token, secret, sign).
+type LoggerFunc func(ctx context.Context, method, url string, reqBody, respBody []byte, err error)
+
+// Metrics is the hook for collecting metrics (eg: prometheus) of http requests,
+// ObserveRequest is invoked once per call (Get, GetStream, Post, PostXML and Upload), whether it succeeded or not.
+// The endpoint is the url path without query (eg: /cgi-bin/user/info), so the cardinality stays bounded.
+// The status is of the last attempt (0 if no response), attempts is the number of requests sent (see WithRetry),
+// and d is the duration of the whole call, including the delays between retries.
+type Metrics interface {
+	ObserveRequest(endpoint string, method HTTPMethod, status, attempts int, err error, d time.Duration)
+}
+
+// NopMetrics is a Metrics which does nothing.
+type NopMetrics struct{}
+
+// ObserveRequest does nothing.
+func (NopMetrics) ObserveRequest(endpoint string, method HTTPMethod, status, attempts int, err error, d time.Duration) {
+}
+
+// BackoffFunc returns the delay before the n-th retry (n starts from 1).
+type BackoffFunc func(n int) time.Duration
+
+// ExponentialBackoff returns a BackoffFunc whose delay grows exponentially from base (base*2^(n-1)),
+// is capped at max (no cap if max <= 0) and has a random jitter in [delay/2, delay].
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(n int) time.Duration {
+		delay := base
+
+		for i := 1; i < n && (max <= 0 || delay < max); i++ {
+			delay *= 2
+		}
+
+		if max > 0 && delay > max {
+			delay = max
+		}
+
+		if delay <= 0 {
+			return 0
+		}
+
+		// equal jitter: [delay/2, delay]
+		half := delay / 2
+
+		return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+	}
+}
+
+// RetryError is returned when the request still fails after retries.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("gochat: request failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
 }
 
 // HTTPOption configures how we set up the http request
 type HTTPOption func(s *httpSettings)
 
-// WithHTTPHeader specifies the headers to http request.
+// WithDebugFunc specifies the hook which is invoked after each http request, whether it succeeded or not.
+func WithDebugFunc(f DebugFunc) HTTPOption {
+	return func(s *httpSettings) {
+		s.debug = f
+	}
+}
+
+// WithLogger specifies the hook which is invoked after each http request (including each retry attempt), whether it succeeded or not.
+func WithLogger(f LoggerFunc) HTTPOption {
+	return func(s *httpSettings) {
+		s.logger = f
+	}
+}
+
+// WithMetrics specifies the hook for collecting metrics of http requests.
+func WithMetrics(m Metrics) HTTPOption {
+	return func(s *httpSettings) {
+		s.metrics = m
+	}
+}
+
+// WithLimiter specifies the rate limiter which is consulted before each http request (eg: NewTokenBucketLimiter).
+func WithLimiter(l Limiter) HTTPOption {
+	return func(s *httpSettings) {
+		s.limiter = l
+	}
+}
+
+// WithHTTPHeader specifies the headers to http request, which overrides the default headers (eg: Content-Type of Post).
 func WithHTTPHeader(key, value string) HTTPOption {
 	return func(s *httpSettings) {
-		s.headers[key] = value
+		s.headers[http.CanonicalHeaderKey(key)] = value
 	}
 }
 
+// WithContentType specifies the Content-Type to http request (except Upload, which is always multipart/form-data).
+func WithContentType(ct string) HTTPOption {
+	return WithHTTPHeader("Content-Type", ct)
+}
+
 // WithHTTPCookies specifies the cookies to http request.
 func WithHTTPCookies(cookies ...*http.Cookie) HTTPOption {
 	return func(s *httpSettings) {
@@ -51,30 +181,133 @@ func WithHTTPClose() HTTPOption {
 	}
 }
 
-// WithHTTPTimeout specifies the timeout to http request.
+// WithHTTPTimeout specifies the timeout to http request (default: 10s).
+// The timeout is applied to each single request on top of the given context, whichever expires first wins,
+// and the returned error wraps context.DeadlineExceeded with the request url.
 func WithHTTPTimeout(timeout time.Duration) HTTPOption {
 	return func(s *httpSettings) {
 		s.timeout = timeout
 	}
 }
 
+// WithTimeout specifies the timeout of the whole call, which covers fetching the upload resource (WithResourceURL),
+// all the retry attempts and the delays between them, while WithHTTPTimeout limits each single request.
+// The returned error wraps context.DeadlineExceeded when the timeout expires.
+func WithTimeout(d time.Duration) HTTPOption {
+	return func(s *httpSettings) {
+		s.callTimeout = d
+	}
+}
+
+// WithRetry specifies the request to be retried at most maxAttempts times (including the first one) on transient failures,
+// the delay between two attempts is decided by backoff (eg: ExponentialBackoff).
+// GET requests are retried on network error, http 5xx and errcode -1 "system busy",
+// while POST (including xml and upload) requests are retried only when they never reached the server (eg: connection refused).
+// Other wechat business errors are never retried.
+func WithRetry(maxAttempts int, backoff BackoffFunc) HTTPOption {
+	return func(s *httpSettings) {
+		s.retryAttempts = maxAttempts
+		s.retryBackoff = backoff
+	}
+}
+
 // apiClient is a Client implementation for wechat http request
 type apiClient struct {
 	client  *http.Client
 	timeout time.Duration
 }
 
-func (c *apiClient) do(ctx context.Context, req *http.Request, options ...HTTPOption) ([]byte, error) {
-	settings := &httpSettings{timeout: c.timeout}
+func (c *apiClient) settings(options []HTTPOption) *httpSettings {
+	settings := &httpSettings{
+		headers: make(map[string]string),
+		timeout: c.timeout,
+	}
+
+	for _, f := range options {
+		f(settings)
+	}
+
+	return settings
+}
+
+// withCallTimeout derives the context with the timeout of the whole call (WithTimeout), the cancel func must be called.
+func (s *httpSettings) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.callTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.callTimeout)
+}
+
+func (c *apiClient) do(ctx context.Context, method HTTPMethod, reqURL string, body []byte, settings *httpSettings) ([]byte, error) {
+	if settings.metrics == nil {
+		b, _, _, err := c.retry(ctx, method, reqURL, body, settings)
+
+		return b, err
+	}
+
+	now := time.Now()
+
+	b, status, attempts, err := c.retry(ctx, method, reqURL, body, settings)
+
+	settings.metrics.ObserveRequest(endpoint(reqURL), method, status, attempts, err, time.Since(now))
+
+	return b, err
+}
+
+// retry sends the http request (retried as WithRetry), returns the response status code of the last attempt and the number of attempts.
+func (c *apiClient) retry(ctx context.Context, method HTTPMethod, reqURL string, body []byte, settings *httpSettings) ([]byte, int, int, error) {
+	if settings.retryAttempts <= 1 {
+		b, status, _, err := c.send(ctx, method, reqURL, body, settings)
+
+		return b, status, 1, err
+	}
+
+	var (
+		b      []byte
+		status int
+		err    error
+		retry  bool
+	)
+
+	attempts := 0
 
-	if len(options) != 0 {
-		settings.headers = make(map[string]string)
+	for attempts < settings.retryAttempts {
+		if attempts != 0 && settings.retryBackoff != nil {
+			timer := time.NewTimer(settings.retryBackoff(attempts))
 
-		for _, f := range options {
-			f(settings)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return nil, status, attempts, &RetryError{Attempts: attempts, Err: wrapURLError(method, reqURL, ctx.Err())}
+			case <-timer.C:
+			}
+		}
+
+		attempts++
+
+		b, status, retry, err = c.send(ctx, method, reqURL, body, settings)
+
+		if !retry {
+			break
 		}
 	}
 
+	if err != nil {
+		return nil, status, attempts, &RetryError{Attempts: attempts, Err: err}
+	}
+
+	return b, status, attempts, nil
+}
+
+func newRequest(method, reqURL string, body io.Reader, settings *httpSettings) (*http.Request, error) {
+	req, err := http.NewRequest(method, reqURL, body)
+
+	if err != nil {
+		return nil, err
+	}
+
 	// headers
 	if len(settings.headers) != 0 {
 		for k, v := range settings.headers {
@@ -93,6 +326,78 @@ func (c *apiClient) do(ctx context.Context, req *http.Request, options ...HTTPOp
 		req.Close = true
 	}
 
+	return req, nil
+}
+
+// send sends the http request once, returns the response status code and reports whether the request can be retried.
+func (c *apiClient) send(ctx context.Context, method HTTPMethod, reqURL string, body []byte, settings *httpSettings) ([]byte, int, bool, error) {
+	if settings.limiter != nil {
+		if err := settings.limiter.Wait(ctx, endpoint(reqURL)); err != nil {
+			return nil, 0, false, wrapURLError(method, reqURL, err)
+		}
+	}
+
+	if settings.debug == nil && settings.logger == nil {
+		return c.roundTrip(ctx, method, reqURL, body, settings)
+	}
+
+	now := time.Now()
+
+	b, status, retry, err := c.roundTrip(ctx, method, reqURL, body, settings)
+
+	settings.observe(ctx, method, reqURL, body, b, status, err, time.Since(now))
+
+	return b, status, retry, err
+}
+
+// observe invokes the debug and logger hooks (once per attempt)
+func (s *httpSettings) observe(ctx context.Context, method HTTPMethod, reqURL string, reqBody, respBody []byte, status int, err error, d time.Duration) {
+	if s.debug != nil {
+		s.debug(ctx, &DebugInfo{
+			Method:       string(method),
+			URL:          redactURL(reqURL),
+			RequestBody:  redactBody(reqBody),
+			ResponseBody: redactBody(respBody),
+			StatusCode:   status,
+			Duration:     d,
+			Err:          err,
+		})
+	}
+
+	if s.logger != nil {
+		s.logger(ctx, string(method), reqURL, reqBody, respBody, err)
+	}
+}
+
+// observeStream invokes the debug, logger and metrics hooks of GetStream, which is sent only once
+func (s *httpSettings) observeStream(ctx context.Context, reqURL string, status int, err error, d time.Duration) {
+	s.observe(ctx, MethodGet, reqURL, nil, nil, status, err, d)
+
+	if s.metrics != nil {
+		s.metrics.ObserveRequest(endpoint(reqURL), MethodGet, status, 1, err, d)
+	}
+}
+
+// roundTrip sends the http request once, returns the response status code and reports whether the request can be retried.
+func (c *apiClient) roundTrip(ctx context.Context, method HTTPMethod, reqURL string, body []byte, settings *httpSettings) ([]byte, int, bool, error) {
+	httpMethod := http.MethodGet
+
+	if method != MethodGet {
+		httpMethod = http.MethodPost
+	}
+
+	var bodyReader io.Reader
+
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := newRequest(httpMethod, reqURL, bodyReader, settings)
+
+	if err != nil {
+		return nil, 0, false, err
+	}
+
 	// timeout
 	ctx, cancel := context.WithTimeout(ctx, settings.timeout)
 
@@ -104,11 +409,11 @@ func (c *apiClient) do(ctx context.Context, req *http.Request, options ...HTTPOp
 		// If the context has been canceled, the context's error is probably more useful.
 		select {
 		case <-ctx.Done():
-			err = ctx.Err()
+			return nil, 0, false, wrapURLError(method, reqURL, ctx.Err())
 		default:
 		}
 
-		return nil, err
+		return nil, 0, method == MethodGet || isUnsentError(err), err
 	}
 
 	defer resp.Body.Close()
@@ -116,40 +421,177 @@ func (c *apiClient) do(ctx context.Context, req *http.Request, options ...HTTPOp
 	if resp.StatusCode != http.StatusOK {
 		io.Copy(ioutil.Discard, resp.Body)
 
-		return nil, fmt.Errorf("error http code: %d", resp.StatusCode)
+		return nil, resp.StatusCode, method == MethodGet && resp.StatusCode >= http.StatusInternalServerError, fmt.Errorf("error http code: %d", resp.StatusCode)
 	}
 
 	b, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return nil, err
+		select {
+		case <-ctx.Done():
+			return nil, resp.StatusCode, false, wrapURLError(method, reqURL, ctx.Err())
+		default:
+		}
+
+		return nil, resp.StatusCode, method == MethodGet, err
+	}
+
+	if method == MethodGet && gjson.GetBytes(b, "errcode").Int() == errcodeSystemBusy {
+		return b, resp.StatusCode, true, nil
+	}
+
+	return b, resp.StatusCode, false, nil
+}
+
+// the sensitive fields of url query (access_token, secret) and json body (access_token, refresh_token, ticket, secret) are redacted in DebugInfo
+var (
+	redactedQueryRegexp = regexp.MustCompile(`([?&](?:access_token|secret)=)[^&#]*`)
+	redactedBodyRegexp  = regexp.MustCompile(`("(?:access_token|refresh_token|ticket|secret)"\s*:\s*)"[^"]*"`)
+)
+
+func redactURL(reqURL string) string {
+	return redactedQueryRegexp.ReplaceAllString(reqURL, "${1}***")
+}
+
+func redactBody(body []byte) []byte {
+	return redactedBodyRegexp.ReplaceAll(body, []byte(`${1}"***"`))
+}
+
+// endpoint returns the url path without query
+func endpoint(reqURL string) string {
+	u, err := url.Parse(reqURL)
+
+	if err != nil {
+		return ""
 	}
 
-	return b, nil
+	return u.Path
+}
+
+// isUnsentError reports whether the request failed before it reached the server,
+// such as dial error, connection refused or connection closed (EOF) before any response.
+func isUnsentError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// wrapURLError wraps the error with the request method and url (query is omitted for safety, eg: access_token).
+func wrapURLError(method HTTPMethod, reqURL string, err error) error {
+	if u, perr := url.Parse(reqURL); perr == nil {
+		u.RawQuery = ""
+		reqURL = u.String()
+	}
+
+	return &url.Error{
+		Op:  string(method),
+		URL: reqURL,
+		Err: err,
+	}
 }
 
 // Get http get request
 func (c *apiClient) Get(ctx context.Context, url string, options ...HTTPOption) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	settings := c.settings(options)
+
+	ctx, cancel := settings.withCallTimeout(ctx)
+
+	defer cancel()
+
+	return c.do(ctx, MethodGet, url, nil, settings)
+}
+
+// GetStream http get request without buffering the response body, which is not retried.
+// The timeout covers reading the body as well, the body must be closed to release the resources.
+func (c *apiClient) GetStream(ctx context.Context, reqURL string, options ...HTTPOption) (io.ReadCloser, http.Header, error) {
+	settings := c.settings(options)
+
+	// the request is never retried, so the timeout of the whole call is the same as the single request
+	if settings.callTimeout > 0 && settings.callTimeout < settings.timeout {
+		settings.timeout = settings.callTimeout
+	}
+
+	req, err := newRequest(http.MethodGet, reqURL, nil, settings)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if settings.limiter != nil {
+		if err = settings.limiter.Wait(ctx, endpoint(reqURL)); err != nil {
+			return nil, nil, wrapURLError(MethodGet, reqURL, err)
+		}
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, settings.timeout)
+
+	now := time.Now()
+
+	resp, err := c.client.Do(req.WithContext(reqCtx))
+
+	if err != nil {
+		// If the context has been canceled, the context's error is probably more useful.
+		if reqCtx.Err() != nil {
+			err = wrapURLError(MethodGet, reqURL, reqCtx.Err())
+		}
+
+		cancel()
+
+		settings.observeStream(ctx, reqURL, 0, err, time.Since(now))
+
+		return nil, nil, err
 	}
 
-	return c.do(ctx, req, options...)
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		cancel()
+
+		err = fmt.Errorf("error http code: %d", resp.StatusCode)
+
+		settings.observeStream(ctx, reqURL, resp.StatusCode, err, time.Since(now))
+
+		return nil, nil, err
+	}
+
+	// the response body is streamed, so it's not reported
+	settings.observeStream(ctx, reqURL, resp.StatusCode, nil, time.Since(now))
+
+	return &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}, resp.Header, nil
+}
+
+// CloseIdleConnections closes the idle (keep-alive) connections, the in-flight requests are not interrupted.
+func (c *apiClient) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// cancelReadCloser cancels the request context when the body is closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (rc *cancelReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+
+	rc.cancel()
+
+	return err
 }
 
 // Post http post request
 func (c *apiClient) Post(ctx context.Context, url string, body []byte, options ...HTTPOption) ([]byte, error) {
-	options = append(options, WithHTTPHeader("Content-Type", "application/json; charset=utf-8"))
+	settings := c.settings(append([]HTTPOption{WithContentType("application/json; charset=utf-8")}, options...))
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	ctx, cancel := settings.withCallTimeout(ctx)
 
-	if err != nil {
-		return nil, err
-	}
+	defer cancel()
 
-	return c.do(ctx, req, options...)
+	return c.do(ctx, MethodPost, url, body, settings)
 }
 
 // PostXML http xml post request
@@ -160,60 +602,159 @@ func (c *apiClient) PostXML(ctx context.Context, url string, body WXML, options
 		return nil, err
 	}
 
-	options = append(options, WithHTTPHeader("Content-Type", "text/xml; charset=utf-8"))
+	settings := c.settings(append([]HTTPOption{WithContentType("text/xml; charset=utf-8")}, options...))
 
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(xmlStr))
+	ctx, cancel := settings.withCallTimeout(ctx)
 
-	if err != nil {
-		return nil, err
-	}
+	defer cancel()
 
-	return c.do(ctx, req, options...)
+	return c.do(ctx, MethodPost, url, []byte(xmlStr), settings)
 }
 
 // Upload http upload media
 func (c *apiClient) Upload(ctx context.Context, url string, form UploadForm, options ...HTTPOption) ([]byte, error) {
-	media, err := form.Buffer()
+	settings := c.settings(options)
 
-	if err != nil {
-		return nil, err
-	}
+	ctx, cancel := settings.withCallTimeout(ctx)
+
+	defer cancel()
 
 	buf := bytes.NewBuffer(make([]byte, 0, 4<<10)) // 4kb
 	w := multipart.NewWriter(buf)
 
+	if err := writeUploadForm(ctx, w, form, c.client); err != nil {
+		return nil, err
+	}
+
+	// the boundary is required, so it can't be overridden
+	WithContentType(w.FormDataContentType())(settings)
+
+	return c.do(ctx, MethodUpload, url, buf.Bytes(), settings)
+}
+
+// extraFields returns the extra fields in insertion order if the form is an OrderedForm, otherwise in key order
+func extraFields(form UploadForm) []FormField {
+	if of, ok := form.(OrderedForm); ok {
+		return of.OrderedExtraFields()
+	}
+
+	m := form.ExtraFields()
+
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	fields := make([]FormField, 0, len(keys))
+
+	for _, k := range keys {
+		fields = append(fields, FormField{Key: k, Value: m[k]})
+	}
+
+	return fields
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeUploadForm writes the media file, extra fields (in insertion order) and additional parts in order, then closes the writer.
+// The resource url of the form is fetched with the client if possible.
+func writeUploadForm(ctx context.Context, w *multipart.Writer, form UploadForm, client *http.Client) error {
+	var (
+		media []byte
+		err   error
+	)
+
+	if f, ok := form.(resourceFetcher); ok {
+		media, err = f.bufferWith(ctx, client)
+	} else {
+		media, err = form.Buffer(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
 	fw, err := w.CreateFormFile(form.FieldName(), form.FileName())
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if _, err = fw.Write(media); err != nil {
-		return nil, err
+		return err
 	}
 
 	// add extra fields
-	if extraFields := form.ExtraFields(); len(extraFields) != 0 {
-		for k, v := range extraFields {
-			if err = w.WriteField(k, v); err != nil {
-				return nil, err
-			}
+	for _, field := range extraFields(form) {
+		if err = w.WriteField(field.Key, field.Value); err != nil {
+			return err
 		}
 	}
 
-	options = append(options, WithHTTPHeader("Content-Type", w.FormDataContentType()))
+	// add additional parts
+	if mf, ok := form.(MultipartForm); ok {
+		for _, part := range mf.Parts() {
+			h := make(textproto.MIMEHeader)
+
+			if len(part.FileName) != 0 {
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(part.FieldName), quoteEscaper.Replace(part.FileName)))
+			} else {
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(part.FieldName)))
+			}
+
+			if len(part.ContentType) != 0 {
+				h.Set("Content-Type", part.ContentType)
+			} else if len(part.FileName) != 0 {
+				h.Set("Content-Type", "application/octet-stream")
+			}
+
+			pw, err := w.CreatePart(h)
+
+			if err != nil {
+				return err
+			}
+
+			if _, err = pw.Write(part.Content); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Don't forget to close the multipart writer.
 	// If you don't close it, your request will be missing the terminating boundary.
-	w.Close()
+	return w.Close()
+}
+
+// streamAdapter implements GetStream for BufferedHTTPClient by buffering the response
+type streamAdapter struct {
+	BufferedHTTPClient
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, buf)
+func (a *streamAdapter) GetStream(ctx context.Context, reqURL string, options ...HTTPOption) (io.ReadCloser, http.Header, error) {
+	b, err := a.Get(ctx, reqURL, options...)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", http.DetectContentType(b))
+
+	return ioutil.NopCloser(bytes.NewReader(b)), header, nil
+}
+
+// NewStreamAdapter returns an HTTPClient whose GetStream buffers the whole response via Get,
+// so that the existing BufferedHTTPClient implementations can still be used.
+// The Content-Type header is detected from the content.
+func NewStreamAdapter(c BufferedHTTPClient) HTTPClient {
+	if v, ok := c.(HTTPClient); ok {
+		return v
 	}
 
-	return c.do(ctx, req, options...)
+	return &streamAdapter{BufferedHTTPClient: c}
 }
 
 // NewHTTPClient returns a new http client
@@ -243,3 +784,84 @@ func NewHTTPClient(tlsCfg ...*tls.Config) HTTPClient {
 		timeout: defaultTimeout,
 	}
 }
+
+// NewHTTPClientWith returns a new http client which sends requests with the specified *http.Client,
+// so that connection pooling, dial timeout, proxy, etc. can be customized.
+// If tls config is specified, the transport of the client will be cloned with it (the given client is not modified),
+// the transport must be an *http.Transport (nil means http.DefaultTransport), otherwise the returned client fails every request with an error,
+// since the tls config (eg: the merchant certificate) cannot be applied to a custom http.RoundTripper.
+// When the transport already has a TLSClientConfig, only the client certificates of the tls config are merged into it,
+// so that the other settings (eg: RootCAs, InsecureSkipVerify) are kept.
+func NewHTTPClientWith(client *http.Client, tlsCfg ...*tls.Config) HTTPClient {
+	if len(tlsCfg) != 0 {
+		var (
+			t    *http.Transport
+			base *tls.Config
+		)
+
+		switch v := client.Transport.(type) {
+		case nil:
+			t = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			// the clone may be given a default TLSClientConfig, so check the original one
+			base = v.TLSClientConfig
+			t = v.Clone()
+		default:
+			return &errHTTPClient{err: fmt.Errorf("gochat: tls config (eg: the merchant certificate) cannot be applied to the transport %T, which must be an *http.Transport", v)}
+		}
+
+		t.TLSClientConfig = tlsCfg[0]
+
+		if base != nil {
+			t.TLSClientConfig = mergeTLSConfig(base, tlsCfg[0])
+		}
+
+		c := *client
+		c.Transport = t
+
+		client = &c
+	}
+
+	return &apiClient{
+		client:  client,
+		timeout: defaultTimeout,
+	}
+}
+
+// errHTTPClient fails every request with the error, which is returned when the http client cannot be set up (eg: NewHTTPClientWith)
+type errHTTPClient struct {
+	err error
+}
+
+func (c *errHTTPClient) Get(ctx context.Context, reqURL string, options ...HTTPOption) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c *errHTTPClient) GetStream(ctx context.Context, reqURL string, options ...HTTPOption) (io.ReadCloser, http.Header, error) {
+	return nil, nil, c.err
+}
+
+func (c *errHTTPClient) Post(ctx context.Context, reqURL string, body []byte, options ...HTTPOption) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c *errHTTPClient) PostXML(ctx context.Context, reqURL string, body WXML, options ...HTTPOption) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c *errHTTPClient) Upload(ctx context.Context, reqURL string, form UploadForm, options ...HTTPOption) ([]byte, error) {
+	return nil, c.err
+}
+
+// mergeTLSConfig returns a clone of base with the client certificates of cfg
+func mergeTLSConfig(base, cfg *tls.Config) *tls.Config {
+	merged := base.Clone()
+
+	merged.Certificates = append(merged.Certificates[:len(merged.Certificates):len(merged.Certificates)], cfg.Certificates...)
+
+	if cfg.GetClientCertificate != nil {
+		merged.GetClientCertificate = cfg.GetClientCertificate
+	}
+
+	return merged
+}
@@ -1,9 +1,25 @@
 package wx
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,15 +55,1156 @@ func TestUploadOption(t *testing.T) {
 		WithExtraField("introduction", "INTRODUCTION"),
 	}
 
-	upload := &httpUpload{extraFields: make(map[string]string)}
+	upload := new(httpUpload)
 
 	for _, f := range options {
 		f(upload)
 	}
 
 	assert.Equal(t, "https://img.test.com/test.jpg", upload.resourceURL)
-	assert.Equal(t, map[string]string{
-		"title":        "TITLE",
-		"introduction": "INTRODUCTION",
+	assert.Equal(t, []FormField{
+		{Key: "title", Value: "TITLE"},
+		{Key: "introduction", Value: "INTRODUCTION"},
 	}, upload.extraFields)
 }
+
+func TestUploadWithReader(t *testing.T) {
+	b, err := NewUploadForm("media", "qrcode.png", WithReader(strings.NewReader("QRCODE"))).Buffer(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("QRCODE"), b)
+}
+
+func TestUploadWithResourceReader(t *testing.T) {
+	form := NewUploadForm("media", "qrcode.png", WithResourceReader(bytes.NewBuffer([]byte("QRCODE"))))
+
+	assert.Equal(t, "media", form.FieldName())
+	assert.Equal(t, "qrcode.png", form.FileName())
+
+	b, err := form.Buffer(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("QRCODE"), b)
+
+	// read again for resending
+	b, err = form.Buffer(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("QRCODE"), b)
+}
+
+func TestUploadFormReuse(t *testing.T) {
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				b, err := ioutil.ReadAll(req.Body)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if !bytes.Contains(b, []byte("QRCODE")) {
+					return newTestResponse(http.StatusOK, `{"errcode":40005,"errmsg":"invalid file type"}`), nil
+				}
+
+				return newTestResponse(http.StatusOK, `{"type":"image","media_id":"MEDIA_ID"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	forms := []UploadForm{
+		NewUploadForm("media", "qrcode.png", WithResourceReader(strings.NewReader("QRCODE"))),
+		NewUploadForm("media", "qrcode.png", WithResourceBytes([]byte("QRCODE"))),
+	}
+
+	for _, form := range forms {
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				b, err := client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", form)
+
+				assert.Nil(t, err)
+				assert.Equal(t, []byte(`{"type":"image","media_id":"MEDIA_ID"}`), b)
+			}()
+		}
+
+		wg.Wait()
+	}
+}
+
+func TestUploadWithResourceBytes(t *testing.T) {
+	form := NewUploadForm("media", "thumb.jpg", WithResourceBytes([]byte("THUMB")), WithExtraField("title", "TITLE"))
+
+	assert.Equal(t, "media", form.FieldName())
+	assert.Equal(t, "thumb.jpg", form.FileName())
+	assert.Equal(t, map[string]string{"title": "TITLE"}, form.ExtraFields())
+
+	b, err := form.Buffer(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("THUMB"), b)
+}
+
+func TestUploadFormExtraFields(t *testing.T) {
+	assert.Equal(t, map[string]string{}, NewUploadForm("media", "test.jpg").ExtraFields())
+}
+
+func TestUploadWithResourceURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("IMAGE"))
+	}))
+
+	defer ts.Close()
+
+	b, err := NewUploadForm("media", "test.jpg", WithResourceURL(ts.URL)).Buffer(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("IMAGE"), b)
+
+	_, err = NewUploadForm("media", "test.jpg", WithResourceURL(ts.URL), WithMaxSize(4)).Buffer(context.TODO())
+
+	assert.True(t, errors.Is(err, ErrMediaTooLarge))
+}
+
+func TestUploadWithResourceURLClient(t *testing.T) {
+	paths := make([]string, 0)
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Host+req.URL.Path)
+
+				if req.URL.Host == "img.test.com" {
+					return newTestResponse(http.StatusOK, "IMAGE"), nil
+				}
+
+				return newTestResponse(http.StatusOK, `{"type":"image","media_id":"MEDIA_ID"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	_, err := client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", NewUploadForm("media", "test.jpg", WithResourceURL("https://img.test.com/test.jpg")))
+
+	assert.Nil(t, err)
+
+	// the resource is fetched with the transport of the client
+	assert.Equal(t, []string{"img.test.com/test.jpg", "api.weixin.qq.com/cgi-bin/media/upload"}, paths)
+}
+
+func TestUploadWithResourceURLCanceled(t *testing.T) {
+	done := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+
+	defer ts.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+
+	defer cancel()
+
+	_, err := NewUploadForm("media", "test.jpg", WithResourceURL(ts.URL)).Buffer(ctx)
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	_, err = NewUploadForm("media", "test.jpg", WithResourceURL(ts.URL), WithResourceTimeout(50*time.Millisecond)).Buffer(context.TODO())
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestUploadMaxSize(t *testing.T) {
+	_, err := NewUploadForm("media", "thumb.jpg", WithResourceBytes([]byte("THUMB")), WithMaxSize(4)).Buffer(context.TODO())
+
+	assert.True(t, errors.Is(err, ErrMediaTooLarge))
+
+	_, err = NewUploadForm("media", "thumb.jpg", WithResourceReader(strings.NewReader("THUMB")), WithMaxSize(4)).Buffer(context.TODO())
+
+	assert.True(t, errors.Is(err, ErrMediaTooLarge))
+
+	b, err := NewUploadForm("media", "thumb.jpg", WithResourceReader(strings.NewReader("THUMB")), WithMaxSize(0)).Buffer(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("THUMB"), b)
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newTestResponse(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestHTTPHeader(t *testing.T) {
+	var header http.Header
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				header = req.Header
+
+				return newTestResponse(http.StatusOK, `{"errcode":0,"errmsg":"ok"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	// default
+	_, err := client.Post(context.TODO(), "https://api.mch.weixin.qq.com/v3/test", []byte(`{}`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "application/json; charset=utf-8", header.Get("Content-Type"))
+
+	// per-request headers override the defaults
+	_, err = client.Post(context.TODO(), "https://api.mch.weixin.qq.com/v3/test", []byte(`{}`),
+		WithContentType("application/json"),
+		WithHTTPHeader("wechatpay-serial", "SERIAL_NO"),
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "SERIAL_NO", header.Get("Wechatpay-Serial"))
+
+	_, err = client.PostXML(context.TODO(), "https://api.mch.weixin.qq.com/pay/test", WXML{}, WithHTTPHeader("content-type", "application/xml"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "application/xml", header.Get("Content-Type"))
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", WithHTTPHeader("Accept-Language", "zh-CN"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "zh-CN", header.Get("Accept-Language"))
+
+	// upload is always multipart/form-data
+	_, err = client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload", NewUploadForm("media", "test.jpg", WithResourceBytes([]byte("IMAGE"))), WithContentType("image/jpeg"), WithHTTPHeader("X-Trace-ID", "TRACE"))
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(header.Get("Content-Type"), "multipart/form-data; boundary="))
+	assert.Equal(t, "TRACE", header.Get("X-Trace-Id"))
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				switch calls {
+				case 1:
+					return nil, errors.New("connection reset by peer")
+				case 2:
+					return newTestResponse(http.StatusServiceUnavailable, ""), nil
+				case 3:
+					return newTestResponse(http.StatusOK, `{"errcode":-1,"errmsg":"system error"}`), nil
+				}
+
+				return newTestResponse(http.StatusOK, `{"errcode":0,"errmsg":"ok"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	b, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", WithRetry(4, ExponentialBackoff(time.Millisecond, 0)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []byte(`{"errcode":0,"errmsg":"ok"}`), b)
+}
+
+func TestRetryAttempts(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				return newTestResponse(http.StatusBadGateway, ""), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", WithRetry(3, ExponentialBackoff(time.Millisecond, 0)))
+
+	var retryErr *RetryError
+
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, 3, retryErr.Attempts)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryBusinessError(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				return newTestResponse(http.StatusOK, `{"errcode":40001,"errmsg":"invalid credential"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	b, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", WithRetry(3, ExponentialBackoff(time.Millisecond, 0)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []byte(`{"errcode":40001,"errmsg":"invalid credential"}`), b)
+}
+
+func TestRetryPost(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				switch calls {
+				case 1:
+					return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+				case 2:
+					return nil, io.EOF
+				}
+
+				return newTestResponse(http.StatusOK, `{"errcode":0,"errmsg":"ok"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	b, err := client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", []byte(`{}`), WithRetry(3, ExponentialBackoff(time.Millisecond, 0)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []byte(`{"errcode":0,"errmsg":"ok"}`), b)
+}
+
+func TestRetryPostReachedServer(t *testing.T) {
+	responses := []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			return newTestResponse(http.StatusBadGateway, ""), nil
+		},
+		func() (*http.Response, error) {
+			return newTestResponse(http.StatusOK, `{"errcode":-1,"errmsg":"system error"}`), nil
+		},
+		func() (*http.Response, error) {
+			return nil, errors.New("connection reset by peer")
+		},
+	}
+
+	f, err := ioutil.TempFile("", "gochat")
+
+	assert.Nil(t, err)
+
+	defer os.Remove(f.Name())
+
+	f.WriteString("media")
+	f.Close()
+
+	for _, resp := range responses {
+		calls := 0
+
+		client := &apiClient{
+			client: &http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					calls++
+
+					return resp()
+				}),
+			},
+			timeout: defaultTimeout,
+		}
+
+		client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", []byte(`{}`), WithRetry(3, ExponentialBackoff(time.Millisecond, 0)))
+		client.PostXML(context.TODO(), "https://api.mch.weixin.qq.com/pay/test", WXML{"appid": "APPID"}, WithRetry(3, ExponentialBackoff(time.Millisecond, 0)))
+		client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload", NewUploadForm("media", f.Name()), WithRetry(3, ExponentialBackoff(time.Millisecond, 0)))
+
+		assert.Equal(t, 3, calls)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				cancel()
+
+				return newTestResponse(http.StatusBadGateway, ""), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	_, err := client.Get(ctx, "https://api.weixin.qq.com/cgi-bin/test", WithRetry(3, ExponentialBackoff(time.Second, 0)))
+
+	var retryErr *RetryError
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, 1, retryErr.Attempts)
+	assert.Equal(t, 1, calls)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, 300*time.Millisecond)
+
+	for n, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		d := backoff(n + 1)
+
+		assert.True(t, d >= max/2 && d <= max)
+	}
+}
+
+func TestNewHTTPClientWith(t *testing.T) {
+	methods := make([]string, 0, 4)
+
+	client := NewHTTPClientWith(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			methods = append(methods, req.Method)
+
+			return newTestResponse(http.StatusOK, "OK"), nil
+		}),
+	})
+
+	f, err := ioutil.TempFile("", "gochat")
+
+	assert.Nil(t, err)
+
+	defer os.Remove(f.Name())
+
+	f.WriteString("media")
+	f.Close()
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test")
+	assert.Nil(t, err)
+
+	_, err = client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/test", []byte(`{}`))
+	assert.Nil(t, err)
+
+	_, err = client.PostXML(context.TODO(), "https://api.mch.weixin.qq.com/pay/test", WXML{"appid": "APPID"})
+	assert.Nil(t, err)
+
+	_, err = client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload", NewUploadForm("media", f.Name()))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{http.MethodGet, http.MethodPost, http.MethodPost, http.MethodPost}, methods)
+}
+
+func TestNewHTTPClientWithTLS(t *testing.T) {
+	transport := &http.Transport{MaxIdleConnsPerHost: 10}
+	tlsCfg := &tls.Config{InsecureSkipVerify: true}
+
+	client := NewHTTPClientWith(&http.Client{Transport: transport}, tlsCfg).(*apiClient)
+
+	v, ok := client.client.Transport.(*http.Transport)
+
+	assert.True(t, ok)
+	assert.Equal(t, 10, v.MaxIdleConnsPerHost)
+	assert.Equal(t, tlsCfg, v.TLSClientConfig)
+
+	// the given transport is not modified
+	assert.True(t, transport.TLSClientConfig != tlsCfg)
+}
+
+func TestNewHTTPClientWithTLSCustomTransport(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newTestResponse(http.StatusOK, "OK"), nil
+	})
+
+	// the tls config cannot be applied to a custom round tripper, every request fails
+	client := NewHTTPClientWith(&http.Client{Transport: transport}, &tls.Config{InsecureSkipVerify: true})
+
+	_, err := client.Get(context.TODO(), "https://api.mch.weixin.qq.com/secapi/pay/refund")
+
+	assert.EqualError(t, err, "gochat: tls config (eg: the merchant certificate) cannot be applied to the transport wx.roundTripFunc, which must be an *http.Transport")
+
+	_, err = client.PostXML(context.TODO(), "https://api.mch.weixin.qq.com/secapi/pay/refund", WXML{"appid": "APPID"})
+
+	assert.NotNil(t, err)
+
+	_, _, err = client.GetStream(context.TODO(), "https://api.mch.weixin.qq.com/secapi/pay/refund")
+
+	assert.NotNil(t, err)
+
+	b, err := NewHTTPClientWith(&http.Client{Transport: transport}).Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("OK"), b)
+}
+
+func TestNewHTTPClientWithTLSMerge(t *testing.T) {
+	base := &tls.Config{ServerName: "api.mch.weixin.qq.com", MinVersion: tls.VersionTLS12}
+	transport := &http.Transport{TLSClientConfig: base}
+
+	cert := tls.Certificate{Certificate: [][]byte{[]byte("CERT")}}
+
+	client := NewHTTPClientWith(&http.Client{Transport: transport}, &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	}).(*apiClient)
+
+	v, ok := client.client.Transport.(*http.Transport)
+
+	assert.True(t, ok)
+
+	// the settings of the transport are kept, and the certificate is merged
+	assert.Equal(t, "api.mch.weixin.qq.com", v.TLSClientConfig.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), v.TLSClientConfig.MinVersion)
+	assert.False(t, v.TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, []tls.Certificate{cert}, v.TLSClientConfig.Certificates)
+
+	// the given transport is not modified
+	assert.Equal(t, 0, len(base.Certificates))
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+
+		w.Write([]byte("OK"))
+	}))
+
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "gochat")
+
+	assert.Nil(t, err)
+
+	defer os.Remove(f.Name())
+
+	f.WriteString("media")
+	f.Close()
+
+	client := NewHTTPClient()
+
+	requests := map[string]func() ([]byte, error){
+		"GET": func() ([]byte, error) {
+			return client.Get(context.TODO(), ts.URL+"/get?access_token=ACCESS_TOKEN", WithHTTPTimeout(50*time.Millisecond))
+		},
+		"POST": func() ([]byte, error) {
+			return client.Post(context.TODO(), ts.URL+"/post?access_token=ACCESS_TOKEN", []byte(`{}`), WithHTTPTimeout(50*time.Millisecond))
+		},
+		"POST_XML": func() ([]byte, error) {
+			return client.PostXML(context.TODO(), ts.URL+"/post?access_token=ACCESS_TOKEN", WXML{"appid": "APPID"}, WithHTTPTimeout(50*time.Millisecond))
+		},
+		"UPLOAD": func() ([]byte, error) {
+			return client.Upload(context.TODO(), ts.URL+"/upload?access_token=ACCESS_TOKEN", NewUploadForm("media", f.Name()), WithHTTPTimeout(50*time.Millisecond))
+		},
+	}
+
+	for name, request := range requests {
+		b, err := request()
+
+		assert.Nil(t, b, name)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), name)
+		assert.Contains(t, err.Error(), ts.URL, name)
+		assert.NotContains(t, err.Error(), "ACCESS_TOKEN", name)
+	}
+}
+
+func TestHTTPTimeoutWithContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+
+		w.Write([]byte("OK"))
+	}))
+
+	defer ts.Close()
+
+	client := NewHTTPClient()
+
+	// the earlier deadline of context wins
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+
+	defer cancel()
+
+	_, err := client.Get(ctx, ts.URL, WithHTTPTimeout(time.Minute))
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+
+		w.Write([]byte("OK"))
+	}))
+
+	defer ts.Close()
+
+	client := NewHTTPClient()
+
+	requests := map[string]func() ([]byte, error){
+		"GET": func() ([]byte, error) {
+			return client.Get(context.TODO(), ts.URL+"/get?access_token=ACCESS_TOKEN", WithTimeout(50*time.Millisecond))
+		},
+		"POST": func() ([]byte, error) {
+			return client.Post(context.TODO(), ts.URL+"/post?access_token=ACCESS_TOKEN", []byte(`{}`), WithTimeout(50*time.Millisecond))
+		},
+		"POST_XML": func() ([]byte, error) {
+			return client.PostXML(context.TODO(), ts.URL+"/post?access_token=ACCESS_TOKEN", WXML{"appid": "APPID"}, WithTimeout(50*time.Millisecond))
+		},
+		// the slow resource is covered as well
+		"UPLOAD": func() ([]byte, error) {
+			return client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", NewUploadForm("media", "test.jpg", WithResourceURL(ts.URL+"/test.jpg")), WithTimeout(50*time.Millisecond))
+		},
+	}
+
+	for name, request := range requests {
+		now := time.Now()
+
+		b, err := request()
+
+		assert.Nil(t, b, name)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), name)
+		assert.True(t, time.Since(now) < 500*time.Millisecond, name)
+	}
+
+	rc, _, err := client.GetStream(context.TODO(), ts.URL+"/get?access_token=ACCESS_TOKEN", WithTimeout(50*time.Millisecond))
+
+	assert.Nil(t, rc)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestTimeoutWithRetry(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				return newTestResponse(http.StatusBadGateway, ""), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	// the timeout covers all the attempts and the delays between them
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN", WithTimeout(50*time.Millisecond), WithRetry(10, func(n int) time.Duration {
+		return 30 * time.Millisecond
+	}))
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 2, calls)
+}
+
+func TestWriteUploadForm(t *testing.T) {
+	form := NewUploadForm("media", "test.mp4",
+		WithResourceBytes([]byte("VIDEO")),
+		WithExtraField("introduction", "INTRODUCTION"),
+		WithFormPart(FormPart{
+			FieldName:   "description",
+			ContentType: "application/json",
+			Content:     []byte(`{"introduction":"INTRODUCTION","title":"TITLE"}`),
+		}),
+		WithFormPart(FormPart{
+			FieldName:   "thumb",
+			FileName:    "thumb.jpg",
+			ContentType: "image/jpeg",
+			Content:     []byte("THUMB"),
+		}),
+	)
+
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	assert.Nil(t, w.SetBoundary("gochat"))
+	assert.Nil(t, writeUploadForm(context.TODO(), w, form, http.DefaultClient))
+
+	golden, err := ioutil.ReadFile("testdata/upload_multipart.golden")
+
+	assert.Nil(t, err)
+	assert.Equal(t, string(golden), buf.String())
+}
+
+func TestWriteUploadFormFieldOrder(t *testing.T) {
+	form := NewUploadForm("media", "test.mp4",
+		WithResourceBytes([]byte("VIDEO")),
+		WithExtraField("title", "TITLE"),
+		WithExtraField("introduction", "INTRODUCTION"),
+		WithExtraField("author", "AUTHOR"),
+		WithExtraField("title", "NEW_TITLE"),
+	)
+
+	assert.Equal(t, map[string]string{"title": "NEW_TITLE", "introduction": "INTRODUCTION", "author": "AUTHOR"}, form.ExtraFields())
+
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	assert.Nil(t, writeUploadForm(context.TODO(), w, form, http.DefaultClient))
+
+	r := multipart.NewReader(buf, w.Boundary())
+
+	names := make([]string, 0, 4)
+
+	for {
+		p, err := r.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		assert.Nil(t, err)
+
+		names = append(names, p.FormName())
+	}
+
+	assert.Equal(t, []string{"media", "title", "introduction", "author"}, names)
+}
+
+func TestWriteUploadFormFieldKeyOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the extra fields of the UploadForm without OrderedExtraFields are written in key order
+	form := NewMockUploadForm(ctrl)
+
+	form.EXPECT().Buffer(gomock.Any()).Return([]byte("IMAGE"), nil)
+	form.EXPECT().FieldName().Return("media")
+	form.EXPECT().FileName().Return("test.jpg")
+	form.EXPECT().ExtraFields().Return(map[string]string{"title": "TITLE", "introduction": "INTRODUCTION"})
+
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	assert.Nil(t, writeUploadForm(context.TODO(), w, form, http.DefaultClient))
+
+	r := multipart.NewReader(buf, w.Boundary())
+
+	names := make([]string, 0, 3)
+
+	for {
+		p, err := r.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		assert.Nil(t, err)
+
+		names = append(names, p.FormName())
+	}
+
+	assert.Equal(t, []string{"media", "introduction", "title"}, names)
+}
+
+func TestGetStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte("VIDEO"))
+	}))
+
+	defer ts.Close()
+
+	body, header, err := NewHTTPClient().GetStream(context.TODO(), ts.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "video/mp4", header.Get("Content-Type"))
+
+	b, err := ioutil.ReadAll(body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("VIDEO"), b)
+	assert.Nil(t, body.Close())
+}
+
+type bufferedClient struct {
+	BufferedHTTPClient
+}
+
+func (c *bufferedClient) Get(ctx context.Context, reqURL string, options ...HTTPOption) ([]byte, error) {
+	return []byte(`{"errcode":40007,"errmsg":"invalid media_id"}`), nil
+}
+
+func TestNewStreamAdapter(t *testing.T) {
+	client := NewStreamAdapter(&bufferedClient{})
+
+	body, header, err := client.GetStream(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/get")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", header.Get("Content-Type"))
+
+	b, err := ioutil.ReadAll(body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"errcode":40007,"errmsg":"invalid media_id"}`), b)
+
+	// HTTPClient is returned as it is
+	c := NewHTTPClient()
+
+	assert.Equal(t, c, NewStreamAdapter(c))
+}
+
+func TestDebugFunc(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				if calls == 1 {
+					return newTestResponse(http.StatusBadGateway, ""), nil
+				}
+
+				return newTestResponse(http.StatusOK, `{"access_token":"ACCESS_TOKEN","expires_in":7200}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	infos := make([]*DebugInfo, 0)
+
+	debug := WithDebugFunc(func(ctx context.Context, info *DebugInfo) {
+		infos = append(infos, info)
+	})
+
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET", debug)
+
+	assert.NotNil(t, err)
+
+	_, err = client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/stable_token", []byte(`{"appid":"APPID","force_refresh":false,"grant_type":"client_credential","secret":"APPSECRET"}`), debug)
+
+	assert.Nil(t, err)
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", debug)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(infos))
+
+	assert.Equal(t, "GET", infos[0].Method)
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=***", infos[0].URL)
+	assert.Equal(t, http.StatusBadGateway, infos[0].StatusCode)
+	assert.NotNil(t, infos[0].Err)
+
+	assert.Equal(t, "POST", infos[1].Method)
+	assert.Equal(t, []byte(`{"appid":"APPID","force_refresh":false,"grant_type":"client_credential","secret":"***"}`), infos[1].RequestBody)
+	assert.Equal(t, []byte(`{"access_token":"***","expires_in":7200}`), infos[1].ResponseBody)
+	assert.Equal(t, http.StatusOK, infos[1].StatusCode)
+	assert.Nil(t, infos[1].Err)
+
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/user/info?access_token=***&openid=OPENID", infos[2].URL)
+}
+
+func TestDebugFuncRedactResponse(t *testing.T) {
+	resp := map[string]string{
+		"/cgi-bin/token":            `{"access_token":"ACCESS_TOKEN","expires_in":7200}`,
+		"/cgi-bin/ticket/getticket": `{"errcode":0,"errmsg":"ok","ticket":"JSAPI_TICKET","expires_in":7200}`,
+		"/sns/oauth2/access_token":  `{"access_token":"ACCESS_TOKEN","expires_in":7200,"refresh_token":"REFRESH_TOKEN","openid":"OPENID","scope":"snsapi_userinfo"}`,
+	}
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return newTestResponse(http.StatusOK, resp[req.URL.Path]), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	infos := make([]*DebugInfo, 0)
+
+	debug := WithDebugFunc(func(ctx context.Context, info *DebugInfo) {
+		infos = append(infos, info)
+	})
+
+	var raw []byte
+
+	logger := WithLogger(func(ctx context.Context, method, url string, reqBody, respBody []byte, err error) {
+		raw = respBody
+	})
+
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=APPID&secret=APPSECRET", debug, logger)
+
+	assert.Nil(t, err)
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=ACCESS_TOKEN&type=jsapi", debug)
+
+	assert.Nil(t, err)
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/sns/oauth2/access_token?appid=APPID&secret=APPSECRET&code=CODE&grant_type=authorization_code", debug)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(infos))
+
+	assert.Equal(t, []byte(`{"access_token":"***","expires_in":7200}`), infos[0].ResponseBody)
+	assert.Equal(t, []byte(`{"errcode":0,"errmsg":"ok","ticket":"***","expires_in":7200}`), infos[1].ResponseBody)
+	assert.Equal(t, []byte(`{"access_token":"***","expires_in":7200,"refresh_token":"***","openid":"OPENID","scope":"snsapi_userinfo"}`), infos[2].ResponseBody)
+
+	// the logger is passed the raw body
+	assert.Equal(t, []byte(resp["/cgi-bin/token"]), raw)
+}
+
+func TestWithLogger(t *testing.T) {
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return newTestResponse(http.StatusOK, `{"openid":"OPENID"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	var (
+		method  string
+		reqURL  string
+		reqBody []byte
+		body    []byte
+		err     error
+	)
+
+	logger := WithLogger(func(ctx context.Context, m, u string, rb, b []byte, e error) {
+		method, reqURL, reqBody, body, err = m, u, rb, b, e
+	})
+
+	_, e := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", logger)
+
+	assert.Nil(t, e)
+	assert.Equal(t, "GET", method)
+	// not redacted
+	assert.Equal(t, "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", reqURL)
+	assert.Nil(t, reqBody)
+	assert.Equal(t, []byte(`{"openid":"OPENID"}`), body)
+	assert.Nil(t, err)
+}
+
+func TestObserveWithoutHooksNoAlloc(t *testing.T) {
+	settings := &httpSettings{}
+
+	body := []byte(`{"openid":"OPENID"}`)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		settings.observe(context.TODO(), MethodGet, "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN", nil, body, http.StatusOK, nil, time.Millisecond)
+	})
+
+	assert.Equal(t, float64(0), allocs)
+}
+
+// prometheusMetrics is an example Metrics adapter, which counts requests by endpoint and outcome
+// (eg: prometheus.CounterVec) and records the latency (eg: prometheus.HistogramVec).
+type prometheusMetrics struct {
+	mutex     sync.Mutex
+	counter   map[string]int
+	durations map[string][]time.Duration
+}
+
+func newPrometheusMetrics() *prometheusMetrics {
+	return &prometheusMetrics{
+		counter:   make(map[string]int),
+		durations: make(map[string][]time.Duration),
+	}
+}
+
+func (m *prometheusMetrics) ObserveRequest(endpoint string, method HTTPMethod, status, attempts int, err error, d time.Duration) {
+	outcome := "success"
+
+	if err != nil {
+		outcome = "failure"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.counter[fmt.Sprintf("%s|%s|%d|%s", endpoint, method, status, outcome)]++
+	m.durations[endpoint] = append(m.durations[endpoint], d)
+}
+
+func TestMetrics(t *testing.T) {
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				switch req.URL.Path {
+				case "/cgi-bin/user/info":
+					return newTestResponse(http.StatusOK, `{"openid":"OPENID"}`), nil
+				case "/cgi-bin/media/upload":
+					return newTestResponse(http.StatusOK, `{"type":"image","media_id":"MEDIA_ID"}`), nil
+				case "/cgi-bin/media/get":
+					return newTestResponse(http.StatusOK, "IMAGE"), nil
+				}
+
+				return nil, errors.New("connection reset")
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	m := newPrometheusMetrics()
+
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID2", WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	_, err = client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=ACCESS_TOKEN", []byte("{}"), WithMetrics(m))
+
+	assert.NotNil(t, err)
+
+	_, err = client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", NewUploadForm("media", "test.jpg", WithResourceBytes([]byte("IMAGE"))), WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	rc, _, err := client.GetStream(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/get?access_token=ACCESS_TOKEN&media_id=MEDIA_ID", WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	rc.Close()
+
+	assert.Equal(t, map[string]int{
+		"/cgi-bin/user/info|GET|200|success":          2,
+		"/cgi-bin/message/custom/send|POST|0|failure": 1,
+		"/cgi-bin/media/upload|UPLOAD|200|success":    1,
+		"/cgi-bin/media/get|GET|200|success":          1,
+	}, m.counter)
+	assert.Equal(t, 2, len(m.durations["/cgi-bin/user/info"]))
+}
+
+// recordMetrics records every observation
+type recordMetrics struct {
+	observations []string
+}
+
+func (m *recordMetrics) ObserveRequest(endpoint string, method HTTPMethod, status, attempts int, err error, d time.Duration) {
+	m.observations = append(m.observations, fmt.Sprintf("%s %s %d %d %v", method, endpoint, status, attempts, err != nil))
+}
+
+func TestMetricsOnePerCall(t *testing.T) {
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path == "/pay/unifiedorder" {
+					return newTestResponse(http.StatusOK, "<xml><return_code>SUCCESS</return_code></xml>"), nil
+				}
+
+				return newTestResponse(http.StatusOK, `{"errcode":0,"errmsg":"ok"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	m := new(recordMetrics)
+
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	_, err = client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=ACCESS_TOKEN", []byte("{}"), WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	_, err = client.PostXML(context.TODO(), "https://api.mch.weixin.qq.com/pay/unifiedorder", WXML{"appid": "APPID"}, WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	_, err = client.Upload(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/upload?access_token=ACCESS_TOKEN&type=image", NewUploadForm("media", "test.jpg", WithResourceBytes([]byte("IMAGE"))), WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	// the endpoint is the url path, the query (eg: access_token) is never included
+	assert.Equal(t, []string{
+		"GET /cgi-bin/user/info 200 1 false",
+		"POST /cgi-bin/message/custom/send 200 1 false",
+		"POST /pay/unifiedorder 200 1 false",
+		"UPLOAD /cgi-bin/media/upload 200 1 false",
+	}, m.observations)
+}
+
+func TestMetricsWithRetry(t *testing.T) {
+	calls := 0
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+
+				if calls < 3 {
+					return newTestResponse(http.StatusBadGateway, ""), nil
+				}
+
+				return newTestResponse(http.StatusOK, `{"openid":"OPENID"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	m := new(recordMetrics)
+
+	_, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", WithRetry(3, nil), WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", WithRetry(2, nil), WithMetrics(m))
+
+	assert.Nil(t, err)
+
+	calls = 0
+
+	_, err = client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", WithRetry(2, nil), WithMetrics(m))
+
+	assert.NotNil(t, err)
+
+	// one observation per call, with the status of the last attempt
+	assert.Equal(t, []string{
+		"GET /cgi-bin/user/info 200 3 false",
+		"GET /cgi-bin/user/info 200 1 false",
+		"GET /cgi-bin/user/info 502 2 true",
+	}, m.observations)
+}
+
+func TestNopMetrics(t *testing.T) {
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return newTestResponse(http.StatusOK, `{"openid":"OPENID"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	b, err := client.Get(context.TODO(), "https://api.weixin.qq.com/cgi-bin/user/info?access_token=ACCESS_TOKEN&openid=OPENID", WithMetrics(NopMetrics{}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"openid":"OPENID"}`), b)
+}
@@ -0,0 +1,138 @@
+package wx
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+)
+
+// Limiter is consulted before each http request (including each retry attempt) to stay under the wechat api quotas.
+// Wait blocks until the request to the endpoint (url path without query) is allowed,
+// it must return the context error once the context is done.
+type Limiter interface {
+	Wait(ctx context.Context, endpoint string) error
+}
+
+// LimitRule specifies the rate limit of the endpoints which match the pattern (syntax of path.Match, eg: /cgi-bin/message/*/send, notice that * does not match /).
+// All the matched endpoints share one bucket, which is refilled at Rate tokens per second and holds at most Burst tokens.
+type LimitRule struct {
+	Pattern string
+	Rate    float64
+	Burst   int
+}
+
+// TokenBucketLimiter is a Limiter which limits the endpoints by token bucket,
+// the requests are allowed in the order they call Wait.
+// The endpoints which match no rule are not limited.
+type TokenBucketLimiter struct {
+	rules   []LimitRule
+	buckets []*tokenBucket
+}
+
+// NewTokenBucketLimiter returns a new TokenBucketLimiter, the first matched rule wins.
+func NewTokenBucketLimiter(rules ...LimitRule) *TokenBucketLimiter {
+	l := &TokenBucketLimiter{
+		rules:   rules,
+		buckets: make([]*tokenBucket, 0, len(rules)),
+	}
+
+	now := time.Now()
+
+	for _, r := range rules {
+		burst := float64(r.Burst)
+
+		if burst < 1 {
+			burst = 1
+		}
+
+		l.buckets = append(l.buckets, &tokenBucket{
+			rate:   r.Rate,
+			burst:  burst,
+			tokens: burst,
+			last:   now,
+		})
+	}
+
+	return l
+}
+
+// Wait blocks until the request to the endpoint is allowed or the context is done.
+func (l *TokenBucketLimiter) Wait(ctx context.Context, endpoint string) error {
+	for i, r := range l.rules {
+		if ok, _ := path.Match(r.Pattern, endpoint); ok {
+			return l.buckets[i].wait(ctx)
+		}
+	}
+
+	return nil
+}
+
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64 // negative means the tokens have been reserved by the waiting callers
+	last   time.Time
+}
+
+// advance refills the tokens up to now
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		b.last = now
+	}
+
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+
+	b.advance(time.Now())
+
+	// reserve a token
+	b.tokens--
+
+	if b.tokens >= 0 {
+		b.mutex.Unlock()
+
+		return nil
+	}
+
+	if b.rate <= 0 {
+		b.tokens++
+		b.mutex.Unlock()
+
+		<-ctx.Done()
+
+		return ctx.Err()
+	}
+
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+
+	b.mutex.Unlock()
+
+	timer := time.NewTimer(delay)
+
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		// give back the reserved token
+		b.mutex.Lock()
+		b.tokens++
+		b.advance(time.Now())
+		b.mutex.Unlock()
+
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
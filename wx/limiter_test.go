@@ -0,0 +1,177 @@
+package wx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := NewTokenBucketLimiter(LimitRule{
+		Pattern: "/cgi-bin/message/*/send",
+		Rate:    20,
+		Burst:   2,
+	})
+
+	now := time.Now()
+
+	// burst
+	assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/message/custom/send"))
+	assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/message/template/send"))
+	assert.True(t, time.Since(now) < 25*time.Millisecond)
+
+	// not limited
+	assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/user/info"))
+	assert.True(t, time.Since(now) < 25*time.Millisecond)
+
+	// 1/20 s
+	assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/message/custom/send"))
+	assert.True(t, time.Since(now) >= 40*time.Millisecond)
+}
+
+func TestTokenBucketLimiterOrder(t *testing.T) {
+	l := NewTokenBucketLimiter(LimitRule{
+		Pattern: "/cgi-bin/message/custom/send",
+		Rate:    50,
+		Burst:   1,
+	})
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+
+	order := make([]int, 0, 5)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func(n int) {
+			defer wg.Done()
+
+			assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/message/custom/send"))
+
+			mutex.Lock()
+			order = append(order, n)
+			mutex.Unlock()
+		}(i)
+
+		// make sure the callers call Wait in order
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+}
+
+func TestTokenBucketLimiterCanceled(t *testing.T) {
+	l := NewTokenBucketLimiter(LimitRule{
+		Pattern: "/cgi-bin/message/custom/send",
+		Rate:    0.1,
+		Burst:   1,
+	})
+
+	assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/message/custom/send"))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	now := time.Now()
+
+	err := l.Wait(ctx, "/cgi-bin/message/custom/send")
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, time.Since(now) < time.Second)
+
+	// the canceled callers give back the reserved tokens
+	ctx, cancel = context.WithTimeout(context.TODO(), 20*time.Millisecond)
+
+	defer cancel()
+
+	assert.True(t, errors.Is(l.Wait(ctx, "/cgi-bin/message/custom/send"), context.DeadlineExceeded))
+	assert.True(t, l.buckets[0].tokens > -1)
+}
+
+// recordLimiter records the endpoints and the order of Wait
+type recordLimiter struct {
+	events *[]string
+}
+
+func (l *recordLimiter) Wait(ctx context.Context, endpoint string) error {
+	*l.events = append(*l.events, "wait "+endpoint)
+
+	return nil
+}
+
+func TestWithLimiter(t *testing.T) {
+	events := make([]string, 0)
+
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				events = append(events, "send "+req.URL.Path)
+
+				return newTestResponse(http.StatusOK, `{"errcode":0,"errmsg":"ok"}`), nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	_, err := client.Post(context.TODO(), "https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=ACCESS_TOKEN", []byte("{}"), WithLimiter(&recordLimiter{events: &events}))
+
+	assert.Nil(t, err)
+
+	rc, _, err := client.GetStream(context.TODO(), "https://api.weixin.qq.com/cgi-bin/media/get?access_token=ACCESS_TOKEN&media_id=MEDIA_ID", WithLimiter(&recordLimiter{events: &events}))
+
+	assert.Nil(t, err)
+
+	rc.Close()
+
+	assert.Equal(t, []string{
+		"wait /cgi-bin/message/custom/send",
+		"send /cgi-bin/message/custom/send",
+		"wait /cgi-bin/media/get",
+		"send /cgi-bin/media/get",
+	}, events)
+}
+
+func TestWithLimiterCanceled(t *testing.T) {
+	client := &apiClient{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				t.Fatal("the request should not be sent")
+
+				return nil, nil
+			}),
+		},
+		timeout: defaultTimeout,
+	}
+
+	l := NewTokenBucketLimiter(LimitRule{
+		Pattern: "/cgi-bin/message/custom/send",
+		Rate:    0.1,
+		Burst:   1,
+	})
+
+	// consume the burst
+	assert.Nil(t, l.Wait(context.TODO(), "/cgi-bin/message/custom/send"))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+
+	defer cancel()
+
+	_, err := client.Post(ctx, "https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=ACCESS_TOKEN", []byte("{}"), WithLimiter(l))
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.NotContains(t, err.Error(), "ACCESS_TOKEN")
+}
@@ -6,6 +6,8 @@ package wx
 
 import (
 	context "context"
+	io "io"
+	http "net/http"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -54,6 +56,27 @@ func (mr *MockHTTPClientMockRecorder) Get(ctx, reqURL interface{}, options ...in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockHTTPClient)(nil).Get), varargs...)
 }
 
+// GetStream mocks base method.
+func (m *MockHTTPClient) GetStream(ctx context.Context, reqURL string, options ...HTTPOption) (io.ReadCloser, http.Header, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, reqURL}
+	for _, a := range options {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetStream", varargs...)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(http.Header)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetStream indicates an expected call of GetStream.
+func (mr *MockHTTPClientMockRecorder) GetStream(ctx, reqURL interface{}, options ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, reqURL}, options...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStream", reflect.TypeOf((*MockHTTPClient)(nil).GetStream), varargs...)
+}
+
 // Post mocks base method.
 func (m *MockHTTPClient) Post(ctx context.Context, reqURL string, body []byte, options ...HTTPOption) ([]byte, error) {
 	m.ctrl.T.Helper()
@@ -138,18 +161,18 @@ func (m *MockUploadForm) EXPECT() *MockUploadFormMockRecorder {
 }
 
 // Buffer mocks base method.
-func (m *MockUploadForm) Buffer() ([]byte, error) {
+func (m *MockUploadForm) Buffer(ctx context.Context) ([]byte, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Buffer")
+	ret := m.ctrl.Call(m, "Buffer", ctx)
 	ret0, _ := ret[0].([]byte)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Buffer indicates an expected call of Buffer.
-func (mr *MockUploadFormMockRecorder) Buffer() *gomock.Call {
+func (mr *MockUploadFormMockRecorder) Buffer(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Buffer", reflect.TypeOf((*MockUploadForm)(nil).Buffer))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Buffer", reflect.TypeOf((*MockUploadForm)(nil).Buffer), ctx)
 }
 
 // ExtraFields mocks base method.
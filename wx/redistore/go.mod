@@ -0,0 +1,10 @@
+module github.com/shenghui0779/gochat/wx/redistore
+
+go 1.13
+
+require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/shenghui0779/gochat v0.0.0-20261016132356-34331a0223df
+	github.com/stretchr/testify v1.7.0
+)
@@ -0,0 +1,201 @@
+// Package redistore provides a redis-backed wx.AccessTokenStore for multi-instance deployments.
+package redistore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/shenghui0779/gochat/wx"
+)
+
+// unlockScript deletes the lock only when it is held by self
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// Store is a redis-backed access_token store, which implements wx.AccessTokenStore, wx.AccessTokenTTLGetter and wx.AccessTokenLocker.
+// The access_token is stored under the key "{prefix}:access_token:{appid}",
+// and only one instance is allowed to refresh it by the lock "{prefix}:access_token:{appid}:lock" (SET NX),
+// the others wait until the lock is released and re-read the store.
+type Store struct {
+	client    redis.UniversalClient
+	key       string
+	lockKey   string
+	lockTTL   time.Duration
+	lockValue string
+	interval  time.Duration
+	margin    time.Duration
+	mutex     sync.Mutex
+}
+
+// Option configures how we set up the store
+type Option func(s *Store)
+
+// WithLockTTL specifies the ttl of the refresh lock (default: 5s), which also limits the time to wait for the lock.
+func WithLockTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.lockTTL = d
+	}
+}
+
+// WithRefreshMargin specifies the margin to refresh access_token before it expires (default: wx.DefaultTokenRefreshMargin).
+func WithRefreshMargin(d time.Duration) Option {
+	return func(s *Store) {
+		s.margin = d
+	}
+}
+
+// New returns a new redis-backed access_token store for the appid
+func New(client redis.UniversalClient, prefix, appid string, options ...Option) *Store {
+	key := prefix + ":access_token:" + appid
+
+	s := &Store{
+		client:   client,
+		key:      key,
+		lockKey:  key + ":lock",
+		lockTTL:  5 * time.Second,
+		interval: 50 * time.Millisecond,
+		margin:   wx.DefaultTokenRefreshMargin,
+	}
+
+	for _, f := range options {
+		f(s)
+	}
+
+	return s
+}
+
+// Get returns the stored access_token, empty string means not found or expired
+func (s *Store) Get(ctx context.Context) (string, error) {
+	token, err := s.client.Get(ctx, s.key).Result()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetWithTTL returns the stored access_token and its remaining ttl, empty string means not found or expired
+func (s *Store) GetWithTTL(ctx context.Context) (string, time.Duration, error) {
+	pipe := s.client.Pipeline()
+
+	get := pipe.Get(ctx, s.key)
+	pttl := pipe.PTTL(ctx, s.key)
+
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, err
+	}
+
+	token, err := get.Result()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", 0, nil
+		}
+
+		return "", 0, err
+	}
+
+	// -1 means no ttl, -2 means not found
+	ttl := pttl.Val()
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return token, ttl, nil
+}
+
+// Set stores the access_token with ttl
+func (s *Store) Set(ctx context.Context, token string, ttl time.Duration) error {
+	// refresh ahead of time, but keep at least half of the ttl
+	if s.margin > 0 {
+		if s.margin < ttl/2 {
+			ttl -= s.margin
+		} else {
+			ttl /= 2
+		}
+	}
+
+	return s.client.Set(ctx, s.key, token, ttl).Err()
+}
+
+// Lock acquires the refresh lock, it returns false when the lock is held by other instance
+// and waits until the lock is released or the lock ttl is reached.
+func (s *Store) Lock(ctx context.Context) (bool, error) {
+	value := make([]byte, 16)
+
+	if _, err := io.ReadFull(rand.Reader, value); err != nil {
+		return false, err
+	}
+
+	lockValue := hex.EncodeToString(value)
+
+	ok, err := s.client.SetNX(ctx, s.lockKey, lockValue, s.lockTTL).Result()
+
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		s.mutex.Lock()
+		s.lockValue = lockValue
+		s.mutex.Unlock()
+
+		return true, nil
+	}
+
+	// wait for the lock released by other instance
+	timer := time.NewTimer(s.lockTTL)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timer.C:
+			return false, nil
+		case <-ticker.C:
+			n, err := s.client.Exists(ctx, s.lockKey).Result()
+
+			if err != nil {
+				return false, err
+			}
+
+			if n == 0 {
+				return false, nil
+			}
+		}
+	}
+}
+
+// Unlock releases the refresh lock held by self
+func (s *Store) Unlock(ctx context.Context) error {
+	s.mutex.Lock()
+	lockValue := s.lockValue
+	s.lockValue = ""
+	s.mutex.Unlock()
+
+	if len(lockValue) == 0 {
+		return nil
+	}
+
+	return unlockScript.Run(ctx, s.client, []string{s.lockKey}, lockValue).Err()
+}
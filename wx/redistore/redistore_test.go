@@ -0,0 +1,169 @@
+package redistore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/shenghui0779/gochat/oa"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	mr, err := miniredis.Run()
+
+	assert.Nil(t, err)
+
+	return mr, redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{mr.Addr()}})
+}
+
+func TestStore(t *testing.T) {
+	mr, client := newTestClient(t)
+	defer mr.Close()
+
+	store := New(client, "gochat", "APPID")
+
+	token, err := store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", 7200*time.Second))
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+	assert.Equal(t, 7200*time.Second-5*time.Minute, mr.TTL("gochat:access_token:APPID"))
+
+	token, ttl, err := store.GetWithTTL(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+	assert.Equal(t, 7200*time.Second-5*time.Minute, ttl)
+
+	// expired
+	mr.FastForward(7200 * time.Second)
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+
+	token, ttl, err = store.GetWithTTL(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestLock(t *testing.T) {
+	mr, client := newTestClient(t)
+	defer mr.Close()
+
+	store1 := New(client, "gochat", "APPID")
+	store2 := New(client, "gochat", "APPID")
+
+	locked, err := store1.Lock(context.TODO())
+
+	assert.Nil(t, err)
+	assert.True(t, locked)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+
+		store1.Unlock(context.TODO())
+	}()
+
+	// wait until the lock is released
+	start := time.Now()
+
+	locked, err = store2.Lock(context.TODO())
+
+	assert.Nil(t, err)
+	assert.False(t, locked)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.False(t, mr.Exists("gochat:access_token:APPID:lock"))
+
+	// the lock held by others can't be released
+	locked, err = store2.Lock(context.TODO())
+
+	assert.Nil(t, err)
+	assert.True(t, locked)
+	assert.Nil(t, store1.Unlock(context.TODO()))
+	assert.True(t, mr.Exists("gochat:access_token:APPID:lock"))
+}
+
+func TestLockTimeout(t *testing.T) {
+	mr, client := newTestClient(t)
+	defer mr.Close()
+
+	mr.Set("gochat:access_token:APPID:lock", "OTHERS")
+
+	store := New(client, "gochat", "APPID", WithLockTTL(100*time.Millisecond))
+
+	locked, err := store.Lock(context.TODO())
+
+	assert.Nil(t, err)
+	assert.False(t, locked)
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestMultiInstance(t *testing.T) {
+	mr, client := newTestClient(t)
+	defer mr.Close()
+
+	var fetches int32
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&fetches, 1)
+
+			time.Sleep(100 * time.Millisecond)
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(strings.NewReader(`{"access_token":"ACCESS_TOKEN","expires_in":7200}`)),
+			}, nil
+		}),
+	}
+
+	var wg sync.WaitGroup
+
+	tokens := make([]string, 5)
+
+	// instances share the same redis
+	for i := 0; i < 5; i++ {
+		wxoa := oa.New("APPID", "APPSECRET", oa.WithHTTPClient(httpClient), oa.WithTokenStore(New(client, "gochat", "APPID")))
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			accessToken, err := wxoa.AccessToken(context.TODO())
+
+			if err == nil {
+				tokens[i] = accessToken.Token
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	assert.Equal(t, []string{"ACCESS_TOKEN", "ACCESS_TOKEN", "ACCESS_TOKEN", "ACCESS_TOKEN", "ACCESS_TOKEN"}, tokens)
+}
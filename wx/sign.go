@@ -0,0 +1,75 @@
+package wx
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// SignMD5 generates the MD5 signature of wechat pay (v2), the params are sorted by key,
+// the empty values and the sign field are skipped, returns the uppercase hex digest.
+func SignMD5(m WXML, apiKey string) string {
+	return sign(md5.New(), m, apiKey)
+}
+
+// SignHMACSHA256 generates the HMAC-SHA256 signature of wechat pay (v2), the params are sorted by key,
+// the empty values and the sign field are skipped, returns the uppercase hex digest.
+func SignHMACSHA256(m WXML, apiKey string) string {
+	return sign(hmac.New(sha256.New, []byte(apiKey)), m, apiKey)
+}
+
+func sign(h hash.Hash, m WXML, apiKey string) string {
+	h.Write([]byte(buildSignStr(m, apiKey)))
+
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// String returns the canonical string to be signed (without the key=apiKey suffix): k1=v1&k2=v2,
+// the params are sorted by key, the empty values and the sign field are skipped, which is useful for debugging the sign mismatch.
+func (m WXML) String() string {
+	var builder strings.Builder
+
+	for _, k := range sortedKeys(m) {
+		if k == "sign" || m[k] == "" {
+			continue
+		}
+
+		if builder.Len() != 0 {
+			builder.WriteString("&")
+		}
+
+		builder.WriteString(k)
+		builder.WriteString("=")
+		builder.WriteString(m[k])
+	}
+
+	return builder.String()
+}
+
+// buildSignStr 生成待签名串：k1=v1&k2=v2&key=apiKey
+func buildSignStr(m WXML, apiKey string) string {
+	s := m.String()
+
+	if s != "" {
+		s += "&"
+	}
+
+	return s + "key=" + apiKey
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys(m WXML) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
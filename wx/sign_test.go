@@ -0,0 +1,86 @@
+package wx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	// 微信支付官方文档的签名示例：https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=4_3
+	example := WXML{
+		"appid":       "wxd930ea5d5a258f4f",
+		"mch_id":      "10000100",
+		"device_info": "1000",
+		"body":        "test",
+		"nonce_str":   "ibuaiVcKdpRxkhJA",
+	}
+
+	cases := []struct {
+		name   string
+		m      WXML
+		md5    string
+		sha256 string
+	}{
+		{
+			name:   "example",
+			m:      example,
+			md5:    "9A0A8659F005D6984697E2CA0A9CF3B7",
+			sha256: "6A9AE1657590FD6257D693A078E1C3E4BB6BA4DC30B23E0EE2496E54170DACD6",
+		},
+		{
+			name: "skip sign and empty values",
+			m: WXML{
+				"appid":       "wxd930ea5d5a258f4f",
+				"mch_id":      "10000100",
+				"device_info": "1000",
+				"body":        "test",
+				"nonce_str":   "ibuaiVcKdpRxkhJA",
+				"attach":      "",
+				"sign":        "9A0A8659F005D6984697E2CA0A9CF3B7",
+			},
+			md5:    "9A0A8659F005D6984697E2CA0A9CF3B7",
+			sha256: "6A9AE1657590FD6257D693A078E1C3E4BB6BA4DC30B23E0EE2496E54170DACD6",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.md5, SignMD5(c.m, "192006250b4c09247ec02edce69f6a2d"))
+			assert.Equal(t, c.sha256, SignHMACSHA256(c.m, "192006250b4c09247ec02edce69f6a2d"))
+		})
+	}
+}
+
+func TestBuildSignStr(t *testing.T) {
+	m := WXML{
+		"nonce_str":   "ibuaiVcKdpRxkhJA",
+		"mch_id":      "10000100",
+		"body":        "test",
+		"device_info": "1000",
+		"appid":       "wxd930ea5d5a258f4f",
+		"sign":        "SIGN",
+		"attach":      "",
+	}
+
+	assert.Equal(t, "appid=wxd930ea5d5a258f4f&body=test&device_info=1000&mch_id=10000100&nonce_str=ibuaiVcKdpRxkhJA&key=192006250b4c09247ec02edce69f6a2d", buildSignStr(m, "192006250b4c09247ec02edce69f6a2d"))
+}
+
+func TestWXMLString(t *testing.T) {
+	m := WXML{
+		"nonce_str":   "ibuaiVcKdpRxkhJA",
+		"mch_id":      "10000100",
+		"body":        "test",
+		"appid":       "wxd930ea5d5a258f4f",
+		"device_info": "1000",
+		"attach":      "",
+		"sign":        "9A0A8659F005D6984697E2CA0A9CF3B7",
+	}
+
+	// 按key排序，跳过空值及 sign 字段
+	assert.Equal(t, "appid=wxd930ea5d5a258f4f&body=test&device_info=1000&mch_id=10000100&nonce_str=ibuaiVcKdpRxkhJA", m.String())
+	assert.Equal(t, m.String()+"&key=192006250b4c09247ec02edce69f6a2d", buildSignStr(m, "192006250b4c09247ec02edce69f6a2d"))
+
+	assert.Equal(t, "", WXML{"sign": "9A0A8659F005D6984697E2CA0A9CF3B7"}.String())
+	assert.Equal(t, "key=192006250b4c09247ec02edce69f6a2d", buildSignStr(WXML{}, "192006250b4c09247ec02edce69f6a2d"))
+}
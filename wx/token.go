@@ -0,0 +1,414 @@
+package wx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultTokenRefreshMargin default margin to refresh access_token before it expires
+const DefaultTokenRefreshMargin = 5 * time.Minute
+
+// DefaultTokenRefreshTimeout default timeout of refreshing access_token, which is shared by the concurrent callers
+const DefaultTokenRefreshTimeout = 30 * time.Second
+
+// AccessTokenStore is the interface that stores access_token
+type AccessTokenStore interface {
+	// Get returns the stored access_token, empty string means not found or expired
+	Get(ctx context.Context) (string, error)
+
+	// Set stores the access_token with ttl
+	Set(ctx context.Context, token string, ttl time.Duration) error
+}
+
+// AccessTokenLocker is an optional interface implemented by AccessTokenStore (eg: redis store),
+// which is used to make sure only one instance refreshes the access_token in multi-instance deployments.
+type AccessTokenLocker interface {
+	// Lock acquires the refresh lock, it returns false when the lock is held by other instance
+	// and waits until the lock is released (the caller should re-read the store then).
+	Lock(ctx context.Context) (bool, error)
+
+	// Unlock releases the refresh lock
+	Unlock(ctx context.Context) error
+}
+
+// AccessTokenMarginSetter is an optional interface implemented by AccessTokenStore (eg: the in-memory store),
+// which allows changing the margin to refresh access_token before it expires at runtime.
+type AccessTokenMarginSetter interface {
+	// SetMargin sets the margin, the stored access_token is regarded as expired when it is about to expire within the margin
+	SetMargin(d time.Duration)
+}
+
+// AccessTokenTTLGetter is an optional interface implemented by AccessTokenStore (eg: the in-memory store, the redis store),
+// which returns the stored access_token with its remaining ttl, so that the cached access_token has the expires_in as well.
+type AccessTokenTTLGetter interface {
+	// GetWithTTL returns the stored access_token and its remaining ttl (until it is regarded as expired by the store),
+	// empty string means not found or expired
+	GetWithTTL(ctx context.Context) (string, time.Duration, error)
+}
+
+// GetAccessToken returns the stored access_token and its remaining ttl,
+// the ttl is 0 if the store does not implement AccessTokenTTLGetter.
+func GetAccessToken(ctx context.Context, store AccessTokenStore) (string, time.Duration, error) {
+	if s, ok := store.(AccessTokenTTLGetter); ok {
+		return s.GetWithTTL(ctx)
+	}
+
+	token, err := store.Get(ctx)
+
+	return token, 0, err
+}
+
+type memAccessTokenStore struct {
+	token    string
+	ttl      time.Duration
+	expireAt time.Time
+	margin   time.Duration
+	now      func() time.Time
+	mutex    sync.RWMutex
+}
+
+func (s *memAccessTokenStore) Get(ctx context.Context) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.token) == 0 || !s.now().Before(s.refreshAt()) {
+		return "", nil
+	}
+
+	return s.token, nil
+}
+
+func (s *memAccessTokenStore) GetWithTTL(ctx context.Context) (string, time.Duration, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ttl := s.refreshAt().Sub(s.now())
+
+	if len(s.token) == 0 || ttl <= 0 {
+		return "", 0, nil
+	}
+
+	return s.token, ttl, nil
+}
+
+func (s *memAccessTokenStore) Set(ctx context.Context, token string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.token = token
+	s.ttl = ttl
+	s.expireAt = s.now().Add(ttl)
+
+	return nil
+}
+
+func (s *memAccessTokenStore) SetMargin(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.margin = d
+}
+
+// refreshAt returns the time to refresh ahead of the expiry, but keep at least half of the ttl
+func (s *memAccessTokenStore) refreshAt() time.Time {
+	if s.margin <= 0 {
+		return s.expireAt
+	}
+
+	if s.margin < s.ttl/2 {
+		return s.expireAt.Add(-s.margin)
+	}
+
+	return s.expireAt.Add(-s.ttl / 2)
+}
+
+// NewMemAccessTokenStore returns a new concurrency-safe in-memory access_token store, which keeps the expiry time of the access_token,
+// the stored access_token is regarded as expired when it is about to expire within margin (eg: DefaultTokenRefreshMargin), see AccessTokenMarginSetter.
+func NewMemAccessTokenStore(margin time.Duration) AccessTokenStore {
+	return &memAccessTokenStore{
+		margin: margin,
+		now:    time.Now,
+	}
+}
+
+// detachedContext keeps the values of the parent context, but is never canceled and has no deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// DetachContext returns a context which keeps the values (eg: trace id) of ctx, but is not canceled when ctx is canceled.
+func DetachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+// SingleflightDo executes fn only once for the concurrent callers with the same key (eg: refreshing access_token),
+// fn runs with a context detached from the cancellation of the callers (see DetachContext) and bounded by the timeout,
+// while each caller waits on its own ctx, so that a canceled caller neither cancels nor fails the others.
+func SingleflightDo(ctx context.Context, g *singleflight.Group, key string, timeout time.Duration, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	ch := g.DoChan(key, func() (interface{}, error) {
+		flightCtx, cancel := context.WithTimeout(DetachContext(ctx), timeout)
+
+		defer cancel()
+
+		return fn(flightCtx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.Val, r.Err
+	}
+}
+
+// AccessTokenFetchFunc fetches a new access_token from wechat (eg: by the appid and secret of the app), returns the access_token and its ttl (expires_in)
+type AccessTokenFetchFunc func(ctx context.Context, options ...HTTPOption) (string, time.Duration, error)
+
+// AccessTokenManager manages the access_token of an app (eg: the official account, the mini program), which is embedded by the app client:
+// the access_token is cached in the AccessTokenStore (default: in-memory store, refreshed DefaultTokenRefreshMargin before it expires),
+// refreshed once for the concurrent callers (and across instances when the store implements AccessTokenLocker),
+// and refreshed once more when the api returns 40001/42001 (see DoWithToken).
+// It also keeps the client level http options (eg: debug, logger, metrics, limiter) which apply to all the requests of the app.
+// The setters (except SetTokenSafetyMargin) are not concurrency-safe and should be called before use (eg: in the options of the app client).
+type AccessTokenManager struct {
+	key       string
+	fetch     AccessTokenFetchFunc
+	store     AccessTokenStore
+	margin    *time.Duration
+	tokenFunc func(ctx context.Context) (string, error)
+	options   []HTTPOption
+	group     singleflight.Group
+	mutex     sync.Mutex
+}
+
+// NewAccessTokenManager returns a new AccessTokenManager with the in-memory store,
+// key identifies the app (eg: appid) and fetch fetches a new access_token from wechat.
+func NewAccessTokenManager(key string, fetch AccessTokenFetchFunc) *AccessTokenManager {
+	return &AccessTokenManager{
+		key:   key,
+		fetch: fetch,
+		store: NewMemAccessTokenStore(DefaultTokenRefreshMargin),
+	}
+}
+
+// SetTokenStore sets the store of access_token (eg: a redis store for multi-instance deployments), nil means fetching from wechat every time,
+// the margin set by SetTokenSafetyMargin applies to the store as well.
+func (m *AccessTokenManager) SetTokenStore(store AccessTokenStore) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.store = store
+
+	if m.margin != nil {
+		m.setMargin(*m.margin)
+	}
+}
+
+// SetTokenSafetyMargin sets the margin to refresh access_token before it expires (default: DefaultTokenRefreshMargin), which takes effect immediately
+// and applies to the store implementing AccessTokenMarginSetter (eg: the in-memory store), see AccessTokenMarginSetter.
+func (m *AccessTokenManager) SetTokenSafetyMargin(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.margin = &d
+
+	m.setMargin(d)
+}
+
+func (m *AccessTokenManager) setMargin(d time.Duration) {
+	if s, ok := m.store.(AccessTokenMarginSetter); ok {
+		s.SetMargin(d)
+	}
+}
+
+// SetAccessTokenFunc sets the func to get access_token (eg: from a central token service),
+// when set, the access_token is never fetched from wechat and the store is ignored.
+func (m *AccessTokenManager) SetAccessTokenFunc(f func(ctx context.Context) (string, error)) {
+	m.tokenFunc = f
+}
+
+// AddHTTPOptions adds the client level http options (eg: WithDebugFunc, WithMetrics), which apply to all the requests of the app.
+func (m *AccessTokenManager) AddHTTPOptions(options ...HTTPOption) {
+	m.options = append(m.options, options...)
+}
+
+// HTTPOptions returns the client level http options followed by the options of the request, which take precedence.
+func (m *AccessTokenManager) HTTPOptions(options []HTTPOption) []HTTPOption {
+	if len(m.options) == 0 {
+		return options
+	}
+
+	opts := make([]HTTPOption, 0, len(m.options)+len(options))
+
+	opts = append(opts, m.options...)
+
+	return append(opts, options...)
+}
+
+// Token returns the access_token and its remaining ttl from the store (the ttl is 0 if the store does not implement AccessTokenTTLGetter),
+// the access_token is fetched from wechat and stored when not found or expired.
+func (m *AccessTokenManager) Token(ctx context.Context, options ...HTTPOption) (string, time.Duration, error) {
+	if m.tokenFunc != nil {
+		return m.funcToken(ctx)
+	}
+
+	if m.store == nil {
+		return m.RefreshToken(ctx, "", options...)
+	}
+
+	token, ttl, err := GetAccessToken(ctx, m.store)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	if len(token) != 0 {
+		return token, ttl, nil
+	}
+
+	return m.RefreshToken(ctx, "", options...)
+}
+
+type accessToken struct {
+	token string
+	ttl   time.Duration
+}
+
+// RefreshToken fetches a new access_token from wechat and stores it, staleToken is the invalid access_token (if any),
+// the stored access_token is returned directly when it has been refreshed by other goroutines or instances.
+// The concurrent callers share the same refreshing, which is not canceled with the ctx of the callers (bounded by DefaultTokenRefreshTimeout).
+func (m *AccessTokenManager) RefreshToken(ctx context.Context, staleToken string, options ...HTTPOption) (string, time.Duration, error) {
+	if m.tokenFunc != nil {
+		return m.funcToken(ctx)
+	}
+
+	v, err := SingleflightDo(ctx, &m.group, m.key, DefaultTokenRefreshTimeout, func(ctx context.Context) (interface{}, error) {
+		return m.loadToken(ctx, staleToken, options...)
+	})
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	token := v.(*accessToken)
+
+	return token.token, token.ttl, nil
+}
+
+func (m *AccessTokenManager) funcToken(ctx context.Context) (string, time.Duration, error) {
+	token, err := m.tokenFunc(ctx)
+
+	if err != nil {
+		return "", 0, fmt.Errorf("gochat: access_token func: %w", err)
+	}
+
+	return token, 0, nil
+}
+
+func (m *AccessTokenManager) loadToken(ctx context.Context, staleToken string, options ...HTTPOption) (*accessToken, error) {
+	if m.store == nil {
+		return m.fetchToken(ctx, options...)
+	}
+
+	// 再次检查，其他 goroutine 可能已刷新
+	token, ttl, err := GetAccessToken(ctx, m.store)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(token) != 0 && token != staleToken {
+		return &accessToken{token: token, ttl: ttl}, nil
+	}
+
+	// 多实例部署时，防止其他实例同时刷新
+	if locker, ok := m.store.(AccessTokenLocker); ok {
+		locked, err := locker.Lock(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if locked {
+			defer locker.Unlock(ctx)
+		}
+
+		// 再次检查，其他实例可能已刷新
+		token, ttl, err = GetAccessToken(ctx, m.store)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(token) != 0 && token != staleToken {
+			return &accessToken{token: token, ttl: ttl}, nil
+		}
+	}
+
+	fresh, err := m.fetchToken(ctx, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.store.Set(ctx, fresh.token, fresh.ttl); err != nil {
+		return nil, err
+	}
+
+	return fresh, nil
+}
+
+func (m *AccessTokenManager) fetchToken(ctx context.Context, options ...HTTPOption) (*accessToken, error) {
+	token, ttl, err := m.fetch(ctx, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessToken{token: token, ttl: ttl}, nil
+}
+
+// DoWithToken executes do with the access_token, if it returns 40001/42001 (eg: the access_token has been refreshed by other instances),
+// the access_token is refreshed and do is retried once.
+func (m *AccessTokenManager) DoWithToken(ctx context.Context, do func(accessToken string) error, options ...HTTPOption) error {
+	token, _, err := m.Token(ctx, options...)
+
+	if err != nil {
+		return err
+	}
+
+	err = do(token)
+
+	if !IsCode(err, ErrCodeInvalidCredential, ErrCodeAccessTokenExpired) {
+		return err
+	}
+
+	// 仅重试一次，避免刷新后依然失效导致无限重试
+	token, _, err = m.RefreshToken(ctx, token, options...)
+
+	if err != nil {
+		return err
+	}
+
+	return do(token)
+}
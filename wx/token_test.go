@@ -0,0 +1,367 @@
+package wx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/singleflight"
+)
+
+func TestMemAccessTokenStore(t *testing.T) {
+	store := NewMemAccessTokenStore(0)
+
+	token, err := store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", time.Hour))
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+
+	// expired
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", -time.Second))
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+}
+
+func TestMemAccessTokenStoreMargin(t *testing.T) {
+	store := NewMemAccessTokenStore(5 * time.Minute).(*memAccessTokenStore)
+
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", 2*time.Hour))
+
+	d := time.Until(store.refreshAt())
+
+	assert.True(t, d > 2*time.Hour-5*time.Minute-time.Second && d <= 2*time.Hour-5*time.Minute)
+
+	// margin is too large, keep half of the ttl
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", 6*time.Minute))
+
+	d = time.Until(store.refreshAt())
+
+	assert.True(t, d > 3*time.Minute-time.Second && d <= 3*time.Minute)
+}
+
+func TestMemAccessTokenStoreConcurrency(t *testing.T) {
+	store := NewMemAccessTokenStore(DefaultTokenRefreshMargin)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			store.Set(context.TODO(), "ACCESS_TOKEN", time.Hour)
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			store.Get(context.TODO())
+		}()
+	}
+
+	wg.Wait()
+
+	token, err := store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+}
+
+func TestMemAccessTokenStoreClock(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	store := NewMemAccessTokenStore(DefaultTokenRefreshMargin).(*memAccessTokenStore)
+	store.now = func() time.Time { return now }
+
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", 7200*time.Second))
+
+	// 提前300秒（6900秒时）视为过期
+	now = now.Add(6899 * time.Second)
+
+	token, err := store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+
+	now = now.Add(time.Second)
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+
+	// 修改 margin 后立即生效，过期时间不变
+	store.SetMargin(time.Minute)
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+
+	now = now.Add(240 * time.Second)
+
+	token, err = store.Get(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+}
+
+func TestMemAccessTokenStoreTTL(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	store := NewMemAccessTokenStore(DefaultTokenRefreshMargin).(*memAccessTokenStore)
+	store.now = func() time.Time { return now }
+
+	token, ttl, err := GetAccessToken(context.TODO(), store)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	assert.Nil(t, store.Set(context.TODO(), "ACCESS_TOKEN", 7200*time.Second))
+
+	// 剩余有效期为提前刷新前的时间
+	now = now.Add(1000 * time.Second)
+
+	token, ttl, err = GetAccessToken(context.TODO(), store)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+	assert.Equal(t, 5900*time.Second, ttl)
+
+	now = now.Add(5900 * time.Second)
+
+	token, ttl, err = GetAccessToken(context.TODO(), store)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", token)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestDetachContext(t *testing.T) {
+	type key struct{}
+
+	parent, cancel := context.WithCancel(context.WithValue(context.TODO(), key{}, "TRACE_ID"))
+
+	ctx := DetachContext(parent)
+
+	cancel()
+
+	assert.Nil(t, ctx.Err())
+	assert.Nil(t, ctx.Done())
+	assert.Equal(t, "TRACE_ID", ctx.Value(key{}))
+}
+
+func TestSingleflightDo(t *testing.T) {
+	var g singleflight.Group
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	calls := 0
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		calls++
+
+		close(started)
+		<-release
+
+		// 不随调用方取消，但有超时
+		assert.Nil(t, ctx.Err())
+
+		_, ok := ctx.Deadline()
+
+		assert.True(t, ok)
+
+		return "ACCESS_TOKEN", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	first := make(chan error, 1)
+
+	go func() {
+		_, err := SingleflightDo(ctx, &g, "APPID", time.Minute, fn)
+
+		first <- err
+	}()
+
+	<-started
+
+	second := make(chan interface{}, 1)
+
+	go func() {
+		v, err := SingleflightDo(context.TODO(), &g, "APPID", time.Minute, fn)
+
+		assert.Nil(t, err)
+
+		second <- v
+	}()
+
+	// 等待第二个调用方加入
+	time.Sleep(50 * time.Millisecond)
+
+	// 调用方按自身的 ctx 返回
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-first)
+
+	close(release)
+
+	assert.Equal(t, "ACCESS_TOKEN", <-second)
+	assert.Equal(t, 1, calls)
+}
+
+func TestAccessTokenManager(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		count int
+	)
+
+	m := NewAccessTokenManager("APPID", func(ctx context.Context, options ...HTTPOption) (string, time.Duration, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		count++
+
+		return "ACCESS_TOKEN", 2 * time.Hour, nil
+	})
+
+	// concurrent callers share the same refreshing
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			token, _, err := m.Token(context.TODO())
+
+			assert.Nil(t, err)
+			assert.Equal(t, "ACCESS_TOKEN", token)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, count)
+
+	// from the store, refreshed DefaultTokenRefreshMargin before it expires
+	token, ttl, err := m.Token(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ACCESS_TOKEN", token)
+	assert.True(t, ttl > 2*time.Hour-DefaultTokenRefreshMargin-time.Second && ttl <= 2*time.Hour-DefaultTokenRefreshMargin)
+	assert.Equal(t, 1, count)
+
+	// the margin applies regardless of the order of SetTokenStore
+	m.SetTokenSafetyMargin(time.Minute)
+	m.SetTokenStore(NewMemAccessTokenStore(DefaultTokenRefreshMargin))
+
+	_, ttl, err = m.Token(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Hour, ttl)
+
+	_, ttl, err = m.Token(context.TODO())
+
+	assert.Nil(t, err)
+	assert.True(t, ttl > 2*time.Hour-time.Minute-time.Second && ttl <= 2*time.Hour-time.Minute)
+	assert.Equal(t, 2, count)
+
+	// nil store, fetch every time
+	m.SetTokenStore(nil)
+
+	_, ttl, err = m.Token(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Hour, ttl)
+	assert.Equal(t, 3, count)
+
+	// access_token func
+	m.SetAccessTokenFunc(func(ctx context.Context) (string, error) {
+		return "", errors.New("unavailable")
+	})
+
+	_, _, err = m.Token(context.TODO())
+
+	assert.EqualError(t, err, "gochat: access_token func: unavailable")
+	assert.Equal(t, 3, count)
+}
+
+func TestAccessTokenManagerDoWithToken(t *testing.T) {
+	tokens := []string{"ACCESS_TOKEN", "NEW_ACCESS_TOKEN"}
+
+	m := NewAccessTokenManager("APPID", func(ctx context.Context, options ...HTTPOption) (string, time.Duration, error) {
+		token := tokens[0]
+
+		tokens = tokens[1:]
+
+		return token, 2 * time.Hour, nil
+	})
+
+	// 40001 refreshes the access_token and retries once
+	used := make([]string, 0, 2)
+
+	err := m.DoWithToken(context.TODO(), func(accessToken string) error {
+		used = append(used, accessToken)
+
+		if accessToken == "ACCESS_TOKEN" {
+			return NewAPIError("https://api.weixin.qq.com/cgi-bin/menu/get", ErrCodeInvalidCredential, "invalid credential")
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ACCESS_TOKEN", "NEW_ACCESS_TOKEN"}, used)
+
+	// the other errors are returned directly
+	err = m.DoWithToken(context.TODO(), func(accessToken string) error {
+		return NewAPIError("https://api.weixin.qq.com/cgi-bin/menu/get", 45009, "reach max api daily quota limit")
+	})
+
+	assert.True(t, IsCode(err, 45009))
+	assert.Len(t, tokens, 0)
+}
+
+func TestAccessTokenManagerHTTPOptions(t *testing.T) {
+	m := NewAccessTokenManager("APPID", nil)
+
+	assert.Len(t, m.HTTPOptions(nil), 0)
+
+	var debugged bool
+
+	m.AddHTTPOptions(WithDebugFunc(func(ctx context.Context, info *DebugInfo) {
+		debugged = true
+	}), WithTimeout(time.Second))
+
+	// the options of the request take precedence
+	s := &httpSettings{headers: make(map[string]string)}
+
+	for _, f := range m.HTTPOptions([]HTTPOption{WithTimeout(time.Minute)}) {
+		f(s)
+	}
+
+	assert.NotNil(t, s.debug)
+	assert.Equal(t, time.Minute, s.callTimeout)
+
+	s.debug(context.TODO(), nil)
+
+	assert.True(t, debugged)
+}